@@ -0,0 +1,214 @@
+// Command migrate-products normalises product documents written before prices and
+// times were typed. Prices stored as text or integers become numbers, times stored as
+// text become timestamps, and missing or unreadable times are filled from the
+// document's own create and update times. Prices that can't be read are left as they
+// are and reported, to be fixed by hand.
+//
+// It only reports what it would change unless run with -apply:
+//
+//	go run ./cmd/migrate-products [-apply] [-report report.json]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"tripund-api/internal/config"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+)
+
+// productReport is what the migration found in one product document
+type productReport struct {
+	ProductID string                  `json:"product_id"`
+	SKU       string                  `json:"sku"`
+	Name      string                  `json:"name"`
+	Converted []string                `json:"converted,omitempty"` // Fields rewritten with their typed value
+	Filled    []string                `json:"filled,omitempty"`    // Times set from the document's metadata
+	Anomalies []models.ProductAnomaly `json:"anomalies,omitempty"` // Values left as stored
+	Error     string                  `json:"error,omitempty"`
+}
+
+type migrationReport struct {
+	Applied       bool            `json:"applied"`
+	RanAt         time.Time       `json:"ran_at"`
+	Scanned       int             `json:"scanned"`
+	Changed       int             `json:"changed"`
+	WithAnomalies int             `json:"with_anomalies"`
+	Failed        int             `json:"failed"`
+	Products      []productReport `json:"products"`
+}
+
+func main() {
+	apply := flag.Bool("apply", false, "write the normalised values; without it the migration only reports")
+	reportPath := flag.String("report", "", "also write the report as JSON to this file")
+	flag.Parse()
+
+	db, err := database.NewFirebase(config.Load())
+	if err != nil {
+		log.Fatalf("Failed to initialize Firebase: %v", err)
+	}
+	defer db.Close()
+
+	docs, err := db.Client.Collection("products").Documents(db.Context).GetAll()
+	if err != nil {
+		log.Fatalf("Failed to fetch products: %v", err)
+	}
+
+	report := migrationReport{Applied: *apply, RanAt: time.Now(), Scanned: len(docs), Products: []productReport{}}
+	for _, doc := range docs {
+		result, updates := normalizeProduct(doc)
+		if len(updates) == 0 && len(result.Anomalies) == 0 && result.Error == "" {
+			continue
+		}
+		if len(updates) > 0 {
+			report.Changed++
+			if *apply {
+				// Don't overwrite an edit made since the document was read
+				if _, err := doc.Ref.Update(db.Context, updates, firestore.LastUpdateTime(doc.UpdateTime)); err != nil {
+					result.Error = err.Error()
+				}
+			}
+		}
+		if len(result.Anomalies) > 0 {
+			report.WithAnomalies++
+		}
+		if result.Error != "" {
+			report.Failed++
+		}
+		report.Products = append(report.Products, result)
+		printProduct(result)
+	}
+
+	verb := "Would change"
+	if *apply {
+		verb = "Changed"
+	}
+	fmt.Printf("\nScanned %d products. %s %d, %d with values to fix by hand, %d failed.\n",
+		report.Scanned, verb, report.Changed, report.WithAnomalies, report.Failed)
+	if !*apply && report.Changed > 0 {
+		fmt.Println("Run with -apply to write the changes.")
+	}
+
+	if *reportPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode report: %v", err)
+		}
+		if err := os.WriteFile(*reportPath, data, 0o644); err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+		fmt.Printf("Report written to %s\n", *reportPath)
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// normalizeProduct works out the updates that store a product document's legacy
+// values with their types. Variants are updated in the stored maps, keeping any fields
+// the model doesn't know.
+func normalizeProduct(doc *firestore.DocumentSnapshot) (productReport, []firestore.Update) {
+	result := productReport{ProductID: doc.Ref.ID}
+	var legacy models.LegacyProduct
+	if err := doc.DataTo(&legacy); err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	product, converted, anomalies := legacy.Normalize()
+	result.SKU, result.Name = product.SKU, product.Name
+
+	var updates []firestore.Update
+	var variants []interface{}
+	for _, field := range converted {
+		switch field {
+		case "price":
+			updates = append(updates, firestore.Update{Path: field, Value: product.Price})
+		case "sale_price":
+			updates = append(updates, firestore.Update{Path: field, Value: float64(product.SalePrice)})
+		case "created_at":
+			updates = append(updates, firestore.Update{Path: field, Value: product.CreatedAt})
+		case "updated_at":
+			updates = append(updates, firestore.Update{Path: field, Value: product.UpdatedAt})
+		default:
+			i, name, ok := variantField(field)
+			if !ok {
+				continue
+			}
+			if variants == nil {
+				variants, _ = doc.Data()["variants"].([]interface{})
+			}
+			if i >= len(variants) {
+				continue
+			}
+			stored, ok := variants[i].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			stored[name] = float64(product.Variants[i].Price)
+			if name == "sale_price" {
+				stored[name] = float64(product.Variants[i].SalePrice)
+			}
+		}
+		result.Converted = append(result.Converted, field)
+	}
+	if variants != nil {
+		updates = append(updates, firestore.Update{Path: "variants", Value: variants})
+	}
+
+	// Times can be recovered from the document itself; prices can't
+	for _, anomaly := range anomalies {
+		switch anomaly.Field {
+		case "created_at":
+			updates = append(updates, firestore.Update{Path: anomaly.Field, Value: doc.CreateTime})
+			result.Filled = append(result.Filled, anomaly.Field)
+		case "updated_at":
+			updates = append(updates, firestore.Update{Path: anomaly.Field, Value: doc.UpdateTime})
+			result.Filled = append(result.Filled, anomaly.Field)
+		default:
+			result.Anomalies = append(result.Anomalies, anomaly)
+		}
+	}
+	return result, updates
+}
+
+// variantField splits a field such as "variants[2].price" into the variant's index
+// and its field name
+func variantField(field string) (int, string, bool) {
+	rest, found := strings.CutPrefix(field, "variants[")
+	if !found {
+		return 0, "", false
+	}
+	index, name, found := strings.Cut(rest, "].")
+	if !found {
+		return 0, "", false
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return 0, "", false
+	}
+	return i, name, true
+}
+
+func printProduct(result productReport) {
+	fmt.Printf("%s (%s) %s\n", result.ProductID, result.SKU, result.Name)
+	if len(result.Converted) > 0 {
+		fmt.Printf("  converted: %s\n", strings.Join(result.Converted, ", "))
+	}
+	if len(result.Filled) > 0 {
+		fmt.Printf("  filled from document metadata: %s\n", strings.Join(result.Filled, ", "))
+	}
+	for _, anomaly := range result.Anomalies {
+		fmt.Printf("  to fix by hand: %s (stored %v)\n", anomaly, anomaly.Value)
+	}
+	if result.Error != "" {
+		fmt.Printf("  failed: %s\n", result.Error)
+	}
+}
@@ -16,7 +16,7 @@ func main() {
 	cfg := config.Load()
 
 	gin.SetMode(cfg.GinMode)
-	
+
 	db, err := database.NewFirebase(cfg)
 	if err != nil {
 		log.Fatal("Failed to initialize Firebase:", err)
@@ -32,9 +32,9 @@ func main() {
 	if err := whatsappService.Initialize(); err != nil {
 		log.Printf("Warning: Failed to initialize WhatsApp service: %v", err)
 	}
-	
+
 	authHandler := handlers.NewAuthHandler(db, cfg.JWTSecret)
-	productHandler := handlers.NewProductHandler(db)
+	productHandler := handlers.NewProductHandler(db, whatsappService)
 	paymentHandler := handlers.NewPaymentHandler(db, cfg.RazorpayKeyID, cfg.RazorpayKeySecret, cfg.RazorpayWebhookSecret, whatsappService)
 	orderHandler := handlers.NewOrderHandler(db, whatsappService)
 	categoryHandler := handlers.NewCategoryHandler(db)
@@ -42,20 +42,37 @@ func main() {
 	settingsHandler := handlers.NewSettingsHandler(db)
 	notificationHandler := handlers.NewNotificationHandler(db)
 	contactHandler := handlers.NewContactHandler(db)
-	
+
 	uploadHandler, err := handlers.NewUploadHandler()
 	if err != nil {
 		log.Printf("Warning: Failed to initialize upload handler: %v", err)
 	}
-	
+
 	promotionHandler := handlers.NewPromotionHandler(db)
+	promotionHandler.StartReservationSweep()
+	go handlers.ReserveExistingSlugs(db)
 	invoiceHandler := handlers.NewInvoiceHandler(db)
 	adminUserHandler := handlers.NewAdminUserHandler(db, cfg.JWTSecret)
-	
+
 	whatsappHandler := handlers.NewWhatsAppHandler(db, whatsappService)
 	analyticsHandler := handlers.NewAnalyticsHandler(db)
 	mobileAuthHandler := handlers.NewMobileAuthHandler(db, cfg.JWTSecret, cfg, whatsappService)
-	stockRequestHandler := handlers.NewStockRequestHandler(db)
+	stockRequestHandler := handlers.NewStockRequestHandler(db, whatsappService)
+	giftCardHandler := handlers.NewGiftCardHandler(db, whatsappService)
+	giftCardHandler.StartExpirySweep()
+	referralHandler := handlers.NewReferralHandler(db, giftCardHandler)
+	reviewHandler := handlers.NewReviewHandler(db)
+	questionHandler := handlers.NewQuestionHandler(db)
+	inventoryHandler := handlers.NewInventoryHandler(db, whatsappService)
+	inventoryHandler.StartHoldSweep()
+	stockAlertHandler := handlers.NewStockAlertHandler(db, whatsappService)
+	stockAlertHandler.StartDailyDigest()
+	productHandler.StartProductScheduler()
+	recommendationHandler := handlers.NewRecommendationHandler(db)
+	recommendationHandler.StartRefresh()
+	sitemapHandler := handlers.NewSitemapHandler(db, cfg.SiteURL)
+
+	r.GET("/sitemap.xml", sitemapHandler.GetSitemap)
 
 	api := r.Group("/api/v1")
 	{
@@ -66,7 +83,7 @@ func main() {
 		// App version endpoint for auto-update functionality
 		appHandler := handlers.NewAppHandler()
 		api.GET("/app/version", appHandler.GetVersion)
-		
+
 		// Simple test endpoint
 		api.GET("/test", func(c *gin.Context) {
 			c.JSON(200, gin.H{"message": "test endpoint working", "version": "1.0.1"})
@@ -88,13 +105,20 @@ func main() {
 		{
 			products.GET("", productHandler.GetProducts)
 			products.GET("/:id", productHandler.GetProduct)
+			products.GET("/slug/:slug", productHandler.GetProductBySlug)
 			products.GET("/search", productHandler.SearchProducts)
+			products.GET("/suggest", productHandler.SuggestProducts)
+			products.GET("/:id/reviews", reviewHandler.GetProductReviews)
+			products.GET("/:id/questions", questionHandler.GetProductQuestions)
+			products.GET("/:id/related", recommendationHandler.GetRelatedProducts)
+			products.POST("/complete-the-set", recommendationHandler.CompleteTheSet)
 		}
 
 		categories := api.Group("/categories")
 		{
 			categories.GET("", categoryHandler.GetCategories)
 			categories.GET("/:id", categoryHandler.GetCategory)
+			categories.GET("/slug/:slug", categoryHandler.GetCategoryBySlug)
 		}
 
 		// Public content endpoints
@@ -106,21 +130,26 @@ func main() {
 
 		// Public settings endpoint (for shipping rates, tax, etc)
 		api.GET("/settings/public", settingsHandler.GetPublicSettings)
-		
+
 		// Contact form submission (public)
 		api.POST("/contact", contactHandler.SubmitContactMessage)
-		
+
 		// Promotion validation (public)
 		api.POST("/promotions/validate", promotionHandler.ValidatePromotion)
 		api.GET("/promotions/active", promotionHandler.GetActivePromotions)
-		
+		api.POST("/promotions/evaluate", promotionHandler.EvaluateOffers)
+		api.POST("/checkout/quote", orderHandler.QuoteOrder)
+
+		// Gift card balance check (public, requires code and PIN)
+		api.POST("/gift-cards/balance", giftCardHandler.CheckBalance)
+
 		// WhatsApp OTP (public endpoints)
 		api.POST("/whatsapp/send-otp", whatsappHandler.SendOTP)
 		api.POST("/whatsapp/verify-otp", whatsappHandler.VerifyOTP)
-		
+
 		// Order tracking redirect (public endpoint)
 		api.GET("/track/:orderNumber", orderHandler.GetTrackingRedirect)
-		
+
 		// Analytics tracking (public endpoints)
 		api.POST("/analytics/track/visit", analyticsHandler.TrackPageVisit)
 		api.POST("/analytics/track/action", analyticsHandler.TrackUserAction)
@@ -131,6 +160,7 @@ func main() {
 		api.GET("/guest/orders/:id", orderHandler.GetGuestOrder)
 		api.POST("/guest/payment/create-order", paymentHandler.CreateGuestRazorpayOrder)
 		api.POST("/guest/payment/verify", paymentHandler.VerifyGuestPayment)
+		api.POST("/guest/payment/gift-card", paymentHandler.CompleteGiftCardPayment)
 
 		protected := api.Group("")
 		protected.Use(middleware.AuthMiddleware(cfg.JWTSecret))
@@ -151,6 +181,21 @@ func main() {
 			protected.POST("/stock-requests", stockRequestHandler.CreateStockRequest)
 			protected.GET("/stock-requests", stockRequestHandler.GetUserStockRequests)
 
+			// Product reviews
+			protected.POST("/reviews", reviewHandler.CreateReview)
+			protected.GET("/reviews/mine", reviewHandler.GetMyReviews)
+			protected.PUT("/reviews/:id", reviewHandler.UpdateReview)
+			protected.DELETE("/reviews/:id", reviewHandler.DeleteReview)
+
+			// Product questions and answers
+			protected.POST("/questions", questionHandler.AskQuestion)
+			protected.GET("/questions/mine", questionHandler.GetMyQuestions)
+			protected.POST("/questions/:id/answers", questionHandler.AnswerQuestion)
+
+			// Referral program
+			protected.GET("/referrals", referralHandler.GetMyReferrals)
+			protected.POST("/referrals/apply", referralHandler.ApplyReferralCode)
+
 			// Order endpoints
 			orders := protected.Group("/orders")
 			{
@@ -158,7 +203,7 @@ func main() {
 				orders.GET("", orderHandler.GetUserOrders)
 				orders.GET("/:id", orderHandler.GetOrder)
 			}
-			
+
 			// Invoice endpoints (for logged-in users)
 			invoices := protected.Group("/invoices")
 			{
@@ -171,6 +216,7 @@ func main() {
 			{
 				payment.POST("/create-order", paymentHandler.CreateRazorpayOrder)
 				payment.POST("/verify", paymentHandler.VerifyPayment)
+				payment.POST("/gift-card", paymentHandler.CompleteGiftCardPayment)
 			}
 		}
 
@@ -204,6 +250,62 @@ func main() {
 			admin.POST("/products", middleware.RequirePermission(models.PermissionProductsCreate), productHandler.CreateProduct)
 			admin.PUT("/products/:id", middleware.RequirePermission(models.PermissionProductsEdit), productHandler.UpdateProduct)
 			admin.DELETE("/products/:id", middleware.RequirePermission(models.PermissionProductsDelete), productHandler.DeleteProduct)
+			admin.POST("/products/search/reindex", middleware.RequirePermission(models.PermissionProductsEdit), productHandler.RebuildSearchIndex)
+			admin.POST("/products/import", middleware.RequirePermission(models.PermissionProductsCreate), productHandler.ImportProducts)
+			admin.GET("/products/export", middleware.RequirePermission(models.PermissionProductsView), productHandler.ExportProducts)
+			admin.GET("/products/imports", middleware.RequirePermission(models.PermissionProductsView), productHandler.GetProductImports)
+			admin.GET("/products/imports/:id", middleware.RequirePermission(models.PermissionProductsView), productHandler.GetProductImport)
+			admin.GET("/products/schedules", middleware.RequirePermission(models.PermissionProductsView), productHandler.GetUpcomingSchedules)
+			admin.GET("/products/:id/schedules", middleware.RequirePermission(models.PermissionProductsView), productHandler.GetProductSchedules)
+			admin.POST("/products/:id/schedules", middleware.RequirePermission(models.PermissionProductsPublish), productHandler.CreateProductSchedule)
+			admin.DELETE("/products/:id/schedules/:scheduleId", middleware.RequirePermission(models.PermissionProductsPublish), productHandler.CancelProductSchedule)
+
+			// Product drafts and revisions; edits without the publish permission are saved as drafts
+			admin.GET("/products/drafts", middleware.RequirePermission(models.PermissionProductsView), productHandler.GetProductDrafts)
+			admin.GET("/products/:id/draft", middleware.RequirePermission(models.PermissionProductsView), productHandler.GetProductDraft)
+			admin.PUT("/products/:id/draft", middleware.RequirePermission(models.PermissionProductsEdit), productHandler.SaveProductDraft)
+			admin.DELETE("/products/:id/draft", middleware.RequirePermission(models.PermissionProductsEdit), productHandler.DiscardProductDraft)
+			admin.POST("/products/:id/draft/publish", middleware.RequirePermission(models.PermissionProductsPublish), productHandler.PublishProductDraft)
+			admin.GET("/products/:id/revisions", middleware.RequirePermission(models.PermissionProductsView), productHandler.GetProductRevisions)
+			admin.GET("/products/:id/revisions/:revisionId", middleware.RequirePermission(models.PermissionProductsView), productHandler.GetProductRevision)
+			admin.POST("/products/:id/revisions/:revisionId/rollback", middleware.RequirePermission(models.PermissionProductsPublish), productHandler.RollbackProduct)
+			admin.POST("/products/recommendations/refresh", middleware.RequirePermission(models.PermissionProductsEdit), recommendationHandler.RefreshRecommendations)
+
+			// Inventory ledger
+			admin.GET("/inventory/movements", middleware.RequirePermission(models.PermissionProductsView), inventoryHandler.GetStockMovements)
+			admin.POST("/inventory/adjustments", middleware.RequirePermission(models.PermissionProductsEdit), inventoryHandler.AdjustStock)
+			admin.POST("/inventory/stocktake", middleware.RequirePermission(models.PermissionProductsEdit), inventoryHandler.RecordStocktake)
+			admin.GET("/inventory/reconcile", middleware.RequirePermission(models.PermissionProductsView), inventoryHandler.GetStockReconciliation)
+			admin.POST("/inventory/reconcile", middleware.RequirePermission(models.PermissionProductsEdit), inventoryHandler.ReconcileStock)
+			admin.GET("/inventory/locations", middleware.RequirePermission(models.PermissionProductsView), inventoryHandler.GetStockLocations)
+			admin.POST("/inventory/locations", middleware.RequirePermission(models.PermissionProductsEdit), inventoryHandler.CreateStockLocation)
+			admin.PUT("/inventory/locations/:id", middleware.RequirePermission(models.PermissionProductsEdit), inventoryHandler.UpdateStockLocation)
+			admin.DELETE("/inventory/locations/:id", middleware.RequirePermission(models.PermissionProductsEdit), inventoryHandler.DeleteStockLocation)
+			admin.GET("/inventory/locations/:id/stock", middleware.RequirePermission(models.PermissionProductsView), inventoryHandler.GetLocationStock)
+			admin.POST("/inventory/transfers", middleware.RequirePermission(models.PermissionProductsEdit), inventoryHandler.TransferStock)
+			admin.GET("/inventory/low-stock", middleware.RequirePermission(models.PermissionProductsView), stockAlertHandler.GetLowStock)
+			admin.POST("/inventory/low-stock/digest", middleware.RequirePermission(models.PermissionProductsEdit), stockAlertHandler.SendLowStockDigest)
+
+			// Review moderation
+			admin.GET("/reviews", middleware.RequirePermission(models.PermissionProductsView), reviewHandler.GetAdminReviews)
+			admin.PUT("/reviews/:id/moderate", middleware.RequirePermission(models.PermissionProductsEdit), reviewHandler.ModerateReview)
+			admin.PUT("/reviews/:id/reply", middleware.RequirePermission(models.PermissionProductsEdit), reviewHandler.ReplyToReview)
+			admin.DELETE("/reviews/:id", middleware.RequirePermission(models.PermissionProductsEdit), reviewHandler.AdminDeleteReview)
+
+			// Product questions and answers
+			admin.GET("/questions", middleware.RequirePermission(models.PermissionProductsView), questionHandler.GetAdminQuestions)
+			admin.POST("/questions/:id/answers", middleware.RequirePermission(models.PermissionProductsEdit), questionHandler.AdminAnswerQuestion)
+			admin.PUT("/questions/:id/moderate", middleware.RequirePermission(models.PermissionProductsEdit), questionHandler.ModerateQuestion)
+			admin.PUT("/questions/:id/answers/:answerId/moderate", middleware.RequirePermission(models.PermissionProductsEdit), questionHandler.ModerateAnswer)
+			admin.POST("/questions/:id/invite", middleware.RequirePermission(models.PermissionProductsEdit), questionHandler.InviteAnswers)
+			admin.DELETE("/questions/:id", middleware.RequirePermission(models.PermissionProductsEdit), questionHandler.DeleteQuestion)
+
+			// Search synonyms and insights
+			admin.GET("/search/synonyms", middleware.RequirePermission(models.PermissionProductsView), productHandler.GetSearchSynonyms)
+			admin.POST("/search/synonyms", middleware.RequirePermission(models.PermissionProductsEdit), productHandler.CreateSearchSynonym)
+			admin.PUT("/search/synonyms/:id", middleware.RequirePermission(models.PermissionProductsEdit), productHandler.UpdateSearchSynonym)
+			admin.DELETE("/search/synonyms/:id", middleware.RequirePermission(models.PermissionProductsEdit), productHandler.DeleteSearchSynonym)
+			admin.GET("/search/insights", middleware.RequirePermission(models.PermissionAnalyticsView), productHandler.GetSearchInsights)
 
 			// Category management with RBAC
 			admin.POST("/categories", middleware.RequirePermission(models.PermissionCategoriesCreate), categoryHandler.CreateCategory)
@@ -215,6 +317,7 @@ func main() {
 			admin.GET("/orders", middleware.RequirePermission(models.PermissionOrdersView), orderHandler.GetAllOrders)
 			admin.PUT("/orders/:id/status", middleware.RequirePermission(models.PermissionOrdersEdit), orderHandler.UpdateOrderStatus)
 			admin.PATCH("/orders/:id/status", middleware.RequirePermission(models.PermissionOrdersEdit), orderHandler.UpdateOrderStatus)
+			admin.POST("/orders/:id/allocate", middleware.RequirePermission(models.PermissionOrdersEdit), inventoryHandler.AllocateOrder)
 
 			// Customer management with RBAC (regular customers from users collection)
 			admin.GET("/customers", middleware.RequirePermission(models.PermissionUsersView), authHandler.GetAllUsers)
@@ -226,10 +329,10 @@ func main() {
 			// Content management endpoints (admin only)
 			admin.GET("/content/:type", contentHandler.GetContentAdmin)
 			admin.PUT("/content/:type", contentHandler.UpdateContent)
-			
+
 			// FAQ management
 			admin.PUT("/faqs", contentHandler.UpdateFAQs)
-			
+
 			// Email template management (admin only)
 			emailTemplateHandler := handlers.NewEmailTemplateHandler(db)
 			admin.GET("/email-templates", emailTemplateHandler.GetTemplates)
@@ -240,38 +343,63 @@ func main() {
 			admin.DELETE("/email-templates/:id", emailTemplateHandler.DeleteTemplate)
 			admin.POST("/email-templates/:id/set-default", emailTemplateHandler.SetDefaultTemplate)
 			admin.POST("/email-templates/test", emailTemplateHandler.TestTemplate)
-			
-			
+
 			// Settings management
 			admin.GET("/settings", settingsHandler.GetSettings)
 			admin.PUT("/settings", settingsHandler.UpdateSettings)
-			
+
 			// Notifications management
 			admin.GET("/notifications", notificationHandler.GetNotifications)
 			admin.PUT("/notifications/:id/read", notificationHandler.MarkAsRead)
 			admin.PUT("/notifications/read-all", notificationHandler.MarkAllAsRead)
 			admin.DELETE("/notifications/:id", notificationHandler.DeleteNotification)
 			admin.DELETE("/notifications", notificationHandler.ClearAllNotifications)
-			
+
 			// Contact messages management (admin only)
 			admin.GET("/contact-messages", contactHandler.GetContactMessages)
 			admin.GET("/contact-messages/:id", contactHandler.GetContactMessage)
 			admin.PUT("/contact-messages/:id", contactHandler.UpdateContactMessage)
 			admin.DELETE("/contact-messages/:id", contactHandler.DeleteContactMessage)
-			
+
 			// Image upload endpoints (admin only)
 			if uploadHandler != nil {
 				admin.POST("/upload/image", uploadHandler.UploadImage)
 				admin.DELETE("/upload/image/*path", uploadHandler.DeleteImage)
 			}
-			
+
 			// Promotion management (admin only)
 			admin.GET("/promotions", promotionHandler.GetPromotions)
 			admin.POST("/promotions", promotionHandler.CreatePromotion)
 			admin.PUT("/promotions/:id", promotionHandler.UpdatePromotion)
 			admin.DELETE("/promotions/:id", promotionHandler.DeletePromotion)
+			admin.GET("/promotions/analytics", promotionHandler.ComparePromotionAnalytics)
+			admin.GET("/promotions/analytics/export", promotionHandler.ExportPromotionComparison)
+			admin.GET("/promotions/:id/usage", promotionHandler.GetPromotionUsage)
+			admin.GET("/promotions/:id/analytics", promotionHandler.GetPromotionAnalytics)
+			admin.GET("/promotions/:id/analytics/export", promotionHandler.ExportPromotionAnalytics)
+			admin.GET("/promotions/:id/codes", promotionHandler.GetPromotionCodes)
+			admin.POST("/promotions/:id/codes", promotionHandler.GeneratePromotionCodes)
+			admin.GET("/promotions/:id/codes/export", promotionHandler.ExportPromotionCodes)
+			admin.POST("/promotions/:id/codes/:code/void", promotionHandler.VoidPromotionCode)
 			admin.POST("/promotions/initialize", promotionHandler.InitializeDefaultPromotions)
-			
+			admin.GET("/promotions/automatic", promotionHandler.GetAutomaticPromotions)
+			admin.POST("/promotions/automatic", promotionHandler.CreateAutomaticPromotion)
+			admin.PUT("/promotions/automatic/:id", promotionHandler.UpdateAutomaticPromotion)
+			admin.PUT("/promotions/automatic/:id/status", promotionHandler.SetAutomaticPromotionStatus)
+			admin.DELETE("/promotions/automatic/:id", promotionHandler.DeletePromotion)
+
+			// Gift card management (admin only)
+			admin.GET("/gift-cards", giftCardHandler.GetGiftCards)
+			admin.GET("/gift-cards/:id", giftCardHandler.GetGiftCard)
+			admin.POST("/gift-cards", giftCardHandler.IssueGiftCard)
+			admin.POST("/gift-cards/:id/void", giftCardHandler.VoidGiftCard)
+			admin.POST("/gift-cards/:id/resend", giftCardHandler.ResendGiftCard)
+
+			// Referral report and review (admin only)
+			admin.GET("/referrals", referralHandler.GetReferrals)
+			admin.POST("/referrals/:id/approve", referralHandler.ApproveReferral)
+			admin.POST("/referrals/:id/reject", referralHandler.RejectReferral)
+
 			// Invoice management (admin only)
 			admin.GET("/invoices", invoiceHandler.ListInvoices)
 			admin.GET("/invoices/:id", invoiceHandler.GetInvoice)
@@ -280,26 +408,26 @@ func main() {
 			admin.PUT("/invoices/:id/status", invoiceHandler.UpdateInvoiceStatus)
 			admin.DELETE("/invoices/:id", invoiceHandler.DeleteInvoice)
 			admin.GET("/invoices/stats", invoiceHandler.GetInvoiceStats)
-			
+
 			// WhatsApp management (admin only)
 			whatsapp := admin.Group("/whatsapp")
 			{
 				// Template management
 				whatsapp.GET("/templates", whatsappHandler.GetTemplates)
 				whatsapp.POST("/templates", whatsappHandler.CreateTemplate)
-				
+
 				// Message management
 				whatsapp.POST("/send", whatsappHandler.SendMessage)
 				whatsapp.POST("/send-bulk", whatsappHandler.SendBulkMessages)
 				whatsapp.GET("/messages", whatsappHandler.GetMessages)
-				
+
 				// Contact management
 				whatsapp.GET("/contacts", whatsappHandler.GetContacts)
-				
+
 				// Campaign management
 				whatsapp.GET("/campaigns", whatsappHandler.GetCampaigns)
 			}
-			
+
 			// Analytics management (admin only)
 			analytics := admin.Group("/analytics")
 			{
@@ -307,7 +435,7 @@ func main() {
 				analytics.GET("/visits", analyticsHandler.GetVisitsStatistics)
 				analytics.GET("/instagram", analyticsHandler.GetInstagramAdPerformance)
 			}
-			
+
 			// Stock requests management (admin only)
 			stockAdmin := admin.Group("/stock-requests")
 			{
@@ -318,7 +446,7 @@ func main() {
 		}
 
 		api.POST("/webhook/razorpay", paymentHandler.RazorpayWebhook)
-		
+
 		// WhatsApp webhook (public endpoint)
 		api.Any("/webhook/whatsapp", whatsappHandler.Webhook)
 	}
@@ -327,4 +455,4 @@ func main() {
 	if err := r.Run(":" + cfg.Port); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
-}
\ No newline at end of file
+}
@@ -17,6 +17,7 @@ type Config struct {
 	RazorpayWebhookSecret string
 	JWTSecret             string
 	CORSOrigin            string
+	SiteURL               string // Storefront address, for links in the sitemap
 	StorageBucket         string
 	// WhatsApp Business API
 	WhatsAppAccessToken   string
@@ -43,6 +44,7 @@ func Load() *Config {
 		RazorpayWebhookSecret: getEnv("RAZORPAY_WEBHOOK_SECRET", ""),
 		JWTSecret:             getEnv("JWT_SECRET", "your-secret-key"),
 		CORSOrigin:            getEnv("CORS_ORIGIN", "http://localhost:5173"),
+		SiteURL:               getEnv("SITE_URL", "https://tripundlifestyle.com"),
 		StorageBucket:         getEnv("STORAGE_BUCKET", ""),
 		// WhatsApp Business API
 		WhatsAppAccessToken:   getEnv("WHATSAPP_ACCESS_TOKEN", ""),
@@ -95,7 +95,7 @@ func (f *Firebase) GetProductByID(productID string) (*models.Product, error) {
 	}
 
 	var product models.Product
-	if err := doc.DataTo(&product); err != nil {
+	if err := DecodeProduct(doc, &product); err != nil {
 		return nil, err
 	}
 	product.ID = doc.Ref.ID
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"log"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+	"tripund-api/internal/models"
+)
+
+// loggedLegacyProducts remembers the legacy products already logged, so each is
+// reported once rather than on every read
+var loggedLegacyProducts sync.Map
+
+// DecodeProduct reads a product document into product like DataTo, also accepting
+// documents that hold prices and times as strings or integers. Values that can't be
+// read are zero and logged; the migrate-products command fixes the stored documents.
+func DecodeProduct(doc *firestore.DocumentSnapshot, product *models.Product) error {
+	if err := doc.DataTo(product); err == nil {
+		return nil
+	}
+
+	var legacy models.LegacyProduct
+	if err := doc.DataTo(&legacy); err != nil {
+		return err
+	}
+	normalized, _, anomalies := legacy.Normalize()
+	*product = normalized
+
+	if _, logged := loggedLegacyProducts.LoadOrStore(doc.Ref.ID, true); !logged {
+		log.Printf("Product %s has legacy price or time values, run migrate-products to fix them", doc.Ref.ID)
+		for _, anomaly := range anomalies {
+			log.Printf("Product %s: %s", doc.Ref.ID, anomaly)
+		}
+	}
+	return nil
+}
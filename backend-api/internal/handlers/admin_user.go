@@ -597,7 +597,7 @@ func (h *AdminUserHandler) getRolePermissions(role string) []string {
 	case models.RoleSuperAdmin:
 		return []string{
 			models.PermissionUsersView, models.PermissionUsersCreate, models.PermissionUsersEdit, models.PermissionUsersDelete,
-			models.PermissionProductsView, models.PermissionProductsCreate, models.PermissionProductsEdit, models.PermissionProductsDelete,
+			models.PermissionProductsView, models.PermissionProductsCreate, models.PermissionProductsEdit, models.PermissionProductsDelete, models.PermissionProductsPublish,
 			models.PermissionOrdersView, models.PermissionOrdersEdit, models.PermissionOrdersDelete, models.PermissionOrdersRefund,
 			models.PermissionCategoriesView, models.PermissionCategoriesCreate, models.PermissionCategoriesEdit, models.PermissionCategoriesDelete,
 			models.PermissionAnalyticsView, models.PermissionReportsView, models.PermissionReportsExport,
@@ -607,7 +607,7 @@ func (h *AdminUserHandler) getRolePermissions(role string) []string {
 	case models.RoleAdmin:
 		return []string{
 			models.PermissionUsersView, models.PermissionUsersCreate, models.PermissionUsersEdit,
-			models.PermissionProductsView, models.PermissionProductsCreate, models.PermissionProductsEdit, models.PermissionProductsDelete,
+			models.PermissionProductsView, models.PermissionProductsCreate, models.PermissionProductsEdit, models.PermissionProductsDelete, models.PermissionProductsPublish,
 			models.PermissionOrdersView, models.PermissionOrdersEdit, models.PermissionOrdersRefund,
 			models.PermissionCategoriesView, models.PermissionCategoriesCreate, models.PermissionCategoriesEdit, models.PermissionCategoriesDelete,
 			models.PermissionAnalyticsView, models.PermissionReportsView, models.PermissionReportsExport,
@@ -615,7 +615,7 @@ func (h *AdminUserHandler) getRolePermissions(role string) []string {
 		}
 	case models.RoleManager:
 		return []string{
-			models.PermissionProductsView, models.PermissionProductsCreate, models.PermissionProductsEdit,
+			models.PermissionProductsView, models.PermissionProductsCreate, models.PermissionProductsEdit, models.PermissionProductsPublish,
 			models.PermissionOrdersView, models.PermissionOrdersEdit,
 			models.PermissionCategoriesView, models.PermissionCategoriesEdit,
 			models.PermissionAnalyticsView, models.PermissionReportsView,
@@ -717,6 +717,7 @@ func (h *AdminUserHandler) getSystemPermissions() []models.Permission {
 		{ID: models.PermissionProductsCreate, Name: models.PermissionProductsCreate, DisplayName: "Create Products", Description: "Create new products", Category: "Product Management", IsSystem: true},
 		{ID: models.PermissionProductsEdit, Name: models.PermissionProductsEdit, DisplayName: "Edit Products", Description: "Edit product details", Category: "Product Management", IsSystem: true},
 		{ID: models.PermissionProductsDelete, Name: models.PermissionProductsDelete, DisplayName: "Delete Products", Description: "Delete products", Category: "Product Management", IsSystem: true},
+		{ID: models.PermissionProductsPublish, Name: models.PermissionProductsPublish, DisplayName: "Publish Products", Description: "Publish product edits and roll back to earlier revisions", Category: "Product Management", IsSystem: true},
 		
 		// Order Management
 		{ID: models.PermissionOrdersView, Name: models.PermissionOrdersView, DisplayName: "View Orders", Description: "View orders list", Category: "Order Management", IsSystem: true},
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+)
+
+// loadProducts fetches products by ID, leaving out those that don't exist
+func loadProducts(db *database.Firebase, productIDs []string) (map[string]*models.Product, error) {
+	products := make(map[string]*models.Product)
+	var refs []*firestore.DocumentRef
+	for _, productID := range productIDs {
+		if productID != "" {
+			refs = append(refs, db.Client.Collection("products").Doc(productID))
+		}
+	}
+	if len(refs) == 0 {
+		return products, nil
+	}
+
+	docs, err := db.Client.GetAll(db.Context, refs)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil {
+			return nil, err
+		}
+		product.ID = doc.Ref.ID
+		products[product.ID] = &product
+	}
+	return products, nil
+}
+
+// componentStock is the sellable stock of a bundle component's product or variant
+func componentStock(product *models.Product, variantID string) int {
+	if variantID != "" && len(product.Variants) > 0 {
+		i := variantIndex(product, variantID)
+		if i < 0 || !product.Variants[i].Available {
+			return 0
+		}
+		return product.Variants[i].StockQuantity
+	}
+	return product.StockQuantity
+}
+
+// bundleStock is how many complete bundles the components' stock makes up
+func bundleStock(bundle *models.Product, components map[string]*models.Product) int {
+	if len(bundle.BundleComponents) == 0 {
+		return 0
+	}
+
+	// A component listed twice needs its quantities together
+	needed := make(map[string]int)
+	for _, component := range bundle.BundleComponents {
+		needed[ledgerKey(component.ProductID, component.VariantID, "")] += max(component.Quantity, 1)
+	}
+
+	stock := -1
+	for _, component := range bundle.BundleComponents {
+		product := components[component.ProductID]
+		if product == nil {
+			return 0
+		}
+		complete := componentStock(product, component.VariantID) / needed[ledgerKey(component.ProductID, component.VariantID, "")]
+		if stock < 0 || complete < stock {
+			stock = complete
+		}
+	}
+	return max(stock, 0)
+}
+
+// bundleProductIDs lists a bundle's component product IDs once each
+func bundleProductIDs(bundle *models.Product) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, component := range bundle.BundleComponents {
+		if !seen[component.ProductID] {
+			seen[component.ProductID] = true
+			ids = append(ids, component.ProductID)
+		}
+	}
+	return ids
+}
+
+// prepareBundle checks a bundle's components and sets the fields derived from them:
+// the component product IDs and the stock. Errors describe what is wrong with the
+// components.
+func (h *InventoryHandler) prepareBundle(bundle *models.Product) error {
+	if len(bundle.BundleComponents) == 0 {
+		return fmt.Errorf("a bundle needs at least one component")
+	}
+	if len(bundle.Variants) > 0 {
+		return fmt.Errorf("bundles can't have variants; add the variants as components instead")
+	}
+
+	bundle.BundleProductIDs = bundleProductIDs(bundle)
+	components, err := loadProducts(h.db, bundle.BundleProductIDs)
+	if err != nil {
+		return fmt.Errorf("failed to load bundle components: %v", err)
+	}
+	for _, component := range bundle.BundleComponents {
+		product := components[component.ProductID]
+		switch {
+		case component.Quantity < 1:
+			return fmt.Errorf("component %s needs a quantity of at least 1", component.ProductID)
+		case product == nil:
+			return fmt.Errorf("component product %s not found", component.ProductID)
+		case product.ID == bundle.ID:
+			return fmt.Errorf("a bundle can't contain itself")
+		case product.IsBundle() || product.IsGiftCard():
+			return fmt.Errorf("%s can't be a bundle component", product.Name)
+		case component.VariantID == "" && len(product.Variants) > 0:
+			return fmt.Errorf("choose a variant of %s", product.Name)
+		case component.VariantID != "" && variantIndex(product, component.VariantID) < 0:
+			return fmt.Errorf("variant %s of %s not found", component.VariantID, product.Name)
+		}
+	}
+
+	bundle.ManageStock = true
+	bundle.StockQuantity = bundleStock(bundle, components)
+	return nil
+}
+
+// bundleForUpdate applies a product edit's type and components to the stored product
+// and checks them, if the result is a bundle. It returns nil for other products.
+func (h *InventoryHandler) bundleForUpdate(productID string, updates map[string]interface{}) (*models.Product, error) {
+	productType, typeUpdated := updates["product_type"].(string)
+	_, componentsUpdated := updates["bundle_components"]
+	if typeUpdated && productType != models.ProductTypeBundle {
+		return nil, nil
+	}
+
+	doc, err := h.db.Client.Collection("products").Doc(productID).Get(h.db.Context)
+	if err != nil {
+		return nil, err
+	}
+	var product models.Product
+	if err := database.DecodeProduct(doc, &product); err != nil {
+		return nil, err
+	}
+	product.ID = productID
+	if !product.IsBundle() && !typeUpdated {
+		return nil, nil
+	}
+	product.ProductType = models.ProductTypeBundle
+
+	if componentsUpdated {
+		data, err := json.Marshal(updates["bundle_components"])
+		if err != nil {
+			return nil, err
+		}
+		product.BundleComponents = nil
+		if err := json.Unmarshal(data, &product.BundleComponents); err != nil {
+			return nil, fmt.Errorf("invalid bundle components: %v", err)
+		}
+	}
+	if variants, ok := updates["variants"].([]interface{}); ok {
+		if len(variants) > 0 {
+			return nil, fmt.Errorf("bundles can't have variants; add the variants as components instead")
+		}
+		product.Variants = nil
+	}
+
+	// The edit saves the components; refreshBundleStock then saves the stock they make up
+	stock := product.StockQuantity
+	if err := h.prepareBundle(&product); err != nil {
+		return nil, err
+	}
+	product.StockQuantity = stock
+	return &product, nil
+}
+
+// refreshBundleStock recomputes a bundle's stock from its components, saving it and
+// telling anyone who asked for the bundle if it came back into stock
+func (h *InventoryHandler) refreshBundleStock(bundle *models.Product) error {
+	components, err := loadProducts(h.db, bundleProductIDs(bundle))
+	if err != nil {
+		return err
+	}
+	stock := bundleStock(bundle, components)
+	if stock == bundle.StockQuantity {
+		return nil
+	}
+
+	_, err = h.db.Client.Collection("products").Doc(bundle.ID).Update(h.db.Context, []firestore.Update{
+		{Path: "stock_quantity", Value: stock},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	productListings.clear()
+	after := *bundle
+	after.StockQuantity = stock
+	go h.stockRequests.NotifyBackInStock(bundle, &after)
+	return nil
+}
+
+// refreshBundles recomputes the stock of the bundles a product is a component of
+func (h *InventoryHandler) refreshBundles(productID string) {
+	docs, err := h.db.Client.Collection("products").Where("bundle_product_ids", "array-contains", productID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		log.Printf("Failed to find bundles containing product %s: %v", productID, err)
+		return
+	}
+	for _, doc := range docs {
+		var bundle models.Product
+		if err := database.DecodeProduct(doc, &bundle); err != nil {
+			continue
+		}
+		bundle.ID = doc.Ref.ID
+		if err := h.refreshBundleStock(&bundle); err != nil {
+			log.Printf("Failed to refresh stock of bundle %s: %v", bundle.ID, err)
+		}
+	}
+}
+
+// orderBundleComponents records a bundle's contents on an order item. The bundle's
+// price is shared among its components by their own prices, for the invoice's HSN split.
+func orderBundleComponents(db *database.Firebase, bundle *models.Product) ([]models.OrderBundleComponent, error) {
+	products, err := loadProducts(db, bundleProductIDs(bundle))
+	if err != nil {
+		return nil, err
+	}
+
+	components := make([]models.OrderBundleComponent, 0, len(bundle.BundleComponents))
+	weights := make([]float64, 0, len(bundle.BundleComponents))
+	total := 0.0
+	for _, component := range bundle.BundleComponents {
+		product := products[component.ProductID]
+		if product == nil {
+			return nil, fmt.Errorf("bundle component %s not found", component.ProductID)
+		}
+		orderComponent := models.OrderBundleComponent{
+			ProductID:   product.ID,
+			VariantID:   component.VariantID,
+			ProductName: product.Name,
+			SKU:         product.SKU,
+			Quantity:    max(component.Quantity, 1),
+			HSNCode:     product.HSNCode,
+		}
+		price := product.EffectivePrice()
+		if component.VariantID != "" {
+			if i := variantIndex(product, component.VariantID); i >= 0 {
+				orderComponent.SKU = product.Variants[i].SKU
+				price = product.VariantPrice(&product.Variants[i])
+			}
+		}
+		weight := price * float64(orderComponent.Quantity)
+		components = append(components, orderComponent)
+		weights = append(weights, weight)
+		total += weight
+	}
+
+	for i := range components {
+		if total > 0 {
+			components[i].ValueShare = weights[i] / total
+		} else {
+			components[i].ValueShare = 1 / float64(len(components))
+		}
+	}
+	return components, nil
+}
+
+// applyBundleItem marks an order line as a bundle and records its contents
+func applyBundleItem(db *database.Firebase, orderItem *models.OrderItem, bundle *models.Product) error {
+	components, err := orderBundleComponents(db, bundle)
+	if err != nil {
+		return err
+	}
+	orderItem.ProductType = models.ProductTypeBundle
+	orderItem.BundleComponents = components
+	return nil
+}
@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
 	"sort"
 	"time"
 
+	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"tripund-api/internal/database"
 	"tripund-api/internal/models"
 )
@@ -148,6 +154,28 @@ func (h *CategoryHandler) GetCategory(c *gin.Context) {
 		return
 	}
 
+	c.JSON(http.StatusOK, categoryFromDoc(doc))
+}
+
+// GetCategoryBySlug returns the category with a slug. A slug the category used to have
+// redirects permanently to its current one.
+func (h *CategoryHandler) GetCategoryBySlug(c *gin.Context) {
+	slug := c.Param("slug")
+	doc, redirected, err := resolveSlug(h.db, models.SlugKindCategory, slug)
+	if err != nil {
+		slugLookupFailed(c, models.SlugKindCategory, err)
+		return
+	}
+	if redirected {
+		redirectToSlug(c, slug, doc)
+		return
+	}
+	c.JSON(http.StatusOK, categoryFromDoc(doc))
+}
+
+// categoryFromDoc reads a category document, tolerating the differently typed fields
+// older documents have
+func categoryFromDoc(doc *firestore.DocumentSnapshot) models.Category {
 	var category models.Category
 	data := doc.Data()
 	
@@ -213,7 +241,7 @@ func (h *CategoryHandler) GetCategory(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, category)
+	return category
 }
 
 func (h *CategoryHandler) CreateCategory(c *gin.Context) {
@@ -223,10 +251,35 @@ func (h *CategoryHandler) CreateCategory(c *gin.Context) {
 		return
 	}
 
+	// Slugs are unique; one left blank comes from the name
+	slug, err := settleSlug(h.db, models.SlugKindCategory, "", category.Slug, category.Name)
+	var taken slugTakenError
+	if errors.As(err, &taken) {
+		c.JSON(http.StatusConflict, gin.H{"error": taken.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+		return
+	}
+	category.Slug = slug
+
 	category.CreatedAt = time.Now()
 	category.UpdatedAt = time.Now()
 
-	docRef, _, err := h.db.Client.Collection("categories").Add(h.db.Context, category)
+	// The category and its slug are saved together
+	docRef := h.db.Client.Collection("categories").NewDoc()
+	err = h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := tx.Create(docRef, category); err != nil {
+			return err
+		}
+		return reserveSlug(tx, h.db, models.SlugKindCategory, category.Slug, "", docRef.ID)
+	})
+	err = slugConflict(err, models.SlugKindCategory, category.Slug)
+	if errors.As(err, &taken) {
+		c.JSON(http.StatusConflict, gin.H{"error": taken.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
 		return
@@ -255,6 +308,27 @@ func (h *CategoryHandler) UpdateCategory(c *gin.Context) {
 	// Get existing data
 	existingData := doc.Data()
 
+	// Slugs stay unique, and a changed one keeps leading to the category
+	oldSlug, _ := existingData["slug"].(string)
+	if value, ok := updates["slug"]; ok {
+		requested, _ := value.(string)
+		name, ok := updates["name"].(string)
+		if !ok {
+			name, _ = existingData["name"].(string)
+		}
+		slug, err := settleSlug(h.db, models.SlugKindCategory, categoryID, requested, name)
+		var taken slugTakenError
+		if errors.As(err, &taken) {
+			c.JSON(http.StatusConflict, gin.H{"error": taken.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
+			return
+		}
+		updates["slug"] = slug
+	}
+
 	// Merge updates with existing data
 	for key, value := range updates {
 		// Skip fields that shouldn't be updated directly
@@ -267,8 +341,25 @@ func (h *CategoryHandler) UpdateCategory(c *gin.Context) {
 	// Always update the timestamp
 	existingData["updated_at"] = time.Now()
 
-	// Use Set with merge to update the document
-	_, err = h.db.Client.Collection("categories").Doc(categoryID).Set(h.db.Context, existingData)
+	// Save the category together with a changed slug
+	newSlug, _ := updates["slug"].(string)
+	_, slugUpdated := updates["slug"]
+	categoryRef := h.db.Client.Collection("categories").Doc(categoryID)
+	err = h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := tx.Set(categoryRef, existingData); err != nil {
+			return err
+		}
+		if !slugUpdated {
+			return nil
+		}
+		return reserveSlug(tx, h.db, models.SlugKindCategory, newSlug, oldSlug, categoryID)
+	})
+	err = slugConflict(err, models.SlugKindCategory, newSlug)
+	var taken slugTakenError
+	if errors.As(err, &taken) {
+		c.JSON(http.StatusConflict, gin.H{"error": taken.Error()})
+		return
+	}
 	if err != nil {
 		// Log the actual error for debugging
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -278,6 +369,13 @@ func (h *CategoryHandler) UpdateCategory(c *gin.Context) {
 		return
 	}
 
+	if slugUpdated && newSlug != oldSlug {
+		recordSlugRedirect(h.db, models.SlugKindCategory, oldSlug, newSlug, categoryID)
+		if oldSlug != "" {
+			go h.renameProductCategory(oldSlug, newSlug)
+		}
+	}
+
 	// Fetch and return the updated category
 	updatedDoc, err := h.db.Client.Collection("categories").Doc(categoryID).Get(h.db.Context)
 	if err != nil {
@@ -336,10 +434,39 @@ func (h *CategoryHandler) UpdateCategory(c *gin.Context) {
 	c.JSON(http.StatusOK, category)
 }
 
+// renameProductCategory moves the products filed under a category's old slug to its
+// new one
+func (h *CategoryHandler) renameProductCategory(oldSlug, newSlug string) {
+	docs, err := h.db.Client.Collection("products").Where("categories", "array-contains", oldSlug).Documents(h.db.Context).GetAll()
+	if err != nil {
+		log.Printf("Failed to find products in category %s: %v", oldSlug, err)
+		return
+	}
+	for start := 0; start < len(docs); start += 400 {
+		batch := h.db.Client.Batch()
+		for _, doc := range docs[start:min(start+400, len(docs))] {
+			stored, _ := doc.Data()["categories"].([]interface{})
+			categories := make([]string, 0, len(stored))
+			for _, value := range stored {
+				if category, ok := value.(string); ok && category != newSlug {
+					if category == oldSlug {
+						category = newSlug
+					}
+					categories = append(categories, category)
+				}
+			}
+			batch.Update(doc.Ref, []firestore.Update{{Path: "categories", Value: categories}})
+		}
+		if _, err := batch.Commit(h.db.Context); err != nil {
+			log.Printf("Failed to move products from category %s to %s: %v", oldSlug, newSlug, err)
+		}
+	}
+}
+
 func (h *CategoryHandler) DeleteCategory(c *gin.Context) {
 	categoryID := c.Param("id")
 	
-	_, err := h.db.Client.Collection("categories").Doc(categoryID).Delete(h.db.Context)
+	err := deleteSluggedDoc(h.db, models.SlugKindCategory, categoryID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
 		return
@@ -450,9 +577,14 @@ func (h *CategoryHandler) InitializeDefaultCategories(c *gin.Context) {
 		category.UpdatedAt = time.Now()
 		docRef := h.db.Client.Collection("categories").NewDoc()
 		batch.Set(docRef, category)
+		batch.Create(slugRef(h.db, models.SlugKindCategory, category.Slug), newSlugReservation(models.SlugKindCategory, category.Slug, docRef.ID))
 	}
 
 	_, err := batch.Commit(h.db.Context)
+	if status.Code(err) == codes.AlreadyExists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Default categories already exist"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize categories"})
 		return
@@ -0,0 +1,853 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+	"tripund-api/internal/services"
+	"tripund-api/internal/utils"
+)
+
+type GiftCardHandler struct {
+	db                  *database.Firebase
+	notificationHandler *NotificationHandler
+	emailService        *services.SendGridEmailService
+	whatsappService     *services.WhatsAppService
+}
+
+func NewGiftCardHandler(db *database.Firebase, whatsappService *services.WhatsAppService) *GiftCardHandler {
+	emailService, err := services.NewSendGridEmailService()
+	if err != nil {
+		log.Printf("WARNING: Failed to initialize email service in GiftCardHandler: %v", err)
+	}
+
+	return &GiftCardHandler{
+		db:                  db,
+		notificationHandler: NewNotificationHandler(db),
+		emailService:        emailService,
+		whatsappService:     whatsappService,
+	}
+}
+
+// issueGiftCardParams describes a gift card to be created
+type issueGiftCardParams struct {
+	Amount          float64
+	Recipient       models.GiftCardRecipient
+	PurchaseOrderID string
+	PurchaserUserID string
+	PurchaserName   string
+	ValidityMonths  int
+	CreatedBy       string
+}
+
+// maxGiftCardsPerOrder keeps the gift cards of an order within one Firestore transaction
+const maxGiftCardsPerOrder = 200
+
+// issueGiftCard creates a new active gift card with a unique code and PIN
+func (h *GiftCardHandler) issueGiftCard(params issueGiftCardParams) (*models.GiftCard, error) {
+	card, txn, err := h.newGiftCard(params)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := h.db.Client.Batch()
+	batch.Set(h.db.Client.Collection("gift_cards").Doc(card.ID), card)
+	batch.Set(h.db.Client.Collection("gift_card_transactions").Doc(txn.ID), txn)
+	if _, err := batch.Commit(h.db.Context); err != nil {
+		return nil, fmt.Errorf("failed to save gift card: %v", err)
+	}
+
+	return card, nil
+}
+
+// newGiftCard prepares a gift card with a unique code and PIN and its issue
+// transaction, without saving them
+func (h *GiftCardHandler) newGiftCard(params issueGiftCardParams) (*models.GiftCard, models.GiftCardTransaction, error) {
+	code, err := h.generateUniqueCode()
+	if err != nil {
+		return nil, models.GiftCardTransaction{}, err
+	}
+
+	validity := params.ValidityMonths
+	if validity <= 0 {
+		validity = models.GiftCardValidityMonths
+	}
+
+	deliveryMethod := params.Recipient.DeliveryMethod
+	if deliveryMethod == "" {
+		deliveryMethod = "email"
+		if params.Recipient.Email == "" && params.Recipient.Phone != "" {
+			deliveryMethod = "whatsapp"
+		}
+	}
+
+	now := time.Now()
+	card := models.GiftCard{
+		ID:              utils.GenerateIDWithPrefix("gc"),
+		Code:            code,
+		PIN:             utils.GenerateSecurePIN(6),
+		InitialBalance:  params.Amount,
+		Balance:         params.Amount,
+		Currency:        "INR",
+		Status:          models.GiftCardStatusActive,
+		PurchaseOrderID: params.PurchaseOrderID,
+		PurchaserUserID: params.PurchaserUserID,
+		PurchaserName:   params.PurchaserName,
+		RecipientName:   params.Recipient.Name,
+		RecipientEmail:  params.Recipient.Email,
+		RecipientPhone:  params.Recipient.Phone,
+		Message:         params.Recipient.Message,
+		DeliveryMethod:  deliveryMethod,
+		ExpiresAt:       now.AddDate(0, validity, 0),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		CreatedBy:       params.CreatedBy,
+	}
+
+	txn := models.GiftCardTransaction{
+		ID:           utils.GenerateIDWithPrefix("gct"),
+		GiftCardID:   card.ID,
+		OrderID:      params.PurchaseOrderID,
+		Type:         models.GiftCardTransactionIssue,
+		Amount:       card.InitialBalance,
+		BalanceAfter: card.Balance,
+		CreatedBy:    params.CreatedBy,
+		CreatedAt:    now,
+	}
+
+	return &card, txn, nil
+}
+
+// generateUniqueCode returns a gift card code not already present in Firestore
+func (h *GiftCardHandler) generateUniqueCode() (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code := utils.GenerateGiftCardCode()
+		docs, err := h.db.Client.Collection("gift_cards").Where("code", "==", code).Limit(1).Documents(h.db.Context).GetAll()
+		if err != nil {
+			return "", err
+		}
+		if len(docs) == 0 {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("failed to generate a unique gift card code")
+}
+
+// orderTotalMismatch reports whether an order's total differs from what its lines and
+// shipping add up to, as on orders priced from amounts the client sent
+func orderTotalMismatch(order *models.Order) bool {
+	total := order.Totals.Shipping
+	for _, item := range order.Items {
+		total += item.Price*float64(item.Quantity) - item.Discount
+	}
+	return math.Abs(total-order.Totals.Total) >= 0.01 ||
+		math.Abs(order.Payment.Amount+order.Totals.GiftCardAmount-order.Totals.Total) >= 0.01
+}
+
+// countGiftCards counts the gift cards bought in an order's lines
+func countGiftCards(items []models.OrderItem) int {
+	count := 0
+	for _, item := range items {
+		if item.ProductType == models.ProductTypeGiftCard {
+			count += item.Quantity
+		}
+	}
+	return count
+}
+
+// errGiftCardsIssued is returned when an order's gift cards have already been issued
+var errGiftCardsIssued = fmt.Errorf("gift cards already issued")
+
+// IssueGiftCardsForOrder creates and delivers the gift cards bought in a paid order.
+// The cards are saved in a Firestore transaction together with the order's issuance
+// record, so confirming the same payment twice, even concurrently, issues them once.
+// Cards are only issued when the order's total adds up from its lines, so each card's
+// value is the denomination that was paid for.
+func (h *GiftCardHandler) IssueGiftCardsForOrder(order models.Order) error {
+	if orderTotalMismatch(&order) {
+		return fmt.Errorf("order %s total %.2f does not match its lines; gift cards need review", order.ID, order.Totals.Total)
+	}
+
+	type orderGiftCard struct {
+		item int
+		card *models.GiftCard
+		txn  models.GiftCardTransaction
+	}
+	var cards []orderGiftCard
+	for i, item := range order.Items {
+		// Orders from before issuance records carry the IDs of cards already issued
+		if item.ProductType != models.ProductTypeGiftCard || len(item.GiftCardIDs) > 0 {
+			continue
+		}
+
+		recipient := models.GiftCardRecipient{Name: order.GuestName, Email: order.GuestEmail, Phone: order.GuestPhone}
+		if item.GiftCard != nil {
+			recipient = *item.GiftCard
+		}
+
+		for q := 0; q < item.Quantity; q++ {
+			if len(cards) == maxGiftCardsPerOrder {
+				return fmt.Errorf("order %s has more than %d gift cards", order.ID, maxGiftCardsPerOrder)
+			}
+			card, txn, err := h.newGiftCard(issueGiftCardParams{
+				Amount:          item.Price,
+				Recipient:       recipient,
+				PurchaseOrderID: order.ID,
+				PurchaserUserID: order.UserID,
+				PurchaserName:   order.GuestName,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to issue gift card for order %s: %v", order.ID, err)
+			}
+			cards = append(cards, orderGiftCard{item: i, card: card, txn: txn})
+		}
+	}
+	if len(cards) == 0 {
+		return nil
+	}
+
+	issuance := models.GiftCardIssuance{OrderID: order.ID, CreatedAt: time.Now()}
+	for _, c := range cards {
+		issuance.GiftCardIDs = append(issuance.GiftCardIDs, c.card.ID)
+	}
+	issuanceRef := h.db.Client.Collection("gift_card_issuances").Doc(order.ID)
+	err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(issuanceRef); err == nil {
+			return errGiftCardsIssued
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		for _, c := range cards {
+			if err := tx.Set(h.db.Client.Collection("gift_cards").Doc(c.card.ID), *c.card); err != nil {
+				return err
+			}
+			if err := tx.Set(h.db.Client.Collection("gift_card_transactions").Doc(c.txn.ID), c.txn); err != nil {
+				return err
+			}
+		}
+		return tx.Create(issuanceRef, issuance)
+	})
+	if err == errGiftCardsIssued {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to issue gift cards for order %s: %v", order.ID, err)
+	}
+
+	for _, c := range cards {
+		order.Items[c.item].GiftCardIDs = append(order.Items[c.item].GiftCardIDs, c.card.ID)
+		if err := h.deliverGiftCard(c.card); err != nil {
+			log.Printf("Failed to deliver gift card %s: %v", c.card.ID, err)
+		}
+	}
+
+	_, err = h.db.Client.Collection("orders").Doc(order.ID).Update(h.db.Context, []firestore.Update{
+		{Path: "items", Value: order.Items},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	return err
+}
+
+// deliverGiftCard sends the gift card by email and/or WhatsApp and records the delivery time
+func (h *GiftCardHandler) deliverGiftCard(card *models.GiftCard) error {
+	var errs []string
+	delivered := false
+
+	if card.DeliveryMethod == "email" || card.DeliveryMethod == "both" {
+		if h.emailService == nil {
+			errs = append(errs, "email service not available")
+		} else if err := h.emailService.SendGiftCard(*card); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			delivered = true
+		}
+	}
+
+	if card.DeliveryMethod == "whatsapp" || card.DeliveryMethod == "both" {
+		senderName := card.PurchaserName
+		if senderName == "" {
+			senderName = "TRIPUND Lifestyle"
+		}
+		if h.whatsappService == nil || card.RecipientPhone == "" {
+			errs = append(errs, "whatsapp delivery not possible")
+		} else if err := h.whatsappService.SendGiftCard(
+			card.RecipientPhone,
+			card.RecipientName,
+			senderName,
+			fmt.Sprintf("%.0f", card.Balance),
+			card.Code,
+			card.PIN,
+			card.ExpiresAt.Format("02 Jan 2006"),
+		); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			delivered = true
+		}
+	}
+
+	if delivered {
+		now := time.Now()
+		card.DeliveredAt = &now
+		h.db.Client.Collection("gift_cards").Doc(card.ID).Update(h.db.Context, []firestore.Update{
+			{Path: "delivered_at", Value: now},
+			{Path: "updated_at", Value: now},
+		})
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// findGiftCard looks up a gift card by code and verifies its PIN. Failed checks count
+// towards lockouts of the client's IP address and of the code.
+func (h *GiftCardHandler) findGiftCard(code, pin, clientIP string) (*models.GiftCard, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	ipKey := giftCardAttemptKey("ip", clientIP)
+	codeKey := giftCardAttemptKey("code", code)
+	if locked, err := h.giftCardLocked(ipKey, codeKey); err != nil {
+		return nil, err
+	} else if locked {
+		return nil, errGiftCardLocked
+	}
+
+	docs, err := h.db.Client.Collection("gift_cards").Where("code", "==", code).Limit(1).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		h.recordGiftCardFailure(ipKey, giftCardIPAttemptLimit)
+		return nil, fmt.Errorf("invalid gift card code")
+	}
+
+	var card models.GiftCard
+	if err := docs[0].DataTo(&card); err != nil {
+		return nil, err
+	}
+	if card.PIN != strings.TrimSpace(pin) {
+		h.recordGiftCardFailure(ipKey, giftCardIPAttemptLimit)
+		h.recordGiftCardFailure(codeKey, giftCardCodeAttemptLimit)
+		return nil, fmt.Errorf("invalid gift card PIN")
+	}
+
+	return &card, nil
+}
+
+// ValidateForOrder checks a gift card at checkout and returns the amount it can cover
+func (h *GiftCardHandler) ValidateForOrder(code, pin, clientIP string, orderTotal float64) (*models.GiftCard, float64, error) {
+	card, err := h.findGiftCard(code, pin, clientIP)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !card.IsRedeemable(time.Now()) {
+		return nil, 0, fmt.Errorf("gift card is %s", giftCardUnavailableReason(card))
+	}
+
+	amount := math.Min(card.Balance, orderTotal)
+	return card, math.Round(amount*100) / 100, nil
+}
+
+// RedeemForOrder debits the gift card amount recorded on a paid order inside a
+// Firestore transaction. The redemption record is keyed by order, so verifying the
+// same payment twice does not debit the card twice.
+func (h *GiftCardHandler) RedeemForOrder(order models.Order) error {
+	if order.Totals.GiftCardCode == "" || order.Totals.GiftCardAmount <= 0 {
+		return nil
+	}
+
+	docs, err := h.db.Client.Collection("gift_cards").Where("code", "==", order.Totals.GiftCardCode).Limit(1).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return fmt.Errorf("gift card %s not found", order.Totals.GiftCardCode)
+	}
+
+	cardRef := docs[0].Ref
+	txnRef := h.db.Client.Collection("gift_card_transactions").Doc(fmt.Sprintf("%s_%s_redeem", cardRef.ID, order.ID))
+
+	return h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(txnRef); err == nil {
+			return nil // Already redeemed for this order
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		cardDoc, err := tx.Get(cardRef)
+		if err != nil {
+			return err
+		}
+		var card models.GiftCard
+		if err := cardDoc.DataTo(&card); err != nil {
+			return err
+		}
+
+		if !card.IsRedeemable(time.Now()) {
+			return fmt.Errorf("gift card is %s", giftCardUnavailableReason(&card))
+		}
+		if card.Balance+0.005 < order.Totals.GiftCardAmount {
+			return fmt.Errorf("insufficient gift card balance: ₹%.2f available", card.Balance)
+		}
+
+		newBalance := math.Round((card.Balance-order.Totals.GiftCardAmount)*100) / 100
+		newStatus := models.GiftCardStatusActive
+		if newBalance <= 0 {
+			newBalance = 0
+			newStatus = models.GiftCardStatusRedeemed
+		}
+
+		now := time.Now()
+		if err := tx.Update(cardRef, []firestore.Update{
+			{Path: "balance", Value: newBalance},
+			{Path: "status", Value: newStatus},
+			{Path: "updated_at", Value: now},
+		}); err != nil {
+			return err
+		}
+
+		return tx.Set(txnRef, models.GiftCardTransaction{
+			ID:           txnRef.ID,
+			GiftCardID:   card.ID,
+			OrderID:      order.ID,
+			Type:         models.GiftCardTransactionRedeem,
+			Amount:       -order.Totals.GiftCardAmount,
+			BalanceAfter: newBalance,
+			CreatedAt:    now,
+		})
+	})
+}
+
+// ReverseForOrder undoes an order's gift card activity when it is cancelled or fully
+// refunded: the amount redeemed from a card goes back onto it, and the cards bought in
+// the order are voided. Each step runs in a Firestore transaction keyed by order, so
+// reversing the same order twice has no further effect.
+func (h *GiftCardHandler) ReverseForOrder(order models.Order, reason string) error {
+	var errs []string
+	if err := h.refundRedemption(order, reason); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	docs, err := h.db.Client.Collection("gift_cards").Where("purchase_order_id", "==", order.ID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, doc := range docs {
+		if err := h.voidGiftCard(doc.Ref.ID, order.ID, "system", reason); err != nil && err != errGiftCardVoided {
+			errs = append(errs, fmt.Sprintf("gift card %s: %v", doc.Ref.ID, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// refundRedemption puts the amount an order redeemed back onto the gift card. Cards
+// voided since keep a zero balance.
+func (h *GiftCardHandler) refundRedemption(order models.Order, reason string) error {
+	if order.Totals.GiftCardCode == "" {
+		return nil
+	}
+
+	docs, err := h.db.Client.Collection("gift_cards").Where("code", "==", order.Totals.GiftCardCode).Limit(1).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return fmt.Errorf("gift card %s not found", order.Totals.GiftCardCode)
+	}
+
+	cardRef := docs[0].Ref
+	redeemRef := h.db.Client.Collection("gift_card_transactions").Doc(fmt.Sprintf("%s_%s_redeem", cardRef.ID, order.ID))
+	refundRef := h.db.Client.Collection("gift_card_transactions").Doc(fmt.Sprintf("%s_%s_refund", cardRef.ID, order.ID))
+
+	return h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		redeemDoc, err := tx.Get(redeemRef)
+		if status.Code(err) == codes.NotFound {
+			return nil // Never redeemed, e.g. the order wasn't paid
+		} else if err != nil {
+			return err
+		}
+		if _, err := tx.Get(refundRef); err == nil {
+			return nil // Already refunded
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		cardDoc, err := tx.Get(cardRef)
+		if err != nil {
+			return err
+		}
+		var card models.GiftCard
+		if err := cardDoc.DataTo(&card); err != nil {
+			return err
+		}
+		var redemption models.GiftCardTransaction
+		if err := redeemDoc.DataTo(&redemption); err != nil {
+			return err
+		}
+
+		amount := -redemption.Amount
+		newBalance := card.Balance
+		newStatus := card.Status
+		if card.Status != models.GiftCardStatusVoided {
+			newBalance = math.Round((card.Balance+amount)*100) / 100
+			if card.Status == models.GiftCardStatusRedeemed && newBalance > 0 {
+				newStatus = models.GiftCardStatusActive
+			}
+		}
+
+		now := time.Now()
+		if err := tx.Update(cardRef, []firestore.Update{
+			{Path: "balance", Value: newBalance},
+			{Path: "status", Value: newStatus},
+			{Path: "updated_at", Value: now},
+		}); err != nil {
+			return err
+		}
+
+		txn := models.GiftCardTransaction{
+			ID:           refundRef.ID,
+			GiftCardID:   card.ID,
+			OrderID:      order.ID,
+			Type:         models.GiftCardTransactionRefund,
+			Amount:       amount,
+			BalanceAfter: newBalance,
+			Note:         reason,
+			CreatedBy:    "system",
+			CreatedAt:    now,
+		}
+		if card.Status == models.GiftCardStatusVoided {
+			txn.Amount = 0
+			txn.Note = reason + " (card voided, balance not restored)"
+		}
+		return tx.Set(refundRef, txn)
+	})
+}
+
+// errGiftCardVoided is returned when voiding a gift card that is already voided
+var errGiftCardVoided = fmt.Errorf("gift card is already voided")
+
+// voidGiftCard cancels the remaining balance of a gift card, recording who voided it
+// and why. orderID links the void to an order when one caused it.
+func (h *GiftCardHandler) voidGiftCard(cardID, orderID, voidedBy, reason string) error {
+	cardRef := h.db.Client.Collection("gift_cards").Doc(cardID)
+
+	return h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(cardRef)
+		if err != nil {
+			return err
+		}
+		var card models.GiftCard
+		if err := doc.DataTo(&card); err != nil {
+			return err
+		}
+		if card.Status == models.GiftCardStatusVoided {
+			return errGiftCardVoided
+		}
+
+		now := time.Now()
+		if err := tx.Update(cardRef, []firestore.Update{
+			{Path: "status", Value: models.GiftCardStatusVoided},
+			{Path: "balance", Value: 0.0},
+			{Path: "voided_at", Value: now},
+			{Path: "voided_by", Value: voidedBy},
+			{Path: "void_reason", Value: reason},
+			{Path: "updated_at", Value: now},
+		}); err != nil {
+			return err
+		}
+
+		txnRef := h.db.Client.Collection("gift_card_transactions").Doc(utils.GenerateIDWithPrefix("gct"))
+		return tx.Set(txnRef, models.GiftCardTransaction{
+			ID:           txnRef.ID,
+			GiftCardID:   card.ID,
+			OrderID:      orderID,
+			Type:         models.GiftCardTransactionVoid,
+			Amount:       -card.Balance,
+			BalanceAfter: 0,
+			Note:         reason,
+			CreatedBy:    voidedBy,
+			CreatedAt:    now,
+		})
+	})
+}
+
+func giftCardUnavailableReason(card *models.GiftCard) string {
+	switch {
+	case card.Status == models.GiftCardStatusVoided:
+		return "voided"
+	case card.Status == models.GiftCardStatusRedeemed || card.Balance <= 0:
+		return "fully redeemed"
+	case card.Status == models.GiftCardStatusExpired || time.Now().After(card.ExpiresAt):
+		return "expired"
+	default:
+		return "not active"
+	}
+}
+
+// giftCardExpiryInterval is how often gift cards past their expiry are marked expired
+const giftCardExpiryInterval = time.Hour
+
+// StartExpirySweep marks expired gift cards now and then every giftCardExpiryInterval
+func (h *GiftCardHandler) StartExpirySweep() {
+	go func() {
+		h.expireGiftCards(time.Now())
+		ticker := time.NewTicker(giftCardExpiryInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			h.expireGiftCards(now)
+		}
+	}()
+}
+
+// expireGiftCards sets the expired status on active cards whose expiry has passed. The
+// balance stays on the card for reporting. Cards changed since they were read are left
+// for the next sweep.
+func (h *GiftCardHandler) expireGiftCards(now time.Time) {
+	docs, err := h.db.Client.Collection("gift_cards").Where("status", "==", models.GiftCardStatusActive).Documents(h.db.Context).GetAll()
+	if err != nil {
+		log.Printf("Failed to load gift cards for expiry: %v", err)
+		return
+	}
+
+	expired := 0
+	for _, doc := range docs {
+		var card models.GiftCard
+		if err := doc.DataTo(&card); err != nil || now.Before(card.ExpiresAt) {
+			continue
+		}
+		_, err := doc.Ref.Update(h.db.Context, []firestore.Update{
+			{Path: "status", Value: models.GiftCardStatusExpired},
+			{Path: "updated_at", Value: now},
+		}, firestore.LastUpdateTime(doc.UpdateTime))
+		if err != nil {
+			log.Printf("Failed to expire gift card %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		expired++
+	}
+	if expired > 0 {
+		log.Printf("Marked %d gift cards as expired", expired)
+	}
+}
+
+// CheckBalance returns the balance of a gift card (public, requires code and PIN)
+func (h *GiftCardHandler) CheckBalance(c *gin.Context) {
+	var req models.GiftCardBalanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	card, err := h.findGiftCard(req.Code, req.PIN, c.ClientIP())
+	if err == errGiftCardLocked {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, please try again later"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid gift card code or PIN"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":       card.Code,
+		"balance":    card.Balance,
+		"currency":   card.Currency,
+		"expires_at": card.ExpiresAt,
+		"redeemable": card.IsRedeemable(time.Now()),
+		"status":     card.Status,
+	})
+}
+
+// Admin endpoints
+
+// GetGiftCards lists gift cards, optionally filtered by status
+func (h *GiftCardHandler) GetGiftCards(c *gin.Context) {
+	query := h.db.Client.Collection("gift_cards").Query
+	if status := c.Query("status"); status != "" && status != "all" {
+		query = query.Where("status", "==", status)
+	}
+
+	docs, err := query.Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch gift cards"})
+		return
+	}
+
+	search := strings.ToLower(c.Query("search"))
+	cards := make([]models.GiftCard, 0)
+	var outstanding float64
+	for _, doc := range docs {
+		var card models.GiftCard
+		if err := doc.DataTo(&card); err != nil {
+			continue
+		}
+		if search != "" &&
+			!strings.Contains(strings.ToLower(card.Code), search) &&
+			!strings.Contains(strings.ToLower(card.RecipientEmail), search) &&
+			!strings.Contains(strings.ToLower(card.RecipientName), search) {
+			continue
+		}
+		if card.IsRedeemable(time.Now()) {
+			outstanding += card.Balance
+		}
+		cards = append(cards, card)
+	}
+
+	sort.Slice(cards, func(i, j int) bool {
+		return cards[i].CreatedAt.After(cards[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"gift_cards":          cards,
+		"total":               len(cards),
+		"outstanding_balance": outstanding,
+	})
+}
+
+// GetGiftCard returns a gift card with its transaction history
+func (h *GiftCardHandler) GetGiftCard(c *gin.Context) {
+	cardID := c.Param("id")
+
+	doc, err := h.db.Client.Collection("gift_cards").Doc(cardID).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	}
+
+	var card models.GiftCard
+	if err := doc.DataTo(&card); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse gift card"})
+		return
+	}
+
+	txnDocs, err := h.db.Client.Collection("gift_card_transactions").Where("gift_card_id", "==", cardID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		log.Printf("Failed to fetch transactions for gift card %s: %v", cardID, err)
+	}
+
+	transactions := make([]models.GiftCardTransaction, 0)
+	for _, txnDoc := range txnDocs {
+		var txn models.GiftCardTransaction
+		if err := txnDoc.DataTo(&txn); err == nil {
+			transactions = append(transactions, txn)
+		}
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].CreatedAt.Before(transactions[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"gift_card":    card,
+		"transactions": transactions,
+	})
+}
+
+// IssueGiftCard lets an admin issue a gift card directly (e.g. goodwill or corporate orders)
+func (h *GiftCardHandler) IssueGiftCard(c *gin.Context) {
+	var req models.IssueGiftCardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.RecipientEmail == "" && req.RecipientPhone == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Recipient email or phone is required"})
+		return
+	}
+
+	card, err := h.issueGiftCard(issueGiftCardParams{
+		Amount: req.Amount,
+		Recipient: models.GiftCardRecipient{
+			Name:           req.RecipientName,
+			Email:          req.RecipientEmail,
+			Phone:          req.RecipientPhone,
+			Message:        req.Message,
+			DeliveryMethod: req.DeliveryMethod,
+		},
+		ValidityMonths: req.ValidityMonths,
+		CreatedBy:      c.GetString("user_id"),
+	})
+	if err != nil {
+		log.Printf("Failed to issue gift card: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue gift card"})
+		return
+	}
+
+	deliveryError := ""
+	if err := h.deliverGiftCard(card); err != nil {
+		log.Printf("Failed to deliver gift card %s: %v", card.ID, err)
+		deliveryError = err.Error()
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":        "Gift card issued successfully",
+		"gift_card":      card,
+		"delivery_error": deliveryError,
+	})
+}
+
+// VoidGiftCard cancels the remaining balance of a gift card
+func (h *GiftCardHandler) VoidGiftCard(c *gin.Context) {
+	cardID := c.Param("id")
+
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.voidGiftCard(cardID, "", c.GetString("user_id"), req.Reason); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to void gift card: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Gift card voided successfully"})
+}
+
+// ResendGiftCard delivers an existing gift card to its recipient again
+func (h *GiftCardHandler) ResendGiftCard(c *gin.Context) {
+	cardID := c.Param("id")
+
+	doc, err := h.db.Client.Collection("gift_cards").Doc(cardID).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	}
+
+	var card models.GiftCard
+	if err := doc.DataTo(&card); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse gift card"})
+		return
+	}
+
+	if !card.IsRedeemable(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Gift card is " + giftCardUnavailableReason(&card)})
+		return
+	}
+
+	if err := h.deliverGiftCard(&card); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deliver gift card: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Gift card sent successfully"})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/models"
+)
+
+const (
+	giftCardAttemptWindow    = 15 * time.Minute
+	giftCardLockout          = 30 * time.Minute
+	giftCardIPAttemptLimit   = 20 // Failed checks from one IP address per window
+	giftCardCodeAttemptLimit = 5  // Failed PIN checks on one card per window
+)
+
+// errGiftCardLocked is returned while checks from an IP address or on a code are locked out
+var errGiftCardLocked = fmt.Errorf("too many failed gift card attempts, please try again later")
+
+// giftCardAttemptKey is the ID of the attempts record for an IP address or a code
+func giftCardAttemptKey(kind, value string) string {
+	if value == "" {
+		return ""
+	}
+	return kind + ":" + strings.ReplaceAll(value, "/", "_")
+}
+
+// giftCardLocked reports whether any of the keys is locked out
+func (h *GiftCardHandler) giftCardLocked(keys ...string) (bool, error) {
+	var refs []*firestore.DocumentRef
+	for _, key := range keys {
+		if key != "" {
+			refs = append(refs, h.db.Client.Collection("gift_card_attempts").Doc(key))
+		}
+	}
+	docs, err := h.db.Client.GetAll(h.db.Context, refs)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		var attempts models.GiftCardAttempts
+		if err := doc.DataTo(&attempts); err != nil {
+			return false, err
+		}
+		if attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// recordGiftCardFailure counts a failed check against the key and locks it out for
+// giftCardLockout once limit failures fall within giftCardAttemptWindow
+func (h *GiftCardHandler) recordGiftCardFailure(key string, limit int) {
+	if key == "" {
+		return
+	}
+	ref := h.db.Client.Collection("gift_card_attempts").Doc(key)
+	err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		attempts := models.GiftCardAttempts{Key: key}
+		doc, err := tx.Get(ref)
+		if err == nil {
+			if err := doc.DataTo(&attempts); err != nil {
+				return err
+			}
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		now := time.Now()
+		if now.Sub(attempts.WindowStart) > giftCardAttemptWindow {
+			attempts.Failures = 0
+			attempts.WindowStart = now
+		}
+		attempts.Failures++
+		if attempts.Failures >= limit {
+			lockedUntil := now.Add(giftCardLockout)
+			attempts.LockedUntil = &lockedUntil
+			attempts.Failures = 0
+			attempts.WindowStart = now
+		}
+		attempts.UpdatedAt = now
+		return tx.Set(ref, attempts)
+	})
+	if err != nil {
+		log.Printf("Failed to record gift card attempt for %s: %v", key, err)
+	}
+}
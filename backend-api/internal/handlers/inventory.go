@@ -0,0 +1,899 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+	"tripund-api/internal/services"
+)
+
+// stockActorSystem is the actor of movements made by order processing
+const stockActorSystem = "system"
+
+// InventoryHandler keeps the inventory ledger. All stock changes go through it so each
+// is recorded as a stock_movements entry next to the stock it changed.
+type InventoryHandler struct {
+	db                  *database.Firebase
+	notificationHandler *NotificationHandler
+	stockRequests       *StockRequestHandler
+}
+
+func NewInventoryHandler(db *database.Firebase, whatsappService *services.WhatsAppService) *InventoryHandler {
+	return &InventoryHandler{
+		db:                  db,
+		notificationHandler: NewNotificationHandler(db),
+		stockRequests:       NewStockRequestHandler(db, whatsappService),
+	}
+}
+
+// stockChanged follows up a change to a product's stock: listings, low stock alerts,
+// notifying customers who asked for anything that came back into stock, and the stock
+// of the bundles containing it
+func (h *InventoryHandler) stockChanged(before, after *models.Product) {
+	productListings.clear()
+	h.checkLowStock(after)
+	go h.stockRequests.NotifyBackInStock(before, after)
+	go h.refreshBundles(after.ID)
+}
+
+// errInsufficientStock is returned when a transfer moves more stock than its source holds
+var errInsufficientStock = errors.New("not enough stock at the source location")
+
+// errBundleStock is returned when stock is moved for a bundle, whose stock comes from
+// its components
+var errBundleStock = errors.New("a bundle's stock comes from its components; move the components' stock instead")
+
+// stockMove is a change to make to a product's or variant's stock at a location, or to
+// its stock not assigned to any location when LocationID is empty. Delta is added to
+// that stock, which never goes below zero; for stocktakes Count replaces it. The total
+// stock changes by the same amount. A move with a Key is recorded once, as the ledger
+// entry with that ID. A move that Reverses another entry undoes that entry's delta at
+// the entry's location and is skipped if it doesn't exist.
+type stockMove struct {
+	ProductID  string
+	VariantID  string // Variant ID or SKU
+	LocationID string
+	Type       string
+	Delta      int
+	Count      *int
+	OrderID    string
+	Reason     string
+	Actor      string
+	Key        string
+	Reverses   string
+}
+
+// variantIndex finds a variant by ID, or by SKU for variants without an ID
+func variantIndex(product *models.Product, ref string) int {
+	for i, variant := range product.Variants {
+		if variant.ID != "" && variant.ID == ref {
+			return i
+		}
+	}
+	for i, variant := range product.Variants {
+		if variant.SKU != "" && strings.EqualFold(variant.SKU, ref) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ledgerKey identifies the stock a movement belongs to, for summing the ledger
+func ledgerKey(productID, variantID, variantSKU string) string {
+	if variantID != "" {
+		return productID + "/" + variantID
+	}
+	if variantSKU != "" {
+		return productID + "/sku:" + strings.ToLower(variantSKU)
+	}
+	return productID
+}
+
+// applyStockMoves makes the moves, one transaction per product, writing each
+// product's stock and its ledger entries together
+func (h *InventoryHandler) applyStockMoves(moves []stockMove) error {
+	var productIDs []string
+	byProduct := make(map[string][]stockMove)
+	for _, move := range moves {
+		if byProduct[move.ProductID] == nil {
+			productIDs = append(productIDs, move.ProductID)
+		}
+		byProduct[move.ProductID] = append(byProduct[move.ProductID], move)
+	}
+
+	var failed []string
+	for _, productID := range productIDs {
+		if err := h.applyProductStockMoves(productID, byProduct[productID]); err != nil {
+			log.Printf("Failed to move stock of product %s: %v", productID, err)
+			failed = append(failed, productID)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("stock not updated for products %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (h *InventoryHandler) applyProductStockMoves(productID string, moves []stockMove) error {
+	productRef := h.db.Client.Collection("products").Doc(productID)
+	ledger := h.db.Client.Collection("stock_movements")
+
+	var original, changed *models.Product
+	err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		changed = nil
+		doc, err := tx.Get(productRef)
+		if err != nil {
+			return err
+		}
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil {
+			return err
+		}
+		if product.IsBundle() {
+			return errBundleStock
+		}
+		before := product
+		before.ID = productID
+		before.Variants = append([]models.ProductVariant(nil), product.Variants...)
+		original = &before
+
+		// Transactions must read everything before writing
+		recorded := make(map[string]*models.StockMovement)
+		for _, move := range moves {
+			for _, key := range []string{move.Key, move.Reverses} {
+				if key == "" {
+					continue
+				}
+				entryDoc, err := tx.Get(ledger.Doc(key))
+				if status.Code(err) == codes.NotFound {
+					continue
+				} else if err != nil {
+					return err
+				}
+				var entry models.StockMovement
+				if err := entryDoc.DataTo(&entry); err != nil {
+					return err
+				}
+				recorded[key] = &entry
+			}
+		}
+
+		now := time.Now()
+		var entries []models.StockMovement
+		var keys []string
+		productChanged, variantsChanged := false, false
+		for _, move := range moves {
+			if move.Key != "" && recorded[move.Key] != nil {
+				continue
+			}
+			delta, locationID := move.Delta, move.LocationID
+			if move.Reverses != "" {
+				reversed := recorded[move.Reverses]
+				if reversed == nil {
+					continue
+				}
+				delta, locationID = -reversed.Delta, reversed.LocationID
+			}
+
+			entry := models.StockMovement{
+				ProductID: productID,
+				SKU:       product.SKU,
+				Type:      move.Type,
+				OrderID:   move.OrderID,
+				Reason:    move.Reason,
+				Actor:     move.Actor,
+				CreatedAt: now,
+			}
+
+			stock, locations := &product.StockQuantity, &product.LocationStock
+			var variant *models.ProductVariant
+			if move.VariantID != "" && len(product.Variants) > 0 {
+				i := variantIndex(&product, move.VariantID)
+				if i < 0 {
+					log.Printf("Variant %s of product %s not found, stock not moved", move.VariantID, productID)
+					continue
+				}
+				variant = &product.Variants[i]
+				stock, locations = &variant.StockQuantity, &variant.LocationStock
+				entry.VariantID = variant.ID
+				entry.VariantSKU = variant.SKU
+			}
+
+			level := unassignedStock(*stock, *locations)
+			if locationID != "" {
+				level = (*locations)[locationID]
+			}
+			if move.Type == models.StockMovementTransfer && level+delta < 0 {
+				return errInsufficientStock
+			}
+			entry.LocationID = locationID
+			entry.Before = level
+			entry.After = max(level+delta, 0)
+			if move.Count != nil {
+				entry.After = *move.Count
+			}
+			entry.Delta = entry.After - entry.Before
+			if entry.Delta == 0 && move.Key == "" {
+				continue
+			}
+			if locationID != "" {
+				if *locations == nil {
+					*locations = make(map[string]int)
+				}
+				(*locations)[locationID] = entry.After
+			}
+			total := *stock
+			*stock = max(total+entry.Delta, 0)
+
+			if variant != nil {
+				variantsChanged = true
+				if *stock == 0 {
+					variant.Available = false
+				} else if total == 0 {
+					variant.Available = true
+				}
+			} else {
+				productChanged = true
+			}
+			entries = append(entries, entry)
+			keys = append(keys, move.Key)
+		}
+
+		if len(entries) == 0 {
+			return nil
+		}
+		updates := []firestore.Update{{Path: "updated_at", Value: now}}
+		if productChanged {
+			updates = append(updates,
+				firestore.Update{Path: "stock_quantity", Value: product.StockQuantity},
+				firestore.Update{Path: "location_stock", Value: product.LocationStock},
+			)
+		}
+		if variantsChanged {
+			updates = append(updates, firestore.Update{Path: "variants", Value: product.Variants})
+		}
+		if err := tx.Update(productRef, updates); err != nil {
+			return err
+		}
+		for i, entry := range entries {
+			entryRef := ledger.NewDoc()
+			if keys[i] != "" {
+				entryRef = ledger.Doc(keys[i])
+			}
+			if err := tx.Create(entryRef, entry); err != nil {
+				return err
+			}
+		}
+		product.ID = productID
+		changed = &product
+		return nil
+	})
+	if err == nil && changed != nil {
+		h.stockChanged(original, changed)
+	}
+	return err
+}
+
+// unassignedStock is the part of a total stock not held at any location, such as stock
+// recorded before locations were set up
+func unassignedStock(total int, locations map[string]int) int {
+	for _, quantity := range locations {
+		total -= quantity
+	}
+	return max(total, 0)
+}
+
+// stockDiff returns the ledger entries for the stock changes between two versions of a
+// product, with the type, reason and actor of base. Variants are matched by ID, or by
+// SKU when they have none; a removed variant's stock is recorded as going out.
+func stockDiff(before, after *models.Product, base models.StockMovement) []models.StockMovement {
+	var entries []models.StockMovement
+	add := func(variant *models.ProductVariant, from, to int) {
+		if from == to {
+			return
+		}
+		entry := base
+		entry.ProductID = after.ID
+		entry.SKU = after.SKU
+		if variant != nil {
+			entry.VariantID = variant.ID
+			entry.VariantSKU = variant.SKU
+		}
+		entry.Before = from
+		entry.After = to
+		entry.Delta = to - from
+		entries = append(entries, entry)
+	}
+
+	add(nil, before.StockQuantity, after.StockQuantity)
+
+	previous := make(map[string]models.ProductVariant)
+	for _, variant := range before.Variants {
+		previous[ledgerKey("", variant.ID, variant.SKU)] = variant
+	}
+	for i := range after.Variants {
+		variant := &after.Variants[i]
+		key := ledgerKey("", variant.ID, variant.SKU)
+		add(variant, previous[key].StockQuantity, variant.StockQuantity)
+		delete(previous, key)
+	}
+	for _, variant := range previous {
+		removed := variant
+		add(&removed, variant.StockQuantity, 0)
+	}
+	return entries
+}
+
+// recordMovements writes ledger entries for stock already saved, e.g. the opening stock
+// of a new product
+func (h *InventoryHandler) recordMovements(entries []models.StockMovement) {
+	if len(entries) == 0 {
+		return
+	}
+	batch := h.db.Client.Batch()
+	for _, entry := range entries {
+		batch.Create(h.db.Client.Collection("stock_movements").NewDoc(), entry)
+	}
+	if _, err := batch.Commit(h.db.Context); err != nil {
+		log.Printf("Failed to record stock movements of product %s: %v", entries[0].ProductID, err)
+	}
+}
+
+// updateProductStock applies a product edit that sets stock_quantity or variants,
+// recording the stock it changes as adjustments in the same transaction, then follows
+// up the change. afterWrite, if set, runs in the transaction too.
+func (h *InventoryHandler) updateProductStock(productID string, updates map[string]interface{}, firestoreUpdates []firestore.Update, actor string, afterWrite productWriteHook) error {
+	productRef := h.db.Client.Collection("products").Doc(productID)
+
+	stockFields := make(map[string]interface{})
+	for _, key := range []string{"stock_quantity", "variants"} {
+		if value, ok := updates[key]; ok {
+			stockFields[key] = value
+		}
+	}
+	data, err := json.Marshal(stockFields)
+	if err != nil {
+		return err
+	}
+
+	var before, after models.Product
+	err = h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(productRef)
+		if err != nil {
+			return err
+		}
+		before = models.Product{}
+		if err := database.DecodeProduct(doc, &before); err != nil {
+			return err
+		}
+		before.ID = productID
+
+		after = before
+		if _, ok := stockFields["variants"]; ok {
+			after.Variants = nil
+		}
+		if err := json.Unmarshal(data, &after); err != nil {
+			return err
+		}
+
+		// Variants sent from the product form keep their stock per location
+		if _, ok := stockFields["variants"]; ok {
+			previous := make(map[string]map[string]int)
+			for _, variant := range before.Variants {
+				previous[ledgerKey("", variant.ID, variant.SKU)] = variant.LocationStock
+			}
+			for i := range after.Variants {
+				if after.Variants[i].LocationStock == nil {
+					after.Variants[i].LocationStock = previous[ledgerKey("", after.Variants[i].ID, after.Variants[i].SKU)]
+				}
+			}
+			for i := range firestoreUpdates {
+				if firestoreUpdates[i].Path == "variants" {
+					firestoreUpdates[i].Value = after.Variants
+				}
+			}
+		}
+
+		entries := stockDiff(&before, &after, models.StockMovement{
+			Type:      models.StockMovementAdjustment,
+			Reason:    "product edited",
+			Actor:     actor,
+			CreatedAt: time.Now(),
+		})
+		if err := tx.Update(productRef, firestoreUpdates); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := tx.Create(h.db.Client.Collection("stock_movements").NewDoc(), entry); err != nil {
+				return err
+			}
+		}
+		if afterWrite != nil {
+			return afterWrite(tx, doc, firestoreUpdates)
+		}
+		return nil
+	})
+	if err == nil {
+		h.stockChanged(&before, &after)
+	}
+	return err
+}
+
+// stockLine is stock an order takes: an item, or for a bundle item one of its
+// components, numbered from 1
+type stockLine struct {
+	ItemIndex int
+	Component int
+	ProductID string
+	VariantID string
+	Quantity  int
+}
+
+// orderStockLines lists the stock an order's items take, with bundles taking their
+// components. Gift cards are not stocked goods.
+func orderStockLines(order models.Order) []stockLine {
+	var lines []stockLine
+	for i, item := range order.Items {
+		if item.ProductType == models.ProductTypeGiftCard || item.ProductID == "" {
+			continue
+		}
+		if len(item.BundleComponents) == 0 {
+			lines = append(lines, stockLine{ItemIndex: i, ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity})
+			continue
+		}
+		for j, component := range item.BundleComponents {
+			lines = append(lines, stockLine{
+				ItemIndex: i,
+				Component: j + 1,
+				ProductID: component.ProductID,
+				VariantID: component.VariantID,
+				Quantity:  component.Quantity * item.Quantity,
+			})
+		}
+	}
+	return lines
+}
+
+// orderStockMoves builds one move per allocated part of each stock line of an order.
+// Orders without allocations, from before locations, move each line's unassigned stock.
+func orderStockMoves(order models.Order, moveType, keySuffix, actor string) []stockMove {
+	allocations := order.Allocations
+	if len(allocations) == 0 {
+		for _, line := range orderStockLines(order) {
+			allocations = append(allocations, models.StockAllocation{
+				ItemIndex: line.ItemIndex,
+				Component: line.Component,
+				ProductID: line.ProductID,
+				VariantID: line.VariantID,
+				Quantity:  line.Quantity,
+			})
+		}
+	}
+
+	var moves []stockMove
+	parts := make(map[string]int)
+	for _, allocation := range allocations {
+		if allocation.ItemIndex < 0 || allocation.ItemIndex >= len(order.Items) {
+			continue
+		}
+		if item := order.Items[allocation.ItemIndex]; item.ProductType == models.ProductTypeGiftCard || item.ProductID == "" {
+			continue
+		}
+		// The first part keeps the key used before orders were split across locations
+		line := fmt.Sprint(allocation.ItemIndex)
+		if allocation.Component > 0 {
+			line = fmt.Sprintf("%d-%d", allocation.ItemIndex, allocation.Component)
+		}
+		part := line
+		if n := parts[line]; n > 0 {
+			part = fmt.Sprintf("%s.%d", line, n)
+		}
+		parts[line]++
+
+		moves = append(moves, stockMove{
+			ProductID:  allocation.ProductID,
+			VariantID:  allocation.VariantID,
+			LocationID: allocation.LocationID,
+			Type:       moveType,
+			Delta:      -allocation.Quantity,
+			OrderID:    order.ID,
+			Actor:      actor,
+			Key:        fmt.Sprintf("%s_%s_%s", order.ID, part, keySuffix),
+		})
+	}
+	return moves
+}
+
+// stockHoldTTL is how long an order awaiting payment holds its stock, the same time it
+// holds its promotions
+const stockHoldTTL = promotionReservationTTL
+
+// HoldOrderStock reserves an order's items while its payment is awaited, allocating it
+// first, so the stock isn't sold to someone else in the meantime
+func (h *InventoryHandler) HoldOrderStock(order models.Order, actor string) error {
+	allocations, err := h.ensureOrderAllocation(order)
+	if err != nil {
+		return err
+	}
+	order.Allocations = allocations
+	_, err = h.db.Client.Collection("orders").Doc(order.ID).Update(h.db.Context, []firestore.Update{
+		{Path: "stock_held", Value: true},
+	})
+	if err != nil {
+		return err
+	}
+	return h.applyStockMoves(orderStockMoves(order, models.StockMovementReservation, models.StockMovementReservation, actor))
+}
+
+// releaseHeldMoves put back the stock an order holds, once, at the locations it was held
+func releaseHeldMoves(order models.Order, reason, actor string) []stockMove {
+	keySuffix := models.StockMovementReservation + "_" + models.StockMovementRelease
+	moves := orderStockMoves(order, models.StockMovementRelease, keySuffix, actor)
+	for i := range moves {
+		moves[i].Reverses = strings.TrimSuffix(moves[i].Key, keySuffix) + models.StockMovementReservation
+		moves[i].Reason = reason
+	}
+	return moves
+}
+
+// RecordOrderSale takes an order's items out of stock at the locations it is allocated
+// to, allocating it first if needed. Stock the order holds is put back in the same
+// transaction, so the hold becomes the sale. It is safe to call more than once: stock is
+// only taken the first time, whether that is at payment or, for cash on delivery orders,
+// when the order ships.
+func (h *InventoryHandler) RecordOrderSale(order models.Order, actor string) error {
+	allocations, err := h.ensureOrderAllocation(order)
+	if err != nil {
+		return err
+	}
+	order.Allocations = allocations
+	moves := releaseHeldMoves(order, "order paid", actor)
+	moves = append(moves, orderStockMoves(order, models.StockMovementSale, models.StockMovementSale, actor)...)
+	return h.applyStockMoves(moves)
+}
+
+// ReleaseOrderStock puts back the stock a cancelled or refunded order took or holds, if
+// it took or holds any
+func (h *InventoryHandler) ReleaseOrderStock(order models.Order, actor string) error {
+	moves := orderStockMoves(order, models.StockMovementRelease, models.StockMovementRelease, actor)
+	for i := range moves {
+		moves[i].Reverses = strings.TrimSuffix(moves[i].Key, models.StockMovementRelease) + models.StockMovementSale
+	}
+	moves = append(moves, releaseHeldMoves(order, "", actor)...)
+	return h.applyStockMoves(moves)
+}
+
+// ReleaseHeldStock puts back the stock an order holds without touching stock it was
+// sold, e.g. when its payment fails
+func (h *InventoryHandler) ReleaseHeldStock(order models.Order, reason string) error {
+	return h.applyStockMoves(releaseHeldMoves(order, reason, stockActorSystem))
+}
+
+// StartHoldSweep releases the stock held by unpaid orders now and then every half hour
+func (h *InventoryHandler) StartHoldSweep() {
+	go func() {
+		h.releaseExpiredHolds(time.Now())
+		ticker := time.NewTicker(30 * time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			h.releaseExpiredHolds(now)
+		}
+	}()
+}
+
+// releaseExpiredHolds puts back the stock of orders that held it for longer than
+// stockHoldTTL without being paid. Orders that were paid keep theirs, as it becomes
+// their sale. Either way the order stops being swept.
+func (h *InventoryHandler) releaseExpiredHolds(now time.Time) {
+	docs, err := h.db.Client.Collection("orders").Where("stock_held", "==", true).Documents(h.db.Context).GetAll()
+	if err != nil {
+		log.Printf("Failed to load orders holding stock: %v", err)
+		return
+	}
+
+	for _, doc := range docs {
+		var order models.Order
+		if err := doc.DataTo(&order); err != nil {
+			continue
+		}
+		order.ID = doc.Ref.ID
+		paid := order.Payment.Status == "completed" || strings.EqualFold(order.Payment.Method, "cod")
+		if !paid {
+			if now.Sub(order.CreatedAt) < stockHoldTTL {
+				continue
+			}
+			if err := h.ReleaseHeldStock(order, "order not paid"); err != nil {
+				log.Printf("Failed to release stock held by order %s: %v", order.ID, err)
+				continue
+			}
+		}
+		if _, err := doc.Ref.Update(h.db.Context, []firestore.Update{{Path: "stock_held", Value: false}}); err != nil {
+			log.Printf("Failed to clear stock hold of order %s: %v", order.ID, err)
+		}
+	}
+}
+
+// GetStockMovements lists ledger entries, newest first, optionally filtered by
+// ?product_id=, ?order_id=, ?variant_id= and ?type=
+func (h *InventoryHandler) GetStockMovements(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 500 {
+		limit = 100
+	}
+
+	query := h.db.Client.Collection("stock_movements").Query
+	switch {
+	case c.Query("product_id") != "":
+		query = query.Where("product_id", "==", c.Query("product_id"))
+	case c.Query("order_id") != "":
+		query = query.Where("order_id", "==", c.Query("order_id"))
+	default:
+		query = query.OrderBy("created_at", firestore.Desc).Limit(limit)
+	}
+	docs, err := query.Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock movements"})
+		return
+	}
+
+	movements := make([]models.StockMovement, 0, len(docs))
+	for _, doc := range docs {
+		var movement models.StockMovement
+		if err := doc.DataTo(&movement); err != nil {
+			continue
+		}
+		if moveType := c.Query("type"); moveType != "" && movement.Type != moveType {
+			continue
+		}
+		if orderID := c.Query("order_id"); orderID != "" && movement.OrderID != orderID {
+			continue
+		}
+		if variantID := c.Query("variant_id"); variantID != "" && movement.VariantID != variantID && !strings.EqualFold(movement.VariantSKU, variantID) {
+			continue
+		}
+		movement.ID = doc.Ref.ID
+		movements = append(movements, movement)
+	}
+	sort.Slice(movements, func(i, j int) bool { return movements[i].CreatedAt.After(movements[j].CreatedAt) })
+	if len(movements) > limit {
+		movements = movements[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{"movements": movements, "count": len(movements)})
+}
+
+// AdjustStock records a restock, customer return or manual correction and changes the
+// stock by its quantity
+func (h *InventoryHandler) AdjustStock(c *gin.Context) {
+	var req models.StockAdjustmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch req.Type {
+	case models.StockMovementRestock, models.StockMovementReturn:
+		if req.Quantity < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Restocks and returns add stock, the quantity must be positive"})
+			return
+		}
+	case models.StockMovementAdjustment:
+		if strings.TrimSpace(req.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required for adjustments"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Type must be restock, return or adjustment"})
+		return
+	}
+
+	if !h.locationsExist(c, req.LocationID) {
+		return
+	}
+
+	err := h.applyStockMoves([]stockMove{{
+		ProductID:  req.ProductID,
+		VariantID:  req.VariantID,
+		LocationID: req.LocationID,
+		Type:       req.Type,
+		Delta:      req.Quantity,
+		OrderID:    req.OrderID,
+		Reason:     strings.TrimSpace(req.Reason),
+		Actor:      c.GetString("user_id"),
+	}})
+	if errors.Is(err, errBundleStock) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stock updated"})
+}
+
+// RecordStocktake replaces the stock of the counted products and variants, recording
+// the difference from the previous stock
+func (h *InventoryHandler) RecordStocktake(c *gin.Context) {
+	var req models.StocktakeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	moves := make([]stockMove, 0, len(req.Items))
+	locationIDs := make([]string, 0, len(req.Items))
+	for _, item := range req.Items {
+		count := item.Count
+		locationIDs = append(locationIDs, item.LocationID)
+		moves = append(moves, stockMove{
+			ProductID:  item.ProductID,
+			VariantID:  item.VariantID,
+			LocationID: item.LocationID,
+			Type:       models.StockMovementStocktake,
+			Count:      &count,
+			Reason:     strings.TrimSpace(req.Reason),
+			Actor:      c.GetString("user_id"),
+		})
+	}
+	if !h.locationsExist(c, locationIDs...) {
+		return
+	}
+	if err := h.applyStockMoves(moves); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stocktake recorded", "count": len(moves)})
+}
+
+// locationsExist checks the locations a request refers to, responding if one doesn't
+// exist. Empty IDs refer to unassigned stock.
+func (h *InventoryHandler) locationsExist(c *gin.Context, locationIDs ...string) bool {
+	checked := make(map[string]bool)
+	for _, locationID := range locationIDs {
+		if locationID == "" || checked[locationID] {
+			continue
+		}
+		location, err := h.getLocation(locationID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock location"})
+			return false
+		}
+		if location == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Stock location not found: " + locationID})
+			return false
+		}
+		checked[locationID] = true
+	}
+	return true
+}
+
+// findStockDiscrepancies compares every product's and variant's stock with the sum of
+// its ledger entries
+func (h *InventoryHandler) findStockDiscrepancies() ([]models.StockDiscrepancy, error) {
+	movementDocs, err := h.db.Client.Collection("stock_movements").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	ledger := make(map[string]int)
+	for _, doc := range movementDocs {
+		var movement models.StockMovement
+		if err := doc.DataTo(&movement); err == nil {
+			ledger[ledgerKey(movement.ProductID, movement.VariantID, movement.VariantSKU)] += movement.Delta
+		}
+	}
+
+	productDocs, err := h.db.Client.Collection("products").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	discrepancies := make([]models.StockDiscrepancy, 0)
+	for _, doc := range productDocs {
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil || product.IsGiftCard() || product.IsBundle() {
+			continue
+		}
+		product.ID = doc.Ref.ID
+
+		if recorded := ledger[product.ID]; recorded != product.StockQuantity {
+			discrepancies = append(discrepancies, models.StockDiscrepancy{
+				ProductID:   product.ID,
+				ProductName: product.Name,
+				SKU:         product.SKU,
+				Stock:       product.StockQuantity,
+				LedgerStock: recorded,
+				Difference:  product.StockQuantity - recorded,
+			})
+		}
+		for _, variant := range product.Variants {
+			if recorded := ledger[ledgerKey(product.ID, variant.ID, variant.SKU)]; recorded != variant.StockQuantity {
+				discrepancies = append(discrepancies, models.StockDiscrepancy{
+					ProductID:   product.ID,
+					ProductName: product.Name,
+					VariantID:   variant.ID,
+					VariantSKU:  variant.SKU,
+					SKU:         product.SKU,
+					Stock:       variant.StockQuantity,
+					LedgerStock: recorded,
+					Difference:  variant.StockQuantity - recorded,
+				})
+			}
+		}
+	}
+	return discrepancies, nil
+}
+
+// GetStockReconciliation lists products and variants whose stock differs from their
+// ledger. Products created before the ledger appear here until they are reconciled.
+func (h *InventoryHandler) GetStockReconciliation(c *gin.Context) {
+	discrepancies, err := h.findStockDiscrepancies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile stock"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"discrepancies": discrepancies, "count": len(discrepancies)})
+}
+
+// ReconcileStock accepts the current stock of every mismatched product and variant,
+// recording each difference as a stocktake entry so the ledger adds up again. Stock
+// itself is not changed.
+func (h *InventoryHandler) ReconcileStock(c *gin.Context) {
+	discrepancies, err := h.findStockDiscrepancies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile stock"})
+		return
+	}
+
+	reason := strings.TrimSpace(c.Query("reason"))
+	if reason == "" {
+		reason = "ledger reconciliation"
+	}
+	now := time.Now()
+	batch := h.db.Client.Batch()
+	pending := 0
+	for _, discrepancy := range discrepancies {
+		batch.Create(h.db.Client.Collection("stock_movements").NewDoc(), models.StockMovement{
+			ProductID:  discrepancy.ProductID,
+			VariantID:  discrepancy.VariantID,
+			VariantSKU: discrepancy.VariantSKU,
+			SKU:        discrepancy.SKU,
+			Type:       models.StockMovementStocktake,
+			Delta:      discrepancy.Difference,
+			Before:     discrepancy.LedgerStock,
+			After:      discrepancy.Stock,
+			Reason:     reason,
+			Actor:      c.GetString("user_id"),
+			CreatedAt:  now,
+		})
+		if pending++; pending == productImportBatchSize {
+			if _, err := batch.Commit(h.db.Context); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record reconciliation"})
+				return
+			}
+			batch = h.db.Client.Batch()
+			pending = 0
+		}
+	}
+	if pending > 0 {
+		if _, err := batch.Commit(h.db.Context); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record reconciliation"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ledger reconciled with current stock", "count": len(discrepancies)})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -237,6 +238,15 @@ func (h *InvoiceHandler) generateItemsHTML(items []models.InvoiceLineItem) strin
 			item.UnitPrice,
 			item.TotalAmount,
 		)
+		for _, component := range item.Components {
+			itemsHTML += fmt.Sprintf(
+				"<tr><td>&nbsp;&nbsp;%s (HSN %s)</td><td>%.0f</td><td></td><td>₹%.2f</td></tr>",
+				component.ProductName,
+				component.HSNCode,
+				component.Quantity,
+				component.TotalAmount,
+			)
+		}
 	}
 	return itemsHTML
 }
@@ -566,28 +576,8 @@ func (h *InvoiceHandler) createInvoiceFromOrder(order *models.Order, settings ma
 	}
 	
 	// Create line items with reverse GST calculation
-	var lineItems []models.InvoiceLineItem
 	isInterState := sellerAddress.StateCode != buyerAddress.StateCode
-
-	for i, item := range order.Items {
-		// Reverse calculate: amount is inclusive of GST
-		inclusiveAmount := item.Price * float64(item.Quantity)
-		taxableValue := inclusiveAmount / (1 + (gstRate / 100)) // Extract base amount
-		
-		lineItem := models.InvoiceLineItem{
-			ID:          fmt.Sprintf("item_%d", i+1),
-			ProductID:   item.ProductID,
-			ProductName: item.ProductName,
-			HSNCode:     "9403", // Default HSN code for handicrafts
-			Quantity:    float64(item.Quantity),
-			UnitPrice:   item.Price,
-			TaxableValue: taxableValue,
-		}
-
-		// Apply GST
-		lineItem.ApplyGST(gstRate, isInterState)
-		lineItems = append(lineItems, lineItem)
-	}
+	lineItems := buildInvoiceLineItems(order, gstRate, isInterState)
 
 	invoice := models.Invoice{
 		InvoiceNumber:   invoiceNumber,
@@ -617,6 +607,10 @@ func (h *InvoiceHandler) createInvoiceFromOrder(order *models.Order, settings ma
 		// Line items
 		LineItems: lineItems,
 		
+		// Gift card redemption
+		GiftCardCode:     order.Totals.GiftCardCode,
+		GiftCardRedeemed: order.Totals.GiftCardAmount,
+		
 		// Payment info (completely removed as requested)
 		BankDetails:     models.BankDetails{},
 		PaymentTerms:    "", // Removed
@@ -636,6 +630,110 @@ func (h *InvoiceHandler) createInvoiceFromOrder(order *models.Order, settings ma
 	return invoice
 }
 
+// buildInvoiceLineItems converts order items into GST line items. Prices are
+// inclusive of GST, so the taxable value is reverse calculated. Gift card
+// purchases are not a supply of goods and are billed without GST.
+func buildInvoiceLineItems(order *models.Order, gstRate float64, isInterState bool) []models.InvoiceLineItem {
+	var lineItems []models.InvoiceLineItem
+
+	for i, item := range order.Items {
+		// Promotion discounts are allocated per line, so tax the net value
+		inclusiveAmount := item.Price*float64(item.Quantity) - item.Discount
+		isGiftCard := item.ProductType == models.ProductTypeGiftCard
+
+		taxableValue := inclusiveAmount / (1 + (gstRate / 100)) // Extract base amount
+		hsnCode := "9403"                                       // Default HSN code for handicrafts
+		if isGiftCard {
+			taxableValue = inclusiveAmount
+			hsnCode = ""
+		}
+
+		lineItem := models.InvoiceLineItem{
+			ID:           fmt.Sprintf("item_%d", i+1),
+			ProductID:    item.ProductID,
+			ProductName:  item.ProductName,
+			HSNCode:      hsnCode,
+			Quantity:     float64(item.Quantity),
+			UnitPrice:    item.Price,
+			Discount:     item.Discount,
+			TaxableValue: taxableValue,
+			TaxExempt:    isGiftCard,
+		}
+
+		// Apply GST
+		lineItem.ApplyGST(gstRate, isInterState)
+		if len(item.BundleComponents) > 0 {
+			splitBundleLine(&lineItem, item, inclusiveAmount, gstRate, isInterState)
+		}
+		lineItems = append(lineItems, lineItem)
+	}
+
+	// Shipping is billed at the goods' rate as part of the supply; a charge waived
+	// by a free shipping promotion is shown as a discount on the shipping line
+	if shippingFee := order.Totals.Shipping + order.Totals.ShippingDiscount; shippingFee > 0 {
+		method := order.ShippingMethod
+		if method == "" {
+			method = models.ShippingMethodStandard
+		}
+		lineItem := models.InvoiceLineItem{
+			ID:           fmt.Sprintf("item_%d", len(lineItems)+1),
+			ProductName:  "Shipping charges",
+			Description:  fmt.Sprintf("%s%s delivery", strings.ToUpper(method[:1]), method[1:]),
+			HSNCode:      "9965", // SAC for goods transport services
+			Quantity:     1,
+			UnitPrice:    shippingFee,
+			Discount:     order.Totals.ShippingDiscount,
+			TaxableValue: order.Totals.Shipping / (1 + (gstRate / 100)),
+		}
+		lineItem.ApplyGST(gstRate, isInterState)
+		lineItems = append(lineItems, lineItem)
+	}
+
+	return lineItems
+}
+
+// splitBundleLine invoices a bundle's components under their own HSN codes, each
+// taking its share of the bundle's value. The bundle line's amounts become their sums.
+func splitBundleLine(lineItem *models.InvoiceLineItem, item models.OrderItem, inclusiveAmount, gstRate float64, isInterState bool) {
+	lineItem.Components = make([]models.InvoiceLineItem, 0, len(item.BundleComponents))
+	lineItem.TaxableValue, lineItem.CGSTAmount, lineItem.SGSTAmount, lineItem.IGSTAmount, lineItem.TotalAmount = 0, 0, 0, 0, 0
+
+	var hsnCodes []string
+	seen := make(map[string]bool)
+	for i, component := range item.BundleComponents {
+		hsnCode := component.HSNCode
+		if hsnCode == "" {
+			hsnCode = "9403" // Default HSN code for handicrafts
+		}
+		if !seen[hsnCode] {
+			seen[hsnCode] = true
+			hsnCodes = append(hsnCodes, hsnCode)
+		}
+
+		quantity := float64(component.Quantity * item.Quantity)
+		part := models.InvoiceLineItem{
+			ID:           fmt.Sprintf("%s_%d", lineItem.ID, i+1),
+			ProductID:    component.ProductID,
+			ProductName:  component.ProductName,
+			Description:  component.SKU,
+			HSNCode:      hsnCode,
+			Quantity:     quantity,
+			UnitPrice:    item.Price * float64(item.Quantity) * component.ValueShare / quantity,
+			Discount:     item.Discount * component.ValueShare,
+			TaxableValue: inclusiveAmount * component.ValueShare / (1 + (gstRate / 100)),
+		}
+		part.ApplyGST(gstRate, isInterState)
+
+		lineItem.TaxableValue += part.TaxableValue
+		lineItem.CGSTAmount += part.CGSTAmount
+		lineItem.SGSTAmount += part.SGSTAmount
+		lineItem.IGSTAmount += part.IGSTAmount
+		lineItem.TotalAmount += part.TotalAmount
+		lineItem.Components = append(lineItem.Components, part)
+	}
+	lineItem.HSNCode = strings.Join(hsnCodes, ", ")
+}
+
 // Helper function to safely extract string from map
 func getString(m map[string]interface{}, key, defaultValue string) string {
 	if value, ok := m[key].(string); ok && value != "" {
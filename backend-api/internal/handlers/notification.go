@@ -3,6 +3,7 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -241,9 +242,79 @@ func (h *NotificationHandler) NotifyLowStock(productName string, currentStock in
 	h.CreateNotification(
 		"product",
 		"Low Stock Alert",
-		productName+" has only "+string(currentStock)+" items left in stock",
+		productName+" has only "+strconv.Itoa(currentStock)+" items left in stock",
 		"AlertCircle",
 		"/products",
 		"admin",
 	)
-}
\ No newline at end of file
+}
+
+func (h *NotificationHandler) NotifyOutOfStock(productName string) {
+	h.CreateNotification(
+		"product",
+		"Out of Stock",
+		productName+" is out of stock",
+		"AlertCircle",
+		"/products",
+		"admin",
+	)
+}
+
+func (h *NotificationHandler) NotifyGiftCardRedemptionFailed(orderID, orderNumber, reason string) {
+	h.CreateNotification(
+		"payment",
+		"Gift Card Redemption Failed",
+		"Gift card could not be redeemed for order #"+orderNumber+": "+reason,
+		"AlertCircle",
+		"/orders/"+orderID,
+		"admin",
+	)
+}
+
+func (h *NotificationHandler) NotifyPromotionRedemptionFailed(orderID, orderNumber, reason string) {
+	h.CreateNotification(
+		"order",
+		"Promotion Limit Exceeded",
+		"Promotion usage could not be recorded for order #"+orderNumber+": "+reason,
+		"AlertCircle",
+		"/orders/"+orderID,
+		"admin",
+	)
+}
+
+// NotifyReferralFlagged creates a notification when fraud checks hold a referral reward
+func (h *NotificationHandler) NotifyReferralFlagged(referralID, orderNumber, reasons string) {
+	h.CreateNotification(
+		"user",
+		"Referral Held for Review",
+		"Referral reward for order #"+orderNumber+" was held: "+reasons,
+		"AlertCircle",
+		"/referrals/"+referralID,
+		"admin",
+	)
+}
+
+// NotifyNewReview creates a notification when a review is waiting for moderation
+func (h *NotificationHandler) NotifyNewReview(reviewID, productName string, rating int) {
+	h.CreateNotification(
+		"product",
+		"New Review to Moderate",
+		strconv.Itoa(rating)+"★ review of "+productName+" is waiting for approval",
+		"Star",
+		"/reviews/"+reviewID,
+		"admin",
+	)
+}
+
+// NotifyNewQuestion creates a notification when a product question or a customer's
+// answer is waiting for the store
+func (h *NotificationHandler) NotifyNewQuestion(questionID, productName string) {
+	h.CreateNotification(
+		"product",
+		"Product Question to Answer",
+		"A question about "+productName+" is waiting for a reply",
+		"MessageCircle",
+		"/questions/"+questionID,
+		"admin",
+	)
+}
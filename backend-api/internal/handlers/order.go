@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"sort"
 	"strings"
@@ -18,10 +20,15 @@ import (
 )
 
 type OrderHandler struct {
-	db                   *database.Firebase
-	notificationHandler  *NotificationHandler
-	emailService         *services.SendGridEmailService
-	whatsappService      *services.WhatsAppService
+	db                  *database.Firebase
+	notificationHandler *NotificationHandler
+	emailService        *services.SendGridEmailService
+	whatsappService     *services.WhatsAppService
+	giftCardHandler     *GiftCardHandler
+	promotionHandler    *PromotionHandler
+	referralHandler     *ReferralHandler
+	reviewHandler       *ReviewHandler
+	inventoryHandler    *InventoryHandler
 }
 
 func NewOrderHandler(db *database.Firebase, whatsappService *services.WhatsAppService) *OrderHandler {
@@ -34,33 +41,43 @@ func NewOrderHandler(db *database.Firebase, whatsappService *services.WhatsAppSe
 	} else {
 		log.Printf("SendGrid email service initialized successfully")
 	}
-	
+
+	giftCardHandler := NewGiftCardHandler(db, whatsappService)
 	return &OrderHandler{
 		db:                  db,
 		notificationHandler: NewNotificationHandler(db),
 		whatsappService:     whatsappService,
 		emailService:        emailService,
+		giftCardHandler:     giftCardHandler,
+		promotionHandler:    NewPromotionHandler(db),
+		referralHandler:     NewReferralHandler(db, giftCardHandler),
+		reviewHandler:       NewReviewHandler(db),
+		inventoryHandler:    NewInventoryHandler(db, whatsappService),
 	}
 }
 
 type CreateOrderRequest struct {
-	Name        string `json:"name" validate:"required"`
-	Email       string `json:"email" validate:"required,email"`
-	Phone       string `json:"phone" validate:"required"`
-	Address     models.UserAddress `json:"address" validate:"required"`
-	Items       []OrderItemRequest `json:"items" validate:"required,min=1"`
-	Totals      models.OrderTotals `json:"totals" validate:"required"`
-	PaymentMethod string `json:"paymentMethod" validate:"required"`
-	Notes       string `json:"notes"`
+	Name           string             `json:"name" validate:"required"`
+	Email          string             `json:"email" validate:"required,email"`
+	Phone          string             `json:"phone" validate:"required"`
+	Address        models.UserAddress `json:"address" validate:"required"`
+	Items          []OrderItemRequest `json:"items" binding:"required,min=1,dive"`
+	Totals         models.OrderTotals `json:"totals"` // Only the coupon code is used; amounts are worked out on the server
+	PaymentMethod  string             `json:"paymentMethod" validate:"required"`
+	ShippingMethod string             `json:"shippingMethod"` // standard or express
+	Notes          string             `json:"notes"`
+	GiftCardCode   string             `json:"gift_card_code,omitempty"`
+	GiftCardPIN    string             `json:"gift_card_pin,omitempty"`
 }
 
 type OrderItemRequest struct {
-	ProductID    string  `json:"product_id" validate:"required"`
-	Quantity     int     `json:"quantity" validate:"required,min=1"`
-	Price        float64 `json:"price" validate:"required,min=0"`
-	VariantID    string  `json:"variant_id,omitempty"`
-	VariantColor string  `json:"variant_color,omitempty"`
-	VariantSize  string  `json:"variant_size,omitempty"`
+	ProductID    string                    `json:"product_id" validate:"required"`
+	Quantity     int                       `json:"quantity" binding:"min=1,max=1000"`
+	Price        float64                   `json:"price,omitempty"` // Ignored; lines are priced from the catalogue
+	VariantID    string                    `json:"variant_id,omitempty"`
+	VariantColor string                    `json:"variant_color,omitempty"`
+	VariantSize  string                    `json:"variant_size,omitempty"`
+	GiftCard     *models.GiftCardRecipient `json:"gift_card,omitempty"` // Recipient when buying a gift card
 }
 
 func (h *OrderHandler) CreateOrder(c *gin.Context) {
@@ -103,9 +120,16 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 			}
 		}
 
+		// Lines are priced from the catalogue; the price the client sent is ignored
+		price, err := orderLinePrice(product, item.VariantID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		orderItem := models.OrderItem{
-			ProductID:    item.ProductID,
-			ProductName:  product.Name,
+			ProductID:   item.ProductID,
+			ProductName: product.Name,
 			ProductImage: func() string {
 				if len(product.Images) > 0 {
 					return product.Images[0]
@@ -114,41 +138,68 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 			}(),
 			SKU:          sku,
 			Quantity:     item.Quantity,
-			Price:        item.Price,
+			Price:        price,
 			Discount:     0,
-			Total:        item.Price * float64(item.Quantity),
+			Total:        price * float64(item.Quantity),
 			VariantID:    item.VariantID,
 			VariantColor: item.VariantColor,
 			VariantSize:  item.VariantSize,
 		}
+		if product.IsGiftCard() {
+			h.applyGiftCardItem(&orderItem, item, req)
+		}
+		if product.IsBundle() {
+			if err := applyBundleItem(h.db, &orderItem, product); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load bundle contents"})
+				return
+			}
+		}
 		orderItems = append(orderItems, orderItem)
 	}
+	if countGiftCards(orderItems) > maxGiftCardsPerOrder {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("An order can include at most %d gift cards", maxGiftCardsPerOrder)})
+		return
+	}
 
 	// Create the order
 	order := models.Order{
-		ID:          orderID,
-		OrderNumber: orderNumber,
-		UserID:      userID.(string),
-		Items:       orderItems,
+		ID:              orderID,
+		OrderNumber:     orderNumber,
+		UserID:          userID.(string),
+		Items:           orderItems,
 		ShippingAddress: req.Address,
 		BillingAddress:  req.Address, // Same as shipping for now
+		ShippingMethod:  normalizeShippingMethod(req.ShippingMethod),
 		Payment: models.Payment{
 			Method:   req.PaymentMethod,
 			Status:   "pending",
-			Amount:   req.Totals.Total,
 			Currency: "INR",
 		},
-		Totals:    req.Totals,
+		Totals:    models.OrderTotals{CouponCode: req.Totals.CouponCode},
 		Status:    "pending",
 		Notes:     req.Notes,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	// Save to Firestore
-	_, err := h.db.Client.Collection("orders").Doc(orderID).Set(h.db.Context, order)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+	// Apply automatic offers, the promo code and the prepaid discount by their stacking rules
+	customer := PromotionCustomer{UserID: order.UserID, Phone: req.Phone, Email: req.Email}
+	if err := h.applyDiscounts(&order, customer, req.GiftCardCode != ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Apply gift card as a partial or full payment
+	if req.GiftCardCode != "" {
+		if err := h.applyGiftCardPayment(&order, req.GiftCardCode, req.GiftCardPIN, c.ClientIP()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Save to Firestore, reserving the promotions the order uses
+	if err := h.saveOrder(orderID, order, customer); err != nil {
+		h.orderSaveFailed(c, err)
 		return
 	}
 
@@ -159,10 +210,12 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Order created successfully",
 		"order": gin.H{
-			"id":           order.ID,
-			"order_number": order.OrderNumber,
-			"total":        order.Totals.Total,
-			"status":       order.Status,
+			"id":               order.ID,
+			"order_number":     order.OrderNumber,
+			"total":            order.Totals.Total,
+			"gift_card_amount": order.Totals.GiftCardAmount,
+			"amount_payable":   order.Payment.Amount,
+			"status":           order.Status,
 		},
 	})
 }
@@ -175,7 +228,7 @@ func (h *OrderHandler) GetUserOrders(c *gin.Context) {
 	}
 
 	orders := make([]models.Order, 0)
-	
+
 	// Try without OrderBy first to avoid index requirements
 	docs, err := h.db.Client.Collection("orders").Where("user_id", "==", userID.(string)).Documents(h.db.Context).GetAll()
 	if err != nil {
@@ -183,7 +236,7 @@ func (h *OrderHandler) GetUserOrders(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
 		return
 	}
-	
+
 	for _, doc := range docs {
 		var order models.Order
 		if err := doc.DataTo(&order); err != nil {
@@ -193,7 +246,7 @@ func (h *OrderHandler) GetUserOrders(c *gin.Context) {
 		order.ID = doc.Ref.ID
 		orders = append(orders, order)
 	}
-	
+
 	// Sort orders by created_at in memory
 	sort.Slice(orders, func(i, j int) bool {
 		return orders[i].CreatedAt.After(orders[j].CreatedAt)
@@ -214,7 +267,7 @@ func (h *OrderHandler) GetGuestOrders(c *gin.Context) {
 	}
 
 	orders := make([]models.Order, 0)
-	
+
 	// Fetch orders by guest email
 	docs, err := h.db.Client.Collection("orders").Where("guest_email", "==", email).Documents(h.db.Context).GetAll()
 	if err != nil {
@@ -222,7 +275,7 @@ func (h *OrderHandler) GetGuestOrders(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
 		return
 	}
-	
+
 	for _, doc := range docs {
 		var order models.Order
 		if err := doc.DataTo(&order); err != nil {
@@ -232,7 +285,7 @@ func (h *OrderHandler) GetGuestOrders(c *gin.Context) {
 		order.ID = doc.Ref.ID
 		orders = append(orders, order)
 	}
-	
+
 	// Sort orders by created_at in memory
 	sort.Slice(orders, func(i, j int) bool {
 		return orders[i].CreatedAt.After(orders[j].CreatedAt)
@@ -277,7 +330,7 @@ func (h *OrderHandler) GetOrder(c *gin.Context) {
 func (h *OrderHandler) GetAllOrders(c *gin.Context) {
 	orders := make([]models.Order, 0)
 	iter := h.db.Client.Collection("orders").OrderBy("created_at", firestore.Desc).Documents(h.db.Context)
-	
+
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
@@ -287,13 +340,13 @@ func (h *OrderHandler) GetAllOrders(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
 			return
 		}
-		
+
 		var order models.Order
 		if err := doc.DataTo(&order); err != nil {
 			continue
 		}
 		order.ID = doc.Ref.ID
-		
+
 		// For logged-in users, populate customer information from user profile
 		if order.UserID != "" && order.UserID != "guest" {
 			userDoc, err := h.db.Client.Collection("mobile_users").Doc(order.UserID).Get(h.db.Context)
@@ -318,7 +371,7 @@ func (h *OrderHandler) GetAllOrders(c *gin.Context) {
 				}
 			}
 		}
-		
+
 		orders = append(orders, order)
 	}
 
@@ -330,12 +383,12 @@ func (h *OrderHandler) GetAllOrders(c *gin.Context) {
 
 func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 	orderID := c.Param("id")
-	
+
 	var req struct {
 		Status      string `json:"status" validate:"required"`
 		TrackingURL string `json:"tracking_url,omitempty"`
 	}
-	
+
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -354,81 +407,19 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
-	// If changing status to "shipped", decrement product/variant stock
-	if req.Status == "shipped" && order.Status != "shipped" {
-		// Iterate through order items and decrement stock
-		for _, item := range order.Items {
-			productRef := h.db.Client.Collection("products").Doc(item.ProductID)
-			
-			// Get current product to check stock
-			productDoc, err := productRef.Get(h.db.Context)
-			if err != nil {
-				log.Printf("Failed to get product %s: %v", item.ProductID, err)
-				continue // Skip this product but continue with others
-			}
-
-			var product models.Product
-			if err := productDoc.DataTo(&product); err != nil {
-				log.Printf("Failed to parse product %s: %v", item.ProductID, err)
-				continue
-			}
-
-			// Check if this is a variant order
-			if item.VariantID != "" && product.HasVariants {
-				// Update variant stock
-				variantUpdated := false
-				for i, variant := range product.Variants {
-					if variant.ID == item.VariantID {
-						// Decrement variant stock
-						newStock := variant.StockQuantity - item.Quantity
-						if newStock < 0 {
-							newStock = 0
-						}
-						product.Variants[i].StockQuantity = newStock
-						
-						// Update availability
-						if newStock == 0 {
-							product.Variants[i].Available = false
-						}
-						
-						variantUpdated = true
-						log.Printf("Updated variant stock for product %s, variant %s (color: %s, size: %s): %d -> %d", 
-							item.ProductID, item.VariantID, item.VariantColor, item.VariantSize, 
-							variant.StockQuantity, newStock)
-						break
-					}
-				}
-				
-				if variantUpdated {
-					// Update the entire product document with modified variants
-					_, err = productRef.Update(h.db.Context, []firestore.Update{
-						{Path: "variants", Value: product.Variants},
-						{Path: "updated_at", Value: time.Now()},
-					})
-					
-					if err != nil {
-						log.Printf("Failed to update variant stock for product %s: %v", item.ProductID, err)
-					}
-				}
-			} else {
-				// Regular product without variants - update main stock
-				newStock := product.StockQuantity - item.Quantity
-				if newStock < 0 {
-					newStock = 0 // Prevent negative stock
-				}
-
-				// Update product stock
-				_, err = productRef.Update(h.db.Context, []firestore.Update{
-					{Path: "stock_quantity", Value: newStock},
-					{Path: "updated_at", Value: time.Now()},
-				})
+	order.ID = orderID
 
-				if err != nil {
-					log.Printf("Failed to update stock for product %s: %v", item.ProductID, err)
-				} else {
-					log.Printf("Updated stock for product %s: %d -> %d", item.ProductID, product.StockQuantity, newStock)
-				}
-			}
+	// Shipping takes the items out of stock unless payment already did, e.g. for cash
+	// on delivery; cancelling or refunding an unshipped order puts back whatever was
+	// taken or held
+	if req.Status == "shipped" && order.Status != "shipped" {
+		if err := h.inventoryHandler.RecordOrderSale(order, c.GetString("user_id")); err != nil {
+			log.Printf("Failed to update stock for shipped order %s: %v", orderID, err)
+		}
+	}
+	if (req.Status == "cancelled" || req.Status == "refunded") && order.Status != req.Status && order.Status != "shipped" && order.Status != "delivered" {
+		if err := h.inventoryHandler.ReleaseOrderStock(order, c.GetString("user_id")); err != nil {
+			log.Printf("Failed to release stock for %s order %s: %v", req.Status, orderID, err)
 		}
 	}
 
@@ -437,11 +428,11 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 		{Path: "status", Value: req.Status},
 		{Path: "updated_at", Value: time.Now()},
 	}
-	
+
 	// Add tracking URL if provided (for shipped status)
 	if req.Status == "shipped" && req.TrackingURL != "" {
 		updates = append(updates, firestore.Update{
-			Path: "tracking", 
+			Path: "tracking",
 			Value: map[string]interface{}{
 				"url":        req.TrackingURL,
 				"shipped_at": time.Now(),
@@ -450,7 +441,7 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 		})
 		log.Printf("Adding tracking URL for order %s: %s", orderID, req.TrackingURL)
 	}
-	
+
 	// Update order status
 	_, err = h.db.Client.Collection("orders").Doc(orderID).Update(h.db.Context, updates)
 
@@ -459,10 +450,38 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
+	// Cancelled and refunded orders no longer count towards promotion limits, get back
+	// what they redeemed from a gift card and lose the gift cards they bought
+	if (req.Status == "cancelled" || req.Status == "refunded") && order.Status != req.Status {
+		go func() {
+			if err := h.promotionHandler.ReverseRedemptions(orderID, "order "+req.Status); err != nil {
+				log.Printf("Failed to reverse promotion usage for order %s: %v", orderID, err)
+			}
+			order.ID = orderID
+			if err := h.giftCardHandler.ReverseForOrder(order, "order "+req.Status); err != nil {
+				log.Printf("Failed to reverse gift cards for order %s: %v", orderID, err)
+			}
+		}()
+	}
+
+	// A referred customer's first delivered order earns the referrer their reward, and
+	// every delivered order invites the customer to review what they bought
+	if req.Status == "delivered" && order.Status != "delivered" {
+		go func() {
+			order.ID = orderID
+			if err := h.referralHandler.ProcessDeliveredOrder(order); err != nil {
+				log.Printf("Failed to process referral for order %s: %v", orderID, err)
+			}
+			if err := h.reviewHandler.SendReviewRequest(order); err != nil {
+				log.Printf("Failed to send review request for order %s: %v", orderID, err)
+			}
+		}()
+	}
+
 	message := "Order status updated successfully"
 	if req.Status == "shipped" && order.Status != "shipped" {
 		message = "Order marked as shipped and product stock updated"
-		
+
 		// Send shipping confirmation email and WhatsApp
 		go func() {
 			if h.emailService != nil {
@@ -474,11 +493,11 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 			} else {
 				log.Printf("Email service not available, skipping shipping confirmation email for order %s", orderID)
 			}
-			
+
 			// Send WhatsApp shipping confirmation
 			if h.whatsappService != nil {
 				customerName := "Customer"
-				
+
 				// Get customer name - priority: registered user > guest name > fallback
 				if order.UserID != "" {
 					// Fetch user details from users collection
@@ -518,7 +537,7 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 				} else if order.GuestName != "" {
 					customerName = order.GuestName
 				}
-				
+
 				// Get phone number - priority: guest_phone > billing > shipping
 				phoneNumber := order.GuestPhone
 				if phoneNumber == "" {
@@ -527,12 +546,12 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 				if phoneNumber == "" {
 					phoneNumber = order.ShippingAddress.Phone
 				}
-				
+
 				trackingURL := req.TrackingURL
 				if trackingURL == "" {
 					trackingURL = fmt.Sprintf("https://tripundlifestyle.com/orders")
 				}
-				
+
 				if phoneNumber != "" {
 					if err := h.whatsappService.SendShippingConfirmation(
 						phoneNumber,
@@ -557,31 +576,31 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 // GetTrackingRedirect redirects to the actual tracking URL for an order
 func (h *OrderHandler) GetTrackingRedirect(c *gin.Context) {
 	orderNumber := c.Param("orderNumber")
-	
+
 	// Find order by order number
 	iter := h.db.Client.Collection("orders").Where("order_number", "==", orderNumber).Documents(h.db.Context)
 	defer iter.Stop()
-	
+
 	doc, err := iter.Next()
 	if err != nil {
 		// If order not found, redirect to general orders page
 		c.Redirect(http.StatusFound, "https://tripundlifestyle.com/orders")
 		return
 	}
-	
+
 	var order models.Order
 	if err := doc.DataTo(&order); err != nil {
 		c.Redirect(http.StatusFound, "https://tripundlifestyle.com/orders")
 		return
 	}
-	
+
 	// Check if order has tracking URL
 	if order.Tracking != nil && order.Tracking.URL != "" {
 		// Redirect to the actual tracking URL provided by admin
 		c.Redirect(http.StatusFound, order.Tracking.URL)
 		return
 	}
-	
+
 	// Fallback: redirect to customer's order page
 	c.Redirect(http.StatusFound, "https://tripundlifestyle.com/orders")
 }
@@ -620,9 +639,16 @@ func (h *OrderHandler) CreateGuestOrder(c *gin.Context) {
 			}
 		}
 
+		// Lines are priced from the catalogue; the price the client sent is ignored
+		price, err := orderLinePrice(product, item.VariantID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		orderItem := models.OrderItem{
-			ProductID:    item.ProductID,
-			ProductName:  product.Name,
+			ProductID:   item.ProductID,
+			ProductName: product.Name,
 			ProductImage: func() string {
 				if len(product.Images) > 0 {
 					return product.Images[0]
@@ -631,49 +657,76 @@ func (h *OrderHandler) CreateGuestOrder(c *gin.Context) {
 			}(),
 			SKU:          sku,
 			Quantity:     item.Quantity,
-			Price:        item.Price,
+			Price:        price,
 			Discount:     0,
-			Total:        item.Price * float64(item.Quantity),
+			Total:        price * float64(item.Quantity),
 			VariantID:    item.VariantID,
 			VariantColor: item.VariantColor,
 			VariantSize:  item.VariantSize,
 		}
+		if product.IsGiftCard() {
+			h.applyGiftCardItem(&orderItem, item, req)
+		}
+		if product.IsBundle() {
+			if err := applyBundleItem(h.db, &orderItem, product); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load bundle contents"})
+				return
+			}
+		}
 		orderItems = append(orderItems, orderItem)
 	}
+	if countGiftCards(orderItems) > maxGiftCardsPerOrder {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("An order can include at most %d gift cards", maxGiftCardsPerOrder)})
+		return
+	}
 
 	// Create the order with guest information
 	order := models.Order{
-		ID:          orderID,
-		OrderNumber: orderNumber,
-		UserID:      "guest", // Mark as guest order
-		GuestEmail:  req.Email,
-		GuestName:   req.Name,
-		GuestPhone:  req.Phone,
-		Items:       orderItems,
+		ID:              orderID,
+		OrderNumber:     orderNumber,
+		UserID:          "guest", // Mark as guest order
+		GuestEmail:      req.Email,
+		GuestName:       req.Name,
+		GuestPhone:      req.Phone,
+		Items:           orderItems,
 		ShippingAddress: req.Address,
 		BillingAddress:  req.Address,
+		ShippingMethod:  normalizeShippingMethod(req.ShippingMethod),
 		Payment: models.Payment{
 			Method:   req.PaymentMethod,
 			Status:   "pending",
-			Amount:   req.Totals.Total,
 			Currency: "INR",
 		},
-		Totals:    req.Totals,
+		Totals:    models.OrderTotals{CouponCode: req.Totals.CouponCode},
 		Status:    "pending",
 		Notes:     req.Notes,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	// Save to Firestore
-	_, err := h.db.Client.Collection("orders").Doc(orderID).Set(h.db.Context, order)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+	// Apply automatic offers, the promo code and the prepaid discount by their stacking rules
+	customer := PromotionCustomer{UserID: order.UserID, Phone: req.Phone, Email: req.Email}
+	if err := h.applyDiscounts(&order, customer, req.GiftCardCode != ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Apply gift card as a partial or full payment
+	if req.GiftCardCode != "" {
+		if err := h.applyGiftCardPayment(&order, req.GiftCardCode, req.GiftCardPIN, c.ClientIP()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Save to Firestore, reserving the promotions the order uses
+	if err := h.saveOrder(orderID, order, customer); err != nil {
+		h.orderSaveFailed(c, err)
 		return
 	}
 
 	// Create notification for new guest order
-	h.notificationHandler.NotifyNewOrder(orderID, orderNumber, req.Totals.Total)
+	h.notificationHandler.NotifyNewOrder(orderID, orderNumber, order.Totals.Total)
 
 	// Note: Order confirmation email will be sent after payment confirmation
 
@@ -712,4 +765,228 @@ func (h *OrderHandler) GetGuestOrder(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{"order": order})
-}
\ No newline at end of file
+}
+
+// QuoteOrderRequest describes a cart at checkout for pricing before the order is placed
+type QuoteOrderRequest struct {
+	Items          []OrderItemRequest  `json:"items" binding:"required,min=1,dive"`
+	CouponCode     string              `json:"coupon_code,omitempty"`
+	PaymentMethod  string              `json:"payment_method,omitempty"`
+	GiftCardCode   string              `json:"gift_card_code,omitempty"`
+	GiftCardPIN    string              `json:"gift_card_pin,omitempty"`
+	ShippingMethod string              `json:"shipping_method,omitempty"` // standard (default) or express
+	Address        *models.UserAddress `json:"address,omitempty"`
+	UserID         string              `json:"user_id,omitempty"`
+	Phone          string              `json:"phone,omitempty"`
+	Email          string              `json:"email,omitempty"`
+}
+
+// QuoteOrder prices a cart, including shipping, with every applicable discount and
+// explains which discounts were applied and why others were rejected
+func (h *OrderHandler) QuoteOrder(c *gin.Context) {
+	var req QuoteOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make([]PromotionCartItem, 0, len(req.Items))
+	var giftCardValue float64
+	for _, item := range req.Items {
+		product, err := h.db.GetProductByID(item.ProductID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Product not found: %s", item.ProductID)})
+			return
+		}
+		price, err := orderLinePrice(product, item.VariantID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if product.IsGiftCard() {
+			giftCardValue += price * float64(item.Quantity)
+		}
+		items = append(items, PromotionCartItem{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+			Price:     price,
+		})
+	}
+
+	customer := PromotionCustomer{UserID: req.UserID, Phone: req.Phone, Email: req.Email}
+	quote, _, err := h.promotionHandler.evaluateDiscounts(items, req.CouponCode, 0, req.PaymentMethod, req.GiftCardCode != "", customer, &PromotionShipping{Method: req.ShippingMethod, Address: req.Address})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate discounts"})
+		return
+	}
+
+	total := math.Round((quote.Subtotal-quote.TotalDiscount+quote.Shipping)*100) / 100
+	var giftCardAmount float64
+	if decision := quote.decision(models.DiscountKindStoreCredit); decision != nil && decision.Applied {
+		// Gift cards cannot be used to buy other gift cards
+		if payable := total - giftCardValue; payable > 0 {
+			if _, amount, err := h.giftCardHandler.ValidateForOrder(req.GiftCardCode, req.GiftCardPIN, c.ClientIP(), payable); err != nil {
+				decision.Applied = false
+				decision.Reason = err.Error()
+			} else {
+				giftCardAmount = amount
+				decision.Amount = amount
+			}
+		} else {
+			decision.Applied = false
+			decision.Reason = "Gift cards cannot be used to purchase gift cards"
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quote":            quote,
+		"total":            total,
+		"gift_card_amount": giftCardAmount,
+		"amount_payable":   math.Round((total-giftCardAmount)*100) / 100,
+	})
+}
+
+// saveOrder creates a new order together with its promotion reservations, then holds
+// its stock until it is paid. Cash on delivery orders take their stock when they ship.
+func (h *OrderHandler) saveOrder(orderID string, order models.Order, customer PromotionCustomer) error {
+	orderRef := h.db.Client.Collection("orders").Doc(orderID)
+	if err := h.promotionHandler.CreateOrderWithRedemptions(orderRef, order, customer); err != nil {
+		return err
+	}
+	if !strings.EqualFold(order.Payment.Method, "cod") {
+		order.ID = orderID
+		if err := h.inventoryHandler.HoldOrderStock(order, stockActorSystem); err != nil {
+			log.Printf("Failed to hold stock for order %s: %v", orderID, err)
+		}
+	}
+	return nil
+}
+
+// orderSaveFailed responds to an order that couldn't be saved
+func (h *OrderHandler) orderSaveFailed(c *gin.Context, err error) {
+	var unavailable promotionUnavailableError
+	if errors.As(err, &unavailable) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Promotion is no longer available: " + unavailable.Error()})
+		return
+	}
+	log.Printf("Failed to create order: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+}
+
+// applyGiftCardItem marks an order line as a gift card purchase. Without explicit
+// recipient details the card is delivered to the buyer.
+func (h *OrderHandler) applyGiftCardItem(orderItem *models.OrderItem, item OrderItemRequest, req CreateOrderRequest) {
+	orderItem.ProductType = models.ProductTypeGiftCard
+	orderItem.GiftCard = item.GiftCard
+	if orderItem.GiftCard == nil {
+		orderItem.GiftCard = &models.GiftCardRecipient{
+			Name:  req.Name,
+			Email: req.Email,
+			Phone: req.Phone,
+		}
+	}
+}
+
+// applyGiftCardPayment validates a gift card and records the amount it covers.
+// The balance is only debited once the order is paid.
+func (h *OrderHandler) applyGiftCardPayment(order *models.Order, code, pin, clientIP string) error {
+	// Gift cards cannot be used to buy other gift cards
+	payable := order.Totals.Total
+	for _, item := range order.Items {
+		if item.ProductType == models.ProductTypeGiftCard {
+			payable -= item.Price*float64(item.Quantity) - item.Discount
+		}
+	}
+	if payable <= 0 {
+		return fmt.Errorf("gift cards cannot be used to purchase gift cards")
+	}
+
+	card, amount, err := h.giftCardHandler.ValidateForOrder(code, pin, clientIP, payable)
+	if err != nil {
+		return err
+	}
+
+	order.Totals.GiftCardCode = card.Code
+	order.Totals.GiftCardAmount = amount
+	for i := range order.DiscountDecisions {
+		if order.DiscountDecisions[i].Kind == models.DiscountKindStoreCredit {
+			order.DiscountDecisions[i].Amount = amount
+		}
+	}
+	order.Payment.Amount = math.Round((order.Totals.Total-amount)*100) / 100
+	return nil
+}
+
+// orderLinePrice is what a line sells at now: the variant's price when a variant is
+// ordered, otherwise the product's. For a gift card this is its denomination.
+func orderLinePrice(product *models.Product, variantID string) (float64, error) {
+	if variantID == "" {
+		return product.EffectivePrice(), nil
+	}
+	for i := range product.Variants {
+		if product.Variants[i].ID == variantID {
+			return product.VariantPrice(&product.Variants[i]), nil
+		}
+	}
+	return 0, fmt.Errorf("variant %s not found for product %s", variantID, product.ID)
+}
+
+// applyDiscounts prices the order on the server. It evaluates the discounts, allocates
+// them across the lines so invoices tax the net value, and works out the shipping, GST
+// and total from the catalogue prices. Free gifts are appended.
+func (h *OrderHandler) applyDiscounts(order *models.Order, customer PromotionCustomer, storeCredit bool) error {
+	shipping := &PromotionShipping{Method: order.ShippingMethod, Address: &order.ShippingAddress}
+	quote, err := h.promotionHandler.ApplyDiscountsToOrderItems(order.Items, order.Totals.CouponCode, 0, order.Payment.Method, storeCredit, customer, shipping)
+	if err != nil {
+		return err
+	}
+
+	order.Items = append(order.Items, quote.FreeGifts...)
+	order.AppliedOffers = quote.Offers
+	order.DiscountDecisions = quote.Decisions
+
+	order.Totals.OfferDiscount = quote.OfferDiscount
+	order.Totals.CouponAmount = quote.CouponDiscount
+	order.Totals.ReferralDiscount = quote.ReferralDiscount
+	order.Totals.PrepaidDiscount = quote.PrepaidDiscount
+	order.Totals.ShippingDiscount = quote.ShippingDiscount
+	order.Totals.Discount = quote.TotalDiscount
+	order.Totals.Shipping = quote.Shipping
+	order.Totals.Total = math.Round((quote.Subtotal-quote.TotalDiscount+quote.Shipping)*100) / 100
+	setOrderTax(order, getTaxSettings(h.db))
+	order.Payment.Amount = order.Totals.Total
+
+	if storeCredit {
+		if decision := quote.decision(models.DiscountKindStoreCredit); decision != nil && !decision.Applied {
+			return fmt.Errorf("gift card cannot be used on this order: %s", decision.Reason)
+		}
+	}
+	return nil
+}
+
+// setOrderTax splits the GST out of the order's GST-inclusive lines after discounts.
+// Subtotal is the value of the items before GST; gift cards carry no GST. Intra-state
+// orders are taxed as CGST and SGST, others as IGST.
+func setOrderTax(order *models.Order, tax TaxSettings) {
+	var taxable, exempt float64
+	for _, item := range order.Items {
+		net := item.Price*float64(item.Quantity) - item.Discount
+		if item.ProductType == models.ProductTypeGiftCard {
+			exempt += net
+		} else {
+			taxable += net
+		}
+	}
+
+	base := math.Round(taxable/(1+tax.Rate/100)*100) / 100
+	order.Totals.Subtotal = math.Round((base+exempt)*100) / 100
+	order.Totals.Tax = math.Round((taxable-base)*100) / 100
+	order.Totals.CGST, order.Totals.SGST, order.Totals.IGST = 0, 0, 0
+	if getStateCode(order.ShippingAddress.State) == tax.HomeStateCode {
+		order.Totals.CGST = math.Round(order.Totals.Tax*50) / 100
+		order.Totals.SGST = math.Round((order.Totals.Tax-order.Totals.CGST)*100) / 100
+	} else {
+		order.Totals.IGST = order.Totals.Tax
+	}
+}
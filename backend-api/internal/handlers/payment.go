@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"sort"
 	"strings"
@@ -15,6 +16,8 @@ import (
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
 	"github.com/razorpay/razorpay-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"tripund-api/internal/database"
 	"tripund-api/internal/models"
 	"tripund-api/internal/services"
@@ -29,17 +32,20 @@ type PaymentHandler struct {
 	notificationHandler *NotificationHandler
 	emailService        *services.SendGridEmailService
 	whatsappService     *services.WhatsAppService
+	giftCardHandler     *GiftCardHandler
+	promotionHandler    *PromotionHandler
+	inventoryHandler    *InventoryHandler
 }
 
 func NewPaymentHandler(db *database.Firebase, keyID, keySecret, webhookSecret string, whatsappService *services.WhatsAppService) *PaymentHandler {
 	client := razorpay.NewClient(keyID, keySecret)
-	
+
 	// Initialize email service
 	emailService, err := services.NewSendGridEmailService()
 	if err != nil {
 		log.Printf("WARNING: Failed to initialize email service in PaymentHandler: %v", err)
 	}
-	
+
 	return &PaymentHandler{
 		db:                  db,
 		client:              client,
@@ -49,13 +55,45 @@ func NewPaymentHandler(db *database.Firebase, keyID, keySecret, webhookSecret st
 		notificationHandler: NewNotificationHandler(db),
 		emailService:        emailService,
 		whatsappService:     whatsappService,
+		giftCardHandler:     NewGiftCardHandler(db, whatsappService),
+		promotionHandler:    NewPromotionHandler(db),
+		inventoryHandler:    NewInventoryHandler(db, whatsappService),
 	}
 }
 
 type CreatePaymentOrderRequest struct {
-	Amount   float64 `json:"amount" validate:"required,min=1"`
+	Amount   float64 `json:"amount,omitempty"` // Ignored; the amount payable on the order is charged
 	Currency string  `json:"currency"`
-	OrderID  string  `json:"order_id" validate:"required"`
+	OrderID  string  `json:"order_id" binding:"required"`
+}
+
+// orderAmountPayable responds with an error unless the order exists and has something
+// left to pay, returning what is payable in paise as priced when the order was created
+func (h *PaymentHandler) orderAmountPayable(c *gin.Context, orderID string) (int, bool) {
+	doc, err := h.db.Client.Collection("orders").Doc(orderID).Get(h.db.Context)
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return 0, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return 0, false
+	}
+	var order models.Order
+	if err := doc.DataTo(&order); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read order"})
+		return 0, false
+	}
+	if order.Payment.Status == "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is already paid"})
+		return 0, false
+	}
+	amount := int(math.Round(order.Payment.Amount * 100))
+	if amount < 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order has no amount payable online"})
+		return 0, false
+	}
+	return amount, true
 }
 
 type VerifyPaymentRequest struct {
@@ -76,8 +114,13 @@ func (h *PaymentHandler) CreateRazorpayOrder(c *gin.Context) {
 		req.Currency = "INR"
 	}
 
+	amount, ok := h.orderAmountPayable(c, req.OrderID)
+	if !ok {
+		return
+	}
+
 	data := map[string]interface{}{
-		"amount":   int(req.Amount * 100),
+		"amount":   amount,
 		"currency": req.Currency,
 		"receipt":  req.OrderID,
 		"notes": map[string]string{
@@ -141,26 +184,33 @@ func (h *PaymentHandler) VerifyPayment(c *gin.Context) {
 	orderDoc, _ := h.db.Client.Collection("orders").Doc(req.OrderID).Get(h.db.Context)
 	var order models.Order
 	orderDoc.DataTo(&order)
-	
+	order.ID = req.OrderID
+
 	// Create notification for payment received
 	h.notificationHandler.NotifyPaymentReceived(order.OrderNumber, order.Totals.Total)
 
 	// Auto-generate invoice, send email, and update stock after payment verification
 	go func() {
+		// Redeem applied gift card and issue purchased ones
+		h.processGiftCards(order)
+
+		// Record promo code and offer usage
+		h.recordPromotionUsage(order, req.RazorpayPaymentID)
+
 		// Update stock quantities
 		if err := h.updateStockForOrder(order); err != nil {
 			log.Printf("Failed to update stock for order %s: %v", req.OrderID, err)
 		} else {
 			log.Printf("Successfully updated stock for order %s", req.OrderID)
 		}
-		
+
 		// Generate invoice
 		if err := h.generateInvoiceForOrder(req.OrderID); err != nil {
 			log.Printf("Failed to auto-generate invoice for order %s: %v", req.OrderID, err)
 		} else {
 			log.Printf("Successfully auto-generated invoice for order %s", req.OrderID)
 		}
-		
+
 		// Send order confirmation email
 		if h.emailService != nil {
 			if err := h.emailService.SendOrderConfirmation(order); err != nil {
@@ -171,11 +221,11 @@ func (h *PaymentHandler) VerifyPayment(c *gin.Context) {
 		} else {
 			log.Printf("Email service not available for order %s", req.OrderID)
 		}
-		
+
 		// Send WhatsApp order confirmation
 		if h.whatsappService != nil {
 			customerName := "Customer"
-			
+
 			// Get customer name - priority: registered user > guest name > fallback
 			if order.UserID != "" {
 				// Fetch user details from users collection
@@ -215,7 +265,7 @@ func (h *PaymentHandler) VerifyPayment(c *gin.Context) {
 			} else if order.GuestName != "" {
 				customerName = order.GuestName
 			}
-			
+
 			// Build items list
 			var items []string
 			for _, item := range order.Items {
@@ -236,7 +286,7 @@ func (h *PaymentHandler) VerifyPayment(c *gin.Context) {
 				itemText += fmt.Sprintf(" x%d", item.Quantity)
 				items = append(items, itemText)
 			}
-			
+
 			// Get phone number - priority: guest_phone > billing > shipping
 			phoneNumber := order.GuestPhone
 			if phoneNumber == "" {
@@ -245,7 +295,7 @@ func (h *PaymentHandler) VerifyPayment(c *gin.Context) {
 			if phoneNumber == "" {
 				phoneNumber = order.ShippingAddress.Phone
 			}
-			
+
 			if phoneNumber != "" {
 				if err := h.whatsappService.SendOrderConfirmation(
 					phoneNumber,
@@ -265,7 +315,7 @@ func (h *PaymentHandler) VerifyPayment(c *gin.Context) {
 	}()
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Payment verified successfully",
+		"message":  "Payment verified successfully",
 		"order_id": req.OrderID,
 	})
 }
@@ -280,7 +330,7 @@ func (h *PaymentHandler) verifySignature(orderID, paymentID, signature string) b
 
 func (h *PaymentHandler) RazorpayWebhook(c *gin.Context) {
 	signature := c.GetHeader("X-Razorpay-Signature")
-	
+
 	// Read the raw body for signature verification
 	body, err := c.GetRawData()
 	if err != nil {
@@ -294,11 +344,11 @@ func (h *PaymentHandler) RazorpayWebhook(c *gin.Context) {
 	if webhookSecret == "" {
 		webhookSecret = h.secret
 	}
-	
+
 	mac := hmac.New(sha256.New, []byte(webhookSecret))
 	mac.Write(body)
 	expectedSig := hex.EncodeToString(mac.Sum(nil))
-	
+
 	if signature != expectedSig {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
 		return
@@ -340,6 +390,12 @@ func (h *PaymentHandler) RazorpayWebhook(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process order paid"})
 			return
 		}
+	case "refund.processed":
+		// Refund completed
+		if err := h.handleRefundProcessed(payload); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process refund"})
+			return
+		}
 	default:
 		// Log other events but don't process
 		log.Printf("Received webhook event: %s", event)
@@ -354,21 +410,21 @@ func (h *PaymentHandler) handlePaymentCaptured(payload map[string]interface{}) e
 	if !ok {
 		return fmt.Errorf("invalid payload structure")
 	}
-	
+
 	paymentWrapper, ok := payloadData["payment"].(map[string]interface{})
 	if !ok {
 		return fmt.Errorf("payment data not found in payload")
 	}
-	
+
 	paymentData, ok := paymentWrapper["entity"].(map[string]interface{})
 	if !ok {
 		return fmt.Errorf("entity data not found in payment")
 	}
-	
+
 	// Extract order ID from notes or description
 	notes, _ := paymentData["notes"].(map[string]interface{})
 	orderID, _ := notes["order_id"].(string)
-	
+
 	if orderID == "" {
 		return fmt.Errorf("order ID not found in payment notes")
 	}
@@ -377,7 +433,7 @@ func (h *PaymentHandler) handlePaymentCaptured(payload map[string]interface{}) e
 	paymentMethod, _ := paymentData["method"].(string)
 	bank, _ := paymentData["bank"].(string)
 	wallet, _ := paymentData["wallet"].(string)
-	
+
 	// Update order payment status with payment method details
 	_, err := h.db.Client.Collection("orders").Doc(orderID).Update(h.db.Context, []firestore.Update{
 		{Path: "payment.status", Value: "completed"},
@@ -392,7 +448,7 @@ func (h *PaymentHandler) handlePaymentCaptured(payload map[string]interface{}) e
 	if err != nil {
 		return err
 	}
-	
+
 	// Note: Invoice generation and email sending handled by frontend payment verification API
 
 	return nil
@@ -400,10 +456,10 @@ func (h *PaymentHandler) handlePaymentCaptured(payload map[string]interface{}) e
 
 func (h *PaymentHandler) handlePaymentFailed(payload map[string]interface{}) error {
 	paymentData := payload["payload"].(map[string]interface{})["payment"].(map[string]interface{})["entity"].(map[string]interface{})
-	
+
 	notes, _ := paymentData["notes"].(map[string]interface{})
 	orderID, _ := notes["order_id"].(string)
-	
+
 	if orderID == "" {
 		return fmt.Errorf("order ID not found in payment notes")
 	}
@@ -415,13 +471,33 @@ func (h *PaymentHandler) handlePaymentFailed(payload map[string]interface{}) err
 		{Path: "status", Value: "payment_failed"},
 		{Path: "updated_at", Value: time.Now()},
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	// The promotions and stock the order reserved are free for others again. A retried
+	// payment that succeeds records them afresh.
+	if err := h.promotionHandler.ReverseRedemptions(orderID, "payment failed"); err != nil {
+		log.Printf("Failed to release promotion usage for order %s: %v", orderID, err)
+	}
+	orderDoc, err := h.db.Client.Collection("orders").Doc(orderID).Get(h.db.Context)
+	if err != nil {
+		log.Printf("Failed to load order %s to release its stock: %v", orderID, err)
+		return nil
+	}
+	var order models.Order
+	if err := orderDoc.DataTo(&order); err == nil && order.Payment.Status != "completed" {
+		order.ID = orderID
+		if err := h.inventoryHandler.ReleaseHeldStock(order, "payment failed"); err != nil {
+			log.Printf("Failed to release stock held by order %s: %v", orderID, err)
+		}
+	}
+	return nil
 }
 
 func (h *PaymentHandler) handleOrderPaid(payload map[string]interface{}) error {
 	orderData := payload["payload"].(map[string]interface{})["order"].(map[string]interface{})["entity"].(map[string]interface{})
-	
+
 	receipt, _ := orderData["receipt"].(string)
 	if receipt == "" {
 		return fmt.Errorf("receipt (order ID) not found")
@@ -438,13 +514,72 @@ func (h *PaymentHandler) handleOrderPaid(payload map[string]interface{}) error {
 	return err
 }
 
+// handleRefundProcessed marks fully refunded orders and releases their promotion usage,
+// gift card activity and, if they hadn't shipped, their stock
+func (h *PaymentHandler) handleRefundProcessed(payload map[string]interface{}) error {
+	payloadData, ok := payload["payload"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid payload structure")
+	}
+	paymentWrapper, ok := payloadData["payment"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("payment data not found in payload")
+	}
+	paymentData, ok := paymentWrapper["entity"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("entity data not found in payment")
+	}
+
+	paymentID, _ := paymentData["id"].(string)
+	amount, _ := paymentData["amount"].(float64)
+	amountRefunded, _ := paymentData["amount_refunded"].(float64)
+
+	// Partial refunds keep the promotion usage
+	if paymentID == "" || amount <= 0 || amountRefunded < amount {
+		return nil
+	}
+
+	docs, err := h.db.Client.Collection("orders").Where("payment.razorpay_payment_id", "==", paymentID).Limit(1).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		log.Printf("Refund webhook: no order found for payment %s", paymentID)
+		return nil
+	}
+
+	orderID := docs[0].Ref.ID
+	_, err = h.db.Client.Collection("orders").Doc(orderID).Update(h.db.Context, []firestore.Update{
+		{Path: "payment.status", Value: "refunded"},
+		{Path: "status", Value: "refunded"},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+
+	var order models.Order
+	if err := docs[0].DataTo(&order); err != nil {
+		return err
+	}
+	order.ID = orderID
+	if err := h.giftCardHandler.ReverseForOrder(order, "order fully refunded"); err != nil {
+		log.Printf("Failed to reverse gift cards for order %s: %v", orderID, err)
+	}
+
+	// Stock of an order refunded before it shipped goes back on the shelf
+	if order.Status != "shipped" && order.Status != "delivered" {
+		if err := h.inventoryHandler.ReleaseOrderStock(order, stockActorSystem); err != nil {
+			log.Printf("Failed to release stock for refunded order %s: %v", orderID, err)
+		}
+	}
+
+	return h.promotionHandler.ReverseRedemptions(orderID, "order fully refunded")
+}
+
 // CreateGuestRazorpayOrder creates a Razorpay order for guest checkout
 func (h *PaymentHandler) CreateGuestRazorpayOrder(c *gin.Context) {
-	var req struct {
-		Amount   float64 `json:"amount" binding:"required"`
-		Currency string  `json:"currency"`
-		OrderID  string  `json:"order_id" binding:"required"`
-	}
+	var req CreatePaymentOrderRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -455,9 +590,14 @@ func (h *PaymentHandler) CreateGuestRazorpayOrder(c *gin.Context) {
 		req.Currency = "INR"
 	}
 
+	amount, ok := h.orderAmountPayable(c, req.OrderID)
+	if !ok {
+		return
+	}
+
 	// Create Razorpay order
 	orderData := map[string]interface{}{
-		"amount":   int(req.Amount * 100), // Convert to paise
+		"amount":   amount, // In paise
 		"currency": req.Currency,
 		"receipt":  req.OrderID,
 	}
@@ -481,9 +621,9 @@ func (h *PaymentHandler) CreateGuestRazorpayOrder(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"razorpay_order_id": order["id"],
-		"amount":           order["amount"],
-		"currency":         order["currency"],
-		"key_id":          h.keyID,
+		"amount":            order["amount"],
+		"currency":          order["currency"],
+		"key_id":            h.keyID,
 	})
 }
 
@@ -505,7 +645,7 @@ func (h *PaymentHandler) VerifyGuestPayment(c *gin.Context) {
 	params := map[string]interface{}{
 		"razorpay_order_id":   req.RazorpayOrderID,
 		"razorpay_payment_id": req.RazorpayPaymentID,
-		"razorpay_signature": req.RazorpaySignature,
+		"razorpay_signature":  req.RazorpaySignature,
 	}
 
 	// Verify signature manually
@@ -513,7 +653,7 @@ func (h *PaymentHandler) VerifyGuestPayment(c *gin.Context) {
 	mac := hmac.New(sha256.New, []byte(h.secret))
 	mac.Write([]byte(signature))
 	expectedSig := hex.EncodeToString(mac.Sum(nil))
-	
+
 	if params["razorpay_signature"].(string) != expectedSig {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment signature"})
 		return
@@ -542,21 +682,27 @@ func (h *PaymentHandler) VerifyGuestPayment(c *gin.Context) {
 			log.Printf("Failed to get order for post-payment processing: %v", err)
 			return
 		}
-		
+
 		var updatedOrder models.Order
 		if err := updatedOrderDoc.DataTo(&updatedOrder); err != nil {
 			log.Printf("Failed to parse order for post-payment processing: %v", err)
 			return
 		}
 		updatedOrder.ID = updatedOrderDoc.Ref.ID
-		
+
+		// Redeem applied gift card and issue purchased ones
+		h.processGiftCards(updatedOrder)
+
+		// Record promo code and offer usage
+		h.recordPromotionUsage(updatedOrder, req.RazorpayPaymentID)
+
 		// Generate invoice
 		if err := h.generateInvoiceForOrder(req.OrderID); err != nil {
 			log.Printf("Failed to auto-generate invoice for guest order %s: %v", req.OrderID, err)
 		} else {
 			log.Printf("Successfully auto-generated invoice for guest order %s", req.OrderID)
 		}
-		
+
 		// Send order confirmation email
 		if h.emailService != nil {
 			if err := h.emailService.SendOrderConfirmation(updatedOrder); err != nil {
@@ -570,10 +716,145 @@ func (h *PaymentHandler) VerifyGuestPayment(c *gin.Context) {
 	}()
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Payment verified successfully",
+		"message":  "Payment verified successfully",
 		"order_id": req.OrderID,
 	})
 }
+
+// processGiftCards debits the gift card applied to a paid order and issues any
+// gift cards bought in it
+func (h *PaymentHandler) processGiftCards(order models.Order) {
+	if err := h.giftCardHandler.RedeemForOrder(order); err != nil {
+		log.Printf("Failed to redeem gift card for order %s: %v", order.ID, err)
+		h.notificationHandler.NotifyGiftCardRedemptionFailed(order.ID, order.OrderNumber, err.Error())
+	}
+
+	if err := h.giftCardHandler.IssueGiftCardsForOrder(order); err != nil {
+		log.Printf("Failed to issue gift cards for order %s: %v", order.ID, err)
+	}
+}
+
+// recordPromotionUsage confirms the promotions reserved by a paid order. Guests are
+// identified by the contact details Razorpay captured with the payment, falling back
+// to the details on the order.
+func (h *PaymentHandler) recordPromotionUsage(order models.Order, razorpayPaymentID string) {
+	if order.Totals.CouponCode == "" && len(order.AppliedOffers) == 0 {
+		return
+	}
+
+	customer := PromotionCustomer{UserID: order.UserID, Phone: order.GuestPhone, Email: order.GuestEmail}
+	if razorpayPaymentID != "" {
+		if payment, err := h.client.Payment.Fetch(razorpayPaymentID, nil, nil); err == nil {
+			if contact, ok := payment["contact"].(string); ok && contact != "" {
+				customer.Phone = contact
+			}
+			if email, ok := payment["email"].(string); ok && email != "" {
+				customer.Email = email
+			}
+		} else {
+			log.Printf("Failed to fetch Razorpay payment %s: %v", razorpayPaymentID, err)
+		}
+	}
+	if customer.Phone == "" {
+		customer.Phone = order.ShippingAddress.Phone
+	}
+
+	if err := h.promotionHandler.RecordRedemptions(order, customer); err != nil {
+		log.Printf("Failed to record promotion usage for order %s: %v", order.ID, err)
+		h.notificationHandler.NotifyPromotionRedemptionFailed(order.ID, order.OrderNumber, err.Error())
+	}
+}
+
+// CompleteGiftCardPayment confirms an order whose total is fully covered by a gift card
+func (h *PaymentHandler) CompleteGiftCardPayment(c *gin.Context) {
+	var req struct {
+		OrderID string `json:"order_id" binding:"required"`
+		Email   string `json:"email,omitempty"` // Required for guest orders
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	orderDoc, err := h.db.Client.Collection("orders").Doc(req.OrderID).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	var order models.Order
+	if err := orderDoc.DataTo(&order); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse order"})
+		return
+	}
+	order.ID = orderDoc.Ref.ID
+
+	// Logged-in users must own the order, guests must match the order email
+	if userID := c.GetString("user_id"); userID != "" {
+		if order.UserID != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+	} else if order.UserID != "guest" || !strings.EqualFold(order.GuestEmail, req.Email) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email for this order"})
+		return
+	}
+
+	if order.Payment.Status == "completed" {
+		c.JSON(http.StatusOK, gin.H{"message": "Payment already completed", "order_id": order.ID})
+		return
+	}
+	if order.Totals.GiftCardAmount <= 0 || order.Payment.Amount > 0.005 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order is not fully covered by a gift card"})
+		return
+	}
+
+	// Debit synchronously so the customer learns immediately if the card can't pay
+	if err := h.giftCardHandler.RedeemForOrder(order); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to redeem gift card: " + err.Error()})
+		return
+	}
+
+	now := time.Now()
+	_, err = h.db.Client.Collection("orders").Doc(order.ID).Update(h.db.Context, []firestore.Update{
+		{Path: "payment.method", Value: "gift_card"},
+		{Path: "payment.status", Value: "completed"},
+		{Path: "payment.transaction_id", Value: order.Totals.GiftCardCode},
+		{Path: "payment.paid_at", Value: now},
+		{Path: "status", Value: "processing"},
+		{Path: "updated_at", Value: now},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order payment status"})
+		return
+	}
+
+	h.notificationHandler.NotifyPaymentReceived(order.OrderNumber, order.Totals.Total)
+
+	go func() {
+		h.recordPromotionUsage(order, "")
+
+		if err := h.updateStockForOrder(order); err != nil {
+			log.Printf("Failed to update stock for order %s: %v", order.ID, err)
+		}
+
+		if err := h.generateInvoiceForOrder(order.ID); err != nil {
+			log.Printf("Failed to auto-generate invoice for order %s: %v", order.ID, err)
+		}
+
+		if h.emailService != nil {
+			if err := h.emailService.SendOrderConfirmation(order); err != nil {
+				log.Printf("Failed to send order confirmation email for order %s: %v", order.ID, err)
+			}
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Payment completed with gift card",
+		"order_id": order.ID,
+	})
+}
+
 // GetAllPayments returns all payments for admin panel
 func (h *PaymentHandler) GetAllPayments(c *gin.Context) {
 	// Get query parameters for filtering
@@ -715,13 +996,13 @@ func (h *PaymentHandler) generateInvoiceForOrder(orderID string) error {
 func (h *PaymentHandler) createCompleteInvoice(order *models.Order, settings map[string]interface{}, invoiceNumber string) models.Invoice {
 	now := time.Now()
 	dueDate := now.AddDate(0, 0, 30) // 30 days due date
-	
+
 	// Extract invoice settings
 	invoiceSettings := make(map[string]interface{})
 	if inv, ok := settings["invoice"].(map[string]interface{}); ok {
 		invoiceSettings = inv
 	}
-	
+
 	// Create seller address
 	sellerAddress := models.InvoiceAddress{
 		Line1:      getStringValue(invoiceSettings, "address_line1", "Office No.-509, Logix Technova, Tower-A"),
@@ -732,7 +1013,7 @@ func (h *PaymentHandler) createCompleteInvoice(order *models.Order, settings map
 		PostalCode: getStringValue(invoiceSettings, "postal_code", "201310"),
 		Country:    "India",
 	}
-	
+
 	// Create buyer address
 	buyerAddress := models.InvoiceAddress{
 		Line1:      order.BillingAddress.Line1,
@@ -743,7 +1024,7 @@ func (h *PaymentHandler) createCompleteInvoice(order *models.Order, settings map
 		PostalCode: order.BillingAddress.PostalCode,
 		Country:    order.BillingAddress.Country,
 	}
-	
+
 	// Create buyer details
 	buyerDetails := models.BillingEntity{
 		Name:    order.GuestName,
@@ -752,7 +1033,7 @@ func (h *PaymentHandler) createCompleteInvoice(order *models.Order, settings map
 		Address: buyerAddress,
 		IsB2B:   false,
 	}
-	
+
 	// Get GST rate from settings
 	var gstRate float64 = 18.0 // Default fallback
 	if paymentSettings, ok := settings["payment"].(map[string]interface{}); ok {
@@ -760,33 +1041,13 @@ func (h *PaymentHandler) createCompleteInvoice(order *models.Order, settings map
 			gstRate = rate
 		}
 	}
-	
+
 	// Create line items with proper tax calculations (reverse calculation for inclusive amounts)
-	var lineItems []models.InvoiceLineItem
 	isInterState := sellerAddress.StateCode != buyerAddress.StateCode
-	
-	for i, item := range order.Items {
-		// Reverse calculate: if amount is inclusive of GST, extract the base amount
-		inclusiveAmount := item.Price * float64(item.Quantity)
-		taxableValue := inclusiveAmount / (1 + (gstRate / 100)) // Extract base amount from inclusive
-		
-		lineItem := models.InvoiceLineItem{
-			ID:          fmt.Sprintf("item_%d", i+1),
-			ProductID:   item.ProductID,
-			ProductName: item.ProductName,
-			HSNCode:     "9403",
-			Quantity:    float64(item.Quantity),
-			UnitPrice:   item.Price,
-			TaxableValue: taxableValue,
-		}
-		
-		// Apply GST based on inter-state or intra-state
-		lineItem.ApplyGST(gstRate, isInterState)
-		lineItems = append(lineItems, lineItem)
-	}
-	
+	lineItems := buildInvoiceLineItems(order, gstRate, isInterState)
+
 	// Payment information removed as requested
-	
+
 	// Create complete invoice
 	invoice := models.Invoice{
 		InvoiceNumber: invoiceNumber,
@@ -794,7 +1055,7 @@ func (h *PaymentHandler) createCompleteInvoice(order *models.Order, settings map
 		UserID:        order.UserID,
 		Type:          models.InvoiceTypeRegular,
 		Status:        models.InvoiceStatusSent,
-		
+
 		// Seller details
 		SellerName:    getStringValue(invoiceSettings, "registered_name", "TRIPUND Lifestyle"),
 		SellerGSTIN:   getStringValue(invoiceSettings, "gstin", ""),
@@ -802,9 +1063,9 @@ func (h *PaymentHandler) createCompleteInvoice(order *models.Order, settings map
 		SellerAddress: sellerAddress,
 		SellerEmail:   "orders@tripundlifestyle.com",
 		SellerPhone:   "+91 9711441830",
-		
+
 		// Buyer details
-		BuyerDetails:    buyerDetails,
+		BuyerDetails: buyerDetails,
 		ShippingAddress: models.InvoiceAddress{
 			Line1:      order.ShippingAddress.Line1,
 			Line2:      order.ShippingAddress.Line2,
@@ -814,111 +1075,42 @@ func (h *PaymentHandler) createCompleteInvoice(order *models.Order, settings map
 			PostalCode: order.ShippingAddress.PostalCode,
 			Country:    order.ShippingAddress.Country,
 		},
-		
+
 		// Invoice details
 		IssueDate:       now,
 		DueDate:         dueDate,
 		PlaceOfSupply:   order.ShippingAddress.State,
 		PlaceOfDelivery: order.ShippingAddress.State,
-		
+
 		// Line items
 		LineItems: lineItems,
-		
+
+		// Gift card redemption
+		GiftCardCode:     order.Totals.GiftCardCode,
+		GiftCardRedeemed: order.Totals.GiftCardAmount,
+
 		// Payment info (empty - removed as requested)
-		BankDetails:     models.BankDetails{},
-		PaymentTerms:    "", // Removed
-		
+		BankDetails:  models.BankDetails{},
+		PaymentTerms: "", // Removed
+
 		// Additional fields (empty - removed as requested)
 		Notes:           "", // Removed
 		TermsConditions: "", // Removed
-		
+
 		// System fields
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
-	
+
 	// Calculate tax summary
 	invoice.CalculateTaxSummary()
 
 	return invoice
 }
 
-// updateStockForOrder decrements stock when payment is successful
+// updateStockForOrder takes the order's items out of stock when payment is successful
 func (h *PaymentHandler) updateStockForOrder(order models.Order) error {
-	// Iterate through order items and decrement stock
-	for _, item := range order.Items {
-		productRef := h.db.Client.Collection("products").Doc(item.ProductID)
-		
-		// Get current product to check stock
-		productDoc, err := productRef.Get(h.db.Context)
-		if err != nil {
-			log.Printf("Failed to get product %s: %v", item.ProductID, err)
-			continue // Skip this product but continue with others
-		}
-
-		var product models.Product
-		if err := productDoc.DataTo(&product); err != nil {
-			log.Printf("Failed to parse product %s: %v", item.ProductID, err)
-			continue
-		}
-
-		// Check if this is a variant order
-		if item.VariantID != "" && product.HasVariants {
-			// Update variant stock
-			variantUpdated := false
-			for i, variant := range product.Variants {
-				if variant.ID == item.VariantID {
-					// Decrement variant stock
-					newStock := variant.StockQuantity - item.Quantity
-					if newStock < 0 {
-						newStock = 0
-					}
-					product.Variants[i].StockQuantity = newStock
-					
-					// Update availability
-					if newStock == 0 {
-						product.Variants[i].Available = false
-					}
-					
-					variantUpdated = true
-					log.Printf("Updated variant stock for product %s, variant %s: new stock %d", 
-						item.ProductID, item.VariantID, newStock)
-					break
-				}
-			}
-			
-			if variantUpdated {
-				// Update the entire product document with modified variants
-				_, err = productRef.Update(h.db.Context, []firestore.Update{
-					{Path: "variants", Value: product.Variants},
-					{Path: "updated_at", Value: time.Now()},
-				})
-				
-				if err != nil {
-					log.Printf("Failed to update variant stock for product %s: %v", item.ProductID, err)
-				}
-			}
-		} else {
-			// Regular product without variants - update main stock
-			newStock := product.StockQuantity - item.Quantity
-			if newStock < 0 {
-				newStock = 0 // Prevent negative stock
-			}
-
-			// Update product stock
-			_, err = productRef.Update(h.db.Context, []firestore.Update{
-				{Path: "stock_quantity", Value: newStock},
-				{Path: "updated_at", Value: time.Now()},
-			})
-
-			if err != nil {
-				log.Printf("Failed to update stock for product %s: %v", item.ProductID, err)
-			} else {
-				log.Printf("Updated stock for product %s: old=%d new=%d", item.ProductID, product.StockQuantity, newStock)
-			}
-		}
-	}
-	return nil
+	return h.inventoryHandler.RecordOrderSale(order, stockActorSystem)
 }
 
 // getStringValue helper function (renamed to avoid conflict)
@@ -1,130 +1,184 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"sort"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"tripund-api/internal/database"
+	"tripund-api/internal/middleware"
 	"tripund-api/internal/models"
+	"tripund-api/internal/search"
+	"tripund-api/internal/services"
 )
 
 type ProductHandler struct {
-	db *database.Firebase
+	db              *database.Firebase
+	searchIndex     *search.Index
+	searchRebuildMu sync.Mutex
+	suggestMu       sync.Mutex
+	suggestCache    *suggestCache
+	popularity      productPopularity
+	inventory       *InventoryHandler
 }
 
-func NewProductHandler(db *database.Firebase) *ProductHandler {
-	return &ProductHandler{db: db}
+func NewProductHandler(db *database.Firebase, whatsappService *services.WhatsAppService) *ProductHandler {
+	return &ProductHandler{db: db, searchIndex: search.NewIndex(), inventory: NewInventoryHandler(db, whatsappService)}
 }
 
+// GetProducts lists products with faceted filtering, sorting and cursor pagination.
+// Only the category and status filters run in Firestore (one array-contains per query);
+// subcategory, price range on the effective price, colour, size, tag, in-stock and
+// featured filters are applied in memory, to up to productListingMaxProducts products of
+// the category and status, cached for productListingMaxAge. Facet counts are returned
+// with each page.
 func (h *ProductHandler) GetProducts(c *gin.Context) {
-	products := make([]models.Product, 0)
-	
-	// Build Firestore query based on filters
 	query := h.db.Client.Collection("products").Query
-	
-	// Get filter parameters
+
 	category := c.Query("category")
-	subcategory := c.Query("subcategory")
-	if subcategory == "" {
-		subcategory = c.Query("type")
-	}
-	
-	// Firestore only allows one array-contains per query
-	// So we'll filter by category in Firestore and subcategory in memory
 	if category != "" {
 		query = query.Where("categories", "array-contains", category)
 	}
-	
+
 	// Status filter
 	status := c.Query("status")
-	if status != "" && status != "all" {
-		query = query.Where("status", "==", status)
-	} else if status == "" {
+	if status == "" {
 		// Default to active if no status specified
-		query = query.Where("status", "==", "active")
+		status = "active"
 	}
-	
-	// Featured filter
-	if featured := c.Query("featured"); featured == "true" {
-		query = query.Where("featured", "==", true)
+	if status != "all" {
+		query = query.Where("status", "==", status)
 	}
-	
-	// Get limit for final result
+
+	filters := parseProductFilters(c)
+
+	sortBy := c.Query("sort")
+	switch sortBy {
+	case productSortDefault, productSortPriceAsc, productSortPriceDesc, productSortNewest, productSortPopularity, productSortRating:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported sort: %s", sortBy)})
+		return
+	}
+
 	limit := 100
 	if l := c.Query("limit"); l != "" {
 		if parsedLimit, err := strconv.Atoi(l); err == nil && parsedLimit > 0 {
 			limit = parsedLimit
 		}
 	}
-	
-	// If we need to filter by subcategory in memory, fetch more results
-	queryLimit := limit
-	if subcategory != "" {
-		queryLimit = 500 // Fetch more to ensure we have enough after filtering
+	if limit > 500 {
+		limit = 500
 	}
-	query = query.Limit(queryLimit)
-	
-	// Execute query
-	docs, err := query.Documents(h.db.Context).GetAll()
-	if err != nil {
-		fmt.Printf("Firestore query error: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
-		return
+
+	var cursor *productCursor
+	if value := c.Query("cursor"); value != "" {
+		decoded, ok := decodeProductCursor(value)
+		if !ok || decoded.Sort != sortBy {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		cursor = &decoded
 	}
 
-	// Parse and filter products
-	for _, doc := range docs {
-		var product models.Product
-		if err := doc.DataTo(&product); err != nil {
-			fmt.Printf("Error parsing product %s: %v\n", doc.Ref.ID, err)
-			continue
+	listingKey := category + "|" + status
+	candidates := productListings.get(listingKey)
+	if candidates == nil {
+		docs, err := query.Limit(productListingMaxProducts).Documents(h.db.Context).GetAll()
+		if err != nil {
+			fmt.Printf("Firestore query error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+			return
 		}
-		product.ID = doc.Ref.ID
-		
-		// Validate variant consistency before returning
-		h.validateVariantConsistency(&product)
-		
-		// Apply subcategory filter in memory if needed
-		if subcategory != "" {
-			found := false
-			for _, subcat := range product.Subcategories {
-				// Simple case-insensitive comparison
-				if strings.EqualFold(strings.TrimSpace(subcat), strings.TrimSpace(subcategory)) {
-					found = true
-					break
-				}
-				// Also try with spaces replacing dashes/underscores
-				normalizedQuery := strings.ReplaceAll(subcategory, "-", " ")
-				normalizedQuery = strings.ReplaceAll(normalizedQuery, "_", " ")
-				normalizedQuery = strings.ReplaceAll(normalizedQuery, "+", " ")
-				if strings.EqualFold(strings.TrimSpace(subcat), strings.TrimSpace(normalizedQuery)) {
-					found = true
-					break
-				}
-			}
-			if !found {
+
+		candidates = make([]models.Product, 0, len(docs))
+		for _, doc := range docs {
+			var product models.Product
+			if err := database.DecodeProduct(doc, &product); err != nil {
+				fmt.Printf("Error parsing product %s: %v\n", doc.Ref.ID, err)
 				continue
 			}
+			product.ID = doc.Ref.ID
+
+			// Validate variant consistency before returning
+			h.validateVariantConsistency(&product)
+			product.SetCurrentPrices()
+			candidates = append(candidates, product)
 		}
-		
-		products = append(products, product)
+		productListings.put(listingKey, candidates)
+	}
+
+	facets := buildProductFacets(candidates, filters)
+
+	// Popularity changes as the sales counts are refreshed, so its pages only follow on
+	// from the counts they started with
+	sortKey, sortedAt := h.productSortKey(sortBy)
+	if cursor != nil && cursor.SortedAt != sortedAt {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cursor has expired, reload the products"})
+		return
+	}
+	type rankedProduct struct {
+		product *models.Product
+		key     float64
+	}
+	matching := make([]rankedProduct, 0, len(candidates))
+	for i := range candidates {
+		if filters.matches(&candidates[i], facetNone) {
+			matching = append(matching, rankedProduct{&candidates[i], sortKey(&candidates[i])})
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		if matching[i].key != matching[j].key {
+			return matching[i].key < matching[j].key
+		}
+		return matching[i].product.ID < matching[j].product.ID
+	})
+
+	// Resume after the cursor's product in the sort order
+	start := 0
+	if cursor != nil {
+		start = sort.Search(len(matching), func(i int) bool {
+			if matching[i].key != cursor.Key {
+				return matching[i].key > cursor.Key
+			}
+			return matching[i].product.ID > cursor.ID
+		})
+	}
+	end := start + limit
+	if end > len(matching) {
+		end = len(matching)
 	}
 
-	// Apply limit to results if needed
-	if len(products) > limit {
-		products = products[:limit]
+	products := make([]models.Product, 0, end-start)
+	for _, ranked := range matching[start:end] {
+		products = append(products, *ranked.product)
 	}
 
-	fmt.Printf("Returning %d products after filtering\n", len(products))
+	var nextCursor string
+	if end < len(matching) {
+		last := matching[end-1]
+		nextCursor = encodeProductCursor(productCursor{Sort: sortBy, Key: last.key, ID: last.product.ID, SortedAt: sortedAt})
+	}
+
+	fmt.Printf("Returning %d of %d products after filtering\n", len(products), len(matching))
 
 	c.JSON(http.StatusOK, gin.H{
-		"products": products,
-		"count":    len(products),
+		"products":    products,
+		"count":       len(products),
+		"total":       len(matching),
+		"facets":      facets,
+		"sort":        sortBy,
+		"next_cursor": nextCursor,
+		"has_more":    nextCursor != "",
 	})
 }
 
@@ -132,18 +186,54 @@ func (h *ProductHandler) GetProduct(c *gin.Context) {
 	productID := c.Param("id")
 	
 	doc, err := h.db.Client.Collection("products").Doc(productID).Get(h.db.Context)
+	if status.Code(err) == codes.NotFound {
+		// Product pages may link by slug
+		var redirected bool
+		doc, redirected, err = resolveSlug(h.db, models.SlugKindProduct, productID)
+		if err == nil && redirected {
+			redirectToSlug(c, productID, doc)
+			return
+		}
+	}
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
+	h.writeProduct(c, doc)
+}
+
+// GetProductBySlug returns the product with a slug. A slug the product used to have
+// redirects permanently to its current one.
+func (h *ProductHandler) GetProductBySlug(c *gin.Context) {
+	slug := c.Param("slug")
+	doc, redirected, err := resolveSlug(h.db, models.SlugKindProduct, slug)
+	if err != nil {
+		slugLookupFailed(c, models.SlugKindProduct, err)
+		return
+	}
+	if redirected {
+		redirectToSlug(c, slug, doc)
+		return
+	}
+	h.writeProduct(c, doc)
+}
 
+// writeProduct responds with a product, its published questions and current prices
+func (h *ProductHandler) writeProduct(c *gin.Context, doc *firestore.DocumentSnapshot) {
 	var product models.Product
-	if err := doc.DataTo(&product); err != nil {
+	if err := database.DecodeProduct(doc, &product); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse product data"})
 		return
 	}
 	product.ID = doc.Ref.ID
 
+	questions, err := publishedQuestions(h.db, product.ID)
+	if err != nil {
+		log.Printf("Failed to load questions for product %s: %v", product.ID, err)
+	}
+	product.Questions = questions
+	product.SetCurrentPrices()
+
 	c.JSON(http.StatusOK, product)
 }
 
@@ -157,17 +247,67 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 	// Auto-correct hasVariants flag based on actual variants data
 	h.validateVariantConsistency(&product)
 
+	// A bundle's stock comes from its components
+	if product.IsBundle() {
+		if err := h.inventory.prepareBundle(&product); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Slugs are unique; one left blank comes from the name
+	slug, err := settleSlug(h.db, models.SlugKindProduct, "", product.Slug, product.Name)
+	var taken slugTakenError
+	if errors.As(err, &taken) {
+		c.JSON(http.StatusConflict, gin.H{"error": taken.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
+		return
+	}
+	product.Slug = slug
+
 	product.CreatedAt = time.Now()
 	product.UpdatedAt = time.Now()
-	product.Status = "active"
+	// Without the publish permission, new products wait as drafts like edits do
+	product.Status = models.ProductStatusActive
+	if !middleware.HasPermission(c, models.PermissionProductsPublish) {
+		product.Status = models.ProductStatusDraft
+	}
 
-	docRef, _, err := h.db.Client.Collection("products").Add(h.db.Context, product)
+	// The product, its slug and its first revision are saved together
+	docRef := h.db.Client.Collection("products").NewDoc()
+	err = h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := tx.Create(docRef, product); err != nil {
+			return err
+		}
+		if err := reserveSlug(tx, h.db, models.SlugKindProduct, product.Slug, "", docRef.ID); err != nil {
+			return err
+		}
+		return tx.Create(h.db.Client.Collection("product_revisions").NewDoc(), firstRevision(docRef.ID, product, c.GetString("user_id")))
+	})
+	err = slugConflict(err, models.SlugKindProduct, product.Slug)
+	if errors.As(err, &taken) {
+		c.JSON(http.StatusConflict, gin.H{"error": taken.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
 		return
 	}
 
 	product.ID = docRef.ID
+	if !product.IsBundle() {
+		h.inventory.recordMovements(stockDiff(&models.Product{}, &product, models.StockMovement{
+			Type:      models.StockMovementAdjustment,
+			Reason:    "opening stock",
+			Actor:     c.GetString("user_id"),
+			CreatedAt: time.Now(),
+		}))
+		h.inventory.checkLowStock(&product)
+	}
+	h.indexProduct(product.ID)
 	c.JSON(http.StatusCreated, product)
 }
 
@@ -179,6 +319,79 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := normalizeProductUpdates(updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Without the publish permission, edits wait in a draft for someone who has it
+	if !middleware.HasPermission(c, models.PermissionProductsPublish) {
+		draft, err := h.saveDraft(productID, updates, c.GetString("user_id"))
+		if status.Code(err) == codes.NotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to save draft of product %s: %v", productID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save draft"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Changes saved as a draft for publishing", "draft": draft})
+		return
+	}
+
+	_, err := h.db.Client.Collection("products").Doc(productID).Get(h.db.Context)
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+	revision, err := h.publishProductUpdates(productID, updates, models.ProductRevision{
+		Action:   models.RevisionActionPublish,
+		AuthorID: c.GetString("user_id"),
+	})
+	if err != nil {
+		h.productUpdateFailed(c, err, "Failed to update product")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product updated successfully", "revision": revision})
+}
+
+// invalidProductUpdate is an edit that can't be applied as sent
+type invalidProductUpdate struct{ error }
+
+// productUpdateFailed responds to an edit that failed to apply
+func (h *ProductHandler) productUpdateFailed(c *gin.Context, err error, message string) {
+	var invalid invalidProductUpdate
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	}
+	var taken slugTakenError
+	if errors.As(err, &taken) {
+		c.JSON(http.StatusConflict, gin.H{"error": taken.Error()})
+		return
+	}
+	log.Printf("%s: %v", message, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// productWriteHook runs in the transaction that writes a product edit, after the write,
+// with the product as read in the transaction and the writes made
+type productWriteHook func(tx *firestore.Transaction, current *firestore.DocumentSnapshot, writes []firestore.Update) error
+
+// applyProductUpdates writes edits to the live product, keeping its variants and bundle
+// fields consistent and its stock changes in the ledger
+func (h *ProductHandler) applyProductUpdates(productID string, updates map[string]interface{}, actor string, afterWrite productWriteHook) error {
+	// Slugs stay unique, and a changed one keeps leading to the product
+	oldSlug, slugUpdated, err := h.prepareSlugUpdate(productID, updates)
+	if err != nil {
+		return err
+	}
 
 	// If updating variants-related fields, validate consistency
 	if hasVariantsUpdate, hasVariants := updates["has_variants"]; hasVariants {
@@ -206,8 +419,38 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		}
 	}
 
+	// A bundle's stock comes from its components, so it isn't edited directly
+	bundle, err := h.inventory.bundleForUpdate(productID, updates)
+	if err != nil {
+		return invalidProductUpdate{err}
+	}
+	if bundle != nil {
+		delete(updates, "stock_quantity")
+		delete(updates, "variants")
+		updates["bundle_components"] = bundle.BundleComponents
+		updates["bundle_product_ids"] = bundle.BundleProductIDs
+		updates["manage_stock"] = true
+	} else if _, ok := updates["product_type"]; ok {
+		updates["bundle_product_ids"] = []string{}
+	}
+
 	updates["updated_at"] = time.Now()
 
+	// A changed slug is reserved in the transaction that saves it
+	newSlug, _ := updates["slug"].(string)
+	if slugUpdated && newSlug != oldSlug {
+		edited := afterWrite
+		afterWrite = func(tx *firestore.Transaction, current *firestore.DocumentSnapshot, writes []firestore.Update) error {
+			if err := reserveSlug(tx, h.db, models.SlugKindProduct, newSlug, oldSlug, productID); err != nil {
+				return err
+			}
+			if edited != nil {
+				return edited(tx, current, writes)
+			}
+			return nil
+		}
+	}
+
 	var firestoreUpdates []firestore.Update
 	for key, value := range updates {
 		firestoreUpdates = append(firestoreUpdates, firestore.Update{
@@ -216,60 +459,129 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		})
 	}
 
-	_, err := h.db.Client.Collection("products").Doc(productID).Update(h.db.Context, firestoreUpdates)
+	// Stock edits go through the inventory ledger
+	_, hasStock := updates["stock_quantity"]
+	_, hasVariants := updates["variants"]
+	if hasStock || hasVariants {
+		err = h.inventory.updateProductStock(productID, updates, firestoreUpdates, actor, afterWrite)
+	} else {
+		productRef := h.db.Client.Collection("products").Doc(productID)
+		err = h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+			doc, err := tx.Get(productRef)
+			if err != nil {
+				return err
+			}
+			if err := tx.Update(productRef, firestoreUpdates); err != nil {
+				return err
+			}
+			if afterWrite != nil {
+				return afterWrite(tx, doc, firestoreUpdates)
+			}
+			return nil
+		})
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
-		return
+		return slugConflict(err, models.SlugKindProduct, newSlug)
+	}
+	if slugUpdated {
+		recordSlugRedirect(h.db, models.SlugKindProduct, oldSlug, newSlug, productID)
+	}
+	// Stock edits are followed up by the ledger; threshold and status edits still need a check
+	if bundle != nil {
+		if err := h.inventory.refreshBundleStock(bundle); err != nil {
+			log.Printf("Failed to refresh stock of bundle %s: %v", productID, err)
+		}
+	} else if !hasStock && !hasVariants {
+		_, hasThreshold := updates["reorder_threshold"]
+		if _, hasStatus := updates["status"]; hasThreshold || hasStatus {
+			h.inventory.checkProductLowStock(productID)
+		}
+	}
+	h.indexProduct(productID)
+	return nil
+}
+
+// prepareSlugUpdate settles the slug in an edit that sets one, returning the product's
+// slug before the edit
+func (h *ProductHandler) prepareSlugUpdate(productID string, updates map[string]interface{}) (string, bool, error) {
+	value, ok := updates["slug"]
+	if !ok {
+		return "", false, nil
+	}
+	requested, ok := value.(string)
+	if !ok && value != nil {
+		return "", false, invalidProductUpdate{fmt.Errorf("slug must be text")}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Product updated successfully"})
+	doc, err := h.db.Client.Collection("products").Doc(productID).Get(h.db.Context)
+	if err != nil {
+		return "", false, err
+	}
+	data := doc.Data()
+	oldSlug, _ := data["slug"].(string)
+	name, ok := updates["name"].(string)
+	if !ok {
+		name, _ = data["name"].(string)
+	}
+
+	slug, err := settleSlug(h.db, models.SlugKindProduct, productID, requested, name)
+	if err != nil {
+		return "", false, err
+	}
+	updates["slug"] = slug
+	return oldSlug, true, nil
 }
 
 func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 	productID := c.Param("id")
 	
-	_, err := h.db.Client.Collection("products").Doc(productID).Delete(h.db.Context)
+	err := deleteSluggedDoc(h.db, models.SlugKindProduct, productID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
 		return
 	}
+	h.searchIndex.Remove(productID)
+	productListings.clear()
+	if _, err := h.db.Client.Collection("product_drafts").Doc(productID).Delete(h.db.Context); err != nil {
+		log.Printf("Failed to delete draft of product %s: %v", productID, err)
+	}
+	// Bundles can't be sold without it
+	go h.inventory.refreshBundles(productID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
 }
 
-func (h *ProductHandler) SearchProducts(c *gin.Context) {
-	searchQuery := c.Query("q")
-	if searchQuery == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query required"})
-		return
+// normalizeProductUpdates stores the prices in a product edit as numbers, whatever form
+// the admin panel sent them in, and drops the fields that are only computed for responses
+func normalizeProductUpdates(updates map[string]interface{}) error {
+	normalize := func(fields map[string]interface{}, prefix string) error {
+		for _, field := range []string{"price", "sale_price"} {
+			if value, ok := fields[field]; ok {
+				amount, err := models.ParseMoney(value)
+				if err != nil {
+					return fmt.Errorf("%s%s: %v", prefix, field, err)
+				}
+				fields[field] = amount
+			}
+		}
+		delete(fields, "current_price")
+		return nil
 	}
 
-	var products []models.Product
-	
-	docs, err := h.db.Client.Collection("products").
-		Where("status", "==", "active").
-		Documents(h.db.Context).GetAll()
-	
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
-		return
+	if err := normalize(updates, ""); err != nil {
+		return err
 	}
-
-	for _, doc := range docs {
-		var product models.Product
-		if err := doc.DataTo(&product); err != nil {
-			// Skip products that fail to parse
-			continue
+	if variants, ok := updates["variants"].([]interface{}); ok {
+		for i, variant := range variants {
+			if fields, ok := variant.(map[string]interface{}); ok {
+				if err := normalize(fields, fmt.Sprintf("variants[%d].", i)); err != nil {
+					return err
+				}
+			}
 		}
-		product.ID = doc.Ref.ID
-		products = append(products, product)
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"products": products,
-		"count":    len(products),
-		"query":    searchQuery,
-	})
+	delete(updates, "created_at")
+	return nil
 }
 
 // validateVariantConsistency ensures hasVariants flag matches actual variants data
@@ -0,0 +1,1023 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"tripund-api/internal/database"
+	"tripund-api/internal/middleware"
+	"tripund-api/internal/models"
+	"tripund-api/internal/spreadsheet"
+	"tripund-api/internal/utils"
+)
+
+// productImportColumns is the layout of product import and export files. Rows with a
+// variant_sku describe a variant of the product named in their sku column; other rows
+// describe the product itself. List cells such as categories and images separate their
+// values with |. Product columns share their names with the stored product fields.
+var productImportColumns = []string{
+	"sku", "variant_sku", "name", "slug", "description", "short_description",
+	"price", "sale_price", "stock_quantity", "manage_stock", "stock_status", "status", "featured",
+	"categories", "subcategories", "tags", "images",
+	"variant_color", "variant_size", "variant_price", "variant_sale_price", "variant_stock_quantity", "variant_available",
+}
+
+// Columns that may be filled on product rows and on variant rows respectively
+var (
+	productImportFields = []string{
+		"name", "slug", "description", "short_description", "price", "sale_price", "stock_quantity",
+		"manage_stock", "stock_status", "status", "featured", "categories", "subcategories", "tags", "images",
+	}
+	variantImportFields = []string{
+		"variant_color", "variant_size", "variant_price", "variant_sale_price", "variant_stock_quantity", "variant_available",
+	}
+)
+
+var productImportResultColumns = []string{"row", "sku", "variant_sku", "action", "product_id", "errors"}
+
+const (
+	productImportMaxRows     = 2000
+	productImportMaxFileSize = 10 << 20
+	productImportBatchSize   = 400 // Firestore allows 500 writes per batch
+	productImportListSep     = "|"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// importRow is a data row of an import file; cells holds its filled cells by column
+type importRow struct {
+	cells  map[string]string
+	result *models.ProductImportRow
+}
+
+func (r *importRow) fail(format string, args ...interface{}) {
+	r.result.Action = models.ImportActionError
+	r.result.Errors = append(r.result.Errors, fmt.Sprintf(format, args...))
+}
+
+func (r *importRow) failed() bool {
+	return len(r.result.Errors) > 0
+}
+
+// importCatalog is the existing data import rows are matched and validated against
+type importCatalog struct {
+	products      map[string]*models.Product // by lower-cased SKU
+	variantOwners map[string]string          // lower-cased variant SKU -> lower-cased product SKU
+	categories    map[string]string          // lower-cased slug, name or ID -> slug
+	subcategories map[string]string          // lower-cased name -> name
+	slugs         map[string]string          // product slug -> lower-cased SKU of the product using it
+}
+
+// importProduct collects the valid rows for one product and the changes they make
+type importProduct struct {
+	key      string
+	existing *models.Product
+	product  models.Product
+	updates  map[string]interface{}
+	rows     []*importRow
+}
+
+// loadImportCatalog loads every product and category
+func (h *ProductHandler) loadImportCatalog() (*importCatalog, error) {
+	catalog := &importCatalog{
+		products:      make(map[string]*models.Product),
+		variantOwners: make(map[string]string),
+		categories:    make(map[string]string),
+		subcategories: make(map[string]string),
+		slugs:         make(map[string]string),
+	}
+
+	docs, err := h.db.Client.Collection("products").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil {
+			log.Printf("Skipping product %s in import: %v", doc.Ref.ID, err)
+			continue
+		}
+		product.ID = doc.Ref.ID
+		key := strings.ToLower(strings.TrimSpace(product.SKU))
+		if key == "" {
+			continue
+		}
+		catalog.products[key] = &product
+		if product.Slug != "" {
+			catalog.slugs[product.Slug] = key
+		}
+		for _, variant := range product.Variants {
+			if sku := strings.ToLower(strings.TrimSpace(variant.SKU)); sku != "" {
+				catalog.variantOwners[sku] = key
+			}
+		}
+	}
+
+	categoryDocs, err := h.db.Client.Collection("categories").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range categoryDocs {
+		data := doc.Data()
+		slug, _ := data["slug"].(string)
+		if slug == "" {
+			slug = doc.Ref.ID
+		}
+		name, _ := data["name"].(string)
+		for _, key := range []string{slug, name, doc.Ref.ID} {
+			if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+				catalog.categories[key] = slug
+			}
+		}
+		if children, ok := data["children"].([]interface{}); ok {
+			for _, child := range children {
+				if childMap, ok := child.(map[string]interface{}); ok {
+					if childName, ok := childMap["name"].(string); ok && strings.TrimSpace(childName) != "" {
+						catalog.subcategories[strings.ToLower(strings.TrimSpace(childName))] = strings.TrimSpace(childName)
+					}
+				}
+			}
+		}
+	}
+	return catalog, nil
+}
+
+// readImportFile reads the rows of the uploaded file; its extension picks the format
+func readImportFile(c *gin.Context) ([][]string, string, string, error) {
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		return nil, "", "", fmt.Errorf("upload the file in the \"file\" field")
+	}
+	defer file.Close()
+
+	if header.Size > productImportMaxFileSize {
+		return nil, "", "", fmt.Errorf("file is larger than %d MB", productImportMaxFileSize>>20)
+	}
+	data, err := io.ReadAll(io.LimitReader(file, productImportMaxFileSize))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to read file")
+	}
+
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(header.Filename), "."))
+	var rows [][]string
+	switch format {
+	case "xlsx":
+		rows, err = spreadsheet.ReadXLSX(bytes.NewReader(data), int64(len(data)))
+	case "csv":
+		data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // Byte order mark added by Excel
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		rows, err = reader.ReadAll()
+	default:
+		return nil, "", "", fmt.Errorf("unsupported file type, upload a .csv or .xlsx file")
+	}
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to parse %s: %v", format, err)
+	}
+	return rows, header.Filename, format, nil
+}
+
+// parseImportRows maps the data rows by the header row. Unknown columns are ignored with
+// a warning; blank rows are skipped.
+func parseImportRows(rows [][]string) ([]*importRow, []string, error) {
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("file is empty")
+	}
+
+	known := make(map[string]bool, len(productImportColumns))
+	for _, column := range productImportColumns {
+		known[column] = true
+	}
+
+	var warnings []string
+	columns := make([]string, len(rows[0]))
+	seen := make(map[string]bool)
+	for i, heading := range rows[0] {
+		column := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(heading)), " ", "_")
+		if column == "" {
+			continue
+		}
+		if !known[column] {
+			warnings = append(warnings, fmt.Sprintf("column %q is not recognised and was ignored", heading))
+			continue
+		}
+		if seen[column] {
+			return nil, nil, fmt.Errorf("column %q appears more than once", column)
+		}
+		seen[column] = true
+		columns[i] = column
+	}
+	if !seen["sku"] {
+		return nil, nil, fmt.Errorf("the sku column is required")
+	}
+
+	var parsed []*importRow
+	for i, values := range rows[1:] {
+		row := &importRow{
+			cells:  make(map[string]string),
+			result: &models.ProductImportRow{Row: i + 2},
+		}
+		for j, value := range values {
+			if j < len(columns) && columns[j] != "" {
+				if value = strings.TrimSpace(value); value != "" {
+					row.cells[columns[j]] = value
+				}
+			}
+		}
+		if len(row.cells) == 0 {
+			continue
+		}
+		row.result.SKU = row.cells["sku"]
+		row.result.VariantSKU = row.cells["variant_sku"]
+		parsed = append(parsed, row)
+	}
+
+	if len(parsed) == 0 {
+		return nil, nil, fmt.Errorf("file has no product rows")
+	}
+	if len(parsed) > productImportMaxRows {
+		return nil, nil, fmt.Errorf("file has %d rows, split it into files of at most %d", len(parsed), productImportMaxRows)
+	}
+	return parsed, warnings, nil
+}
+
+func splitImportList(value string) []string {
+	values := make([]string, 0)
+	for _, part := range strings.Split(value, productImportListSep) {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// parseImportPrice accepts plain numbers as well as "₹1,299.00"
+func parseImportPrice(value string) (float64, bool) {
+	value = strings.ReplaceAll(strings.TrimPrefix(strings.TrimSpace(value), "₹"), ",", "")
+	price, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || price < 0 || math.IsInf(price, 0) || math.IsNaN(price) {
+		return 0, false
+	}
+	return math.Round(price*100) / 100, true
+}
+
+func parseImportBool(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "y", "1":
+		return true, true
+	case "false", "no", "n", "0":
+		return false, true
+	}
+	return false, false
+}
+
+func isImageURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// parseImportFields converts the row's filled cells among columns to the values stored
+// on the product, recording an error on the row for each invalid cell
+func parseImportFields(row *importRow, columns []string, catalog *importCatalog) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for _, column := range columns {
+		value, ok := row.cells[column]
+		if !ok {
+			continue
+		}
+
+		switch column {
+		case "price", "variant_price":
+			price, ok := parseImportPrice(value)
+			if !ok || price == 0 {
+				row.fail("%s must be a number above 0", column)
+				continue
+			}
+			fields[column] = price
+		case "sale_price", "variant_sale_price":
+			price, ok := parseImportPrice(value)
+			if !ok {
+				row.fail("%s must be a number, 0 for no sale", column)
+				continue
+			}
+			fields[column] = price
+		case "stock_quantity", "variant_stock_quantity":
+			quantity, err := strconv.Atoi(value)
+			if err != nil || quantity < 0 {
+				row.fail("%s must be a whole number of 0 or more", column)
+				continue
+			}
+			fields[column] = quantity
+		case "manage_stock", "featured", "variant_available":
+			flag, ok := parseImportBool(value)
+			if !ok {
+				row.fail("%s must be true or false", column)
+				continue
+			}
+			fields[column] = flag
+		case "stock_status":
+			value = strings.ToLower(value)
+			if value != models.StockStatusInStock && value != models.StockStatusOutOfStock && value != models.StockStatusBackorder {
+				row.fail("stock_status must be in_stock, out_of_stock or on_backorder")
+				continue
+			}
+			fields[column] = value
+		case "status":
+			value = strings.ToLower(value)
+			if value != models.ProductStatusActive && value != models.ProductStatusDraft && value != models.ProductStatusArchived {
+				row.fail("status must be active, draft or archived")
+				continue
+			}
+			fields[column] = value
+		case "slug":
+			if utils.GenerateSlug(value) != value {
+				row.fail("slug may only contain lowercase letters, digits and single dashes")
+				continue
+			}
+			fields[column] = value
+		case "categories":
+			slugs := make([]string, 0)
+			for _, category := range splitImportList(value) {
+				slug, ok := catalog.categories[strings.ToLower(category)]
+				if !ok {
+					row.fail("unknown category %q", category)
+					continue
+				}
+				slugs = append(slugs, slug)
+			}
+			fields[column] = slugs
+		case "subcategories":
+			names := make([]string, 0)
+			for _, subcategory := range splitImportList(value) {
+				name, ok := catalog.subcategories[strings.ToLower(subcategory)]
+				if !ok {
+					row.fail("unknown subcategory %q", subcategory)
+					continue
+				}
+				names = append(names, name)
+			}
+			fields[column] = names
+		case "images":
+			images := splitImportList(value)
+			for _, image := range images {
+				if !isImageURL(image) {
+					row.fail("image %q is not an http or https URL", image)
+				}
+			}
+			fields[column] = images
+		case "tags":
+			fields[column] = splitImportList(value)
+		default:
+			fields[column] = value
+		}
+	}
+	return fields
+}
+
+// setProductField applies a parsed product column to the product
+func setProductField(product *models.Product, column string, value interface{}) {
+	switch column {
+	case "name":
+		product.Name = value.(string)
+	case "slug":
+		product.Slug = value.(string)
+	case "description":
+		product.Description = value.(string)
+	case "short_description":
+		product.ShortDescription = value.(string)
+	case "price":
+		product.Price = value.(float64)
+	case "sale_price":
+		product.SalePrice = models.Money(value.(float64))
+	case "stock_quantity":
+		product.StockQuantity = value.(int)
+	case "manage_stock":
+		product.ManageStock = value.(bool)
+	case "stock_status":
+		product.StockStatus = value.(string)
+	case "status":
+		product.Status = value.(string)
+	case "featured":
+		product.Featured = value.(bool)
+	case "categories":
+		product.Categories = value.([]string)
+	case "subcategories":
+		product.Subcategories = value.([]string)
+	case "tags":
+		product.Tags = value.([]string)
+	case "images":
+		product.Images = value.([]string)
+	}
+}
+
+// setVariantField applies a parsed variant column to the variant
+func setVariantField(variant *models.ProductVariant, column string, value interface{}) {
+	switch column {
+	case "variant_color":
+		variant.Color = value.(string)
+	case "variant_size":
+		variant.Size = value.(string)
+	case "variant_price":
+		variant.Price = models.Money(value.(float64))
+	case "variant_sale_price":
+		variant.SalePrice = models.Money(value.(float64))
+	case "variant_stock_quantity":
+		variant.StockQuantity = value.(int)
+	case "variant_available":
+		variant.Available = value.(bool)
+	}
+}
+
+// rejectMisplacedCells fails rows that fill columns belonging to the other row kind
+func rejectMisplacedCells(row *importRow) {
+	misplaced := productImportFields
+	if row.result.VariantSKU == "" {
+		misplaced = variantImportFields
+	}
+	for _, column := range misplaced {
+		if _, ok := row.cells[column]; !ok {
+			continue
+		}
+		if row.result.VariantSKU == "" {
+			row.fail("%s is only used on variant rows, which have a variant_sku", column)
+		} else {
+			row.fail("%s belongs on the product row, not the variant row", column)
+		}
+	}
+}
+
+// planProductImport validates every row and works out the change each valid row makes.
+// Rows are grouped by product in file order; rows that fail validation are left out.
+// Without canPublish, new products are saved as drafts.
+func (h *ProductHandler) planProductImport(rows []*importRow, catalog *importCatalog, canPublish bool) []*importProduct {
+	var plans []*importProduct
+	byKey := make(map[string]*importProduct)
+	productRows := make(map[string]*importRow)
+	variantRows := make(map[string][]*importRow)
+	seenVariants := make(map[string]int)
+
+	for _, row := range rows {
+		key := strings.ToLower(row.result.SKU)
+		if key == "" {
+			row.fail("sku is required")
+			continue
+		}
+		rejectMisplacedCells(row)
+
+		if byKey[key] == nil {
+			byKey[key] = &importProduct{key: key, existing: catalog.products[key]}
+			plans = append(plans, byKey[key])
+		}
+
+		if row.result.VariantSKU == "" {
+			if previous, ok := productRows[key]; ok {
+				row.fail("sku %s is already on row %d", row.result.SKU, previous.result.Row)
+				continue
+			}
+			productRows[key] = row
+			continue
+		}
+
+		variantKey := strings.ToLower(row.result.VariantSKU)
+		if previous, ok := seenVariants[variantKey]; ok {
+			row.fail("variant_sku %s is already on row %d", row.result.VariantSKU, previous)
+			continue
+		}
+		seenVariants[variantKey] = row.result.Row
+		if owner, ok := catalog.variantOwners[variantKey]; ok && owner != key {
+			row.fail("variant_sku %s belongs to product %s", row.result.VariantSKU, catalog.products[owner].SKU)
+			continue
+		}
+		variantRows[key] = append(variantRows[key], row)
+	}
+
+	for _, plan := range plans {
+		plan.updates = make(map[string]interface{})
+		if plan.existing != nil {
+			plan.product = *plan.existing
+			plan.product.Variants = append([]models.ProductVariant{}, plan.existing.Variants...)
+		}
+
+		productRow := productRows[plan.key]
+		if productRow != nil {
+			applyImportProductRow(plan, productRow, catalog, canPublish)
+		}
+		if plan.existing == nil && (productRow == nil || productRow.failed()) {
+			for _, row := range variantRows[plan.key] {
+				if productRow == nil {
+					row.fail("product %s does not exist, add a product row for it", row.result.SKU)
+				} else {
+					row.fail("product row %d for %s has errors", productRow.result.Row, row.result.SKU)
+				}
+			}
+			continue
+		}
+
+		variantsChanged := false
+		for _, row := range variantRows[plan.key] {
+			if applyImportVariantRow(plan, row, catalog) {
+				variantsChanged = true
+			}
+		}
+		if variantsChanged {
+			h.validateVariantConsistency(&plan.product)
+			plan.updates["variants"] = plan.product.Variants
+			plan.updates["has_variants"] = plan.product.HasVariants
+			plan.updates["available_colors"] = plan.product.AvailableColors
+			plan.updates["available_sizes"] = plan.product.AvailableSizes
+		}
+	}
+	return plans
+}
+
+// applyImportProductRow applies a product row, which creates the product when its SKU
+// is new. Blank cells leave an existing product's fields unchanged.
+func applyImportProductRow(plan *importProduct, row *importRow, catalog *importCatalog, canPublish bool) {
+	fields := parseImportFields(row, productImportFields, catalog)
+	if !canPublish && fields["status"] == models.ProductStatusActive {
+		row.fail("status active needs the publish permission")
+	}
+
+	if plan.existing == nil {
+		if row.cells["name"] == "" {
+			row.fail("name is required for a new product")
+		}
+		if row.cells["price"] == "" {
+			row.fail("price is required for a new product")
+		}
+	}
+
+	price := plan.product.Price
+	if value, ok := fields["price"]; ok {
+		price = value.(float64)
+	}
+	salePrice := float64(plan.product.SalePrice)
+	if value, ok := fields["sale_price"]; ok {
+		salePrice = value.(float64)
+	}
+	if salePrice > 0 && price > 0 && salePrice >= price {
+		row.fail("sale_price must be below price")
+	}
+	if _, ok := fields["stock_quantity"]; ok && plan.existing != nil && plan.existing.IsBundle() {
+		row.fail("%s is a bundle, its stock comes from its components", row.result.SKU)
+	}
+	if slug, ok := fields["slug"].(string); ok {
+		if owner, taken := catalog.slugs[slug]; taken && owner != plan.key {
+			row.fail("slug %s is already used by %s", slug, strings.ToUpper(owner))
+		}
+	}
+	if row.failed() {
+		return
+	}
+
+	if plan.existing == nil {
+		status := models.ProductStatusActive
+		if !canPublish {
+			status = models.ProductStatusDraft
+		}
+		plan.product = models.Product{
+			SKU:           row.result.SKU,
+			ManageStock:   true,
+			StockStatus:   models.StockStatusInStock,
+			Status:        status,
+			Images:        []string{},
+			Categories:    []string{},
+			Subcategories: []string{},
+			Tags:          []string{},
+		}
+	}
+	for column, value := range fields {
+		setProductField(&plan.product, column, value)
+		plan.updates[column] = value
+	}
+	if plan.product.Slug == "" && plan.product.Name != "" {
+		// Slugs from the name get a numeric suffix if another product has them
+		base := utils.GenerateSlug(plan.product.Name)
+		slug := base
+		for n := 2; catalog.slugs[slug] != "" && catalog.slugs[slug] != plan.key; n++ {
+			slug = fmt.Sprintf("%s-%d", base, n)
+		}
+		plan.product.Slug = slug
+		plan.updates["slug"] = slug
+	}
+	if plan.product.Slug != "" {
+		catalog.slugs[plan.product.Slug] = plan.key
+	}
+	plan.rows = append(plan.rows, row)
+}
+
+// applyImportVariantRow adds or updates a variant, reporting whether it did. New
+// variants take the product's price unless they have their own and are available.
+func applyImportVariantRow(plan *importProduct, row *importRow, catalog *importCatalog) bool {
+	fields := parseImportFields(row, variantImportFields, catalog)
+	if plan.product.IsBundle() {
+		row.fail("%s is a bundle, bundles can't have variants", row.result.SKU)
+	}
+	if row.failed() {
+		return false
+	}
+
+	index := -1
+	for i, variant := range plan.product.Variants {
+		if strings.EqualFold(variant.SKU, row.result.VariantSKU) {
+			index = i
+			break
+		}
+	}
+
+	variant := models.ProductVariant{
+		ID:        utils.GenerateIDWithPrefix("var"),
+		SKU:       row.result.VariantSKU,
+		Price:     models.Money(plan.product.Price),
+		Available: true,
+	}
+	if index >= 0 {
+		variant = plan.product.Variants[index]
+	} else if fields["variant_color"] == nil && fields["variant_size"] == nil {
+		row.fail("a new variant needs a variant_color or variant_size")
+		return false
+	}
+
+	price := float64(variant.Price)
+	if value, ok := fields["variant_price"]; ok {
+		price = value.(float64)
+	}
+	salePrice := float64(variant.SalePrice)
+	if value, ok := fields["variant_sale_price"]; ok {
+		salePrice = value.(float64)
+	}
+	if salePrice > 0 && price > 0 && salePrice >= price {
+		row.fail("variant_sale_price must be below variant_price")
+		return false
+	}
+
+	for column, value := range fields {
+		setVariantField(&variant, column, value)
+	}
+	if index >= 0 {
+		plan.product.Variants[index] = variant
+	} else {
+		plan.product.Variants = append(plan.product.Variants, variant)
+	}
+	plan.rows = append(plan.rows, row)
+	return true
+}
+
+// saveProductImport writes the new products in batches, with their slug reservations,
+// first revisions and ledger entries for their opening stock, and publishes the edits to existing products
+// one by one, recording a revision of each. Rows that fail to save are marked as failed.
+func (h *ProductHandler) saveProductImport(plans []*importProduct, actor string) {
+	now := time.Now()
+	batch := h.db.Client.Batch()
+	var pending, stocked []*importProduct
+	unsaved := make(map[*importProduct]bool)
+	writes := 0
+
+	commit := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if _, err := batch.Commit(h.db.Context); err != nil {
+			log.Printf("Failed to commit product import batch: %v", err)
+			for _, plan := range pending {
+				unsaved[plan] = true
+				for _, row := range plan.rows {
+					row.fail("failed to save: %v", err)
+				}
+			}
+		}
+		batch = h.db.Client.Batch()
+		pending = nil
+		writes = 0
+	}
+
+	movement := models.StockMovement{
+		Type:      models.StockMovementAdjustment,
+		Reason:    "product import",
+		Actor:     actor,
+		CreatedAt: now,
+	}
+	for _, plan := range plans {
+		if len(plan.rows) == 0 || plan.existing != nil {
+			continue
+		}
+		ref := h.db.Client.Collection("products").NewDoc()
+		plan.product.ID = ref.ID
+		plan.product.CreatedAt = now
+		plan.product.UpdatedAt = now
+		entries := stockDiff(&models.Product{}, &plan.product, movement)
+		if writes+3+len(entries) > productImportBatchSize {
+			commit()
+		}
+		batch.Create(ref, plan.product)
+		if plan.product.Slug != "" {
+			batch.Create(slugRef(h.db, models.SlugKindProduct, plan.product.Slug), newSlugReservation(models.SlugKindProduct, plan.product.Slug, ref.ID))
+		}
+		batch.Create(h.db.Client.Collection("product_revisions").NewDoc(), firstRevision(ref.ID, plan.product, actor))
+		for _, entry := range entries {
+			batch.Create(h.db.Client.Collection("stock_movements").NewDoc(), entry)
+		}
+		writes += 3 + len(entries)
+		pending = append(pending, plan)
+		if len(entries) > 0 {
+			stocked = append(stocked, plan)
+		}
+	}
+	commit()
+
+	// Edits go through the same path as the product form, which keeps the ledger,
+	// slug redirects and low stock checks up to date
+	for _, plan := range plans {
+		if len(plan.rows) == 0 || plan.existing == nil {
+			continue
+		}
+		_, err := h.publishProductUpdates(plan.existing.ID, plan.updates, models.ProductRevision{
+			Action:   models.RevisionActionPublish,
+			AuthorID: actor,
+		})
+		if err != nil {
+			log.Printf("Failed to save imported product %s: %v", plan.existing.ID, err)
+			for _, row := range plan.rows {
+				row.fail("failed to save: %v", err)
+			}
+		}
+	}
+
+	// Follow up the opening stock of new products in the background
+	go func() {
+		for _, plan := range stocked {
+			if !unsaved[plan] {
+				h.inventory.checkLowStock(&plan.product)
+			}
+		}
+	}()
+}
+
+// ImportProducts creates and updates products and variants from a CSV or XLSX file in
+// the export layout, matching products by sku and variants by variant_sku. Every row is
+// validated first; valid rows are saved in batches and invalid ones reported. With
+// ?dry_run=true nothing is saved and the report shows what would happen. The report is
+// kept so it can be downloaded as a file. Rows updating existing products, or making
+// new ones active, need the publish permission; without it new products are drafts.
+func (h *ProductHandler) ImportProducts(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+
+	rows, fileName, format, err := readImportFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	importRows, warnings, err := parseImportRows(rows)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	catalog, err := h.loadImportCatalog()
+	if err != nil {
+		log.Printf("Failed to load catalog for product import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load products"})
+		return
+	}
+
+	canPublish := middleware.HasPermission(c, models.PermissionProductsPublish)
+	plans := h.planProductImport(importRows, catalog, canPublish)
+
+	// Changes to live products need the publish permission, as on the product form
+	if !canPublish {
+		for _, plan := range plans {
+			if plan.existing == nil {
+				continue
+			}
+			for _, row := range plan.rows {
+				row.fail("updating product %s needs the publish permission", plan.existing.SKU)
+			}
+			plan.rows = nil
+		}
+	}
+	if !dryRun {
+		h.saveProductImport(plans, c.GetString("user_id"))
+	}
+
+	record := models.ProductImport{
+		FileName:  fileName,
+		Format:    format,
+		DryRun:    dryRun,
+		TotalRows: len(importRows),
+		Warnings:  warnings,
+		Results:   make([]models.ProductImportRow, 0, len(importRows)),
+		CreatedBy: c.GetString("user_id"),
+		CreatedAt: time.Now(),
+	}
+	for _, plan := range plans {
+		saved := false
+		for _, row := range plan.rows {
+			if row.failed() {
+				continue
+			}
+			saved = true
+			row.result.ProductID = plan.product.ID
+			row.result.Action = models.ImportActionUpdate
+			if plan.existing == nil {
+				row.result.Action = models.ImportActionCreate
+			}
+		}
+		if saved && plan.existing == nil {
+			record.Created++
+		} else if saved {
+			record.Updated++
+		}
+	}
+	for _, row := range importRows {
+		if row.failed() {
+			record.Failed++
+		}
+		record.Results = append(record.Results, *row.result)
+	}
+
+	docRef, _, err := h.db.Client.Collection("product_imports").Add(h.db.Context, record)
+	if err != nil {
+		log.Printf("Failed to save product import report: %v", err)
+	} else {
+		record.ID = docRef.ID
+	}
+
+	if !dryRun && record.Created+record.Updated > 0 {
+		if err := h.rebuildSearchIndex(); err != nil {
+			log.Printf("Failed to rebuild search index after product import: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, record)
+}
+
+// formatImportPrice leaves zero prices blank
+func formatImportPrice(price float64) string {
+	if price <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(price, 'f', -1, 64)
+}
+
+// productExportRows lays products out in the import format, each product followed by
+// its variants
+func productExportRows(products []models.Product) [][]string {
+	rows := [][]string{productImportColumns}
+	for _, product := range products {
+		rows = append(rows, []string{
+			product.SKU, "", product.Name, product.Slug, product.Description, product.ShortDescription,
+			formatImportPrice(product.Price), formatImportPrice(float64(product.SalePrice)),
+			strconv.Itoa(product.StockQuantity), strconv.FormatBool(product.ManageStock),
+			product.StockStatus, product.Status, strconv.FormatBool(product.Featured),
+			strings.Join(product.Categories, productImportListSep),
+			strings.Join(product.Subcategories, productImportListSep),
+			strings.Join(product.Tags, productImportListSep),
+			strings.Join(product.Images, productImportListSep),
+			"", "", "", "", "", "",
+		})
+		for _, variant := range product.Variants {
+			// Variant columns come last
+			row := make([]string, len(productImportColumns)-len(variantImportFields), len(productImportColumns))
+			row[0], row[1] = product.SKU, variant.SKU
+			rows = append(rows, append(row,
+				variant.Color, variant.Size,
+				formatImportPrice(float64(variant.Price)),
+				formatImportPrice(float64(variant.SalePrice)),
+				strconv.Itoa(variant.StockQuantity), strconv.FormatBool(variant.Available),
+			))
+		}
+	}
+	return rows
+}
+
+// writeTableFile sends rows as a CSV download or, with format xlsx, a workbook
+func writeTableFile(c *gin.Context, name, format string, rows [][]string) {
+	var buf bytes.Buffer
+	if format == "xlsx" {
+		if err := spreadsheet.WriteXLSX(&buf, name, rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build spreadsheet"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.xlsx", name))
+		c.Data(http.StatusOK, xlsxContentType, buf.Bytes())
+		return
+	}
+
+	writer := csv.NewWriter(&buf)
+	writer.WriteAll(rows)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", name))
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
+
+// ExportProducts downloads products in the import layout, as CSV or with ?format=xlsx a
+// workbook, optionally limited by ?status= and ?category=. Editing the file and
+// importing it again updates the products.
+func (h *ProductHandler) ExportProducts(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or xlsx"})
+		return
+	}
+
+	query := h.db.Client.Collection("products").Query
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status", "==", status)
+	}
+	if category := c.Query("category"); category != "" {
+		query = query.Where("categories", "array-contains", category)
+	}
+	docs, err := query.Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+
+	products := make([]models.Product, 0, len(docs))
+	for _, doc := range docs {
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil {
+			continue
+		}
+		product.ID = doc.Ref.ID
+		products = append(products, product)
+	}
+	sort.Slice(products, func(i, j int) bool {
+		return strings.ToLower(products[i].SKU) < strings.ToLower(products[j].SKU)
+	})
+
+	writeTableFile(c, "products-"+time.Now().Format("20060102"), format, productExportRows(products))
+}
+
+// GetProductImports lists recent imports without their row results
+func (h *ProductHandler) GetProductImports(c *gin.Context) {
+	docs, err := h.db.Client.Collection("product_imports").
+		OrderBy("created_at", firestore.Desc).
+		Limit(50).
+		Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch imports"})
+		return
+	}
+
+	imports := make([]models.ProductImport, 0, len(docs))
+	for _, doc := range docs {
+		var record models.ProductImport
+		if err := doc.DataTo(&record); err != nil {
+			continue
+		}
+		record.ID = doc.Ref.ID
+		record.Results = nil
+		imports = append(imports, record)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"imports": imports, "count": len(imports)})
+}
+
+// GetProductImport returns an import report; with ?format=csv or xlsx the row results
+// are downloaded as a file instead
+func (h *ProductHandler) GetProductImport(c *gin.Context) {
+	doc, err := h.db.Client.Collection("product_imports").Doc(c.Param("id")).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+
+	var record models.ProductImport
+	if err := doc.DataTo(&record); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse import"})
+		return
+	}
+	record.ID = doc.Ref.ID
+
+	format := c.Query("format")
+	if format == "" {
+		c.JSON(http.StatusOK, record)
+		return
+	}
+	if format != "csv" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or xlsx"})
+		return
+	}
+
+	rows := [][]string{productImportResultColumns}
+	for _, result := range record.Results {
+		rows = append(rows, []string{
+			strconv.Itoa(result.Row), result.SKU, result.VariantSKU, result.Action,
+			result.ProductID, strings.Join(result.Errors, "; "),
+		})
+	}
+	writeTableFile(c, "product-import-"+record.ID, format, rows)
+}
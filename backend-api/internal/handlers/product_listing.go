@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"tripund-api/internal/models"
+)
+
+// Product listing sort orders
+const (
+	productSortDefault    = ""
+	productSortPriceAsc   = "price_asc"
+	productSortPriceDesc  = "price_desc"
+	productSortNewest     = "newest"
+	productSortPopularity = "popularity"
+	productSortRating     = "rating"
+)
+
+// popularityWindow is how far back sales are counted for the popularity sort, and
+// popularityMaxAge how long the counts are reused
+const (
+	popularityWindow = 90 * 24 * time.Hour
+	popularityMaxAge = 30 * time.Minute
+)
+
+// productListingMaxAge is how long the products loaded for a listing are reused,
+// productListingMaxEntries how many category and status combinations are kept and
+// productListingMaxProducts how many products of each are loaded
+const (
+	productListingMaxAge      = time.Minute
+	productListingMaxEntries  = 100
+	productListingMaxProducts = 500
+)
+
+// productPriceBuckets are the upper bounds of the price facet ranges
+var productPriceBuckets = []float64{500, 1000, 2500, 5000}
+
+// productPopularity caches units sold per product over popularityWindow
+type productPopularity struct {
+	mu       sync.Mutex
+	sold     map[string]int
+	loadedAt time.Time
+}
+
+// productListingCache holds the products of recent listings by category and status, so
+// paging, sorting and refiltering don't reload them
+type productListingCache struct {
+	mu      sync.Mutex
+	entries map[string]*productListing
+}
+
+// productListings is shared by every handler that changes products: product edits,
+// stock movements, bundle stock and ratings clear it so listings show the change at once
+var productListings productListingCache
+
+type productListing struct {
+	products []models.Product
+	loadedAt time.Time
+}
+
+// get returns the cached products of a listing, or nil if they are missing or stale.
+// The products are shared and must not be changed.
+func (c *productListingCache) get(key string) []models.Product {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry := c.entries[key]; entry != nil && time.Since(entry.loadedAt) < productListingMaxAge {
+		return entry.products
+	}
+	return nil
+}
+
+func (c *productListingCache) put(key string, products []models.Product) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= productListingMaxEntries {
+		c.entries = make(map[string]*productListing)
+	}
+	c.entries[key] = &productListing{products: products, loadedAt: time.Now()}
+}
+
+// clear drops every cached listing after a product changes
+func (c *productListingCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
+
+// FacetValue is one value of a facet and how many products have it
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// PriceRangeFacet counts products whose effective price is in [Min, Max); Max is zero
+// for the open-ended top range
+type PriceRangeFacet struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max,omitempty"`
+	Count int     `json:"count"`
+}
+
+// ProductFacets are counted over the products matching every filter except the facet's
+// own, so selecting one colour still shows how many products the other colours have
+type ProductFacets struct {
+	Colors      []FacetValue      `json:"colors"`
+	Sizes       []FacetValue      `json:"sizes"`
+	Tags        []FacetValue      `json:"tags"`
+	PriceRanges []PriceRangeFacet `json:"price_ranges"`
+	MinPrice    float64           `json:"min_price"`
+	MaxPrice    float64           `json:"max_price"`
+	InStock     int               `json:"in_stock"`
+	Featured    int               `json:"featured"`
+}
+
+// productFilters are the in-memory filters of the product listing
+type productFilters struct {
+	subcategory string
+	minPrice    float64
+	maxPrice    float64
+	colors      []string
+	sizes       []string
+	tags        []string
+	inStock     bool
+	featured    bool
+}
+
+// productCursor marks the last product of a page in the listing's sort order. Cursors
+// of the popularity sort carry the time of the sales counts their keys came from.
+type productCursor struct {
+	Sort     string  `json:"s"`
+	Key      float64 `json:"k"`
+	ID       string  `json:"id"`
+	SortedAt int64   `json:"t,omitempty"`
+}
+
+func encodeProductCursor(cursor productCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeProductCursor(value string) (productCursor, bool) {
+	var cursor productCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(data, &cursor) != nil || cursor.ID == "" {
+		return cursor, false
+	}
+	return cursor, true
+}
+
+// splitFilterValues reads a comma separated query parameter
+func splitFilterValues(value string) []string {
+	values := make([]string, 0)
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// matchesSubcategory compares subcategories case-insensitively, treating dashes,
+// underscores and plus signs in the filter as spaces
+func matchesSubcategory(subcategories []string, filter string) bool {
+	normalized := strings.NewReplacer("-", " ", "_", " ", "+", " ").Replace(filter)
+	for _, subcat := range subcategories {
+		if strings.EqualFold(strings.TrimSpace(subcat), strings.TrimSpace(filter)) ||
+			strings.EqualFold(strings.TrimSpace(subcat), strings.TrimSpace(normalized)) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyFold reports whether any of values is in list, ignoring case
+func anyFold(list, values []string) bool {
+	for _, value := range values {
+		for _, item := range list {
+			if strings.EqualFold(strings.TrimSpace(item), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Facet names used to leave a facet's own filter out when counting it
+const (
+	facetNone  = ""
+	facetPrice = "price"
+	facetColor = "color"
+	facetSize  = "size"
+	facetTag   = "tag"
+	facetStock = "stock"
+	facetFeat  = "featured"
+)
+
+// matches applies every filter except the one belonging to the skipped facet
+func (f *productFilters) matches(product *models.Product, skip string) bool {
+	if f.subcategory != "" && !matchesSubcategory(product.Subcategories, f.subcategory) {
+		return false
+	}
+	if skip != facetPrice {
+		price := product.EffectivePrice()
+		if (f.minPrice > 0 && price < f.minPrice) || (f.maxPrice > 0 && price > f.maxPrice) {
+			return false
+		}
+	}
+	if skip != facetColor && len(f.colors) > 0 && !anyFold(product.Colors(), f.colors) {
+		return false
+	}
+	if skip != facetSize && len(f.sizes) > 0 && !anyFold(product.Sizes(), f.sizes) {
+		return false
+	}
+	if skip != facetTag && len(f.tags) > 0 && !anyFold(product.Tags, f.tags) {
+		return false
+	}
+	if skip != facetStock && f.inStock && !product.InStock() {
+		return false
+	}
+	if skip != facetFeat && f.featured && !product.Featured {
+		return false
+	}
+	return true
+}
+
+// countFacetValues tallies values case-insensitively, keeping the first spelling seen
+func countFacetValues(counts map[string]*FacetValue, values []string) {
+	seen := make(map[string]bool)
+	for _, value := range values {
+		key := strings.ToLower(strings.TrimSpace(value))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if counts[key] == nil {
+			counts[key] = &FacetValue{Value: strings.TrimSpace(value)}
+		}
+		counts[key].Count++
+	}
+}
+
+func sortedFacetValues(counts map[string]*FacetValue) []FacetValue {
+	values := make([]FacetValue, 0, len(counts))
+	for _, value := range counts {
+		values = append(values, *value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return strings.ToLower(values[i].Value) < strings.ToLower(values[j].Value)
+	})
+	return values
+}
+
+// buildProductFacets counts the facets of the candidate products
+func buildProductFacets(products []models.Product, filters *productFilters) ProductFacets {
+	facets := ProductFacets{}
+	colors := make(map[string]*FacetValue)
+	sizes := make(map[string]*FacetValue)
+	tags := make(map[string]*FacetValue)
+
+	ranges := make([]PriceRangeFacet, len(productPriceBuckets)+1)
+	for i := range ranges {
+		if i > 0 {
+			ranges[i].Min = productPriceBuckets[i-1]
+		}
+		if i < len(productPriceBuckets) {
+			ranges[i].Max = productPriceBuckets[i]
+		}
+	}
+
+	pricesSeen := false
+	for i := range products {
+		product := &products[i]
+		if filters.matches(product, facetColor) {
+			countFacetValues(colors, product.Colors())
+		}
+		if filters.matches(product, facetSize) {
+			countFacetValues(sizes, product.Sizes())
+		}
+		if filters.matches(product, facetTag) {
+			countFacetValues(tags, product.Tags)
+		}
+		if filters.matches(product, facetStock) && product.InStock() {
+			facets.InStock++
+		}
+		if filters.matches(product, facetFeat) && product.Featured {
+			facets.Featured++
+		}
+		if filters.matches(product, facetPrice) {
+			price := product.EffectivePrice()
+			if !pricesSeen || price < facets.MinPrice {
+				facets.MinPrice = price
+			}
+			if !pricesSeen || price > facets.MaxPrice {
+				facets.MaxPrice = price
+			}
+			pricesSeen = true
+			for j := range ranges {
+				if price >= ranges[j].Min && (ranges[j].Max == 0 || price < ranges[j].Max) {
+					ranges[j].Count++
+					break
+				}
+			}
+		}
+	}
+
+	facets.Colors = sortedFacetValues(colors)
+	facets.Sizes = sortedFacetValues(sizes)
+	facets.Tags = sortedFacetValues(tags)
+	facets.PriceRanges = ranges
+	return facets
+}
+
+// getProductPopularity returns units sold per product in placed orders over the
+// popularity window and when they were counted, reloading the counts when stale
+func (h *ProductHandler) getProductPopularity() (map[string]int, time.Time) {
+	h.popularity.mu.Lock()
+	defer h.popularity.mu.Unlock()
+
+	if h.popularity.sold != nil && time.Since(h.popularity.loadedAt) < popularityMaxAge {
+		return h.popularity.sold, h.popularity.loadedAt
+	}
+
+	sold := make(map[string]int)
+	docs, err := h.db.Client.Collection("orders").Where("created_at", ">=", time.Now().Add(-popularityWindow)).Documents(h.db.Context).GetAll()
+	if err != nil {
+		log.Printf("Failed to load orders for product popularity: %v", err)
+		return sold, time.Time{}
+	}
+	for _, doc := range docs {
+		var order models.Order
+		if err := doc.DataTo(&order); err != nil || !isPlacedOrder(&order) {
+			continue
+		}
+		for _, item := range order.Items {
+			sold[item.ProductID] += item.Quantity
+		}
+	}
+
+	h.popularity.sold = sold
+	h.popularity.loadedAt = time.Now()
+	return sold, h.popularity.loadedAt
+}
+
+// productSortKey returns the value products are ordered by; keys are sorted ascending,
+// so descending orders use negated values. Keys that change over time come with the
+// time they were taken, as Unix nanoseconds; other sorts return zero.
+func (h *ProductHandler) productSortKey(sortBy string) (func(*models.Product) float64, int64) {
+	switch sortBy {
+	case productSortPriceAsc:
+		return func(p *models.Product) float64 { return p.EffectivePrice() }, 0
+	case productSortPriceDesc:
+		return func(p *models.Product) float64 { return -p.EffectivePrice() }, 0
+	case productSortNewest:
+		return func(p *models.Product) float64 { return -float64(p.CreatedAt.Unix()) }, 0
+	case productSortPopularity:
+		sold, countedAt := h.getProductPopularity()
+		return func(p *models.Product) float64 { return -float64(sold[p.ID]) }, countedAt.UnixNano()
+	case productSortRating:
+		// Rating first, then the number of reviews as a tie-breaker
+		return func(p *models.Product) float64 { return -(p.AverageRating*1e6 + float64(p.ReviewCount)) }, 0
+	}
+	return func(p *models.Product) float64 { return 0 }, 0
+}
+
+// parseProductFilters reads the listing's in-memory filters from the query string
+func parseProductFilters(c *gin.Context) *productFilters {
+	filters := &productFilters{
+		subcategory: c.Query("subcategory"),
+		colors:      splitFilterValues(c.Query("color")),
+		sizes:       splitFilterValues(c.Query("size")),
+		tags:        splitFilterValues(c.Query("tag")),
+		inStock:     c.Query("in_stock") == "true",
+		featured:    c.Query("featured") == "true",
+	}
+	if filters.subcategory == "" {
+		filters.subcategory = c.Query("type")
+	}
+	filters.minPrice, _ = strconv.ParseFloat(c.Query("min_price"), 64)
+	filters.maxPrice, _ = strconv.ParseFloat(c.Query("max_price"), 64)
+	return filters
+}
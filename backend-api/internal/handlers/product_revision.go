@@ -0,0 +1,588 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+)
+
+// unrevisedFields are product fields kept out of drafts, revision diffs and rollbacks:
+// stock moves through the inventory ledger, and the rest is maintained by the store
+var unrevisedFields = map[string]bool{
+	"stock_quantity":     true,
+	"location_stock":     true,
+	"bundle_product_ids": true,
+	"average_rating":     true,
+	"review_count":       true,
+	"created_at":         true,
+	"updated_at":         true,
+}
+
+// diffProductData lists the fields that differ between two versions of a product
+// document, in field order
+func diffProductData(before, after map[string]interface{}) []models.ProductFieldChange {
+	fields := make(map[string]bool)
+	for field := range before {
+		fields[field] = true
+	}
+	for field := range after {
+		fields[field] = true
+	}
+
+	changes := []models.ProductFieldChange{}
+	for field := range fields {
+		if unrevisedFields[field] || reflect.DeepEqual(before[field], after[field]) {
+			continue
+		}
+		changes = append(changes, models.ProductFieldChange{Field: field, Before: before[field], After: after[field]})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// keepVariantStock sets the stock of the variants in an edit to what the live product
+// holds now, so publishing a draft or restoring a revision doesn't undo stock moved
+// since. Variants the product no longer has are restored without stock when
+// dropNewStock is set.
+func keepVariantStock(variants interface{}, current []models.ProductVariant, dropNewStock bool) {
+	list, ok := variants.([]interface{})
+	if !ok {
+		return
+	}
+	stock := make(map[string]*models.ProductVariant)
+	for i := range current {
+		stock[current[i].ID] = &current[i]
+	}
+	for _, item := range list {
+		variant, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := variant["id"].(string)
+		if live := stock[id]; live != nil {
+			variant["stock_quantity"] = live.StockQuantity
+			variant["location_stock"] = live.LocationStock
+		} else if dropNewStock {
+			variant["stock_quantity"] = 0
+			delete(variant, "location_stock")
+		}
+	}
+}
+
+// publishProductUpdates applies edits to the live product and records them as a
+// revision in the transaction that writes them, so the revision's before and after are
+// exactly what the edit changed. It returns no revision if nothing changed.
+func (h *ProductHandler) publishProductUpdates(productID string, updates map[string]interface{}, revision models.ProductRevision) (*models.ProductRevision, error) {
+	var recorded *models.ProductRevision
+	err := h.applyProductUpdates(productID, updates, revision.AuthorID, func(tx *firestore.Transaction, current *firestore.DocumentSnapshot, writes []firestore.Update) error {
+		var err error
+		recorded, err = h.createRevision(tx, current, writes, revision)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recorded, nil
+}
+
+// createRevision records the revision writes make to a product, in the transaction
+// that makes them, returning nil if they change nothing
+func (h *ProductHandler) createRevision(tx *firestore.Transaction, current *firestore.DocumentSnapshot, writes []firestore.Update, revision models.ProductRevision) (*models.ProductRevision, error) {
+	recorded := revisionOf(current, writes, revision)
+	if recorded == nil {
+		return nil, nil
+	}
+	ref := h.db.Client.Collection("product_revisions").NewDoc()
+	recorded.ID = ref.ID
+	return recorded, tx.Create(ref, *recorded)
+}
+
+// firstRevision is a new product's first revision, saved with the product
+func firstRevision(productID string, product interface{}, author string) models.ProductRevision {
+	data, _ := firestoreValue(reflect.ValueOf(product)).(map[string]interface{})
+	return models.ProductRevision{
+		ProductID: productID,
+		Action:    models.RevisionActionCreate,
+		Changes:   []models.ProductFieldChange{},
+		Snapshot:  data,
+		AuthorID:  author,
+		CreatedAt: time.Now(),
+	}
+}
+
+// revisionOf works out the revision writes make to a product as read in the writing
+// transaction: the product afterwards and the fields changed. A product's first
+// revision, with no current document, lists no changes. It returns nil if nothing changes.
+func revisionOf(current *firestore.DocumentSnapshot, writes []firestore.Update, revision models.ProductRevision) *models.ProductRevision {
+	after := make(map[string]interface{})
+	var before map[string]interface{}
+	if current != nil && current.Exists() {
+		before = current.Data()
+		for field, value := range before {
+			after[field] = value
+		}
+	}
+	for _, write := range writes {
+		if write.Value == firestore.Delete {
+			delete(after, write.Path)
+		} else {
+			after[write.Path] = firestoreValue(reflect.ValueOf(write.Value))
+		}
+	}
+
+	if current != nil {
+		revision.ProductID = current.Ref.ID
+	}
+	revision.Changes = []models.ProductFieldChange{}
+	if before != nil {
+		revision.Changes = diffProductData(before, after)
+		if len(revision.Changes) == 0 {
+			return nil
+		}
+	}
+	revision.Snapshot = after
+	revision.CreatedAt = time.Now()
+	return &revision
+}
+
+// firestoreValue converts a value to the form Firestore returns it in when read back:
+// integers as int64, floats as float64, times in UTC to the microsecond, structs by
+// their firestore tags as maps, and slices and maps of interface{} values
+func firestoreValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return x.UTC().Truncate(time.Microsecond)
+	case []byte:
+		return x
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return firestoreValue(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = firestoreValue(v.Index(i))
+		}
+		return list
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			m[key.String()] = firestoreValue(v.MapIndex(key))
+		}
+		return m
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		addStructFields(m, v)
+		return m
+	}
+	return v.Interface()
+}
+
+// addStructFields adds a struct's fields to m as Firestore saves them, following their
+// firestore tags and promoting the fields of embedded structs
+func addStructFields(m map[string]interface{}, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("firestore"), ",")
+		name := tag[0]
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		value := v.Field(i)
+		if field.Anonymous && name == "" && value.Kind() == reflect.Struct {
+			addStructFields(m, value)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if len(tag) > 1 && tag[1] == "omitempty" && firestoreEmpty(value) {
+			continue
+		}
+		m[name] = firestoreValue(value)
+	}
+}
+
+// firestoreEmpty reports whether Firestore leaves out a value tagged omitempty
+func firestoreEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return v.IsZero()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.IsZero()
+	}
+	return false
+}
+
+// saveDraft merges edits into the product's draft, starting one if it has none. Stock
+// isn't drafted; it changes through inventory adjustments.
+func (h *ProductHandler) saveDraft(productID string, updates map[string]interface{}, actor string) (*models.ProductDraft, error) {
+	productRef := h.db.Client.Collection("products").Doc(productID)
+	draftRef := h.db.Client.Collection("product_drafts").Doc(productID)
+
+	var draft models.ProductDraft
+	err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		productDoc, err := tx.Get(productRef)
+		if err != nil {
+			return err
+		}
+		draftDoc, err := tx.Get(draftRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		now := time.Now()
+		draft = models.ProductDraft{}
+		if draftDoc.Exists() {
+			if err := draftDoc.DataTo(&draft); err != nil {
+				return err
+			}
+			if draft.Changes == nil {
+				draft.Changes = make(map[string]interface{})
+			}
+		} else {
+			var product models.Product
+			if err := database.DecodeProduct(productDoc, &product); err != nil {
+				return err
+			}
+			draft = models.ProductDraft{
+				Changes:       make(map[string]interface{}),
+				BaseUpdatedAt: product.UpdatedAt,
+				CreatedBy:     actor,
+				CreatedAt:     now,
+			}
+		}
+		for field, value := range updates {
+			if !unrevisedFields[field] {
+				draft.Changes[field] = value
+			}
+		}
+		draft.UpdatedBy = actor
+		draft.UpdatedAt = now
+		return tx.Set(draftRef, draft)
+	})
+	if err != nil {
+		return nil, err
+	}
+	draft.ProductID = productID
+	return &draft, nil
+}
+
+// productDraftView is a draft with how it differs from the live product
+type productDraftView struct {
+	models.ProductDraft
+	Diff []models.ProductFieldChange `json:"diff"`
+	// The live product was changed after the draft was started, so the draft may undo
+	// those changes
+	LiveChanged bool `json:"live_changed"`
+}
+
+// draftView compares a draft with the live product
+func draftView(draft models.ProductDraft, live *firestore.DocumentSnapshot) productDraftView {
+	data := live.Data()
+	diff := []models.ProductFieldChange{}
+	for field, value := range draft.Changes {
+		if !reflect.DeepEqual(data[field], value) {
+			diff = append(diff, models.ProductFieldChange{Field: field, Before: data[field], After: value})
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		return diff[i].Field < diff[j].Field
+	})
+	updatedAt, _ := data["updated_at"].(time.Time)
+	return productDraftView{ProductDraft: draft, Diff: diff, LiveChanged: updatedAt.After(draft.BaseUpdatedAt)}
+}
+
+// GetProductDrafts lists the drafts waiting to be published, most recently edited first
+func (h *ProductHandler) GetProductDrafts(c *gin.Context) {
+	docs, err := h.db.Client.Collection("product_drafts").Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch drafts"})
+		return
+	}
+	drafts := make([]models.ProductDraft, 0, len(docs))
+	for _, doc := range docs {
+		var draft models.ProductDraft
+		if err := doc.DataTo(&draft); err != nil {
+			continue
+		}
+		draft.ProductID = doc.Ref.ID
+		drafts = append(drafts, draft)
+	}
+	sort.Slice(drafts, func(i, j int) bool {
+		return drafts[i].UpdatedAt.After(drafts[j].UpdatedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{"drafts": drafts, "count": len(drafts)})
+}
+
+// GetProductDraft returns a product's draft and how it differs from the live product
+func (h *ProductHandler) GetProductDraft(c *gin.Context) {
+	productID := c.Param("id")
+	draftDoc, err := h.db.Client.Collection("product_drafts").Doc(productID).Get(h.db.Context)
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product has no draft"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch draft"})
+		return
+	}
+	productDoc, err := h.db.Client.Collection("products").Doc(productID).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	var draft models.ProductDraft
+	if err := draftDoc.DataTo(&draft); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read draft"})
+		return
+	}
+	draft.ProductID = productID
+	c.JSON(http.StatusOK, draftView(draft, productDoc))
+}
+
+// SaveProductDraft saves edits to a product's draft without publishing them, whatever
+// the editor's permissions
+func (h *ProductHandler) SaveProductDraft(c *gin.Context) {
+	productID := c.Param("id")
+	var updates map[string]interface{}
+	if err := c.ShouldBindJSON(&updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeProductUpdates(updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	draft, err := h.saveDraft(productID, updates, c.GetString("user_id"))
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save draft of product %s: %v", productID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save draft"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Draft saved", "draft": draft})
+}
+
+// DiscardProductDraft deletes a product's draft
+func (h *ProductHandler) DiscardProductDraft(c *gin.Context) {
+	if _, err := h.db.Client.Collection("product_drafts").Doc(c.Param("id")).Delete(h.db.Context); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to discard draft"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Draft discarded"})
+}
+
+// PublishProductDraft makes a product's draft live as a new revision. The draft is
+// kept if it was edited while publishing, so those edits aren't lost.
+func (h *ProductHandler) PublishProductDraft(c *gin.Context) {
+	productID := c.Param("id")
+	draftDoc, err := h.db.Client.Collection("product_drafts").Doc(productID).Get(h.db.Context)
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product has no draft"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch draft"})
+		return
+	}
+	var draft models.ProductDraft
+	if err := draftDoc.DataTo(&draft); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read draft"})
+		return
+	}
+
+	before, err := h.db.Client.Collection("products").Doc(productID).Get(h.db.Context)
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish draft"})
+		return
+	}
+	var current models.Product
+	if err := database.DecodeProduct(before, &current); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read product"})
+		return
+	}
+	keepVariantStock(draft.Changes["variants"], current.Variants, false)
+
+	revision, err := h.publishProductUpdates(productID, draft.Changes, models.ProductRevision{
+		Action:        models.RevisionActionPublish,
+		AuthorID:      c.GetString("user_id"),
+		DraftAuthorID: draft.UpdatedBy,
+	})
+	if err != nil {
+		h.productUpdateFailed(c, err, "Failed to publish draft")
+		return
+	}
+
+	if _, err := draftDoc.Ref.Delete(h.db.Context, firestore.LastUpdateTime(draftDoc.UpdateTime)); err != nil {
+		log.Printf("Kept draft of product %s after publishing: %v", productID, err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Draft published", "revision": revision})
+}
+
+// GetProductRevisions lists a product's revisions, newest first, without their snapshots
+func (h *ProductHandler) GetProductRevisions(c *gin.Context) {
+	docs, err := h.db.Client.Collection("product_revisions").Where("product_id", "==", c.Param("id")).Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revisions"})
+		return
+	}
+	revisions := make([]models.ProductRevision, 0, len(docs))
+	for _, doc := range docs {
+		var revision models.ProductRevision
+		if err := doc.DataTo(&revision); err != nil {
+			continue
+		}
+		revision.ID = doc.Ref.ID
+		revision.Snapshot = nil
+		revisions = append(revisions, revision)
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].CreatedAt.After(revisions[j].CreatedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions, "count": len(revisions)})
+}
+
+// productRevision loads one of a product's revisions
+func (h *ProductHandler) productRevision(productID, revisionID string) (*models.ProductRevision, error) {
+	doc, err := h.db.Client.Collection("product_revisions").Doc(revisionID).Get(h.db.Context)
+	if err != nil {
+		return nil, err
+	}
+	var revision models.ProductRevision
+	if err := doc.DataTo(&revision); err != nil {
+		return nil, err
+	}
+	if revision.ProductID != productID {
+		return nil, status.Error(codes.NotFound, "revision belongs to another product")
+	}
+	revision.ID = doc.Ref.ID
+	return &revision, nil
+}
+
+// GetProductRevision returns a revision with the whole product as it was
+func (h *ProductHandler) GetProductRevision(c *gin.Context) {
+	revision, err := h.productRevision(c.Param("id"), c.Param("revisionId"))
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revision"})
+		return
+	}
+	c.JSON(http.StatusOK, revision)
+}
+
+// RollbackProduct restores a product to how it was at a revision, recorded as a new
+// revision. Stock, reviews and other fields the store maintains keep their live values.
+func (h *ProductHandler) RollbackProduct(c *gin.Context) {
+	productID := c.Param("id")
+	revision, err := h.productRevision(productID, c.Param("revisionId"))
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revision"})
+		return
+	}
+
+	before, err := h.db.Client.Collection("products").Doc(productID).Get(h.db.Context)
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back product"})
+		return
+	}
+	var current models.Product
+	if err := database.DecodeProduct(before, &current); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read product"})
+		return
+	}
+
+	updates := make(map[string]interface{})
+	for _, change := range diffProductData(before.Data(), revision.Snapshot) {
+		// Fields the revision didn't have are cleared. The type, variants and bundle
+		// components are emptied rather than deleted, as the edit reads them.
+		switch {
+		case change.After != nil:
+			updates[change.Field] = change.After
+		case change.Field == "product_type":
+			updates[change.Field] = ""
+		case change.Field == "variants" || change.Field == "bundle_components":
+			updates[change.Field] = []interface{}{}
+		default:
+			updates[change.Field] = firestore.Delete
+		}
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Product already matches the revision"})
+		return
+	}
+	keepVariantStock(updates["variants"], current.Variants, true)
+
+	rollback, err := h.publishProductUpdates(productID, updates, models.ProductRevision{
+		Action:       models.RevisionActionRollback,
+		AuthorID:     c.GetString("user_id"),
+		RolledBackTo: revision.ID,
+	})
+	if err != nil {
+		h.productUpdateFailed(c, err, "Failed to roll back product")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Product rolled back", "revision": rollback})
+}
@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+)
+
+// productScheduleInterval is how often the scheduler applies due product schedules
+const productScheduleInterval = time.Minute
+
+// scheduleDue is when a schedule next needs applying: its start, or the end of a
+// running sale
+func scheduleDue(schedule *models.ProductSchedule) time.Time {
+	if schedule.State == models.ScheduleStateActive && schedule.EndsAt != nil {
+		return *schedule.EndsAt
+	}
+	return schedule.StartsAt
+}
+
+// validateProductSchedule checks a new schedule against the product it changes and
+// fills in the status a publish or unpublish sets
+func validateProductSchedule(product *models.Product, schedule *models.ProductSchedule) error {
+	if schedule.Type != models.ScheduleTypeSale && schedule.EndsAt != nil {
+		return fmt.Errorf("only sales have an end time")
+	}
+
+	switch schedule.Type {
+	case models.ScheduleTypePublish, models.ScheduleTypeUnpublish:
+		if schedule.VariantID != "" || schedule.Price != nil || schedule.SalePrice != nil {
+			return fmt.Errorf("publishing applies to the whole product and doesn't change prices")
+		}
+		if schedule.Type == models.ScheduleTypePublish {
+			schedule.Status = models.ProductStatusActive
+		} else if schedule.Status == "" {
+			schedule.Status = models.ProductStatusDraft
+		} else if schedule.Status != models.ProductStatusDraft && schedule.Status != models.ProductStatusArchived {
+			return fmt.Errorf("an unpublished product becomes draft or archived")
+		}
+		return nil
+	}
+
+	if schedule.Status != "" {
+		return fmt.Errorf("price changes don't set a status")
+	}
+	price := product.Price
+	if schedule.VariantID != "" {
+		i := variantIndex(product, schedule.VariantID)
+		if i < 0 {
+			return fmt.Errorf("variant %s not found", schedule.VariantID)
+		}
+		if variantPrice := float64(product.Variants[i].Price); variantPrice > 0 {
+			price = variantPrice
+		}
+	}
+
+	if schedule.Type == models.ScheduleTypePrice {
+		if schedule.Price == nil && schedule.SalePrice == nil {
+			return fmt.Errorf("a price change needs a price or a sale price")
+		}
+		if schedule.Price != nil {
+			if *schedule.Price <= 0 {
+				return fmt.Errorf("price must be positive")
+			}
+			price = *schedule.Price
+		}
+		if schedule.SalePrice != nil && (*schedule.SalePrice < 0 || *schedule.SalePrice > 0 && *schedule.SalePrice >= price) {
+			return fmt.Errorf("sale price must be below the price, or 0 to remove it")
+		}
+		return nil
+	}
+
+	if schedule.Price != nil {
+		return fmt.Errorf("a sale only sets the sale price; schedule a price change for the price")
+	}
+	if schedule.SalePrice == nil || *schedule.SalePrice <= 0 || *schedule.SalePrice >= price {
+		return fmt.Errorf("a sale needs a sale price below the price")
+	}
+	if schedule.EndsAt == nil || !schedule.EndsAt.After(schedule.StartsAt) {
+		return fmt.Errorf("a sale needs an end time after its start")
+	}
+	return nil
+}
+
+// productSchedules loads a product's schedules, soonest first
+func (h *ProductHandler) productSchedules(productID string) ([]models.ProductSchedule, error) {
+	docs, err := h.db.Client.Collection("product_schedules").Where("product_id", "==", productID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	schedules := make([]models.ProductSchedule, 0, len(docs))
+	for _, doc := range docs {
+		var schedule models.ProductSchedule
+		if err := doc.DataTo(&schedule); err != nil {
+			continue
+		}
+		schedule.ID = doc.Ref.ID
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].StartsAt.Before(schedules[j].StartsAt)
+	})
+	return schedules, nil
+}
+
+// GetProductSchedules lists a product's scheduled price and status changes
+func (h *ProductHandler) GetProductSchedules(c *gin.Context) {
+	schedules, err := h.productSchedules(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules, "count": len(schedules)})
+}
+
+// GetUpcomingSchedules lists the schedules still to start or end across all products,
+// next due first
+func (h *ProductHandler) GetUpcomingSchedules(c *gin.Context) {
+	schedules, err := h.pendingSchedules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules, "count": len(schedules)})
+}
+
+// pendingSchedules loads the schedules waiting to start and the running sales, next due
+// first
+func (h *ProductHandler) pendingSchedules() ([]models.ProductSchedule, error) {
+	docs, err := h.db.Client.Collection("product_schedules").
+		Where("state", "in", []string{models.ScheduleStateScheduled, models.ScheduleStateActive}).
+		Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	schedules := make([]models.ProductSchedule, 0, len(docs))
+	for _, doc := range docs {
+		var schedule models.ProductSchedule
+		if err := doc.DataTo(&schedule); err != nil {
+			continue
+		}
+		schedule.ID = doc.Ref.ID
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return scheduleDue(&schedules[i]).Before(scheduleDue(&schedules[j]))
+	})
+	return schedules, nil
+}
+
+// CreateProductSchedule schedules a price change, a sale or publishing for a product.
+// A product or variant can only have one sale at a time.
+func (h *ProductHandler) CreateProductSchedule(c *gin.Context) {
+	productID := c.Param("id")
+	var req models.ProductScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	product, err := h.db.GetProductByID(productID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	now := time.Now()
+	schedule := models.ProductSchedule{
+		ProductID: productID,
+		VariantID: req.VariantID,
+		Type:      req.Type,
+		Price:     req.Price,
+		SalePrice: req.SalePrice,
+		Status:    req.Status,
+		StartsAt:  req.StartsAt,
+		EndsAt:    req.EndsAt,
+		State:     models.ScheduleStateScheduled,
+		CreatedBy: c.GetString("user_id"),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := validateProductSchedule(product, &schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Each sale restores the sale price it replaced, so sales can't overlap
+	if schedule.Type == models.ScheduleTypeSale {
+		existing, err := h.productSchedules(productID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing sales"})
+			return
+		}
+		for _, other := range existing {
+			if other.Type != models.ScheduleTypeSale || other.VariantID != schedule.VariantID || other.EndsAt == nil ||
+				(other.State != models.ScheduleStateScheduled && other.State != models.ScheduleStateActive) {
+				continue
+			}
+			if schedule.StartsAt.Before(*other.EndsAt) && other.StartsAt.Before(*schedule.EndsAt) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Another sale is scheduled during this time"})
+				return
+			}
+		}
+	}
+
+	ref := h.db.Client.Collection("product_schedules").NewDoc()
+	if _, err := ref.Set(h.db.Context, schedule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule"})
+		return
+	}
+	schedule.ID = ref.ID
+
+	c.JSON(http.StatusCreated, schedule)
+}
+
+// CancelProductSchedule cancels a schedule that hasn't started. A running sale is
+// ended early instead, restoring the previous sale price.
+func (h *ProductHandler) CancelProductSchedule(c *gin.Context) {
+	productID := c.Param("id")
+	ref := h.db.Client.Collection("product_schedules").Doc(c.Param("scheduleId"))
+
+	var schedule models.ProductSchedule
+	err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&schedule); err != nil {
+			return err
+		}
+		if schedule.ProductID != productID || schedule.State != models.ScheduleStateScheduled {
+			return nil
+		}
+		schedule.State = models.ScheduleStateCancelled
+		schedule.UpdatedAt = time.Now()
+		return tx.Set(ref, schedule)
+	})
+	if status.Code(err) == codes.NotFound || err == nil && schedule.ProductID != productID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel schedule"})
+		return
+	}
+
+	switch schedule.State {
+	case models.ScheduleStateCancelled:
+		c.JSON(http.StatusOK, gin.H{"message": "Schedule cancelled"})
+	case models.ScheduleStateActive:
+		if _, err := h.applySchedule(ref.ID, true); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end sale"})
+			return
+		}
+		h.indexProduct(productID)
+		c.JSON(http.StatusOK, gin.H{"message": "Sale ended"})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "Schedule has already been applied"})
+	}
+}
+
+// StartProductScheduler applies product schedules as they fall due, checking every
+// minute and once on startup to catch up. Each schedule is claimed in a transaction, so
+// only one instance applies it.
+func (h *ProductHandler) StartProductScheduler() {
+	go func() {
+		h.runDueSchedules(time.Now())
+		ticker := time.NewTicker(productScheduleInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			h.runDueSchedules(now)
+		}
+	}()
+}
+
+// runDueSchedules starts the schedules due by now and ends the sales that are over
+func (h *ProductHandler) runDueSchedules(now time.Time) {
+	schedules, err := h.pendingSchedules()
+	if err != nil {
+		log.Printf("Failed to load product schedules: %v", err)
+		return
+	}
+	for i := range schedules {
+		schedule := &schedules[i]
+		if scheduleDue(schedule).After(now) {
+			break
+		}
+		ending := schedule.State == models.ScheduleStateActive
+		applied, err := h.applySchedule(schedule.ID, ending)
+		if err != nil {
+			log.Printf("Failed to apply product schedule %s: %v", schedule.ID, err)
+			continue
+		}
+		if !applied {
+			continue
+		}
+		h.indexProduct(schedule.ProductID)
+		if schedule.Type == models.ScheduleTypePublish || schedule.Type == models.ScheduleTypeUnpublish {
+			h.inventory.checkProductLowStock(schedule.ProductID)
+		}
+	}
+}
+
+// applySchedule starts a schedule, or ends a running sale, reporting whether it changed
+// the product. A schedule another instance already applied is left alone; one that
+// can't be applied is marked failed.
+func (h *ProductHandler) applySchedule(scheduleID string, ending bool) (bool, error) {
+	scheduleRef := h.db.Client.Collection("product_schedules").Doc(scheduleID)
+	applied := false
+	err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		applied = false
+		doc, err := tx.Get(scheduleRef)
+		if err != nil {
+			return err
+		}
+		var schedule models.ProductSchedule
+		if err := doc.DataTo(&schedule); err != nil {
+			return err
+		}
+		from := models.ScheduleStateScheduled
+		if ending {
+			from = models.ScheduleStateActive
+		}
+		if schedule.State != from {
+			return nil
+		}
+
+		now := time.Now()
+		schedule.UpdatedAt = now
+		productRef := h.db.Client.Collection("products").Doc(schedule.ProductID)
+		productDoc, err := tx.Get(productRef)
+		if status.Code(err) == codes.NotFound {
+			schedule.State = models.ScheduleStateFailed
+			schedule.Error = "product not found"
+			return tx.Set(scheduleRef, schedule)
+		} else if err != nil {
+			return err
+		}
+		var product models.Product
+		if err := database.DecodeProduct(productDoc, &product); err != nil {
+			return err
+		}
+
+		updates, err := scheduleUpdates(&product, &schedule, ending, now)
+		if err != nil {
+			schedule.State = models.ScheduleStateFailed
+			schedule.Error = err.Error()
+			return tx.Set(scheduleRef, schedule)
+		}
+		if len(updates) > 0 {
+			updates = append(updates, firestore.Update{Path: "updated_at", Value: now})
+			if err := tx.Update(productRef, updates); err != nil {
+				return err
+			}
+			if _, err := h.createRevision(tx, productDoc, updates, models.ProductRevision{
+				Action:   models.RevisionActionSchedule,
+				AuthorID: schedule.CreatedBy,
+			}); err != nil {
+				return err
+			}
+		}
+		applied = true
+		return tx.Set(scheduleRef, schedule)
+	})
+	return applied, err
+}
+
+// scheduleUpdates makes a schedule's change to the product, returning the product
+// fields to save, and moves the schedule on to its next state
+func scheduleUpdates(product *models.Product, schedule *models.ProductSchedule, ending bool, now time.Time) ([]firestore.Update, error) {
+	if ending {
+		schedule.State = models.ScheduleStateCompleted
+		schedule.EndedAt = &now
+	} else if schedule.Type == models.ScheduleTypeSale {
+		schedule.State = models.ScheduleStateActive
+		schedule.StartedAt = &now
+	} else {
+		schedule.State = models.ScheduleStateCompleted
+		schedule.StartedAt = &now
+	}
+
+	if schedule.Type == models.ScheduleTypePublish || schedule.Type == models.ScheduleTypeUnpublish {
+		return []firestore.Update{{Path: "status", Value: schedule.Status}}, nil
+	}
+
+	// Prices are set on the product or, for variant schedules, in its variants list
+	salePrice, saleEndsAt := &product.SalePrice, &product.SaleEndsAt
+	var variant *models.ProductVariant
+	if schedule.VariantID != "" {
+		i := variantIndex(product, schedule.VariantID)
+		if i < 0 {
+			return nil, fmt.Errorf("variant %s not found", schedule.VariantID)
+		}
+		variant = &product.Variants[i]
+		salePrice, saleEndsAt = &variant.SalePrice, &variant.SaleEndsAt
+	}
+
+	switch {
+	case schedule.Type == models.ScheduleTypePrice:
+		if schedule.Price != nil {
+			if variant != nil {
+				variant.Price = models.Money(*schedule.Price)
+			} else {
+				product.Price = *schedule.Price
+			}
+		}
+		// A scheduled sale price stays until changed again
+		if schedule.SalePrice != nil {
+			*salePrice = models.Money(*schedule.SalePrice)
+			*saleEndsAt = nil
+		}
+	case !ending:
+		schedule.PreviousSalePrice = *salePrice
+		*salePrice = models.Money(*schedule.SalePrice)
+		*saleEndsAt = schedule.EndsAt
+	default:
+		// Leave a sale price edited during the sale in place
+		if float64(*salePrice) == *schedule.SalePrice {
+			*salePrice = schedule.PreviousSalePrice
+		}
+		if *saleEndsAt != nil && schedule.EndsAt != nil && (*saleEndsAt).Equal(*schedule.EndsAt) {
+			*saleEndsAt = nil
+		}
+	}
+
+	if variant != nil {
+		return []firestore.Update{{Path: "variants", Value: product.Variants}}, nil
+	}
+	updates := []firestore.Update{
+		{Path: "price", Value: product.Price},
+		{Path: "sale_price", Value: product.SalePrice},
+		{Path: "sale_ends_at", Value: firestore.Delete},
+	}
+	if product.SaleEndsAt != nil {
+		updates[2].Value = *product.SaleEndsAt
+	}
+	return updates, nil
+}
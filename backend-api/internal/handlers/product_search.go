@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+	"tripund-api/internal/search"
+)
+
+// Ranking weights of the product fields in the search index
+const (
+	searchWeightName        = 10.0
+	searchWeightSKU         = 8.0
+	searchWeightTags        = 5.0
+	searchWeightCategory    = 4.0
+	searchWeightColor       = 3.0
+	searchWeightDescription = 1.0
+	searchBoostFeatured     = 0.5
+)
+
+// productSearchMaxAge is how long the index is trusted before it is rebuilt from
+// Firestore, which picks up writes made by other instances
+const productSearchMaxAge = 10 * time.Minute
+
+// productSearchDocument describes the searchable text of a product
+func productSearchDocument(product *models.Product) search.Document {
+	doc := search.Document{
+		ID: product.ID,
+		Fields: []search.Field{
+			{Text: product.Name, Weight: searchWeightName},
+			{Text: product.SKU, Weight: searchWeightSKU, Exact: true},
+			{Text: strings.Join(product.Tags, " "), Weight: searchWeightTags},
+			{Text: strings.Join(append(append([]string{}, product.Categories...), product.Subcategories...), " "), Weight: searchWeightCategory},
+			{Text: strings.Join(product.AvailableColors, " "), Weight: searchWeightColor},
+			{Text: product.ShortDescription + " " + product.Description, Weight: searchWeightDescription},
+		},
+	}
+	for _, variant := range product.Variants {
+		doc.Fields = append(doc.Fields,
+			search.Field{Text: variant.Color, Weight: searchWeightColor},
+			search.Field{Text: variant.SKU, Weight: searchWeightSKU, Exact: true},
+		)
+	}
+	if product.Featured {
+		doc.Boost = searchBoostFeatured
+	}
+	return doc
+}
+
+// ensureSearchIndex builds the index on first use and rebuilds it once it is stale
+func (h *ProductHandler) ensureSearchIndex() error {
+	if builtAt := h.searchIndex.BuiltAt(); !builtAt.IsZero() && time.Since(builtAt) < productSearchMaxAge {
+		return nil
+	}
+
+	h.searchRebuildMu.Lock()
+	defer h.searchRebuildMu.Unlock()
+	if builtAt := h.searchIndex.BuiltAt(); !builtAt.IsZero() && time.Since(builtAt) < productSearchMaxAge {
+		return nil // Rebuilt while waiting
+	}
+	return h.rebuildSearchIndex()
+}
+
+// rebuildSearchIndex indexes every active product and reloads the synonym dictionary
+func (h *ProductHandler) rebuildSearchIndex() error {
+	productListings.clear()
+	if err := h.loadSearchSynonyms(); err != nil {
+		log.Printf("Failed to load search synonyms: %v", err)
+	}
+
+	docs, err := h.db.Client.Collection("products").Where("status", "==", "active").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return err
+	}
+
+	documents := make([]search.Document, 0, len(docs))
+	for _, doc := range docs {
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil {
+			continue
+		}
+		product.ID = doc.Ref.ID
+		documents = append(documents, productSearchDocument(&product))
+	}
+
+	h.searchIndex.Rebuild(documents)
+	log.Printf("Search index rebuilt with %d products", len(documents))
+	return nil
+}
+
+// indexProduct refreshes one product in the search index after a write, and clears the
+// cached listings. Products that aren't active are removed.
+func (h *ProductHandler) indexProduct(productID string) {
+	productListings.clear()
+	doc, err := h.db.Client.Collection("products").Doc(productID).Get(h.db.Context)
+	if err != nil {
+		h.searchIndex.Remove(productID)
+		return
+	}
+
+	var product models.Product
+	if err := database.DecodeProduct(doc, &product); err != nil {
+		log.Printf("Failed to parse product %s for the search index: %v", productID, err)
+		return
+	}
+	product.ID = productID
+
+	if product.Status != "active" {
+		h.searchIndex.Remove(productID)
+		return
+	}
+	h.searchIndex.Put(productSearchDocument(&product))
+}
+
+// SearchProducts returns active products matching q, best match first. Matching
+// tolerates typos and partial words and applies the synonym dictionary; results are
+// paginated with page and limit. First pages are logged for search insights.
+func (h *ProductHandler) SearchProducts(c *gin.Context) {
+	searchQuery := c.Query("q")
+	if searchQuery == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query required"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	if err := h.ensureSearchIndex(); err != nil {
+		log.Printf("Failed to build search index: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+
+	hits, total := h.searchIndex.Search(searchQuery, (page-1)*limit, limit)
+	if page == 1 {
+		go h.logSearch(searchQuery, total, c.GetString("user_id"))
+	}
+
+	// Load the page from Firestore so stock and prices are current
+	products := make([]models.Product, 0, len(hits))
+	if len(hits) > 0 {
+		refs := make([]*firestore.DocumentRef, len(hits))
+		for i, hit := range hits {
+			refs[i] = h.db.Client.Collection("products").Doc(hit.ID)
+		}
+		docs, err := h.db.Client.GetAll(h.db.Context, refs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+			return
+		}
+		for _, doc := range docs {
+			if !doc.Exists() {
+				continue
+			}
+			var product models.Product
+			if err := database.DecodeProduct(doc, &product); err != nil {
+				continue
+			}
+			product.ID = doc.Ref.ID
+			if product.Status != "active" {
+				continue
+			}
+			h.validateVariantConsistency(&product)
+			product.SetCurrentPrices()
+			products = append(products, product)
+		}
+	}
+
+	totalPages := (total + limit - 1) / limit
+	c.JSON(http.StatusOK, gin.H{
+		"products":    products,
+		"count":       len(products),
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": totalPages,
+		"query":       searchQuery,
+	})
+}
+
+// RebuildSearchIndex rebuilds the product search index from Firestore (admin)
+func (h *ProductHandler) RebuildSearchIndex(c *gin.Context) {
+	h.searchRebuildMu.Lock()
+	defer h.searchRebuildMu.Unlock()
+
+	if err := h.rebuildSearchIndex(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild search index"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Search index rebuilt",
+		"products": h.searchIndex.Len(),
+	})
+}
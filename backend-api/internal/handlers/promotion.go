@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"fmt"
+	"log"
+	"math"
 	"net/http"
 	"time"
 
@@ -21,17 +23,48 @@ func NewPromotionHandler(db *database.Firebase) *PromotionHandler {
 }
 
 type ValidatePromotionRequest struct {
-	Code       string  `json:"code" binding:"required"`
-	OrderTotal float64 `json:"order_total" binding:"required"`
-	UserID     string  `json:"user_id"`
+	Code       string              `json:"code" binding:"required"`
+	OrderTotal float64             `json:"order_total" binding:"required"`
+	UserID     string              `json:"user_id"`
+	Phone      string              `json:"phone,omitempty"` // Identifies guests for per-customer limits
+	Email      string              `json:"email,omitempty"`
+	Items      []PromotionCartItem `json:"items,omitempty" binding:"dive"` // Required for product-scoped promotions
+	// Delivery details; without them free shipping codes are validated but waive nothing
+	ShippingMethod string              `json:"shipping_method,omitempty"`
+	Address        *models.UserAddress `json:"address,omitempty"`
+}
+
+// PromotionCartItem is a cart line used to work out which items a promotion covers
+type PromotionCartItem struct {
+	ProductID string  `json:"product_id"`
+	VariantID string  `json:"variant_id,omitempty"`
+	Quantity  int     `json:"quantity" binding:"min=1,max=1000"`
+	Price     float64 `json:"price"`
+	Discount  float64 `json:"discount,omitempty"` // Already applied by other promotions
+}
+
+// netValue is the line value after discounts already applied
+func (item PromotionCartItem) netValue() float64 {
+	return item.Price*float64(item.Quantity) - item.Discount
+}
+
+// LineDiscount is the share of a promotion's discount allocated to one cart line
+type LineDiscount struct {
+	ProductID string  `json:"product_id"`
+	VariantID string  `json:"variant_id,omitempty"`
+	Discount  float64 `json:"discount"`
 }
 
 type ValidatePromotionResponse struct {
-	Valid    bool                     `json:"valid"`
-	Discount float64                  `json:"discount"`
-	Type     models.PromotionType     `json:"type"`
-	Message  string                   `json:"message"`
-	Promo    *models.Promotion        `json:"promotion,omitempty"`
+	Valid            bool                      `json:"valid"`
+	Discount         float64                   `json:"discount"`
+	ShippingDiscount float64                   `json:"shipping_discount,omitempty"` // Free shipping codes
+	Type             models.PromotionType      `json:"type"`
+	Message          string                    `json:"message"`
+	Promo            *models.Promotion         `json:"promotion,omitempty"`
+	EligibleTotal    float64                   `json:"eligible_total,omitempty"`
+	LineDiscounts    []LineDiscount            `json:"line_discounts,omitempty"`
+	Decisions        []models.DiscountDecision `json:"decisions,omitempty"` // Other discounts considered alongside the code
 }
 
 func (h *PromotionHandler) ValidatePromotion(c *gin.Context) {
@@ -41,15 +74,13 @@ func (h *PromotionHandler) ValidatePromotion(c *gin.Context) {
 		return
 	}
 
-	// Find promotion by code
-	iter := h.db.Client.Collection("promotions").Where("code", "==", req.Code).Documents(h.db.Context)
-	docs, err := iter.GetAll()
+	promotion, uniqueCode, err := h.resolvePromotionCode(req.Code)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promotion"})
 		return
 	}
 
-	if len(docs) == 0 {
+	if promotion == nil {
 		c.JSON(http.StatusOK, ValidatePromotionResponse{
 			Valid:   false,
 			Message: "Invalid promo code",
@@ -57,34 +88,216 @@ func (h *PromotionHandler) ValidatePromotion(c *gin.Context) {
 		return
 	}
 
-	doc := docs[0]
-	var promotion models.Promotion
-	if err := doc.DataTo(&promotion); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse promotion"})
+	if message := uniqueCodeUnavailableMessage(uniqueCode); message != "" {
+		c.JSON(http.StatusOK, ValidatePromotionResponse{
+			Valid:   false,
+			Message: message,
+		})
 		return
 	}
-	promotion.ID = doc.Ref.ID
 
-	// Validate promotion
-	validationResult := h.validatePromotionRules(&promotion, req.OrderTotal, req.UserID)
-	if !validationResult.Valid {
-		c.JSON(http.StatusOK, validationResult)
+	customer := PromotionCustomer{UserID: req.UserID, Phone: req.Phone, Email: req.Email}
+	if len(req.Items) == 0 {
+		c.JSON(http.StatusOK, h.evaluatePromotion(promotion, req.Items, req.OrderTotal, customer))
 		return
 	}
 
-	// Calculate actual discount
-	actualDiscount := h.calculateDiscount(&promotion, req.OrderTotal)
-	
-	c.JSON(http.StatusOK, ValidatePromotionResponse{
-		Valid:    true,
-		Discount: actualDiscount,
-		Type:     promotion.Type,
-		Message:  "Promo code is valid",
-		Promo:    &promotion,
-	})
+	// With a cart the code is evaluated alongside automatic offers and stacking rules
+	var shipping *PromotionShipping
+	if req.ShippingMethod != "" || req.Address != nil {
+		shipping = &PromotionShipping{Method: req.ShippingMethod, Address: req.Address}
+	}
+	quote, _, err := h.evaluateDiscounts(req.Items, req.Code, req.OrderTotal, "", false, customer, shipping)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate promotion"})
+		return
+	}
+
+	decision := quote.decision(models.DiscountKindPromoCode)
+	if decision == nil || !decision.Applied {
+		response := ValidatePromotionResponse{Valid: false, Message: "Invalid promo code", Decisions: quote.Decisions}
+		if decision != nil {
+			response.Message = decision.Reason
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	response := ValidatePromotionResponse{
+		Valid:         true,
+		Discount:      quote.CouponDiscount,
+		Type:          promotion.Type,
+		Message:       "Promo code is valid",
+		Promo:         promotion,
+		EligibleTotal: quote.couponEligibleTotal,
+		Decisions:     quote.Decisions,
+	}
+	if promotion.Type == models.PromotionTypeFreeShipping {
+		response.ShippingDiscount = decision.Amount
+	}
+	for i, discount := range quote.couponLines {
+		if discount > 0 {
+			response.LineDiscounts = append(response.LineDiscounts, LineDiscount{
+				ProductID: req.Items[i].ProductID,
+				VariantID: req.Items[i].VariantID,
+				Discount:  math.Round(discount*100) / 100,
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
-func (h *PromotionHandler) validatePromotionRules(promo *models.Promotion, orderTotal float64, userID string) ValidatePromotionResponse {
+// findPromotionByCode returns the promotion with the given code, or nil if none exists
+func (h *PromotionHandler) findPromotionByCode(code string) (*models.Promotion, error) {
+	docs, err := h.db.Client.Collection("promotions").Where("code", "==", code).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	var promotion models.Promotion
+	if err := docs[0].DataTo(&promotion); err != nil {
+		return nil, err
+	}
+	promotion.ID = docs[0].Ref.ID
+
+	return &promotion, nil
+}
+
+// evaluatePromotion validates a promotion against a cart and allocates the discount
+// across the eligible lines. Without items the whole order total is treated as eligible,
+// which only works for promotions that aren't scoped to products.
+func (h *PromotionHandler) evaluatePromotion(promo *models.Promotion, items []PromotionCartItem, orderTotal float64, customer PromotionCustomer) ValidatePromotionResponse {
+	var eligible []bool
+	eligibleTotal := orderTotal
+	if len(items) > 0 {
+		eligible, eligibleTotal = h.eligibleLines(promo, items)
+	} else if promo.HasProductRules() {
+		return ValidatePromotionResponse{
+			Valid:   false,
+			Message: "This promo code applies to selected products only",
+		}
+	}
+
+	validationResult := h.validatePromotionRules(promo, orderTotal, eligibleTotal, customer)
+	if !validationResult.Valid {
+		return validationResult
+	}
+
+	discount := h.calculateDiscount(promo, eligibleTotal)
+
+	response := ValidatePromotionResponse{
+		Valid:         true,
+		Discount:      discount,
+		Type:          promo.Type,
+		Message:       "Promo code is valid",
+		Promo:         promo,
+		EligibleTotal: eligibleTotal,
+	}
+
+	if len(items) > 0 {
+		allocations := allocateDiscount(items, eligible, discount)
+		for i, item := range items {
+			if allocations[i] > 0 {
+				response.LineDiscounts = append(response.LineDiscounts, LineDiscount{
+					ProductID: item.ProductID,
+					VariantID: item.VariantID,
+					Discount:  allocations[i],
+				})
+			}
+		}
+	}
+
+	return response
+}
+
+// eligibleLines marks the cart lines a promotion applies to and sums their net value
+func (h *PromotionHandler) eligibleLines(promo *models.Promotion, items []PromotionCartItem) ([]bool, float64) {
+	return eligibleLinesFor(promo, items, h.loadCartProducts(items))
+}
+
+// loadCartProducts fetches each distinct product in the cart once
+func (h *PromotionHandler) loadCartProducts(items []PromotionCartItem) map[string]*models.Product {
+	products := make(map[string]*models.Product)
+	for _, item := range items {
+		if _, ok := products[item.ProductID]; ok {
+			continue
+		}
+		product, err := h.db.GetProductByID(item.ProductID)
+		if err != nil {
+			log.Printf("Promotion evaluation: product %s not found: %v", item.ProductID, err)
+		}
+		products[item.ProductID] = product
+	}
+	return products
+}
+
+func eligibleLinesFor(promo *models.Promotion, items []PromotionCartItem, products map[string]*models.Product) ([]bool, float64) {
+	eligible := make([]bool, len(items))
+	var eligibleTotal float64
+
+	for i, item := range items {
+		if promo.AppliesToProduct(products[item.ProductID]) {
+			eligible[i] = true
+			eligibleTotal += item.netValue()
+		}
+	}
+
+	return eligible, eligibleTotal
+}
+
+// allocateDiscount splits a discount across eligible lines in proportion to their value.
+// Amounts are rounded to paise and the rounding remainder goes to the last eligible line.
+func allocateDiscount(items []PromotionCartItem, eligible []bool, discount float64) []float64 {
+	allocations := make([]float64, len(items))
+
+	var eligibleTotal float64
+	last := -1
+	for i, item := range items {
+		if eligible[i] {
+			eligibleTotal += item.netValue()
+			last = i
+		}
+	}
+	if eligibleTotal <= 0 || discount <= 0 {
+		return allocations
+	}
+
+	var allocated float64
+	for i, item := range items {
+		if !eligible[i] {
+			continue
+		}
+		if i == last {
+			allocations[i] = math.Round((discount-allocated)*100) / 100
+			break
+		}
+		share := math.Round(discount*item.netValue()/eligibleTotal*100) / 100
+		allocations[i] = share
+		allocated += share
+	}
+
+	return allocations
+}
+
+func cartItemsFromOrder(items []models.OrderItem) []PromotionCartItem {
+	cartItems := make([]PromotionCartItem, len(items))
+	for i, item := range items {
+		cartItems[i] = PromotionCartItem{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+			Discount:  item.Discount,
+		}
+	}
+	return cartItems
+}
+
+func (h *PromotionHandler) validatePromotionRules(promo *models.Promotion, orderTotal, eligibleTotal float64, customer PromotionCustomer) ValidatePromotionResponse {
 	now := time.Now()
 
 	// Check if promotion is active
@@ -118,6 +331,14 @@ func (h *PromotionHandler) validatePromotionRules(promo *models.Promotion, order
 		}
 	}
 
+	// Check that something in the cart qualifies
+	if eligibleTotal <= 0 {
+		return ValidatePromotionResponse{
+			Valid:   false,
+			Message: "This promo code doesn't apply to the items in your cart",
+		}
+	}
+
 	// Check maximum uses
 	if promo.MaxUses > 0 && promo.UsedCount >= promo.MaxUses {
 		return ValidatePromotionResponse{
@@ -126,16 +347,16 @@ func (h *PromotionHandler) validatePromotionRules(promo *models.Promotion, order
 		}
 	}
 
-	// Check user-specific limits
-	if userID != "" {
-		userUsageCount := h.getUserPromotionUsageCount(promo.ID, userID)
-		if promo.MaxUsesPerUser > 0 && userUsageCount >= promo.MaxUsesPerUser {
-			return ValidatePromotionResponse{
-				Valid:   false,
-				Message: "You have already used this promo code",
-			}
+	// Check per-customer limits; guests are matched by phone and email
+	if promo.MaxUsesPerUser > 0 && h.getCustomerUsageCount(promo.ID, customer) >= promo.MaxUsesPerUser {
+		return ValidatePromotionResponse{
+			Valid:   false,
+			Message: "You have already used this promo code",
 		}
+	}
 
+	// Check account restrictions
+	if userID := customer.UserID; userID != "" && userID != "guest" {
 		// Check if it's for new customers only
 		if promo.NewCustomersOnly {
 			if h.isReturningCustomer(userID) {
@@ -167,11 +388,17 @@ func (h *PromotionHandler) validatePromotionRules(promo *models.Promotion, order
 	return ValidatePromotionResponse{Valid: true}
 }
 
-func (h *PromotionHandler) calculateDiscount(promo *models.Promotion, orderTotal float64) float64 {
+// calculateDiscount works out the discount on the value of the eligible lines. Free
+// shipping discounts the shipping charge, not the items.
+func (h *PromotionHandler) calculateDiscount(promo *models.Promotion, eligibleTotal float64) float64 {
 	var discount float64
 
+	if promo.Type == models.PromotionTypeFreeShipping {
+		return 0
+	}
+
 	if promo.Type == models.PromotionTypePercentage {
-		discount = (orderTotal * promo.Discount) / 100
+		discount = (eligibleTotal * promo.Discount) / 100
 		// Apply max discount limit if set
 		if promo.MaxDiscount > 0 && discount > promo.MaxDiscount {
 			discount = promo.MaxDiscount
@@ -180,36 +407,12 @@ func (h *PromotionHandler) calculateDiscount(promo *models.Promotion, orderTotal
 		discount = promo.Discount
 	}
 
-	// Ensure discount doesn't exceed order total
-	if discount > orderTotal {
-		discount = orderTotal
-	}
-
-	return discount
-}
-
-func (h *PromotionHandler) getUserPromotionUsageCount(promoID, userID string) int {
-	if userID == "" {
-		return 0
-	}
-
-	iter := h.db.Client.Collection("promotion_usage").
-		Where("promotion_id", "==", promoID).
-		Where("user_id", "==", userID).
-		Documents(h.db.Context)
-
-	count := 0
-	for {
-		_, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err == nil {
-			count++
-		}
+	// Ensure discount doesn't exceed the value it applies to
+	if discount > eligibleTotal {
+		discount = eligibleTotal
 	}
 
-	return count
+	return math.Round(discount*100) / 100
 }
 
 func (h *PromotionHandler) isReturningCustomer(userID string) bool {
@@ -232,10 +435,10 @@ func (h *PromotionHandler) isReturningCustomer(userID string) bool {
 func (h *PromotionHandler) GetActivePromotions(c *gin.Context) {
 	now := time.Now()
 	fmt.Printf("GetActivePromotions: Starting fetch at %v\n", now)
-	
+
 	// Query for all promotions - filter in memory to avoid index requirements
 	iter := h.db.Client.Collection("promotions").Documents(h.db.Context)
-	
+
 	docs, err := iter.GetAll()
 	if err != nil {
 		// Log error and return empty array
@@ -245,7 +448,7 @@ func (h *PromotionHandler) GetActivePromotions(c *gin.Context) {
 		})
 		return
 	}
-	
+
 	fmt.Printf("GetActivePromotions: Found %d total promotions\n", len(docs))
 
 	var activePromotions []map[string]interface{}
@@ -254,15 +457,15 @@ func (h *PromotionHandler) GetActivePromotions(c *gin.Context) {
 		if err := doc.DataTo(&promo); err != nil {
 			continue
 		}
-		
+
 		fmt.Printf("GetActivePromotions: Processing promotion %s, status: %s\n", promo.Code, promo.Status)
-		
+
 		// Check if promotion is active
 		if promo.Status != models.PromotionStatusActive {
 			fmt.Printf("GetActivePromotions: Skipping %s - not active\n", promo.Code)
 			continue
 		}
-		
+
 		// Check if promotion should show in banner
 		rawData := doc.Data()
 		showInBanner, _ := rawData["show_in_banner"].(bool)
@@ -271,12 +474,12 @@ func (h *PromotionHandler) GetActivePromotions(c *gin.Context) {
 			fmt.Printf("GetActivePromotions: Skipping %s - not marked for banner\n", promo.Code)
 			continue
 		}
-		
+
 		// Check if promotion is within date range
 		// Handle both time.Time and string date formats from Firestore
 		var startDate, endDate time.Time
 		var err error
-		
+
 		// Try to parse start date
 		if !promo.StartDate.IsZero() {
 			startDate = promo.StartDate
@@ -290,8 +493,8 @@ func (h *PromotionHandler) GetActivePromotions(c *gin.Context) {
 				}
 			}
 		}
-		
-		// Try to parse end date  
+
+		// Try to parse end date
 		if !promo.EndDate.IsZero() {
 			endDate = promo.EndDate
 		} else {
@@ -304,12 +507,12 @@ func (h *PromotionHandler) GetActivePromotions(c *gin.Context) {
 				}
 			}
 		}
-		
+
 		// Check if promotion is within date range
 		if now.Before(startDate) || now.After(endDate) {
 			continue
 		}
-		
+
 		// Return only public info
 		activePromotions = append(activePromotions, map[string]interface{}{
 			"code":        promo.Code,
@@ -318,7 +521,7 @@ func (h *PromotionHandler) GetActivePromotions(c *gin.Context) {
 			"discount":    promo.Discount,
 		})
 	}
-	
+
 	c.JSON(http.StatusOK, gin.H{
 		"promotions": activePromotions,
 	})
@@ -380,7 +583,7 @@ func (h *PromotionHandler) CreatePromotion(c *gin.Context) {
 
 func (h *PromotionHandler) UpdatePromotion(c *gin.Context) {
 	promoID := c.Param("id")
-	
+
 	var updates map[string]interface{}
 	if err := c.ShouldBindJSON(&updates); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -393,7 +596,7 @@ func (h *PromotionHandler) UpdatePromotion(c *gin.Context) {
 	_, err := h.db.Client.Collection("promotions").Doc(promoID).Update(h.db.Context, []firestore.Update{
 		{Path: "updated_at", Value: updates["updated_at"]},
 	})
-	
+
 	// Apply other updates
 	for key, value := range updates {
 		if key != "updated_at" && key != "id" && key != "created_at" && key != "created_by" {
@@ -413,7 +616,7 @@ func (h *PromotionHandler) UpdatePromotion(c *gin.Context) {
 
 func (h *PromotionHandler) DeletePromotion(c *gin.Context) {
 	promoID := c.Param("id")
-	
+
 	_, err := h.db.Client.Collection("promotions").Doc(promoID).Delete(h.db.Context)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete promotion"})
@@ -427,60 +630,60 @@ func (h *PromotionHandler) DeletePromotion(c *gin.Context) {
 func (h *PromotionHandler) InitializeDefaultPromotions(c *gin.Context) {
 	defaultPromotions := []models.Promotion{
 		{
-			Code:        "TRIPUND10",
-			Description: "10% off on all orders",
-			Type:        models.PromotionTypePercentage,
-			Discount:    10,
-			Status:      models.PromotionStatusActive,
-			MaxUses:     10000,
-			MaxUsesPerUser: 3,
-			MinOrderValue: 999,
-			MaxDiscount:   500,
+			Code:             "TRIPUND10",
+			Description:      "10% off on all orders",
+			Type:             models.PromotionTypePercentage,
+			Discount:         10,
+			Status:           models.PromotionStatusActive,
+			MaxUses:          10000,
+			MaxUsesPerUser:   3,
+			MinOrderValue:    999,
+			MaxDiscount:      500,
 			NewCustomersOnly: false,
-			ShowInBanner: true,
-			StartDate:   time.Now(),
-			EndDate:     time.Now().AddDate(1, 0, 0), // 1 year from now
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			ShowInBanner:     true,
+			StartDate:        time.Now(),
+			EndDate:          time.Now().AddDate(1, 0, 0), // 1 year from now
+			CreatedAt:        time.Now(),
+			UpdatedAt:        time.Now(),
 		},
 		{
-			Code:        "FESTIVE15",
-			Description: "15% off on festive items",
-			Type:        models.PromotionTypePercentage,
-			Discount:    15,
-			Status:      models.PromotionStatusActive,
-			MaxUses:     5000,
-			MaxUsesPerUser: 2,
-			MinOrderValue: 1500,
-			MaxDiscount:   750,
+			Code:             "FESTIVE15",
+			Description:      "15% off on festive items",
+			Type:             models.PromotionTypePercentage,
+			Discount:         15,
+			Status:           models.PromotionStatusActive,
+			MaxUses:          5000,
+			MaxUsesPerUser:   2,
+			MinOrderValue:    1500,
+			MaxDiscount:      750,
 			NewCustomersOnly: false,
-			ShowInBanner: true,
-			StartDate:   time.Now(),
-			EndDate:     time.Now().AddDate(0, 3, 0), // 3 months from now
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			ShowInBanner:     true,
+			StartDate:        time.Now(),
+			EndDate:          time.Now().AddDate(0, 3, 0), // 3 months from now
+			CreatedAt:        time.Now(),
+			UpdatedAt:        time.Now(),
 		},
 		{
-			Code:        "FIRST20",
-			Description: "20% off on first order",
-			Type:        models.PromotionTypePercentage,
-			Discount:    20,
-			Status:      models.PromotionStatusActive,
-			MaxUses:     1000,
-			MaxUsesPerUser: 1,
-			MinOrderValue: 2000,
-			MaxDiscount:   1000,
+			Code:             "FIRST20",
+			Description:      "20% off on first order",
+			Type:             models.PromotionTypePercentage,
+			Discount:         20,
+			Status:           models.PromotionStatusActive,
+			MaxUses:          1000,
+			MaxUsesPerUser:   1,
+			MinOrderValue:    2000,
+			MaxDiscount:      1000,
 			NewCustomersOnly: true,
-			ShowInBanner: true,
-			StartDate:   time.Now(),
-			EndDate:     time.Now().AddDate(0, 6, 0), // 6 months from now
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			ShowInBanner:     true,
+			StartDate:        time.Now(),
+			EndDate:          time.Now().AddDate(0, 6, 0), // 6 months from now
+			CreatedAt:        time.Now(),
+			UpdatedAt:        time.Now(),
 		},
 	}
 
 	batch := h.db.Client.Batch()
-	
+
 	for _, promo := range defaultPromotions {
 		docRef := h.db.Client.Collection("promotions").NewDoc()
 		batch.Set(docRef, promo)
@@ -496,4 +699,4 @@ func (h *PromotionHandler) InitializeDefaultPromotions(c *gin.Context) {
 		"message": "Default promotions initialized successfully",
 		"count":   len(defaultPromotions),
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"tripund-api/internal/models"
+)
+
+// PromotionAnalytics summarises how a promotion performed over a period. Net revenue is
+// what customers paid for orders using the promotion; gross revenue adds back the
+// promotion's discount.
+type PromotionAnalytics struct {
+	PromotionID         string                 `json:"promotion_id"`
+	Code                string                 `json:"code,omitempty"`
+	Description         string                 `json:"description"`
+	Type                models.PromotionType   `json:"type"`
+	Automatic           bool                   `json:"automatic"`
+	Status              models.PromotionStatus `json:"status"`
+	Redemptions         int                    `json:"redemptions"`
+	Reversals           int                    `json:"reversals"` // Cancelled or refunded
+	GrossRevenue        float64                `json:"gross_revenue"`
+	NetRevenue          float64                `json:"net_revenue"`
+	TotalDiscount       float64                `json:"total_discount"`
+	DiscountRate        float64                `json:"discount_rate"` // Discount as a percentage of gross revenue
+	AOVWithPromotion    float64                `json:"aov_with_promotion"`
+	AOVWithoutPromotion float64                `json:"aov_without_promotion"`
+	NewCustomers        int                    `json:"new_customers"`
+	ReturningCustomers  int                    `json:"returning_customers"`
+	Daily               []PromotionDailyStats  `json:"daily,omitempty"`
+}
+
+// PromotionDailyStats is one day of a promotion's trend
+type PromotionDailyStats struct {
+	Date          string  `json:"date"`
+	Redemptions   int     `json:"redemptions"`
+	NetRevenue    float64 `json:"net_revenue"`
+	TotalDiscount float64 `json:"total_discount"`
+}
+
+// promotionAnalyticsData holds the orders and usage records analytics are built from
+type promotionAnalyticsData struct {
+	start        time.Time
+	orders       []models.Order                   // Placed orders in the period
+	firstOrderAt map[string]time.Time             // Earliest placed order per customer, all time
+	usages       map[string]models.PromotionUsage // Keyed by promotion and order ID
+	reversals    map[string]int                   // Usages reversed in the period per promotion
+}
+
+// analyticsCustomerKey identifies the customer of an order; guests by email or phone
+func analyticsCustomerKey(order *models.Order) string {
+	if order.UserID != "" && order.UserID != "guest" {
+		return "user:" + order.UserID
+	}
+	if email := strings.ToLower(strings.TrimSpace(order.GuestEmail)); email != "" {
+		return "email:" + email
+	}
+	if phone := phoneKey(order.GuestPhone); phone != "" {
+		return "phone:" + phone
+	}
+	return "order:" + order.ID
+}
+
+// loadPromotionAnalyticsData reads orders and usage records. Both collections are read
+// in full and filtered in memory; customer history needs orders from before the period.
+func (h *PromotionHandler) loadPromotionAnalyticsData(start time.Time) (*promotionAnalyticsData, error) {
+	data := &promotionAnalyticsData{
+		start:        start,
+		firstOrderAt: make(map[string]time.Time),
+		usages:       make(map[string]models.PromotionUsage),
+		reversals:    make(map[string]int),
+	}
+
+	orderDocs, err := h.db.Client.Collection("orders").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range orderDocs {
+		var order models.Order
+		if err := doc.DataTo(&order); err != nil {
+			continue
+		}
+		if order.ID == "" {
+			order.ID = doc.Ref.ID
+		}
+		if !isPlacedOrder(&order) {
+			continue
+		}
+
+		key := analyticsCustomerKey(&order)
+		if first, ok := data.firstOrderAt[key]; !ok || order.CreatedAt.Before(first) {
+			data.firstOrderAt[key] = order.CreatedAt
+		}
+		if !order.CreatedAt.Before(start) {
+			data.orders = append(data.orders, order)
+		}
+	}
+
+	usageDocs, err := h.db.Client.Collection("promotion_usage").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range usageDocs {
+		var usage models.PromotionUsage
+		if err := doc.DataTo(&usage); err != nil {
+			continue
+		}
+		data.usages[usage.PromotionID+"_"+usage.OrderID] = usage
+		if !usage.IsActive() && isOrderReversal(usage.ReversalReason) && usage.ReversedAt != nil && !usage.ReversedAt.Before(start) {
+			data.reversals[usage.PromotionID]++
+		}
+	}
+
+	return data, nil
+}
+
+// isOrderReversal tells usages reversed because their order was cancelled or refunded
+// from reservations released because the order was never paid or the customer wasn't
+// entitled to them. Usages reversed before reasons were recorded were all cancellations
+// or refunds.
+func isOrderReversal(reason string) bool {
+	return reason == "" || reason == "order cancelled" || strings.HasSuffix(reason, "refunded")
+}
+
+// promotionDiscountOnOrder returns the discount a promotion gave an order. Usage records
+// are authoritative; orders without one (e.g. cash on delivery) fall back to the order's
+// applied offers and coupon code.
+func (data *promotionAnalyticsData) promotionDiscountOnOrder(promo *models.Promotion, order *models.Order) (float64, bool) {
+	if usage, ok := data.usages[promo.ID+"_"+order.ID]; ok {
+		return usage.DiscountApplied, usage.IsActive()
+	}
+
+	for _, offer := range order.AppliedOffers {
+		if offer.PromotionID == promo.ID {
+			return offer.Savings, true
+		}
+	}
+
+	if !promo.Automatic && promo.Code != "" && strings.EqualFold(order.Totals.CouponCode, promo.Code) {
+		return couponDiscountOnOrder(order), true
+	}
+
+	return 0, false
+}
+
+// analyze builds the analytics of one promotion
+func (data *promotionAnalyticsData) analyze(promo *models.Promotion, withTrend bool) PromotionAnalytics {
+	result := PromotionAnalytics{
+		PromotionID: promo.ID,
+		Code:        promo.Code,
+		Description: promo.Description,
+		Type:        promo.Type,
+		Automatic:   promo.Automatic,
+		Status:      promo.Status,
+		Reversals:   data.reversals[promo.ID],
+	}
+
+	daily := make(map[string]*PromotionDailyStats)
+	var otherRevenue float64
+	var otherOrders int
+
+	for i := range data.orders {
+		order := &data.orders[i]
+		discount, applied := data.promotionDiscountOnOrder(promo, order)
+		if !applied {
+			otherRevenue += order.Totals.Total
+			otherOrders++
+			continue
+		}
+
+		result.Redemptions++
+		result.NetRevenue += order.Totals.Total
+		result.TotalDiscount += discount
+
+		if first, ok := data.firstOrderAt[analyticsCustomerKey(order)]; ok && first.Before(order.CreatedAt) {
+			result.ReturningCustomers++
+		} else {
+			result.NewCustomers++
+		}
+
+		if withTrend {
+			date := order.CreatedAt.Format("2006-01-02")
+			day := daily[date]
+			if day == nil {
+				day = &PromotionDailyStats{Date: date}
+				daily[date] = day
+			}
+			day.Redemptions++
+			day.NetRevenue += order.Totals.Total
+			day.TotalDiscount += discount
+		}
+	}
+
+	result.GrossRevenue = roundAmount(result.NetRevenue + result.TotalDiscount)
+	result.NetRevenue = roundAmount(result.NetRevenue)
+	result.TotalDiscount = roundAmount(result.TotalDiscount)
+	if result.GrossRevenue > 0 {
+		result.DiscountRate = roundAmount(result.TotalDiscount / result.GrossRevenue * 100)
+	}
+	if result.Redemptions > 0 {
+		result.AOVWithPromotion = roundAmount(result.NetRevenue / float64(result.Redemptions))
+	}
+	if otherOrders > 0 {
+		result.AOVWithoutPromotion = roundAmount(otherRevenue / float64(otherOrders))
+	}
+
+	if withTrend {
+		// Include days without redemptions so the trend is continuous
+		result.Daily = make([]PromotionDailyStats, 0)
+		for day := data.start; !day.After(time.Now()); day = day.AddDate(0, 0, 1) {
+			date := day.Format("2006-01-02")
+			stats := PromotionDailyStats{Date: date}
+			if recorded := daily[date]; recorded != nil {
+				stats = *recorded
+				stats.NetRevenue = roundAmount(stats.NetRevenue)
+				stats.TotalDiscount = roundAmount(stats.TotalDiscount)
+			}
+			result.Daily = append(result.Daily, stats)
+		}
+	}
+
+	return result
+}
+
+func roundAmount(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// analyticsStartDate returns midnight at the start of the requested period (days query, default 30)
+func analyticsStartDate(c *gin.Context) time.Time {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -(days - 1))
+}
+
+// getPromotionForAnalytics loads a promotion by ID
+func (h *PromotionHandler) getPromotionForAnalytics(promoID string) (*models.Promotion, error) {
+	doc, err := h.db.Client.Collection("promotions").Doc(promoID).Get(h.db.Context)
+	if err != nil {
+		return nil, err
+	}
+	var promo models.Promotion
+	if err := doc.DataTo(&promo); err != nil {
+		return nil, err
+	}
+	promo.ID = doc.Ref.ID
+	return &promo, nil
+}
+
+// comparePromotions builds analytics for the promotions in the ids query (comma separated),
+// or for every promotion, ordered by net revenue
+func (h *PromotionHandler) comparePromotions(c *gin.Context) ([]PromotionAnalytics, time.Time, error) {
+	start := analyticsStartDate(c)
+
+	var promotions []models.Promotion
+	if ids := strings.TrimSpace(c.Query("ids")); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			promo, err := h.getPromotionForAnalytics(strings.TrimSpace(id))
+			if err != nil {
+				continue
+			}
+			promotions = append(promotions, *promo)
+		}
+	} else {
+		docs, err := h.db.Client.Collection("promotions").Documents(h.db.Context).GetAll()
+		if err != nil {
+			return nil, start, err
+		}
+		for _, doc := range docs {
+			var promo models.Promotion
+			if err := doc.DataTo(&promo); err != nil {
+				continue
+			}
+			promo.ID = doc.Ref.ID
+			promotions = append(promotions, promo)
+		}
+	}
+
+	data, err := h.loadPromotionAnalyticsData(start)
+	if err != nil {
+		return nil, start, err
+	}
+
+	results := make([]PromotionAnalytics, 0, len(promotions))
+	for i := range promotions {
+		results = append(results, data.analyze(&promotions[i], false))
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].NetRevenue != results[j].NetRevenue {
+			return results[i].NetRevenue > results[j].NetRevenue
+		}
+		return results[i].PromotionID < results[j].PromotionID
+	})
+
+	return results, start, nil
+}
+
+// GetPromotionAnalytics returns the performance and daily trend of one promotion (admin)
+func (h *PromotionHandler) GetPromotionAnalytics(c *gin.Context) {
+	promo, err := h.getPromotionForAnalytics(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	start := analyticsStartDate(c)
+	data, err := h.loadPromotionAnalyticsData(start)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load promotion analytics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"analytics":  data.analyze(promo, true),
+		"start_date": start,
+		"end_date":   time.Now(),
+	})
+}
+
+// ComparePromotionAnalytics returns side-by-side analytics for several promotions (admin)
+func (h *PromotionHandler) ComparePromotionAnalytics(c *gin.Context) {
+	results, start, err := h.comparePromotions(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load promotion analytics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"promotions": results,
+		"count":      len(results),
+		"start_date": start,
+		"end_date":   time.Now(),
+	})
+}
+
+var promotionAnalyticsCSVHeader = []string{
+	"promotion_id", "code", "description", "type", "automatic", "status",
+	"redemptions", "reversals", "gross_revenue", "net_revenue", "total_discount", "discount_rate",
+	"aov_with_promotion", "aov_without_promotion", "new_customers", "returning_customers",
+}
+
+func promotionAnalyticsCSVRow(result PromotionAnalytics) []string {
+	money := func(value float64) string { return strconv.FormatFloat(value, 'f', 2, 64) }
+	return []string{
+		result.PromotionID,
+		result.Code,
+		result.Description,
+		string(result.Type),
+		strconv.FormatBool(result.Automatic),
+		string(result.Status),
+		strconv.Itoa(result.Redemptions),
+		strconv.Itoa(result.Reversals),
+		money(result.GrossRevenue),
+		money(result.NetRevenue),
+		money(result.TotalDiscount),
+		money(result.DiscountRate),
+		money(result.AOVWithPromotion),
+		money(result.AOVWithoutPromotion),
+		strconv.Itoa(result.NewCustomers),
+		strconv.Itoa(result.ReturningCustomers),
+	}
+}
+
+// ExportPromotionComparison downloads the comparison view as CSV (admin)
+func (h *PromotionHandler) ExportPromotionComparison(c *gin.Context) {
+	results, _, err := h.comparePromotions(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load promotion analytics"})
+		return
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(promotionAnalyticsCSVHeader)
+	for _, result := range results {
+		writer.Write(promotionAnalyticsCSVRow(result))
+	}
+	writer.Flush()
+
+	filename := fmt.Sprintf("promotion-analytics-%s.csv", time.Now().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
+
+// ExportPromotionAnalytics downloads one promotion's summary and daily trend as CSV (admin)
+func (h *PromotionHandler) ExportPromotionAnalytics(c *gin.Context) {
+	promo, err := h.getPromotionForAnalytics(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	data, err := h.loadPromotionAnalyticsData(analyticsStartDate(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load promotion analytics"})
+		return
+	}
+	result := data.analyze(promo, true)
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(promotionAnalyticsCSVHeader)
+	writer.Write(promotionAnalyticsCSVRow(result))
+	writer.Write([]string{})
+	writer.Write([]string{"date", "redemptions", "net_revenue", "total_discount"})
+	for _, day := range result.Daily {
+		writer.Write([]string{
+			day.Date,
+			strconv.Itoa(day.Redemptions),
+			strconv.FormatFloat(day.NetRevenue, 'f', 2, 64),
+			strconv.FormatFloat(day.TotalDiscount, 'f', 2, 64),
+		})
+	}
+	writer.Flush()
+
+	filename := fmt.Sprintf("promotion-analytics-%s-%s.csv", promo.ID, time.Now().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
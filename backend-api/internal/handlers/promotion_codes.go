@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/models"
+	"tripund-api/internal/utils"
+)
+
+const (
+	defaultPromotionCodeLength = 8
+	minPromotionCodeLength     = 6
+	maxPromotionCodeLength     = 16
+	maxPromotionCodePrefix     = 12
+)
+
+// normalizePromotionCode upper-cases a code so unique codes match however they are typed
+func normalizePromotionCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// resolvePromotionCode looks up a shared promotion code first, then a unique batch code.
+// For batch codes the parent promotion is returned with Code set to the unique code.
+func (h *PromotionHandler) resolvePromotionCode(code string) (*models.Promotion, *models.PromotionCode, error) {
+	promo, err := h.findPromotionByCode(code)
+	if err != nil || promo != nil {
+		return promo, nil, err
+	}
+
+	normalized := normalizePromotionCode(code)
+	if normalized == "" || strings.Contains(normalized, "/") {
+		return nil, nil, nil
+	}
+
+	doc, err := h.db.Client.Collection("promotion_codes").Doc(normalized).Get(h.db.Context)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var uniqueCode models.PromotionCode
+	if err := doc.DataTo(&uniqueCode); err != nil {
+		return nil, nil, err
+	}
+
+	promoDoc, err := h.db.Client.Collection("promotions").Doc(uniqueCode.PromotionID).Get(h.db.Context)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var parent models.Promotion
+	if err := promoDoc.DataTo(&parent); err != nil {
+		return nil, nil, err
+	}
+	parent.ID = promoDoc.Ref.ID
+	parent.Code = uniqueCode.Code
+
+	return &parent, &uniqueCode, nil
+}
+
+// uniqueCodeUnavailableMessage explains why a batch code can't be used, or returns ""
+func uniqueCodeUnavailableMessage(uniqueCode *models.PromotionCode) string {
+	if uniqueCode == nil {
+		return ""
+	}
+	switch uniqueCode.Status {
+	case models.PromotionCodeAvailable:
+		return ""
+	case models.PromotionCodeRedeemed:
+		return "This promo code has already been used"
+	default:
+		return "This promo code is no longer valid"
+	}
+}
+
+// sanitizeCodePrefix keeps letters, digits and dashes so codes are safe as document IDs
+func sanitizeCodePrefix(prefix string) string {
+	prefix = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		default:
+			return -1
+		}
+	}, strings.ToUpper(strings.TrimSpace(prefix)))
+
+	if len(prefix) > maxPromotionCodePrefix {
+		prefix = prefix[:maxPromotionCodePrefix]
+	}
+	return prefix
+}
+
+// generateUniquePromotionCodes returns count new codes that don't exist in Firestore yet
+func (h *PromotionHandler) generateUniquePromotionCodes(prefix string, length, count int) ([]string, error) {
+	seen := make(map[string]bool, count)
+	result := make([]string, 0, count)
+
+	for attempt := 0; attempt < 5 && len(result) < count; attempt++ {
+		var candidates []string
+		for len(result)+len(candidates) < count {
+			code := prefix + utils.GenerateCode(length)
+			if seen[code] {
+				continue
+			}
+			seen[code] = true
+			candidates = append(candidates, code)
+		}
+
+		// Drop candidates that collide with existing codes
+		for start := 0; start < len(candidates); start += 300 {
+			end := start + 300
+			if end > len(candidates) {
+				end = len(candidates)
+			}
+
+			refs := make([]*firestore.DocumentRef, 0, end-start)
+			for _, code := range candidates[start:end] {
+				refs = append(refs, h.db.Client.Collection("promotion_codes").Doc(code))
+			}
+
+			snapshots, err := h.db.Client.GetAll(h.db.Context, refs)
+			if err != nil {
+				return nil, err
+			}
+			for i, snapshot := range snapshots {
+				if !snapshot.Exists() {
+					result = append(result, candidates[start+i])
+				}
+			}
+		}
+	}
+
+	if len(result) < count {
+		return nil, fmt.Errorf("could only generate %d of %d unique codes; use a longer code length", len(result), count)
+	}
+	return result, nil
+}
+
+// GeneratePromotionCodes creates a batch of unique single-use codes under a promotion
+func (h *PromotionHandler) GeneratePromotionCodes(c *gin.Context) {
+	promoID := c.Param("id")
+
+	var req models.GeneratePromotionCodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promoDoc, err := h.db.Client.Collection("promotions").Doc(promoID).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	var promo models.Promotion
+	if err := promoDoc.DataTo(&promo); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse promotion"})
+		return
+	}
+	if promo.Automatic {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Automatic promotions don't use codes"})
+		return
+	}
+
+	length := req.Length
+	if length == 0 {
+		length = defaultPromotionCodeLength
+	}
+	if length < minPromotionCodeLength || length > maxPromotionCodeLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Length must be between %d and %d", minPromotionCodeLength, maxPromotionCodeLength)})
+		return
+	}
+	prefix := sanitizeCodePrefix(req.Prefix)
+
+	generated, err := h.generateUniquePromotionCodes(prefix, length, req.Count)
+	if err != nil {
+		log.Printf("Failed to generate codes for promotion %s: %v", promoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	batchInfo := models.PromotionCodeBatch{
+		ID:          utils.GenerateIDWithPrefix("pcb"),
+		PromotionID: promoID,
+		Name:        req.Name,
+		Prefix:      prefix,
+		Length:      length,
+		Count:       len(generated),
+		CreatedAt:   now,
+		CreatedBy:   c.GetString("user_id"),
+	}
+
+	// Firestore batches are limited to 500 writes
+	for start := 0; start < len(generated); start += 500 {
+		end := start + 500
+		if end > len(generated) {
+			end = len(generated)
+		}
+
+		batch := h.db.Client.Batch()
+		for _, code := range generated[start:end] {
+			batch.Create(h.db.Client.Collection("promotion_codes").Doc(code), models.PromotionCode{
+				Code:        code,
+				PromotionID: promoID,
+				BatchID:     batchInfo.ID,
+				Status:      models.PromotionCodeAvailable,
+				CreatedAt:   now,
+			})
+		}
+		if _, err := batch.Commit(h.db.Context); err != nil {
+			log.Printf("Failed to save codes %d-%d for promotion %s: %v", start, end, promoID, err)
+			h.deletePromotionCodes(generated[:start])
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save generated codes"})
+			return
+		}
+	}
+
+	if _, err := h.db.Client.Collection("promotion_code_batches").Doc(batchInfo.ID).Set(h.db.Context, batchInfo); err != nil {
+		log.Printf("Failed to save code batch %s: %v", batchInfo.ID, err)
+	}
+
+	_, err = h.db.Client.Collection("promotions").Doc(promoID).Update(h.db.Context, []firestore.Update{
+		{Path: "unique_codes", Value: true},
+		{Path: "unique_code_count", Value: firestore.Increment(len(generated))},
+		{Path: "updated_at", Value: now},
+	})
+	if err != nil {
+		log.Printf("Failed to update code count for promotion %s: %v", promoID, err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": fmt.Sprintf("%d codes generated successfully", len(generated)),
+		"batch":   batchInfo,
+	})
+}
+
+// deletePromotionCodes removes codes saved by a generation that failed part way, so a
+// batch is saved either whole or not at all
+func (h *PromotionHandler) deletePromotionCodes(codes []string) {
+	for start := 0; start < len(codes); start += 500 {
+		end := start + 500
+		if end > len(codes) {
+			end = len(codes)
+		}
+
+		batch := h.db.Client.Batch()
+		for _, code := range codes[start:end] {
+			batch.Delete(h.db.Client.Collection("promotion_codes").Doc(code))
+		}
+		if _, err := batch.Commit(h.db.Context); err != nil {
+			log.Printf("Failed to delete codes %d-%d of a failed generation: %v", start, end, err)
+		}
+	}
+}
+
+// fetchPromotionCodes returns a promotion's codes, optionally filtered by batch and status
+func (h *PromotionHandler) fetchPromotionCodes(promoID, batchID, codeStatus string) ([]models.PromotionCode, error) {
+	query := h.db.Client.Collection("promotion_codes").Where("promotion_id", "==", promoID)
+	if batchID != "" {
+		query = query.Where("batch_id", "==", batchID)
+	}
+
+	docs, err := query.Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.PromotionCode, 0, len(docs))
+	for _, doc := range docs {
+		var code models.PromotionCode
+		if err := doc.DataTo(&code); err != nil {
+			continue
+		}
+		if codeStatus != "" && codeStatus != "all" && string(code.Status) != codeStatus {
+			continue
+		}
+		result = append(result, code)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Code < result[j].Code
+	})
+
+	return result, nil
+}
+
+// GetPromotionCodes lists unique codes with their redemption status
+func (h *PromotionHandler) GetPromotionCodes(c *gin.Context) {
+	promoID := c.Param("id")
+
+	promoCodes, err := h.fetchPromotionCodes(promoID, c.Query("batch_id"), c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promotion codes"})
+		return
+	}
+
+	summary := map[models.PromotionCodeStatus]int{
+		models.PromotionCodeAvailable: 0,
+		models.PromotionCodeRedeemed:  0,
+		models.PromotionCodeVoid:      0,
+	}
+	for _, code := range promoCodes {
+		summary[code.Status]++
+	}
+
+	batchDocs, err := h.db.Client.Collection("promotion_code_batches").Where("promotion_id", "==", promoID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		log.Printf("Failed to fetch code batches for promotion %s: %v", promoID, err)
+	}
+	batches := make([]models.PromotionCodeBatch, 0, len(batchDocs))
+	for _, doc := range batchDocs {
+		var batchInfo models.PromotionCodeBatch
+		if err := doc.DataTo(&batchInfo); err == nil {
+			batches = append(batches, batchInfo)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].CreatedAt.After(batches[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"codes":   promoCodes,
+		"total":   len(promoCodes),
+		"summary": summary,
+		"batches": batches,
+	})
+}
+
+// ExportPromotionCodes downloads unique codes as CSV for distribution
+func (h *PromotionHandler) ExportPromotionCodes(c *gin.Context) {
+	promoID := c.Param("id")
+
+	promoCodes, err := h.fetchPromotionCodes(promoID, c.Query("batch_id"), c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promotion codes"})
+		return
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"code", "status", "batch_id", "created_at", "redeemed_at", "order_number"})
+	for _, code := range promoCodes {
+		redeemedAt := ""
+		if code.RedeemedAt != nil {
+			redeemedAt = code.RedeemedAt.Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			code.Code,
+			string(code.Status),
+			code.BatchID,
+			code.CreatedAt.Format(time.RFC3339),
+			redeemedAt,
+			code.RedeemedOrderNo,
+		})
+	}
+	writer.Flush()
+
+	filename := fmt.Sprintf("promotion-codes-%s-%s.csv", promoID, time.Now().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
+
+// VoidPromotionCode disables an unused unique code
+func (h *PromotionHandler) VoidPromotionCode(c *gin.Context) {
+	promoID := c.Param("id")
+	code := normalizePromotionCode(c.Param("code"))
+
+	codeRef := h.db.Client.Collection("promotion_codes").Doc(code)
+	doc, err := codeRef.Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion code not found"})
+		return
+	}
+
+	var promoCode models.PromotionCode
+	if err := doc.DataTo(&promoCode); err != nil || promoCode.PromotionID != promoID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion code not found"})
+		return
+	}
+	if promoCode.Status != models.PromotionCodeAvailable {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only unused codes can be voided"})
+		return
+	}
+
+	// Precondition guards against a redemption landing at the same time
+	_, err = codeRef.Update(h.db.Context, []firestore.Update{
+		{Path: "status", Value: models.PromotionCodeVoid},
+	}, firestore.LastUpdateTime(doc.UpdateTime))
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Code changed while voiding, please retry"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Promotion code voided successfully"})
+}
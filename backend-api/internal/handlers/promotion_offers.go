@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"tripund-api/internal/models"
+)
+
+type EvaluateOffersRequest struct {
+	Items  []PromotionCartItem `json:"items" binding:"required,min=1,dive"`
+	UserID string              `json:"user_id"`
+	Phone  string              `json:"phone,omitempty"`
+	Email  string              `json:"email,omitempty"`
+}
+
+// OfferEvaluation is the result of running automatic promotions against a cart
+type OfferEvaluation struct {
+	Offers       []models.AppliedOffer     `json:"offers"`
+	LineSavings  []LineDiscount            `json:"line_savings"`
+	FreeGifts    []models.OrderItem        `json:"free_gifts,omitempty"`
+	TotalSavings float64                   `json:"total_savings"` // Excludes the value of free gifts
+	Decisions    []models.DiscountDecision `json:"decisions"`
+}
+
+// getActiveAutomaticPromotions returns the active automatic promotions
+func (h *PromotionHandler) getActiveAutomaticPromotions() ([]models.Promotion, error) {
+	docs, err := h.db.Client.Collection("promotions").Where("automatic", "==", true).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	promotions := make([]models.Promotion, 0)
+	for _, doc := range docs {
+		var promo models.Promotion
+		if err := doc.DataTo(&promo); err != nil {
+			continue
+		}
+		promo.ID = doc.Ref.ID
+		if promo.Status == models.PromotionStatusActive {
+			promotions = append(promotions, promo)
+		}
+	}
+
+	return promotions, nil
+}
+
+// evaluateOffers applies the qualifying automatic promotions to the cart according to
+// their priority and stacking rules
+func (h *PromotionHandler) evaluateOffers(items []PromotionCartItem, customer PromotionCustomer) (OfferEvaluation, error) {
+	quote, _, err := h.evaluateDiscounts(items, "", 0, "", false, customer, nil)
+	if err != nil {
+		return OfferEvaluation{}, err
+	}
+
+	return OfferEvaluation{
+		Offers:       quote.Offers,
+		LineSavings:  quote.LineDiscounts,
+		FreeGifts:    quote.FreeGifts,
+		TotalSavings: quote.OfferDiscount,
+		Decisions:    quote.Decisions,
+	}, nil
+}
+
+// buyXGetYSavings groups eligible units from most to least expensive; in each group of
+// BuyQuantity+GetQuantity units the cheapest GetQuantity units are discounted. Units
+// of a line are consecutive in that order, so each line's free units are counted from
+// where it starts and ends rather than unit by unit.
+func buyXGetYSavings(promo *models.Promotion, items []PromotionCartItem, eligible []bool) []float64 {
+	savings := make([]float64, len(items))
+	if promo.BuyQuantity <= 0 || promo.GetQuantity <= 0 {
+		return savings
+	}
+
+	percent := promo.GetDiscountPercent
+	if percent <= 0 || percent > 100 {
+		percent = 100
+	}
+
+	var lines []int
+	units := 0
+	for i, item := range items {
+		if !eligible[i] || item.Quantity <= 0 {
+			continue
+		}
+		lines = append(lines, i)
+		units += item.Quantity
+	}
+
+	unitPrice := func(i int) float64 {
+		return items[i].netValue() / float64(items[i].Quantity)
+	}
+	sort.SliceStable(lines, func(a, b int) bool {
+		return unitPrice(lines[a]) > unitPrice(lines[b])
+	})
+
+	// freeBefore counts the discounted units among the first n, in complete groups only
+	groupSize := promo.BuyQuantity + promo.GetQuantity
+	grouped := units / groupSize * groupSize
+	freeBefore := func(n int) int {
+		if n > grouped {
+			n = grouped
+		}
+		free := n / groupSize * promo.GetQuantity
+		if rest := n%groupSize - promo.BuyQuantity; rest > 0 {
+			free += rest
+		}
+		return free
+	}
+
+	start := 0
+	for _, i := range lines {
+		end := start + items[i].Quantity
+		if free := freeBefore(end) - freeBefore(start); free > 0 {
+			savings[i] = float64(free) * unitPrice(i) * percent / 100
+		}
+		start = end
+	}
+
+	return savings
+}
+
+// tieredDiscount returns the discount of the highest tier reached by the eligible value
+func tieredDiscount(promo *models.Promotion, eligibleTotal float64) float64 {
+	var best *models.PromotionTier
+	for i := range promo.Tiers {
+		tier := &promo.Tiers[i]
+		if eligibleTotal >= tier.MinSpend && (best == nil || tier.MinSpend > best.MinSpend) {
+			best = tier
+		}
+	}
+	if best == nil {
+		return 0
+	}
+
+	discount := best.Discount
+	if best.Type != models.PromotionTypeFixed {
+		discount = eligibleTotal * best.Discount / 100
+	}
+	if promo.MaxDiscount > 0 && discount > promo.MaxDiscount {
+		discount = promo.MaxDiscount
+	}
+	if discount > eligibleTotal {
+		discount = eligibleTotal
+	}
+
+	return math.Round(discount*100) / 100
+}
+
+// freeGiftUnavailableError is a free gift that can't be given, with the reason shown to
+// the customer
+type freeGiftUnavailableError struct {
+	reason string
+}
+
+func (e freeGiftUnavailableError) Error() string {
+	return e.reason
+}
+
+// buildFreeGift returns the gift as a fully discounted order line, valued at what the
+// gift sells for now. Gifts that aren't on sale or are out of stock can't be given.
+func (h *PromotionHandler) buildFreeGift(promo *models.Promotion) (*models.OrderItem, error) {
+	product, err := h.db.GetProductByID(promo.FreeGiftProductID)
+	if err != nil {
+		return nil, err
+	}
+	if product.Status != models.ProductStatusActive {
+		return nil, freeGiftUnavailableError{"The free gift is no longer available"}
+	}
+
+	quantity := promo.FreeGiftQuantity
+	if quantity <= 0 {
+		quantity = 1
+	}
+
+	gift := &models.OrderItem{
+		ProductID:   product.ID,
+		ProductName: product.Name,
+		SKU:         product.SKU,
+		Quantity:    quantity,
+		Price:       product.EffectivePrice(),
+		VariantID:   promo.FreeGiftVariantID,
+	}
+	if len(product.Images) > 0 {
+		gift.ProductImage = product.Images[0]
+	}
+	inStock := product.InStock() && (!product.ManageStock || product.HasVariants || product.StockQuantity >= quantity)
+	for i := range product.Variants {
+		v := &product.Variants[i]
+		if v.ID == promo.FreeGiftVariantID {
+			gift.SKU = v.SKU
+			gift.VariantColor = v.Color
+			gift.VariantSize = v.Size
+			gift.Price = product.VariantPrice(v)
+			inStock = v.Available && v.StockQuantity >= quantity
+			break
+		}
+	}
+	if !inStock {
+		return nil, freeGiftUnavailableError{"The free gift is out of stock"}
+	}
+	gift.Discount = gift.Price * float64(quantity)
+	gift.Total = 0
+	if product.IsBundle() {
+		if err := applyBundleItem(h.db, gift, product); err != nil {
+			return nil, err
+		}
+	}
+
+	return gift, nil
+}
+
+// EvaluateOffers returns the automatic promotions that apply to a cart (public)
+func (h *PromotionHandler) EvaluateOffers(c *gin.Context) {
+	var req EvaluateOffersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	evaluation, err := h.evaluateOffers(req.Items, PromotionCustomer{UserID: req.UserID, Phone: req.Phone, Email: req.Email})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate offers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, evaluation)
+}
+
+// Admin endpoints
+
+// GetAutomaticPromotions lists automatic promotions
+func (h *PromotionHandler) GetAutomaticPromotions(c *gin.Context) {
+	docs, err := h.db.Client.Collection("promotions").Where("automatic", "==", true).Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promotions"})
+		return
+	}
+
+	promotions := make([]models.Promotion, 0)
+	for _, doc := range docs {
+		var promo models.Promotion
+		if err := doc.DataTo(&promo); err != nil {
+			continue
+		}
+		promo.ID = doc.Ref.ID
+		promotions = append(promotions, promo)
+	}
+
+	sort.Slice(promotions, func(i, j int) bool {
+		return promotions[i].CreatedAt.After(promotions[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"promotions": promotions,
+		"count":      len(promotions),
+	})
+}
+
+// CreateAutomaticPromotion creates a BOGO, tiered or free gift promotion
+func (h *PromotionHandler) CreateAutomaticPromotion(c *gin.Context) {
+	var promo models.Promotion
+	if err := c.ShouldBindJSON(&promo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateAutomaticPromotion(&promo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	promo.Automatic = true
+	promo.Code = ""
+	promo.CreatedAt = now
+	promo.UpdatedAt = now
+	promo.UsedCount = 0
+	promo.CreatedBy = c.GetString("user_id")
+
+	docRef, _, err := h.db.Client.Collection("promotions").Add(h.db.Context, promo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promotion"})
+		return
+	}
+
+	promo.ID = docRef.ID
+	c.JSON(http.StatusCreated, promo)
+}
+
+// UpdateAutomaticPromotion replaces the configuration of an automatic promotion
+func (h *PromotionHandler) UpdateAutomaticPromotion(c *gin.Context) {
+	promoID := c.Param("id")
+
+	doc, err := h.db.Client.Collection("promotions").Doc(promoID).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	var existing models.Promotion
+	if err := doc.DataTo(&existing); err != nil || !existing.Automatic {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Automatic promotion not found"})
+		return
+	}
+
+	var promo models.Promotion
+	if err := c.ShouldBindJSON(&promo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateAutomaticPromotion(&promo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promo.ID = ""
+	promo.Automatic = true
+	promo.Code = ""
+	promo.UsedCount = existing.UsedCount
+	promo.CreatedAt = existing.CreatedAt
+	promo.CreatedBy = existing.CreatedBy
+	promo.UpdatedAt = time.Now()
+
+	if _, err := h.db.Client.Collection("promotions").Doc(promoID).Set(h.db.Context, promo); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update promotion"})
+		return
+	}
+
+	promo.ID = promoID
+	c.JSON(http.StatusOK, promo)
+}
+
+// SetAutomaticPromotionStatus activates or deactivates an automatic promotion
+func (h *PromotionHandler) SetAutomaticPromotionStatus(c *gin.Context) {
+	var req struct {
+		Status models.PromotionStatus `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := h.db.Client.Collection("promotions").Doc(c.Param("id")).Update(h.db.Context, []firestore.Update{
+		{Path: "status", Value: req.Status},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update promotion status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Promotion status updated successfully"})
+}
+
+func validateAutomaticPromotion(promo *models.Promotion) error {
+	if !promo.IsAutomaticType() && promo.Type != models.PromotionTypeFreeShipping {
+		return fmt.Errorf("type must be one of %s, %s, %s, %s",
+			models.PromotionTypeBuyXGetY, models.PromotionTypeTiered, models.PromotionTypeFreeGift, models.PromotionTypeFreeShipping)
+	}
+	if promo.Description == "" {
+		return fmt.Errorf("description is required")
+	}
+	if promo.EndDate.IsZero() || promo.EndDate.Before(promo.StartDate) {
+		return fmt.Errorf("end_date must be after start_date")
+	}
+
+	switch promo.Type {
+	case models.PromotionTypeBuyXGetY:
+		if promo.BuyQuantity <= 0 || promo.GetQuantity <= 0 {
+			return fmt.Errorf("buy_quantity and get_quantity must be positive")
+		}
+		if promo.GetDiscountPercent < 0 || promo.GetDiscountPercent > 100 {
+			return fmt.Errorf("get_discount_percent must be between 0 and 100")
+		}
+	case models.PromotionTypeTiered:
+		if len(promo.Tiers) == 0 {
+			return fmt.Errorf("at least one tier is required")
+		}
+		for _, tier := range promo.Tiers {
+			if tier.Discount <= 0 || tier.MinSpend < 0 {
+				return fmt.Errorf("each tier needs a positive discount and a non-negative min_spend")
+			}
+			if tier.Type != models.PromotionTypeFixed && tier.Discount > 100 {
+				return fmt.Errorf("percentage tiers cannot exceed 100%%")
+			}
+		}
+	case models.PromotionTypeFreeGift:
+		if promo.FreeGiftProductID == "" {
+			return fmt.Errorf("free_gift_product_id is required")
+		}
+		if promo.MinOrderValue <= 0 {
+			return fmt.Errorf("min_order_value is required for free gift promotions")
+		}
+	case models.PromotionTypeFreeShipping:
+		if err := validateShippingMethods(promo.ShippingMethods); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateShippingMethods checks the shipping methods a free shipping promotion is limited to
+func validateShippingMethods(methods []string) error {
+	for _, method := range methods {
+		if method != models.ShippingMethodStandard && method != models.ShippingMethodExpress {
+			return fmt.Errorf("shipping_methods may only contain %s and %s", models.ShippingMethodStandard, models.ShippingMethodExpress)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"tripund-api/internal/models"
+)
+
+// PromotionShipping is the delivery a cart is being priced for. Address may be nil
+// before the customer has entered one.
+type PromotionShipping struct {
+	Method  string
+	Address *models.UserAddress
+}
+
+// DiscountQuote is the result of evaluating every discount that could apply to a cart.
+// Promotions are evaluated in a fixed order (see sortDiscountCandidates), followed by the
+// prepaid discount and finally store credit. Each step works on the value left by the
+// previous ones and Decisions records what was applied and why anything was rejected.
+// TotalDiscount covers the items only; a free shipping promotion reduces Shipping instead.
+type DiscountQuote struct {
+	Subtotal         float64                   `json:"subtotal"`
+	Offers           []models.AppliedOffer     `json:"offers"`
+	FreeGifts        []models.OrderItem        `json:"free_gifts,omitempty"`
+	OfferDiscount    float64                   `json:"offer_discount"` // Excludes the value of free gifts
+	CouponCode       string                    `json:"coupon_code,omitempty"`
+	CouponDiscount   float64                   `json:"coupon_discount"`
+	ReferralDiscount float64                   `json:"referral_discount"`
+	PrepaidDiscount  float64                   `json:"prepaid_discount"`
+	ShippingMethod   string                    `json:"shipping_method,omitempty"`
+	ShippingFee      float64                   `json:"shipping_fee"`      // Before free shipping promotions
+	ShippingDiscount float64                   `json:"shipping_discount"` // Waived by free shipping promotions
+	Shipping         float64                   `json:"shipping"`          // Charged
+	TotalDiscount    float64                   `json:"total_discount"`
+	LineDiscounts    []LineDiscount            `json:"line_discounts"`
+	Decisions        []models.DiscountDecision `json:"decisions"`
+
+	// Promo code detail used by ValidatePromotion
+	couponEligibleTotal float64
+	couponLines         []float64
+}
+
+// decision returns the first decision of the given kind, or nil
+func (q *DiscountQuote) decision(kind string) *models.DiscountDecision {
+	for i := range q.Decisions {
+		if q.Decisions[i].Kind == kind {
+			return &q.Decisions[i]
+		}
+	}
+	return nil
+}
+
+// discountCandidate is a promotion, the prepaid discount or store credit being considered
+type discountCandidate struct {
+	promo    *models.Promotion // nil for prepaid and store credit
+	decision models.DiscountDecision
+}
+
+func newPromotionCandidate(promo *models.Promotion) *discountCandidate {
+	candidate := &discountCandidate{
+		promo: promo,
+		decision: models.DiscountDecision{
+			Kind:        promo.DiscountKind(),
+			PromotionID: promo.ID,
+			Description: promo.Description,
+			Priority:    promo.Priority,
+		},
+	}
+	if !promo.Automatic {
+		candidate.decision.Code = promo.Code
+	}
+	if candidate.decision.Description == "" {
+		candidate.decision.Description = promo.Code
+	}
+	return candidate
+}
+
+// combinesWith checks the stacking rules of both discounts
+func (d *discountCandidate) combinesWith(other *discountCandidate) bool {
+	if d.promo != nil && !d.promo.CombinesWith(other.decision.PromotionID, other.decision.Kind) {
+		return false
+	}
+	if other.promo != nil && !other.promo.CombinesWith(d.decision.PromotionID, d.decision.Kind) {
+		return false
+	}
+	return true
+}
+
+// conflictWith returns the first applied discount that can't be combined with the candidate
+func (d *discountCandidate) conflictWith(applied []*discountCandidate) *discountCandidate {
+	for _, other := range applied {
+		if !d.combinesWith(other) {
+			return other
+		}
+	}
+	return nil
+}
+
+// sortDiscountCandidates orders promotions for evaluation: highest priority first, then
+// automatic offers before promo codes, then oldest first, then by ID
+func sortDiscountCandidates(candidates []*discountCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i].promo, candidates[j].promo
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if a.Automatic != b.Automatic {
+			return a.Automatic
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// isPrepaidMethod reports whether a payment method qualifies for the prepaid discount
+func isPrepaidMethod(paymentMethod string) bool {
+	method := strings.ToLower(strings.TrimSpace(paymentMethod))
+	return method != "" && method != "cod"
+}
+
+// getPrepaidDiscountPercent reads PaymentSettings.PrepaidDiscount. Without saved settings
+// no prepaid discount is given.
+func (h *PromotionHandler) getPrepaidDiscountPercent() float64 {
+	doc, err := h.db.Client.Collection("settings").Doc("main").Get(h.db.Context)
+	if err != nil {
+		return 0
+	}
+
+	var settings Settings
+	if err := doc.DataTo(&settings); err != nil {
+		log.Printf("Failed to parse settings for prepaid discount: %v", err)
+		return 0
+	}
+
+	return settings.Payment.PrepaidDiscount
+}
+
+// freeShippingRejection returns why a free shipping promotion can't waive the shipping
+// charge, or an empty string if it can. Without shipping details only the promotion's
+// other rules are checked.
+func freeShippingRejection(promo *models.Promotion, shipping *PromotionShipping, remaining float64) string {
+	if shipping == nil {
+		return ""
+	}
+	if !promo.AllowsShippingMethod(shipping.Method) {
+		return fmt.Sprintf("Free shipping is not available for %s delivery", shipping.Method)
+	}
+	if len(promo.ShippingZones) > 0 {
+		if shipping.Address == nil {
+			return "Enter a delivery address to check free shipping"
+		}
+		if !promo.CoversShippingZone(*shipping.Address) {
+			return "Free shipping is not available for your delivery address"
+		}
+	}
+	if remaining <= 0 {
+		return "Shipping is already free on this order"
+	}
+	return ""
+}
+
+// promotionSavings works out a promotion's per-line savings on the current cart.
+// Free gift promotions return the gift line instead, or the reason it can't be given.
+func (h *PromotionHandler) promotionSavings(promo *models.Promotion, cart []PromotionCartItem, eligible []bool, eligibleTotal float64) ([]float64, *models.OrderItem, string) {
+	switch promo.Type {
+	case models.PromotionTypeBuyXGetY:
+		return buyXGetYSavings(promo, cart, eligible), nil, ""
+	case models.PromotionTypeTiered:
+		return allocateDiscount(cart, eligible, tieredDiscount(promo, eligibleTotal)), nil, ""
+	case models.PromotionTypeFreeGift:
+		if eligibleTotal < promo.MinOrderValue {
+			return nil, nil, ""
+		}
+		gift, err := h.buildFreeGift(promo)
+		var unavailable freeGiftUnavailableError
+		if errors.As(err, &unavailable) {
+			return nil, nil, unavailable.reason
+		} else if err != nil {
+			log.Printf("Free gift %s for promotion %s unavailable: %v", promo.FreeGiftProductID, promo.ID, err)
+			return nil, nil, ""
+		}
+		return nil, gift, ""
+	case models.PromotionTypePercentage, models.PromotionTypeFixed:
+		return allocateDiscount(cart, eligible, h.calculateDiscount(promo, eligibleTotal)), nil, ""
+	}
+	return nil, nil, ""
+}
+
+// shippingWaiver is the part of the remaining shipping charge a free shipping promotion
+// waives, capped by MaxDiscount when set
+func shippingWaiver(promo *models.Promotion, remaining float64) float64 {
+	if promo.MaxDiscount > 0 && remaining > promo.MaxDiscount {
+		return promo.MaxDiscount
+	}
+	return remaining
+}
+
+// evaluateDiscounts runs automatic promotions, the promo code, the referral discount, the
+// prepaid discount and store credit against a cart according to their stacking rules. orderTotal is the value
+// the promo code's minimum order is checked against. Store credit is only checked for
+// compatibility here; its amount depends on the card balance and is filled in by the
+// caller. With shipping details the shipping charge is quoted as well; without them free
+// shipping promotions are validated but waive nothing. The returned slice holds the total
+// discount for each input line.
+func (h *PromotionHandler) evaluateDiscounts(items []PromotionCartItem, code string, orderTotal float64, paymentMethod string, storeCredit bool, customer PromotionCustomer, shipping *PromotionShipping) (DiscountQuote, []float64, error) {
+	quote := DiscountQuote{
+		Offers:        make([]models.AppliedOffer, 0),
+		LineDiscounts: make([]LineDiscount, 0),
+		Decisions:     make([]models.DiscountDecision, 0),
+		CouponCode:    code,
+	}
+	lineDiscounts := make([]float64, len(items))
+
+	// Work on a copy so discounts accumulate without touching the caller's items
+	cart := make([]PromotionCartItem, len(items))
+	copy(cart, items)
+	products := h.loadCartProducts(cart)
+	for _, item := range cart {
+		quote.Subtotal += item.netValue()
+	}
+	if shipping != nil {
+		shipping.Method = normalizeShippingMethod(shipping.Method)
+		quote.ShippingMethod = shipping.Method
+		quote.ShippingFee = getShippingSettings(h.db).Fee(shipping.Method, quote.Subtotal)
+		quote.Shipping = quote.ShippingFee
+	}
+
+	candidates := make([]*discountCandidate, 0)
+	promotions, err := h.getActiveAutomaticPromotions()
+	if err != nil {
+		log.Printf("Failed to fetch automatic promotions: %v", err)
+	}
+	for i := range promotions {
+		candidates = append(candidates, newPromotionCandidate(&promotions[i]))
+	}
+
+	if code != "" {
+		promo, uniqueCode, err := h.resolvePromotionCode(code)
+		if err != nil {
+			return quote, lineDiscounts, err
+		}
+		rejected := models.DiscountDecision{Kind: models.DiscountKindPromoCode, Code: code, Description: code}
+		if promo == nil {
+			rejected.Reason = "Invalid promo code"
+			quote.Decisions = append(quote.Decisions, rejected)
+		} else if message := uniqueCodeUnavailableMessage(uniqueCode); message != "" {
+			rejected.PromotionID = promo.ID
+			rejected.Reason = message
+			quote.Decisions = append(quote.Decisions, rejected)
+		} else {
+			candidates = append(candidates, newPromotionCandidate(promo))
+		}
+	}
+
+	if promo := h.referralPromotion(customer); promo != nil {
+		candidate := newPromotionCandidate(promo)
+		candidate.decision.Kind = models.DiscountKindReferral
+		candidates = append(candidates, candidate)
+	}
+	sortDiscountCandidates(candidates)
+
+	var applied []*discountCandidate
+	apply := func(savings []float64) float64 {
+		var amount float64
+		for j, saving := range savings {
+			if saving <= 0 {
+				continue
+			}
+			cart[j].Discount += saving
+			lineDiscounts[j] += saving
+			amount += saving
+		}
+		return math.Round(amount*100) / 100
+	}
+	reject := func(candidate *discountCandidate, reason string) {
+		candidate.decision.Reason = reason
+		quote.Decisions = append(quote.Decisions, candidate.decision)
+	}
+
+	for _, candidate := range candidates {
+		promo := candidate.promo
+
+		cartTotal := orderTotal
+		if candidate.decision.Kind != models.DiscountKindPromoCode || orderTotal <= 0 {
+			cartTotal = 0
+			for _, item := range cart {
+				cartTotal += item.netValue()
+			}
+		}
+
+		eligible, eligibleTotal := eligibleLinesFor(promo, cart, products)
+		if result := h.validatePromotionRules(promo, cartTotal, eligibleTotal, customer); !result.Valid {
+			reject(candidate, result.Message)
+			continue
+		}
+
+		if blocker := candidate.conflictWith(applied); blocker != nil {
+			reject(candidate, fmt.Sprintf("Cannot be combined with %s", blocker.decision.Description))
+			continue
+		}
+
+		if promo.Type == models.PromotionTypeFreeShipping {
+			if reason := freeShippingRejection(promo, shipping, quote.Shipping); reason != "" {
+				reject(candidate, reason)
+				continue
+			}
+
+			var amount float64
+			if shipping != nil {
+				amount = math.Round(shippingWaiver(promo, quote.Shipping)*100) / 100
+				quote.ShippingDiscount += amount
+				quote.Shipping = math.Round((quote.Shipping-amount)*100) / 100
+			}
+
+			candidate.decision.Applied = true
+			candidate.decision.Amount = amount
+			quote.Decisions = append(quote.Decisions, candidate.decision)
+			applied = append(applied, candidate)
+
+			if candidate.decision.Kind == models.DiscountKindPromoCode {
+				quote.couponEligibleTotal = eligibleTotal
+			} else {
+				quote.Offers = append(quote.Offers, models.AppliedOffer{
+					PromotionID: promo.ID,
+					Description: promo.Description,
+					Type:        promo.Type,
+					Savings:     amount,
+				})
+			}
+			continue
+		}
+
+		savings, gift, rejection := h.promotionSavings(promo, cart, eligible, eligibleTotal)
+		if rejection != "" {
+			reject(candidate, rejection)
+			continue
+		}
+		amount := apply(savings)
+		if gift != nil {
+			amount = math.Round(gift.Discount*100) / 100
+		}
+		if amount <= 0 {
+			reject(candidate, "No discount applies to the items in your cart")
+			continue
+		}
+
+		candidate.decision.Applied = true
+		candidate.decision.Amount = amount
+		quote.Decisions = append(quote.Decisions, candidate.decision)
+		applied = append(applied, candidate)
+
+		switch candidate.decision.Kind {
+		case models.DiscountKindPromoCode:
+			quote.CouponDiscount = amount
+			quote.couponEligibleTotal = eligibleTotal
+			quote.couponLines = savings
+			continue
+		case models.DiscountKindReferral:
+			quote.ReferralDiscount = amount
+			continue
+		}
+
+		offer := models.AppliedOffer{
+			PromotionID: promo.ID,
+			Description: promo.Description,
+			Type:        promo.Type,
+			Savings:     amount,
+			FreeGift:    gift,
+		}
+		quote.Offers = append(quote.Offers, offer)
+		if gift != nil {
+			quote.FreeGifts = append(quote.FreeGifts, *gift)
+		} else {
+			quote.OfferDiscount += amount
+		}
+	}
+
+	// Prepaid discount on what is left of every line except gift cards
+	if isPrepaidMethod(paymentMethod) {
+		if percent := h.getPrepaidDiscountPercent(); percent > 0 {
+			candidate := &discountCandidate{decision: models.DiscountDecision{
+				Kind:        models.DiscountKindPrepaid,
+				Description: fmt.Sprintf("%g%% prepaid discount", percent),
+			}}
+
+			eligible := make([]bool, len(cart))
+			var eligibleTotal float64
+			for i, item := range cart {
+				if product := products[item.ProductID]; product != nil && !product.IsGiftCard() {
+					eligible[i] = true
+					eligibleTotal += item.netValue()
+				}
+			}
+
+			if blocker := candidate.conflictWith(applied); blocker != nil {
+				reject(candidate, fmt.Sprintf("Cannot be combined with %s", blocker.decision.Description))
+			} else if eligibleTotal <= 0 {
+				reject(candidate, "No items qualify for the prepaid discount")
+			} else {
+				discount := math.Round(eligibleTotal*percent) / 100
+				candidate.decision.Applied = true
+				candidate.decision.Amount = apply(allocateDiscount(cart, eligible, discount))
+				quote.PrepaidDiscount = candidate.decision.Amount
+				quote.Decisions = append(quote.Decisions, candidate.decision)
+				applied = append(applied, candidate)
+			}
+		}
+	}
+
+	if storeCredit {
+		candidate := &discountCandidate{decision: models.DiscountDecision{
+			Kind:        models.DiscountKindStoreCredit,
+			Description: "Store credit",
+		}}
+		if blocker := candidate.conflictWith(applied); blocker != nil {
+			reject(candidate, fmt.Sprintf("Cannot be combined with %s", blocker.decision.Description))
+		} else {
+			candidate.decision.Applied = true
+			quote.Decisions = append(quote.Decisions, candidate.decision)
+		}
+	}
+
+	for i, discount := range lineDiscounts {
+		if discount <= 0 {
+			continue
+		}
+		lineDiscounts[i] = math.Round(discount*100) / 100
+		quote.LineDiscounts = append(quote.LineDiscounts, LineDiscount{
+			ProductID: items[i].ProductID,
+			VariantID: items[i].VariantID,
+			Discount:  lineDiscounts[i],
+		})
+	}
+	quote.OfferDiscount = math.Round(quote.OfferDiscount*100) / 100
+	quote.ShippingDiscount = math.Round(quote.ShippingDiscount*100) / 100
+	quote.TotalDiscount = math.Round((quote.OfferDiscount+quote.CouponDiscount+quote.ReferralDiscount+quote.PrepaidDiscount)*100) / 100
+
+	return quote, lineDiscounts, nil
+}
+
+// ApplyDiscountsToOrderItems evaluates every discount for an order and adds each line's
+// share to OrderItem.Discount, reducing the line total accordingly. A promo code that
+// can't be applied is returned as an error so the customer isn't charged unexpectedly.
+func (h *PromotionHandler) ApplyDiscountsToOrderItems(items []models.OrderItem, code string, orderTotal float64, paymentMethod string, storeCredit bool, customer PromotionCustomer, shipping *PromotionShipping) (DiscountQuote, error) {
+	quote, lineDiscounts, err := h.evaluateDiscounts(cartItemsFromOrder(items), code, orderTotal, paymentMethod, storeCredit, customer, shipping)
+	if err != nil {
+		return quote, err
+	}
+
+	if code != "" {
+		if decision := quote.decision(models.DiscountKindPromoCode); decision == nil || !decision.Applied {
+			reason := "invalid promo code"
+			if decision != nil {
+				reason = decision.Reason
+			}
+			return quote, fmt.Errorf("promo code %s cannot be applied: %s", code, reason)
+		}
+	}
+
+	for i := range items {
+		items[i].Discount += lineDiscounts[i]
+		items[i].Total = items[i].Price*float64(items[i].Quantity) - items[i].Discount
+	}
+
+	return quote, nil
+}
@@ -0,0 +1,587 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/models"
+)
+
+// PromotionCustomer identifies who is using a promotion. Guests are identified by
+// their phone and email so per-customer limits can't be bypassed by checking out
+// without an account.
+type PromotionCustomer struct {
+	UserID string
+	Phone  string
+	Email  string
+}
+
+// Keys returns the normalised identities used to count a customer's redemptions
+func (c PromotionCustomer) Keys() []string {
+	var keys []string
+	if c.UserID != "" && c.UserID != "guest" {
+		keys = append(keys, "user:"+c.UserID)
+	}
+
+	if phone := phoneKey(c.Phone); phone != "" {
+		keys = append(keys, "phone:"+phone)
+	}
+
+	if email := strings.ToLower(strings.TrimSpace(c.Email)); email != "" {
+		keys = append(keys, "email:"+email)
+	}
+
+	return keys
+}
+
+// phoneKey returns the last ten digits of a phone number so numbers match with or
+// without a country code, or "" if the number is too short
+func phoneKey(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(digits) < 10 {
+		return ""
+	}
+	return digits[len(digits)-10:]
+}
+
+// matchesUsage reports whether a usage record belongs to this customer
+func (c PromotionCustomer) matchesUsage(usage *models.PromotionUsage) bool {
+	if c.UserID != "" && c.UserID != "guest" && usage.UserID == c.UserID {
+		return true
+	}
+	for _, key := range c.Keys() {
+		for _, usageKey := range usage.CustomerKeys {
+			if key == usageKey {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// customerUsageQueries find a customer's usages of every promotion, by the identities
+// recorded with them and, for usages recorded before those, by user ID. Usages are
+// filtered by promotion in memory to avoid composite index requirements.
+func (h *PromotionHandler) customerUsageQueries(customer PromotionCustomer) []firestore.Query {
+	usage := h.db.Client.Collection("promotion_usage")
+	var queries []firestore.Query
+	if keys := customer.Keys(); len(keys) > 0 {
+		queries = append(queries, usage.Where("customer_keys", "array-contains-any", keys))
+	}
+	if customer.UserID != "" && customer.UserID != "guest" {
+		queries = append(queries, usage.Where("user_id", "==", customer.UserID))
+	}
+	return queries
+}
+
+// countCustomerUsage counts the customer's active usages of a promotion among the docs
+// the customerUsageQueries found, leaving out the usage exceptID
+func countCustomerUsage(docs []*firestore.DocumentSnapshot, promoID string, customer PromotionCustomer, exceptID string) int {
+	seen := make(map[string]bool)
+	count := 0
+	for _, doc := range docs {
+		if seen[doc.Ref.ID] || doc.Ref.ID == exceptID {
+			continue
+		}
+		seen[doc.Ref.ID] = true
+		var usage models.PromotionUsage
+		if err := doc.DataTo(&usage); err != nil {
+			continue
+		}
+		if usage.PromotionID == promoID && usage.IsActive() && customer.matchesUsage(&usage) {
+			count++
+		}
+	}
+	return count
+}
+
+// getCustomerUsageCount counts a customer's active redemptions of a promotion
+func (h *PromotionHandler) getCustomerUsageCount(promoID string, customer PromotionCustomer) int {
+	var docs []*firestore.DocumentSnapshot
+	for _, query := range h.customerUsageQueries(customer) {
+		found, err := query.Documents(h.db.Context).GetAll()
+		if err != nil {
+			log.Printf("Failed to fetch usage for promotion %s: %v", promoID, err)
+			return 0
+		}
+		docs = append(docs, found...)
+	}
+	return countCustomerUsage(docs, promoID, customer, "")
+}
+
+// promotionRedemption is one promotion an order uses
+type promotionRedemption struct {
+	promoID    string
+	code       string
+	uniqueCode bool
+	discount   float64
+}
+
+// promotionUnavailableError is a promotion an order can no longer use, because its
+// limits were reached or its code was redeemed since the order was priced
+type promotionUnavailableError struct {
+	promoID string
+	reason  string
+}
+
+func (e promotionUnavailableError) Error() string {
+	return e.reason
+}
+
+// orderRedemptions lists the order's promo code, automatic offers and referral discount
+func (h *PromotionHandler) orderRedemptions(order models.Order) ([]promotionRedemption, error) {
+	var redemptions []promotionRedemption
+	if order.Totals.CouponCode != "" {
+		promo, uniqueCode, err := h.resolvePromotionCode(order.Totals.CouponCode)
+		if err != nil {
+			return nil, err
+		}
+		if promo != nil {
+			redemptions = append(redemptions, promotionRedemption{promo.ID, promo.Code, uniqueCode != nil, couponDiscountOnOrder(&order)})
+		}
+	}
+	for _, offer := range order.AppliedOffers {
+		redemptions = append(redemptions, promotionRedemption{offer.PromotionID, "", false, offer.Savings})
+	}
+	if order.Totals.ReferralDiscount > 0 {
+		redemptions = append(redemptions, promotionRedemption{referralPromotionID, "", false, order.Totals.ReferralDiscount})
+	}
+	return redemptions, nil
+}
+
+// CreateOrderWithRedemptions saves a new order in the transaction that reserves the
+// promotions it uses, re-checking MaxUses and MaxUsesPerUser, so concurrent checkouts
+// cannot exceed the limits. An order whose promotions are no longer available is not
+// saved and a promotionUnavailableError is returned.
+func (h *PromotionHandler) CreateOrderWithRedemptions(orderRef *firestore.DocumentRef, order models.Order, customer PromotionCustomer) error {
+	redemptions, err := h.orderRedemptions(order)
+	if err != nil {
+		return err
+	}
+	return h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		// Every read comes before the first write
+		var writes []redemptionWrite
+		for _, r := range redemptions {
+			write, err := h.prepareRedemption(tx, r, order, customer, models.PromotionUsageReserved)
+			if err != nil {
+				return err
+			}
+			if write != nil {
+				writes = append(writes, write)
+			}
+		}
+		for _, write := range writes {
+			if err := write(tx); err != nil {
+				return err
+			}
+		}
+		return tx.Create(orderRef, order)
+	})
+}
+
+// RecordRedemptions marks the promotions a paid order reserved as redeemed, with the
+// customer identities known from the payment. Reservations are checked again against
+// the per-customer limit with those identities and released when it is reached.
+// Promotions whose reservation was released, and orders placed before reservations,
+// are recorded afresh within the limits. Recording is idempotent per order.
+func (h *PromotionHandler) RecordRedemptions(order models.Order, customer PromotionCustomer) error {
+	redemptions, err := h.orderRedemptions(order)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, r := range redemptions {
+		err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+			write, err := h.prepareRedemption(tx, r, order, customer, models.PromotionUsageRedeemed)
+			if err != nil || write == nil {
+				return err
+			}
+			return write(tx)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", r.promoID, err))
+		}
+
+		// A reservation the payment's customer is not entitled to is given back
+		var unavailable promotionUnavailableError
+		if errors.As(err, &unavailable) {
+			usageRef := h.db.Client.Collection("promotion_usage").Doc(fmt.Sprintf("%s_%s", r.promoID, order.ID))
+			if err := h.reverseUsage(usageRef, unavailable.reason); err != nil {
+				log.Printf("Failed to release promotion %s reserved by order %s: %v", r.promoID, order.ID, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// couponDiscountOnOrder returns what the order's promo code saved, including shipping
+// waived by a free shipping code
+func couponDiscountOnOrder(order *models.Order) float64 {
+	for _, decision := range order.DiscountDecisions {
+		if decision.Kind == models.DiscountKindPromoCode && decision.Applied {
+			return decision.Amount
+		}
+	}
+	return order.Totals.CouponAmount
+}
+
+// redemptionWrite records a redemption in the transaction that checked it
+type redemptionWrite func(tx *firestore.Transaction) error
+
+// prepareRedemption reads what a redemption depends on in a transaction and checks it
+// against the promotion's limits, returning the writes that record it with the status.
+// It returns no writes if the order already holds the redemption with that status.
+func (h *PromotionHandler) prepareRedemption(tx *firestore.Transaction, r promotionRedemption, order models.Order, customer PromotionCustomer, usageStatus models.PromotionUsageStatus) (redemptionWrite, error) {
+	promoRef := h.db.Client.Collection("promotions").Doc(r.promoID)
+	usageRef := h.db.Client.Collection("promotion_usage").Doc(fmt.Sprintf("%s_%s", r.promoID, order.ID))
+
+	// A reservation held by the order is confirmed rather than made again
+	var reserved *models.PromotionUsage
+	usageDoc, err := tx.Get(usageRef)
+	if err == nil {
+		var usage models.PromotionUsage
+		if err := usageDoc.DataTo(&usage); err != nil {
+			return nil, err
+		}
+		if usage.IsActive() {
+			if usage.Status != models.PromotionUsageReserved || usageStatus == models.PromotionUsageReserved {
+				return nil, nil
+			}
+			reserved = &usage
+		}
+	} else if status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+
+	// The referral discount has no promotion document and is used once per customer
+	referral := r.promoID == referralPromotionID
+	promo := models.Promotion{MaxUsesPerUser: 1}
+	if !referral {
+		promoDoc, err := tx.Get(promoRef)
+		if err != nil {
+			return nil, err
+		}
+		promo = models.Promotion{}
+		if err := promoDoc.DataTo(&promo); err != nil {
+			return nil, err
+		}
+	}
+
+	// Customers are counted by the identities known now, which at payment include the
+	// contact details the payment was made with
+	customerUsage := func() (int, error) {
+		var docs []*firestore.DocumentSnapshot
+		for _, query := range h.customerUsageQueries(customer) {
+			found, err := tx.Documents(query).GetAll()
+			if err != nil {
+				return 0, err
+			}
+			docs = append(docs, found...)
+		}
+		return countCustomerUsage(docs, r.promoID, customer, usageRef.ID), nil
+	}
+
+	if reserved != nil {
+		if promo.MaxUsesPerUser > 0 {
+			count, err := customerUsage()
+			if err != nil {
+				return nil, err
+			}
+			if count >= promo.MaxUsesPerUser {
+				return nil, promotionUnavailableError{r.promoID, fmt.Sprintf("per-customer limit of %d reached", promo.MaxUsesPerUser)}
+			}
+		}
+		return func(tx *firestore.Transaction) error {
+			return tx.Update(usageRef, []firestore.Update{
+				{Path: "status", Value: usageStatus},
+				{Path: "customer_keys", Value: mergeCustomerKeys(reserved.CustomerKeys, customer.Keys())},
+			})
+		}, nil
+	}
+
+	if promo.MaxUses > 0 && promo.UsedCount >= promo.MaxUses {
+		return nil, promotionUnavailableError{r.promoID, fmt.Sprintf("usage limit of %d reached", promo.MaxUses)}
+	}
+
+	// Single-use batch codes must still be available
+	var codeRef *firestore.DocumentRef
+	if r.uniqueCode {
+		codeRef = h.db.Client.Collection("promotion_codes").Doc(r.code)
+		codeDoc, err := tx.Get(codeRef)
+		if err != nil {
+			return nil, err
+		}
+		var promoCode models.PromotionCode
+		if err := codeDoc.DataTo(&promoCode); err != nil {
+			return nil, err
+		}
+		if promoCode.Status != models.PromotionCodeAvailable {
+			return nil, promotionUnavailableError{r.promoID, fmt.Sprintf("code %s is %s", r.code, promoCode.Status)}
+		}
+	}
+
+	if promo.MaxUsesPerUser > 0 {
+		count, err := customerUsage()
+		if err != nil {
+			return nil, err
+		}
+		if count >= promo.MaxUsesPerUser {
+			return nil, promotionUnavailableError{r.promoID, fmt.Sprintf("per-customer limit of %d reached", promo.MaxUsesPerUser)}
+		}
+	}
+
+	return func(tx *firestore.Transaction) error {
+		now := time.Now()
+		if codeRef != nil {
+			if err := tx.Update(codeRef, []firestore.Update{
+				{Path: "status", Value: models.PromotionCodeRedeemed},
+				{Path: "redeemed_at", Value: now},
+				{Path: "redeemed_order_id", Value: order.ID},
+				{Path: "redeemed_order_number", Value: order.OrderNumber},
+				{Path: "redeemed_by", Value: strings.Join(customer.Keys(), ",")},
+			}); err != nil {
+				return err
+			}
+		}
+
+		if !referral {
+			if err := tx.Update(promoRef, []firestore.Update{
+				{Path: "used_count", Value: firestore.Increment(1)},
+				{Path: "updated_at", Value: now},
+			}); err != nil {
+				return err
+			}
+		}
+
+		return tx.Set(usageRef, models.PromotionUsage{
+			ID:              usageRef.ID,
+			PromotionID:     r.promoID,
+			UserID:          order.UserID,
+			OrderID:         order.ID,
+			OrderNumber:     order.OrderNumber,
+			Code:            r.code,
+			DiscountApplied: r.discount,
+			CustomerKeys:    customer.Keys(),
+			Status:          usageStatus,
+			UsedAt:          now,
+		})
+	}, nil
+}
+
+// mergeCustomerKeys adds the keys a usage doesn't have yet
+func mergeCustomerKeys(keys, more []string) []string {
+	merged := append([]string{}, keys...)
+	for _, key := range more {
+		found := false
+		for _, existing := range merged {
+			if existing == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, key)
+		}
+	}
+	return merged
+}
+
+// ReverseRedemptions releases the promotion usage of a cancelled, unpaid or fully
+// refunded order so it no longer counts towards MaxUses or MaxUsesPerUser
+func (h *PromotionHandler) ReverseRedemptions(orderID, reason string) error {
+	docs, err := h.db.Client.Collection("promotion_usage").Where("order_id", "==", orderID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		if err := h.reverseUsage(doc.Ref, reason); err != nil {
+			return fmt.Errorf("failed to reverse usage %s: %v", doc.Ref.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// reverseUsage releases one promotion usage, giving back its batch code and its place
+// in the promotion's used count. Usages that were never recorded are left alone.
+func (h *PromotionHandler) reverseUsage(usageRef *firestore.DocumentRef, reason string) error {
+	return h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		usageDoc, err := tx.Get(usageRef)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var usage models.PromotionUsage
+		if err := usageDoc.DataTo(&usage); err != nil {
+			return err
+		}
+		if !usage.IsActive() {
+			return nil // Already reversed
+		}
+
+		promoRef := h.db.Client.Collection("promotions").Doc(usage.PromotionID)
+		promoDoc, err := tx.Get(promoRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		// Batch codes become usable again
+		var codeRef *firestore.DocumentRef
+		if usage.Code != "" && !strings.Contains(usage.Code, "/") {
+			ref := h.db.Client.Collection("promotion_codes").Doc(usage.Code)
+			codeDoc, err := tx.Get(ref)
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			if codeDoc != nil && codeDoc.Exists() {
+				var promoCode models.PromotionCode
+				if err := codeDoc.DataTo(&promoCode); err == nil && promoCode.RedeemedOrderID == usage.OrderID {
+					codeRef = ref
+				}
+			}
+		}
+
+		now := time.Now()
+		if codeRef != nil {
+			if err := tx.Update(codeRef, []firestore.Update{
+				{Path: "status", Value: models.PromotionCodeAvailable},
+				{Path: "redeemed_at", Value: firestore.Delete},
+				{Path: "redeemed_order_id", Value: firestore.Delete},
+				{Path: "redeemed_order_number", Value: firestore.Delete},
+				{Path: "redeemed_by", Value: firestore.Delete},
+			}); err != nil {
+				return err
+			}
+		}
+
+		if promoDoc != nil && promoDoc.Exists() {
+			var promo models.Promotion
+			if err := promoDoc.DataTo(&promo); err == nil && promo.UsedCount > 0 {
+				if err := tx.Update(promoRef, []firestore.Update{
+					{Path: "used_count", Value: firestore.Increment(-1)},
+					{Path: "updated_at", Value: now},
+				}); err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.Update(usageRef, []firestore.Update{
+			{Path: "status", Value: models.PromotionUsageReversed},
+			{Path: "reversed_at", Value: now},
+			{Path: "reversal_reason", Value: reason},
+		})
+	})
+}
+
+// promotionReservationTTL is how long an order awaiting payment holds the promotions it
+// reserved. Orders paid later record them afresh, within the limits.
+const promotionReservationTTL = 2 * time.Hour
+
+// StartReservationSweep releases the promotions held by unpaid orders now and then
+// every half hour
+func (h *PromotionHandler) StartReservationSweep() {
+	go func() {
+		h.releaseUnpaidReservations(time.Now())
+		ticker := time.NewTicker(30 * time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			h.releaseUnpaidReservations(now)
+		}
+	}()
+}
+
+// releaseUnpaidReservations reverses reservations older than promotionReservationTTL
+// whose orders were not paid. Cash on delivery orders keep theirs.
+func (h *PromotionHandler) releaseUnpaidReservations(now time.Time) {
+	docs, err := h.db.Client.Collection("promotion_usage").Where("status", "==", models.PromotionUsageReserved).Documents(h.db.Context).GetAll()
+	if err != nil {
+		log.Printf("Failed to load promotion reservations: %v", err)
+		return
+	}
+
+	checked := make(map[string]bool)
+	for _, doc := range docs {
+		var usage models.PromotionUsage
+		if err := doc.DataTo(&usage); err != nil || now.Sub(usage.UsedAt) < promotionReservationTTL || checked[usage.OrderID] {
+			continue
+		}
+		checked[usage.OrderID] = true
+
+		orderDoc, err := h.db.Client.Collection("orders").Doc(usage.OrderID).Get(h.db.Context)
+		if err != nil && status.Code(err) != codes.NotFound {
+			log.Printf("Failed to load order %s for its promotion reservations: %v", usage.OrderID, err)
+			continue
+		}
+		if orderDoc != nil && orderDoc.Exists() {
+			var order models.Order
+			if err := orderDoc.DataTo(&order); err != nil {
+				continue
+			}
+			if order.Payment.Status == "completed" || strings.EqualFold(order.Payment.Method, "cod") {
+				continue
+			}
+		}
+		if err := h.ReverseRedemptions(usage.OrderID, "order not paid"); err != nil {
+			log.Printf("Failed to release promotion usage for order %s: %v", usage.OrderID, err)
+		}
+	}
+}
+
+// GetPromotionUsage lists the redemptions of a promotion (admin)
+func (h *PromotionHandler) GetPromotionUsage(c *gin.Context) {
+	promoID := c.Param("id")
+
+	docs, err := h.db.Client.Collection("promotion_usage").Where("promotion_id", "==", promoID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promotion usage"})
+		return
+	}
+
+	usages := make([]models.PromotionUsage, 0)
+	active := 0
+	for _, doc := range docs {
+		var usage models.PromotionUsage
+		if err := doc.DataTo(&usage); err != nil {
+			continue
+		}
+		usage.ID = doc.Ref.ID
+		if usage.IsActive() {
+			active++
+		}
+		usages = append(usages, usage)
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].UsedAt.After(usages[j].UsedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"usage":    usages,
+		"total":    len(usages),
+		"redeemed": active,
+		"reversed": len(usages) - active,
+	})
+}
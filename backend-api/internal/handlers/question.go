@@ -0,0 +1,617 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+	"tripund-api/internal/services"
+	"tripund-api/internal/utils"
+)
+
+// Limits on questions and answers
+const (
+	questionMaxLength = 500
+	answerMaxLength   = 1000
+	questionMaxInvite = 10 // Buyers invited to answer at a time
+)
+
+// errQuestionRequest is returned from question updates that the request can't make;
+// its message is shown to the caller
+type errQuestionRequest struct{ message string }
+
+func (e errQuestionRequest) Error() string { return e.message }
+
+type QuestionHandler struct {
+	db                  *database.Firebase
+	notificationHandler *NotificationHandler
+	emailService        *services.SendGridEmailService
+}
+
+func NewQuestionHandler(db *database.Firebase) *QuestionHandler {
+	emailService, err := services.NewSendGridEmailService()
+	if err != nil {
+		log.Printf("WARNING: Product question emails disabled: %v", err)
+		emailService = nil
+	}
+	return &QuestionHandler{
+		db:                  db,
+		notificationHandler: NewNotificationHandler(db),
+		emailService:        emailService,
+	}
+}
+
+func productURL(productID string) string {
+	return "https://tripundlifestyle.com/products/" + productID
+}
+
+// publishedQuestions returns a product's published questions with their published
+// answers, most recently answered first. Customer IDs are left out.
+func publishedQuestions(db *database.Firebase, productID string) ([]models.ProductQuestion, error) {
+	docs, err := db.Client.Collection("product_questions").Where("product_id", "==", productID).Documents(db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	questions := make([]models.ProductQuestion, 0)
+	for _, doc := range docs {
+		var question models.ProductQuestion
+		if err := doc.DataTo(&question); err != nil || question.Status != models.QuestionStatusPublished {
+			continue
+		}
+		question.ID = doc.Ref.ID
+		question.UserID = ""
+		question.InvitedUserIDs = nil
+
+		answers := make([]models.ProductAnswer, 0, len(question.Answers))
+		for _, answer := range question.Answers {
+			if answer.Status == models.QuestionStatusPublished {
+				answer.AuthorID = ""
+				answers = append(answers, answer)
+			}
+		}
+		question.Answers = answers
+		questions = append(questions, question)
+	}
+
+	sort.Slice(questions, func(i, j int) bool {
+		a, b := questions[i].AnsweredAt, questions[j].AnsweredAt
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.After(*b)
+	})
+	return questions, nil
+}
+
+// getMobileUser loads a customer account
+func (h *QuestionHandler) getMobileUser(userID string) (*models.MobileUser, error) {
+	doc, err := h.db.Client.Collection("mobile_users").Doc(userID).Get(h.db.Context)
+	if err != nil {
+		return nil, err
+	}
+	var user models.MobileUser
+	if err := doc.DataTo(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// updateQuestion applies change to a question in a transaction
+func (h *QuestionHandler) updateQuestion(id string, change func(*models.ProductQuestion) error) (*models.ProductQuestion, error) {
+	ref := h.db.Client.Collection("product_questions").Doc(id)
+	var question models.ProductQuestion
+	err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		question = models.ProductQuestion{}
+		if err := doc.DataTo(&question); err != nil {
+			return err
+		}
+		question.ID = doc.Ref.ID
+		if err := change(&question); err != nil {
+			return err
+		}
+		question.UpdatedAt = time.Now()
+		return tx.Set(ref, question)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &question, nil
+}
+
+// respondQuestionError reports a failed question update
+func respondQuestionError(c *gin.Context, err error) {
+	var requestErr errQuestionRequest
+	switch {
+	case errors.As(err, &requestErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": requestErr.message})
+	case status.Code(err) == codes.NotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+	default:
+		log.Printf("Failed to update product question: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update question"})
+	}
+}
+
+// publishAnswer marks an answer published, publishing the question with it
+func publishAnswer(question *models.ProductQuestion, answer *models.ProductAnswer) {
+	answer.Status = models.QuestionStatusPublished
+	question.Status = models.QuestionStatusPublished
+	if question.AnsweredAt == nil {
+		now := time.Now()
+		question.AnsweredAt = &now
+	}
+}
+
+// notifyAsker tells the customer who asked that an answer has been published
+func (h *QuestionHandler) notifyAsker(question *models.ProductQuestion, answer models.ProductAnswer) {
+	if question.UserID == "" {
+		return
+	}
+	h.notificationHandler.CreateNotification(
+		"question",
+		"Your Question Was Answered",
+		"Your question about "+question.ProductName+" has a new answer",
+		"MessageCircle",
+		"/products/"+question.ProductID,
+		question.UserID,
+	)
+
+	if h.emailService == nil {
+		return
+	}
+	user, err := h.getMobileUser(question.UserID)
+	if err != nil || user.Email == "" {
+		return
+	}
+	err = h.emailService.SendQuestionAnswered(user.Email, services.QuestionEmailData{
+		CustomerName: user.Profile.FirstName,
+		ProductName:  question.ProductName,
+		ProductURL:   productURL(question.ProductID),
+		Question:     question.Question,
+		Answer:       answer.Text,
+	})
+	if err != nil {
+		log.Printf("Failed to email answer to question %s: %v", question.ID, err)
+	}
+}
+
+// GetProductQuestions lists a product's published questions and answers
+func (h *QuestionHandler) GetProductQuestions(c *gin.Context) {
+	questions, err := publishedQuestions(h.db, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch questions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"questions": questions, "count": len(questions)})
+}
+
+// AskQuestion submits a customer's question about a product for the store to answer
+func (h *QuestionHandler) AskQuestion(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req models.CreateQuestionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Question = strings.TrimSpace(req.Question); req.Question == "" || len([]rune(req.Question)) > questionMaxLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question must be between 1 and " + strconv.Itoa(questionMaxLength) + " characters"})
+		return
+	}
+
+	productDoc, err := h.db.Client.Collection("products").Doc(req.ProductID).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	var product models.Product
+	if err := database.DecodeProduct(productDoc, &product); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse product data"})
+		return
+	}
+
+	user, err := h.getMobileUser(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	now := time.Now()
+	question := models.ProductQuestion{
+		ProductID:   req.ProductID,
+		ProductName: product.Name,
+		UserID:      userID,
+		UserName:    reviewerName(user),
+		Question:    req.Question,
+		Status:      models.QuestionStatusPending,
+		Answers:     []models.ProductAnswer{},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	docRef, _, err := h.db.Client.Collection("product_questions").Add(h.db.Context, question)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save question"})
+		return
+	}
+	question.ID = docRef.ID
+
+	h.notificationHandler.NotifyNewQuestion(question.ID, product.Name)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Thank you! We'll let you know when your question is answered.",
+		"question": question,
+	})
+}
+
+// GetMyQuestions lists the questions the customer asked and those they were invited
+// to answer
+func (h *QuestionHandler) GetMyQuestions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	collection := h.db.Client.Collection("product_questions")
+
+	load := func(query firestore.Query) ([]models.ProductQuestion, error) {
+		docs, err := query.Documents(h.db.Context).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		questions := make([]models.ProductQuestion, 0, len(docs))
+		for _, doc := range docs {
+			var question models.ProductQuestion
+			if err := doc.DataTo(&question); err == nil {
+				question.ID = doc.Ref.ID
+				question.InvitedUserIDs = nil
+				questions = append(questions, question)
+			}
+		}
+		sort.Slice(questions, func(i, j int) bool { return questions[i].CreatedAt.After(questions[j].CreatedAt) })
+		return questions, nil
+	}
+
+	asked, err := load(collection.Where("user_id", "==", userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch questions"})
+		return
+	}
+	invited, err := load(collection.Where("invited_user_ids", "array-contains", userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch questions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"asked": asked, "invited": invited})
+}
+
+// AnswerQuestion adds an answer from a customer who was invited to answer as a buyer
+// of the product. It is shown once approved.
+func (h *QuestionHandler) AnswerQuestion(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req models.AnswerQuestionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Text = strings.TrimSpace(req.Text); req.Text == "" || len([]rune(req.Text)) > answerMaxLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Answer must be between 1 and " + strconv.Itoa(answerMaxLength) + " characters"})
+		return
+	}
+
+	user, err := h.getMobileUser(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	answer := models.ProductAnswer{
+		ID:         utils.GenerateIDWithPrefix("ans"),
+		Text:       req.Text,
+		AuthorType: models.AnswerAuthorCustomer,
+		AuthorID:   userID,
+		AuthorName: reviewerName(user),
+		Status:     models.QuestionStatusPending,
+		CreatedAt:  time.Now(),
+	}
+	question, err := h.updateQuestion(c.Param("id"), func(question *models.ProductQuestion) error {
+		invited := false
+		for _, id := range question.InvitedUserIDs {
+			invited = invited || id == userID
+		}
+		if !invited {
+			return errQuestionRequest{"Only customers invited to answer this question can answer it"}
+		}
+		for _, existing := range question.Answers {
+			if existing.AuthorID == userID {
+				return errQuestionRequest{"You have already answered this question"}
+			}
+		}
+		question.Answers = append(question.Answers, answer)
+		return nil
+	})
+	if err != nil {
+		respondQuestionError(c, err)
+		return
+	}
+
+	h.notificationHandler.NotifyNewQuestion(question.ID, question.ProductName)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Thank you for helping! Your answer will appear once it has been approved.",
+		"answer":  answer,
+	})
+}
+
+// GetAdminQuestions lists questions for the admin panel, newest first, optionally
+// filtered by ?status= and ?product_id=
+func (h *QuestionHandler) GetAdminQuestions(c *gin.Context) {
+	query := h.db.Client.Collection("product_questions").Query
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("product_id", "==", productID)
+	}
+	docs, err := query.Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch questions"})
+		return
+	}
+
+	questionStatus := c.Query("status")
+	questions := make([]models.ProductQuestion, 0, len(docs))
+	pending := 0
+	for _, doc := range docs {
+		var question models.ProductQuestion
+		if err := doc.DataTo(&question); err != nil {
+			continue
+		}
+		question.ID = doc.Ref.ID
+		// Pending answers need moderation as much as pending questions
+		awaiting := question.Status == models.QuestionStatusPending
+		for _, answer := range question.Answers {
+			awaiting = awaiting || answer.Status == models.QuestionStatusPending
+		}
+		if awaiting {
+			pending++
+		}
+		if questionStatus != "" && question.Status != questionStatus && !(questionStatus == models.QuestionStatusPending && awaiting) {
+			continue
+		}
+		questions = append(questions, question)
+	}
+	sort.Slice(questions, func(i, j int) bool { return questions[i].CreatedAt.After(questions[j].CreatedAt) })
+
+	c.JSON(http.StatusOK, gin.H{"questions": questions, "count": len(questions), "pending": pending})
+}
+
+// AdminAnswerQuestion publishes the store's answer with the question and tells the
+// customer who asked
+func (h *QuestionHandler) AdminAnswerQuestion(c *gin.Context) {
+	var req models.AnswerQuestionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Text = strings.TrimSpace(req.Text); req.Text == "" || len([]rune(req.Text)) > answerMaxLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Answer must be between 1 and " + strconv.Itoa(answerMaxLength) + " characters"})
+		return
+	}
+
+	answer := models.ProductAnswer{
+		ID:         utils.GenerateIDWithPrefix("ans"),
+		Text:       req.Text,
+		AuthorType: models.AnswerAuthorStore,
+		AuthorID:   c.GetString("user_id"),
+		AuthorName: "TRIPUND Lifestyle",
+		CreatedAt:  time.Now(),
+	}
+	question, err := h.updateQuestion(c.Param("id"), func(question *models.ProductQuestion) error {
+		publishAnswer(question, &answer)
+		question.Answers = append(question.Answers, answer)
+		return nil
+	})
+	if err != nil {
+		respondQuestionError(c, err)
+		return
+	}
+
+	go h.notifyAsker(question, answer)
+	c.JSON(http.StatusOK, question)
+}
+
+// ModerateQuestion publishes or rejects a question. A question can only be published
+// once it has a published answer.
+func (h *QuestionHandler) ModerateQuestion(c *gin.Context) {
+	var req models.ModerateQuestionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status != models.QuestionStatusPublished && req.Status != models.QuestionStatusRejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be published or rejected"})
+		return
+	}
+
+	question, err := h.updateQuestion(c.Param("id"), func(question *models.ProductQuestion) error {
+		if req.Status == models.QuestionStatusPublished {
+			answered := false
+			for _, answer := range question.Answers {
+				answered = answered || answer.Status == models.QuestionStatusPublished
+			}
+			if !answered {
+				return errQuestionRequest{"Answer the question before publishing it"}
+			}
+		}
+		question.Status = req.Status
+		return nil
+	})
+	if err != nil {
+		respondQuestionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, question)
+}
+
+// ModerateAnswer publishes or rejects a customer's answer. Publishing the first answer
+// publishes the question and tells the customer who asked.
+func (h *QuestionHandler) ModerateAnswer(c *gin.Context) {
+	var req models.ModerateQuestionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status != models.QuestionStatusPublished && req.Status != models.QuestionStatusRejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be published or rejected"})
+		return
+	}
+
+	var answer models.ProductAnswer
+	newlyPublished := false
+	question, err := h.updateQuestion(c.Param("id"), func(question *models.ProductQuestion) error {
+		for i := range question.Answers {
+			if question.Answers[i].ID != c.Param("answerId") {
+				continue
+			}
+			if req.Status == models.QuestionStatusPublished {
+				newlyPublished = question.Answers[i].Status != models.QuestionStatusPublished
+				publishAnswer(question, &question.Answers[i])
+			} else {
+				question.Answers[i].Status = models.QuestionStatusRejected
+			}
+			answer = question.Answers[i]
+			return nil
+		}
+		return errQuestionRequest{"Answer not found"}
+	})
+	if err != nil {
+		respondQuestionError(c, err)
+		return
+	}
+
+	if newlyPublished {
+		go h.notifyAsker(question, answer)
+	}
+	c.JSON(http.StatusOK, question)
+}
+
+// InviteAnswers asks customers with a delivered order of the product to answer the
+// question, most recent buyers first, skipping the asker and anyone already invited
+func (h *QuestionHandler) InviteAnswers(c *gin.Context) {
+	doc, err := h.db.Client.Collection("product_questions").Doc(c.Param("id")).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+	var question models.ProductQuestion
+	if err := doc.DataTo(&question); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse question"})
+		return
+	}
+	question.ID = doc.Ref.ID
+
+	orderDocs, err := h.db.Client.Collection("orders").Where("status", "==", "delivered").Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+	var orders []models.Order
+	for _, orderDoc := range orderDocs {
+		var order models.Order
+		if err := orderDoc.DataTo(&order); err == nil && order.UserID != "" && order.UserID != "guest" {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.After(orders[j].CreatedAt) })
+
+	skip := map[string]bool{question.UserID: true}
+	for _, id := range question.InvitedUserIDs {
+		skip[id] = true
+	}
+	var buyers []string
+	for _, order := range orders {
+		if len(buyers) >= questionMaxInvite {
+			break
+		}
+		if skip[order.UserID] {
+			continue
+		}
+		for _, item := range order.Items {
+			if item.ProductID == question.ProductID {
+				skip[order.UserID] = true
+				buyers = append(buyers, order.UserID)
+				break
+			}
+		}
+	}
+	if len(buyers) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "No other buyers of this product to invite", "invited": 0})
+		return
+	}
+
+	invitees := make([]interface{}, len(buyers))
+	for i, id := range buyers {
+		invitees[i] = id
+	}
+	_, err = doc.Ref.Update(h.db.Context, []firestore.Update{
+		{Path: "invited_user_ids", Value: firestore.ArrayUnion(invitees...)},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite buyers"})
+		return
+	}
+
+	go func() {
+		for _, userID := range buyers {
+			h.notificationHandler.CreateNotification(
+				"question",
+				"Can You Help Another Customer?",
+				"A customer has a question about "+question.ProductName+", which you bought",
+				"MessageCircle",
+				"/products/"+question.ProductID,
+				userID,
+			)
+			if h.emailService == nil {
+				continue
+			}
+			user, err := h.getMobileUser(userID)
+			if err != nil || user.Email == "" {
+				continue
+			}
+			err = h.emailService.SendAnswerInvite(user.Email, services.QuestionEmailData{
+				CustomerName: user.Profile.FirstName,
+				ProductName:  question.ProductName,
+				ProductURL:   productURL(question.ProductID),
+				Question:     question.Question,
+			})
+			if err != nil {
+				log.Printf("Failed to invite %s to answer question %s: %v", userID, question.ID, err)
+			}
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Invited %d buyers to answer", len(buyers)),
+		"invited": len(buyers),
+	})
+}
+
+// DeleteQuestion removes a question and its answers
+func (h *QuestionHandler) DeleteQuestion(c *gin.Context) {
+	if _, err := h.db.Client.Collection("product_questions").Doc(c.Param("id")).Delete(h.db.Context); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete question"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Question deleted successfully"})
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+)
+
+// Recommendations are rebuilt from orders over recommendationOrderWindow and product
+// views over recommendationViewWindow every recommendationRefresh
+const (
+	recommendationOrderWindow = 180 * 24 * time.Hour
+	recommendationViewWindow  = 30 * 24 * time.Hour
+	recommendationRefresh     = 6 * time.Hour
+
+	// Sessions viewing more products than this are crawlers or browsing the whole
+	// catalogue, and say little about which products go together
+	maxSessionViews = 30
+)
+
+// Reasons a product is recommended
+const (
+	recommendationBoughtTogether = "bought_together"
+	recommendationViewedTogether = "viewed_together"
+	recommendationSimilar        = "similar"
+)
+
+// RecommendedProduct is a product recommended alongside others, with why
+type RecommendedProduct struct {
+	models.Product
+	Reason string `json:"reason"`
+}
+
+// productFeatures is what the similarity fallback compares products by
+type productFeatures struct {
+	categories    []string
+	subcategories []string
+	tags          []string
+	sold          int
+}
+
+// recommendationModel holds the associations between products. Each pair is counted
+// once per order, or per browsing session, that has both.
+type recommendationModel struct {
+	boughtWith map[string]map[string]int
+	viewedWith map[string]map[string]int
+	products   map[string]*productFeatures // Active products that can be recommended
+	builtAt    time.Time
+}
+
+// RecommendationHandler serves related products and cart suggestions
+type RecommendationHandler struct {
+	db    *database.Firebase
+	mu    sync.Mutex // Held while building, so requests wait for the first build
+	model *recommendationModel
+}
+
+func NewRecommendationHandler(db *database.Firebase) *RecommendationHandler {
+	return &RecommendationHandler{db: db}
+}
+
+// StartRefresh rebuilds the recommendations in the background every
+// recommendationRefresh
+func (h *RecommendationHandler) StartRefresh() {
+	go func() {
+		ticker := time.NewTicker(recommendationRefresh)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := h.rebuild(); err != nil {
+				log.Printf("Failed to refresh recommendations: %v", err)
+			}
+		}
+	}()
+}
+
+// getModel returns the recommendations, building them on first use
+func (h *RecommendationHandler) getModel() (*recommendationModel, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.model != nil {
+		return h.model, nil
+	}
+	model, err := h.buildModel()
+	if err != nil {
+		return nil, err
+	}
+	h.model = model
+	return model, nil
+}
+
+func (h *RecommendationHandler) rebuild() error {
+	model, err := h.buildModel()
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.model = model
+	h.mu.Unlock()
+	return nil
+}
+
+// countPairs adds one to the association of every pair of the products
+func countPairs(associations map[string]map[string]int, productIDs []string) {
+	for _, a := range productIDs {
+		for _, b := range productIDs {
+			if a == b {
+				continue
+			}
+			if associations[a] == nil {
+				associations[a] = make(map[string]int)
+			}
+			associations[a][b]++
+		}
+	}
+}
+
+// buildModel counts which products are bought in the same placed orders and viewed in
+// the same sessions, and loads the features of the active products
+func (h *RecommendationHandler) buildModel() (*recommendationModel, error) {
+	model := &recommendationModel{
+		boughtWith: make(map[string]map[string]int),
+		viewedWith: make(map[string]map[string]int),
+		products:   make(map[string]*productFeatures),
+		builtAt:    time.Now(),
+	}
+
+	productDocs, err := h.db.Client.Collection("products").Where("status", "==", models.ProductStatusActive).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range productDocs {
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil || product.IsGiftCard() {
+			continue
+		}
+		model.products[doc.Ref.ID] = &productFeatures{
+			categories:    product.Categories,
+			subcategories: product.Subcategories,
+			tags:          product.Tags,
+		}
+	}
+
+	orderDocs, err := h.db.Client.Collection("orders").Where("created_at", ">=", time.Now().Add(-recommendationOrderWindow)).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range orderDocs {
+		var order models.Order
+		if err := doc.DataTo(&order); err != nil || !isPlacedOrder(&order) {
+			continue
+		}
+		var bought []string
+		seen := make(map[string]bool)
+		for _, item := range order.Items {
+			// Gift cards and free gifts weren't chosen to go with the rest
+			if item.ProductType == models.ProductTypeGiftCard || item.Total == 0 && item.Discount > 0 {
+				continue
+			}
+			if features := model.products[item.ProductID]; features != nil {
+				features.sold += item.Quantity
+			}
+			if !seen[item.ProductID] {
+				seen[item.ProductID] = true
+				bought = append(bought, item.ProductID)
+			}
+		}
+		countPairs(model.boughtWith, bought)
+	}
+
+	// Recent actions are filtered to views in memory, to avoid a composite index
+	since := time.Now().Add(-recommendationViewWindow)
+	viewDocs, err := h.db.Client.Collection("user_actions").Where("timestamp", ">=", since).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make(map[string][]string)
+	viewed := make(map[string]map[string]bool)
+	for _, doc := range viewDocs {
+		var action models.UserAction
+		if err := doc.DataTo(&action); err != nil || action.Action != "view_product" || action.ProductID == "" || action.SessionID == "" {
+			continue
+		}
+		if viewed[action.SessionID] == nil {
+			viewed[action.SessionID] = make(map[string]bool)
+		}
+		if !viewed[action.SessionID][action.ProductID] {
+			viewed[action.SessionID][action.ProductID] = true
+			sessions[action.SessionID] = append(sessions[action.SessionID], action.ProductID)
+		}
+	}
+	for _, productIDs := range sessions {
+		if len(productIDs) <= maxSessionViews {
+			countPairs(model.viewedWith, productIDs)
+		}
+	}
+
+	log.Printf("Built recommendations from %d orders and %d browsing sessions", len(orderDocs), len(sessions))
+	return model, nil
+}
+
+// sharedCount is how many values two lists have in common, ignoring case
+func sharedCount(a, b []string) int {
+	count := 0
+	for _, x := range a {
+		for _, y := range b {
+			if strings.EqualFold(x, y) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// similarity scores how alike two products are by category, subcategory and tags
+func similarity(a, b *productFeatures) int {
+	return 3*sharedCount(a.categories, b.categories) + 2*sharedCount(a.subcategories, b.subcategories) + sharedCount(a.tags, b.tags)
+}
+
+// recommendationScores adds up the associations of the seed products with each other
+// active product, leaving out the seeds
+func (m *recommendationModel) recommendationScores(associations map[string]map[string]int, seeds []string) map[string]int {
+	exclude := make(map[string]bool)
+	for _, seed := range seeds {
+		exclude[seed] = true
+	}
+	scores := make(map[string]int)
+	for _, seed := range seeds {
+		for productID, count := range associations[seed] {
+			if !exclude[productID] && m.products[productID] != nil {
+				scores[productID] += count
+			}
+		}
+	}
+	return scores
+}
+
+// rank orders scored products best first, breaking ties by sales and then ID, and
+// drops those already picked
+func (m *recommendationModel) rank(scores map[string]int, picked map[string]bool) []string {
+	ranked := make([]string, 0, len(scores))
+	for productID, score := range scores {
+		if score > 0 && !picked[productID] {
+			ranked = append(ranked, productID)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		if m.products[a].sold != m.products[b].sold {
+			return m.products[a].sold > m.products[b].sold
+		}
+		return a < b
+	})
+	return ranked
+}
+
+// recommend picks up to limit products to go with the seeds: those most often bought
+// with them, then viewed with them, then the most similar. It returns the product IDs
+// with the reason for each.
+func (m *recommendationModel) recommend(seeds []string, limit int) ([]string, map[string]string) {
+	picked := make(map[string]bool)
+	reasons := make(map[string]string)
+	var productIDs []string
+	add := func(ranked []string, reason string) {
+		for _, productID := range ranked {
+			if len(productIDs) >= limit {
+				return
+			}
+			picked[productID] = true
+			reasons[productID] = reason
+			productIDs = append(productIDs, productID)
+		}
+	}
+
+	add(m.rank(m.recommendationScores(m.boughtWith, seeds), picked), recommendationBoughtTogether)
+	add(m.rank(m.recommendationScores(m.viewedWith, seeds), picked), recommendationViewedTogether)
+	if len(productIDs) < limit {
+		exclude := make(map[string]bool)
+		for _, seed := range seeds {
+			exclude[seed] = true
+		}
+		scores := make(map[string]int)
+		for _, seed := range seeds {
+			features := m.products[seed]
+			if features == nil {
+				continue
+			}
+			for productID, other := range m.products {
+				if !exclude[productID] {
+					scores[productID] += similarity(features, other)
+				}
+			}
+		}
+		add(m.rank(scores, picked), recommendationSimilar)
+	}
+	return productIDs, reasons
+}
+
+// loadRecommended loads the recommended products in order, so prices and stock are
+// current, leaving out any no longer active
+func (h *RecommendationHandler) loadRecommended(productIDs []string, reasons map[string]string) ([]RecommendedProduct, error) {
+	products, err := loadProducts(h.db, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	recommended := make([]RecommendedProduct, 0, len(productIDs))
+	for _, productID := range productIDs {
+		product := products[productID]
+		if product == nil || product.Status != models.ProductStatusActive {
+			continue
+		}
+		product.SetCurrentPrices()
+		recommended = append(recommended, RecommendedProduct{Product: *product, Reason: reasons[productID]})
+	}
+	return recommended, nil
+}
+
+// recommendationLimit reads the limit query parameter, defaulting to 8 and at most 24
+func recommendationLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "8"))
+	if err != nil || limit < 1 {
+		return 8
+	}
+	return min(limit, 24)
+}
+
+// GetRelatedProducts returns the products frequently bought together with a product,
+// and a wider list of related products: bought or viewed together, then similar by
+// category and tags
+func (h *RecommendationHandler) GetRelatedProducts(c *gin.Context) {
+	productID := c.Param("id")
+	model, err := h.getModel()
+	if err != nil {
+		log.Printf("Failed to build recommendations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load related products"})
+		return
+	}
+
+	boughtIDs := model.rank(model.recommendationScores(model.boughtWith, []string{productID}), nil)
+	if len(boughtIDs) > 3 {
+		boughtIDs = boughtIDs[:3]
+	}
+	reasons := make(map[string]string)
+	for _, id := range boughtIDs {
+		reasons[id] = recommendationBoughtTogether
+	}
+	boughtTogether, err := h.loadRecommended(boughtIDs, reasons)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load related products"})
+		return
+	}
+
+	relatedIDs, reasons := model.recommend([]string{productID}, recommendationLimit(c))
+	related, err := h.loadRecommended(relatedIDs, reasons)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load related products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"frequently_bought_together": boughtTogether,
+		"related":                    related,
+	})
+}
+
+type CompleteTheSetRequest struct {
+	ProductIDs []string `json:"product_ids" binding:"required,min=1"`
+}
+
+// CompleteTheSet suggests products to add to a cart, from what's most often bought and
+// viewed with everything in it
+func (h *RecommendationHandler) CompleteTheSet(c *gin.Context) {
+	var req CompleteTheSetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	model, err := h.getModel()
+	if err != nil {
+		log.Printf("Failed to build recommendations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load suggestions"})
+		return
+	}
+
+	productIDs, reasons := model.recommend(req.ProductIDs, recommendationLimit(c))
+	suggestions, err := h.loadRecommended(productIDs, reasons)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load suggestions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"products": suggestions, "count": len(suggestions)})
+}
+
+// RefreshRecommendations rebuilds the recommendations now (admin)
+func (h *RecommendationHandler) RefreshRecommendations(c *gin.Context) {
+	if err := h.rebuild(); err != nil {
+		log.Printf("Failed to refresh recommendations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh recommendations"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Recommendations refreshed"})
+}
@@ -0,0 +1,855 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+	"tripund-api/internal/utils"
+)
+
+const (
+	referralLinkBase   = "https://tripundlifestyle.com/?ref="
+	referralCodeLength = 8
+)
+
+// Fraud signals recorded on referrals
+const (
+	referralFlagSamePhone      = "same_phone"
+	referralFlagSameAddress    = "same_address"
+	referralFlagReferrerDevice = "device_matches_referrer"
+	referralFlagDeviceReused   = "device_reused"
+)
+
+type ReferralHandler struct {
+	db                  *database.Firebase
+	notificationHandler *NotificationHandler
+	giftCardHandler     *GiftCardHandler
+	promotionHandler    *PromotionHandler
+}
+
+func NewReferralHandler(db *database.Firebase, giftCardHandler *GiftCardHandler) *ReferralHandler {
+	return &ReferralHandler{
+		db:                  db,
+		notificationHandler: NewNotificationHandler(db),
+		giftCardHandler:     giftCardHandler,
+		promotionHandler:    NewPromotionHandler(db),
+	}
+}
+
+// getReferralSettings returns the referral settings, or a disabled program if none are saved
+func getReferralSettings(db *database.Firebase) ReferralSettings {
+	doc, err := db.Client.Collection("settings").Doc("main").Get(db.Context)
+	if err != nil {
+		return ReferralSettings{}
+	}
+
+	var settings Settings
+	if err := doc.DataTo(&settings); err != nil {
+		log.Printf("Failed to parse referral settings: %v", err)
+		return ReferralSettings{}
+	}
+	return settings.Referral
+}
+
+// isPlacedOrder reports whether an order counts as a purchase: paid online or cash on
+// delivery, and not cancelled or refunded
+func isPlacedOrder(order *models.Order) bool {
+	if order.Status == "cancelled" || order.Status == "refunded" {
+		return false
+	}
+	return order.Payment.Status == "completed" || strings.EqualFold(order.Payment.Method, "cod")
+}
+
+// hasPlacedOrders reports whether a customer has any placed order
+func hasPlacedOrders(db *database.Firebase, userID string) bool {
+	docs, err := db.Client.Collection("orders").Where("user_id", "==", userID).Documents(db.Context).GetAll()
+	if err != nil {
+		log.Printf("Failed to fetch orders for user %s: %v", userID, err)
+		return true // Fail closed so first-order discounts aren't handed out on errors
+	}
+
+	for _, doc := range docs {
+		var order models.Order
+		if err := doc.DataTo(&order); err != nil {
+			continue
+		}
+		if isPlacedOrder(&order) {
+			return true
+		}
+	}
+	return false
+}
+
+// referralPromotionID identifies the referral discount in promotion usage. The discount
+// has no promotion document; orders reserve it like a promotion, once per customer, so
+// a second order can't take it while the first awaits payment.
+const referralPromotionID = "referral"
+
+// referralPromotion returns the first-order discount of a referred customer as a
+// promotion so it goes through the usual eligibility and stacking rules, or nil
+func (h *PromotionHandler) referralPromotion(customer PromotionCustomer) *models.Promotion {
+	if customer.UserID == "" || customer.UserID == "guest" {
+		return nil
+	}
+
+	settings := getReferralSettings(h.db)
+	if !settings.Enabled || settings.RefereeDiscount <= 0 {
+		return nil
+	}
+
+	doc, err := h.db.Client.Collection("referrals").Doc(customer.UserID).Get(h.db.Context)
+	if err != nil {
+		return nil
+	}
+	var referral models.Referral
+	if err := doc.DataTo(&referral); err != nil || referral.Status != models.ReferralStatusPending {
+		return nil
+	}
+
+	if hasPlacedOrders(h.db, customer.UserID) {
+		return nil
+	}
+
+	promoType := models.PromotionTypePercentage
+	if settings.RefereeDiscountType == string(models.PromotionTypeFixed) {
+		promoType = models.PromotionTypeFixed
+	}
+
+	// The discount stays available for as long as the referral is pending
+	now := time.Now()
+	return &models.Promotion{
+		ID:             referralPromotionID,
+		Description:    "Referral welcome discount",
+		Type:           promoType,
+		Discount:       settings.RefereeDiscount,
+		MaxDiscount:    settings.RefereeMaxDiscount,
+		MinOrderValue:  settings.RefereeMinOrderValue,
+		MaxUsesPerUser: 1,
+		Status:         models.PromotionStatusActive,
+		StartDate:      referral.CreatedAt,
+		EndDate:        now.AddDate(0, 0, 1),
+	}
+}
+
+// ensureReferralCode returns the customer's referral code, creating one on first use
+func (h *ReferralHandler) ensureReferralCode(userID string) (*models.ReferralCode, error) {
+	userRef := h.db.Client.Collection("mobile_users").Doc(userID)
+	userDoc, err := userRef.Get(h.db.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.MobileUser
+	if err := userDoc.DataTo(&user); err != nil {
+		return nil, err
+	}
+
+	if user.ReferralCode != "" {
+		codeDoc, err := h.db.Client.Collection("referral_codes").Doc(user.ReferralCode).Get(h.db.Context)
+		if err == nil {
+			var code models.ReferralCode
+			if err := codeDoc.DataTo(&code); err == nil {
+				return &code, nil
+			}
+		} else if status.Code(err) != codes.NotFound {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		code := models.ReferralCode{
+			Code:      utils.GenerateCode(referralCodeLength),
+			UserID:    userID,
+			CreatedAt: time.Now(),
+		}
+		_, err := h.db.Client.Collection("referral_codes").Doc(code.Code).Create(h.db.Context, code)
+		if status.Code(err) == codes.AlreadyExists {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := userRef.Update(h.db.Context, []firestore.Update{
+			{Path: "referral_code", Value: code.Code},
+			{Path: "updated_at", Value: time.Now()},
+		}); err != nil {
+			return nil, err
+		}
+		return &code, nil
+	}
+
+	return nil, fmt.Errorf("failed to generate a unique referral code")
+}
+
+// referralDeviceID returns the client's device identifier from the request body or header
+func referralDeviceID(c *gin.Context, fromBody string) string {
+	if fromBody != "" {
+		return strings.TrimSpace(fromBody)
+	}
+	return strings.TrimSpace(c.GetHeader("X-Device-ID"))
+}
+
+// maskPhone keeps the last four digits of a phone number
+func maskPhone(phone string) string {
+	if len(phone) <= 4 {
+		return phone
+	}
+	return strings.Repeat("*", len(phone)-4) + phone[len(phone)-4:]
+}
+
+// GetMyReferrals returns the customer's referral code, link and the friends they invited
+func (h *ReferralHandler) GetMyReferrals(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	code, err := h.ensureReferralCode(userID)
+	if err != nil {
+		log.Printf("Failed to get referral code for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get referral code"})
+		return
+	}
+
+	// Remember the referrer's devices so self-referrals from the same device can be spotted
+	if deviceID := referralDeviceID(c, ""); deviceID != "" {
+		h.db.Client.Collection("referral_codes").Doc(code.Code).Update(h.db.Context, []firestore.Update{
+			{Path: "device_ids", Value: firestore.ArrayUnion(deviceID)},
+		})
+	}
+
+	docs, err := h.db.Client.Collection("referrals").Where("referrer_id", "==", userID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referrals"})
+		return
+	}
+
+	referrals := make([]gin.H, 0, len(docs))
+	var pending, rewarded int
+	var earned float64
+	for _, doc := range docs {
+		var referral models.Referral
+		if err := doc.DataTo(&referral); err != nil {
+			continue
+		}
+		switch referral.Status {
+		case models.ReferralStatusRewarded:
+			rewarded++
+			earned += referral.RewardAmount
+		case models.ReferralStatusPending, models.ReferralStatusFlagged:
+			pending++
+		}
+
+		// Flags and review notes are for admins only
+		displayStatus := referral.Status
+		if displayStatus == models.ReferralStatusFlagged {
+			displayStatus = models.ReferralStatusPending
+		}
+		referrals = append(referrals, gin.H{
+			"name":        referral.RefereeName,
+			"phone":       maskPhone(referral.RefereePhone),
+			"status":      displayStatus,
+			"reward_type": referral.RewardType,
+			"reward":      referral.RewardAmount,
+			"reward_code": referral.RewardCode,
+			"created_at":  referral.CreatedAt,
+		})
+	}
+
+	settings := getReferralSettings(h.db)
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":   settings.Enabled,
+		"code":      code.Code,
+		"link":      referralLinkBase + code.Code,
+		"referrals": referrals,
+		"stats": gin.H{
+			"invited":  len(referrals),
+			"pending":  pending,
+			"rewarded": rewarded,
+			"earned":   math.Round(earned*100) / 100,
+		},
+		"referee_discount_type": settings.RefereeDiscountType,
+		"referee_discount":      settings.RefereeDiscount,
+		"reward_type":           settings.RewardType,
+		"reward_amount":         settings.RewardAmount,
+	})
+}
+
+// ApplyReferralCode links a new customer to the friend who invited them. The referee's
+// first order then qualifies for the referral discount.
+func (h *ReferralHandler) ApplyReferralCode(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req models.ApplyReferralRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !getReferralSettings(h.db).Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The referral program is not active"})
+		return
+	}
+
+	codeDoc, err := h.db.Client.Collection("referral_codes").Doc(normalizePromotionCode(req.Code)).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid referral code"})
+		return
+	}
+	var code models.ReferralCode
+	if err := codeDoc.DataTo(&code); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read referral code"})
+		return
+	}
+
+	if code.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot use your own referral code"})
+		return
+	}
+
+	var referee, referrer models.MobileUser
+	refereeDoc, err := h.db.Client.Collection("mobile_users").Doc(userID).Get(h.db.Context)
+	if err != nil || refereeDoc.DataTo(&referee) != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	referrerDoc, err := h.db.Client.Collection("mobile_users").Doc(code.UserID).Get(h.db.Context)
+	if err != nil || referrerDoc.DataTo(&referrer) != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid referral code"})
+		return
+	}
+
+	if phone := phoneKey(referee.MobileNumber); phone != "" && phone == phoneKey(referrer.MobileNumber) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This referral code can't be used on this account"})
+		return
+	}
+
+	if hasPlacedOrders(h.db, userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Referral codes are only for new customers"})
+		return
+	}
+
+	// Device signals don't block the referral but hold the reward for review
+	var flags []string
+	deviceID := referralDeviceID(c, req.DeviceID)
+	if deviceID != "" {
+		for _, id := range code.DeviceIDs {
+			if id == deviceID {
+				flags = append(flags, referralFlagReferrerDevice)
+				break
+			}
+		}
+		others, err := h.db.Client.Collection("referrals").Where("device_id", "==", deviceID).Limit(1).Documents(h.db.Context).GetAll()
+		if err == nil && len(others) > 0 {
+			flags = append(flags, referralFlagDeviceReused)
+		}
+	}
+
+	refereeName := referee.Name
+	if refereeName == "" {
+		refereeName = strings.TrimSpace(referee.Profile.FirstName + " " + referee.Profile.LastName)
+	}
+
+	now := time.Now()
+	referral := models.Referral{
+		ID:            userID,
+		Code:          code.Code,
+		ReferrerID:    code.UserID,
+		ReferrerPhone: referrer.MobileNumber,
+		RefereeID:     userID,
+		RefereePhone:  referee.MobileNumber,
+		RefereeName:   refereeName,
+		DeviceID:      deviceID,
+		Status:        models.ReferralStatusPending,
+		FraudFlags:    flags,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if _, err := h.db.Client.Collection("referrals").Doc(userID).Create(h.db.Context, referral); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "A referral code has already been applied to this account"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply referral code"})
+		return
+	}
+
+	h.db.Client.Collection("mobile_users").Doc(userID).Update(h.db.Context, []firestore.Update{
+		{Path: "referred_by", Value: code.UserID},
+		{Path: "updated_at", Value: now},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Referral code applied. Your first order qualifies for the referral discount.",
+		"code":    code.Code,
+	})
+}
+
+// ProcessDeliveredOrder converts the referral of a customer whose first order has been
+// delivered. The referrer is paid out unless fraud checks flag the referral for review.
+func (h *ReferralHandler) ProcessDeliveredOrder(order models.Order) error {
+	if order.UserID == "" || order.UserID == "guest" {
+		return nil
+	}
+
+	doc, err := h.db.Client.Collection("referrals").Doc(order.UserID).Get(h.db.Context)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return err
+	}
+	var referral models.Referral
+	if err := doc.DataTo(&referral); err != nil {
+		return err
+	}
+	if referral.Status != models.ReferralStatusPending || referral.OrderID != "" {
+		return nil
+	}
+
+	flags := append(referral.FraudFlags, h.deliveryFraudChecks(&referral, order)...)
+	flags = uniqueStrings(flags)
+
+	now := time.Now()
+	updates := []firestore.Update{
+		{Path: "order_id", Value: order.ID},
+		{Path: "order_number", Value: order.OrderNumber},
+		{Path: "order_total", Value: order.Totals.Total},
+		{Path: "converted_at", Value: now},
+		{Path: "fraud_flags", Value: flags},
+		{Path: "updated_at", Value: now},
+	}
+	if len(flags) > 0 {
+		updates = append(updates, firestore.Update{Path: "status", Value: models.ReferralStatusFlagged})
+	}
+	if _, err := doc.Ref.Update(h.db.Context, updates); err != nil {
+		return err
+	}
+
+	if len(flags) > 0 {
+		h.notificationHandler.NotifyReferralFlagged(referral.ID, order.OrderNumber, strings.Join(flags, ", "))
+		return nil
+	}
+
+	_, err = h.payReferral(referral.ID, "")
+	return err
+}
+
+// deliveryFraudChecks compares the referee's delivered order with the referrer's phone
+// and saved or previously used addresses
+func (h *ReferralHandler) deliveryFraudChecks(referral *models.Referral, order models.Order) []string {
+	var flags []string
+
+	referrerPhone := phoneKey(referral.ReferrerPhone)
+	if referrerPhone != "" {
+		for _, phone := range []string{order.ShippingAddress.Phone, order.GuestPhone, referral.RefereePhone} {
+			if phoneKey(phone) == referrerPhone {
+				flags = append(flags, referralFlagSamePhone)
+				break
+			}
+		}
+	}
+
+	var addresses []models.UserAddress
+	if doc, err := h.db.Client.Collection("mobile_users").Doc(referral.ReferrerID).Get(h.db.Context); err == nil {
+		var referrer models.MobileUser
+		if doc.DataTo(&referrer) == nil {
+			addresses = append(addresses, referrer.Addresses...)
+		}
+	}
+	if docs, err := h.db.Client.Collection("orders").Where("user_id", "==", referral.ReferrerID).Documents(h.db.Context).GetAll(); err == nil {
+		for _, doc := range docs {
+			var referrerOrder models.Order
+			if doc.DataTo(&referrerOrder) == nil {
+				addresses = append(addresses, referrerOrder.ShippingAddress)
+			}
+		}
+	}
+	for _, address := range addresses {
+		if sameAddress(address, order.ShippingAddress) {
+			flags = append(flags, referralFlagSameAddress)
+			break
+		}
+	}
+
+	return flags
+}
+
+// sameAddress compares the first address line and postal code, ignoring case and punctuation
+func sameAddress(a, b models.UserAddress) bool {
+	normalize := func(value string) string {
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+				return r
+			default:
+				return -1
+			}
+		}, strings.ToLower(value))
+	}
+
+	line1, postal := normalize(a.Line1), normalize(a.PostalCode)
+	return line1 != "" && postal != "" && line1 == normalize(b.Line1) && postal == normalize(b.PostalCode)
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// payReferral issues the referrer's reward for a converted referral. The referral is
+// claimed in a transaction first so a reward is never paid twice.
+func (h *ReferralHandler) payReferral(referralID, reviewedBy string) (*models.Referral, error) {
+	settings := getReferralSettings(h.db)
+	rewardType := settings.RewardType
+	if rewardType == "" {
+		rewardType = models.ReferralRewardStoreCredit
+	}
+	if rewardType == models.ReferralRewardStoreCredit && settings.RewardAmount <= 0 {
+		return nil, fmt.Errorf("referral reward amount is not configured")
+	}
+	if rewardType == models.ReferralRewardCoupon && settings.RewardPromotionID == "" {
+		return nil, fmt.Errorf("referral reward promotion is not configured")
+	}
+
+	ref := h.db.Client.Collection("referrals").Doc(referralID)
+	var referral models.Referral
+	var previousStatus models.ReferralStatus
+	err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&referral); err != nil {
+			return err
+		}
+		if referral.OrderID == "" {
+			return fmt.Errorf("the referee's first order hasn't been delivered yet")
+		}
+		if referral.Status != models.ReferralStatusPending && referral.Status != models.ReferralStatusFlagged {
+			return fmt.Errorf("referral is already %s", referral.Status)
+		}
+
+		previousStatus = referral.Status
+		now := time.Now()
+		referral.Status = models.ReferralStatusRewarded
+		referral.RewardType = rewardType
+		referral.RewardedAt = &now
+		referral.ReviewedBy = reviewedBy
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: referral.Status},
+			{Path: "reward_type", Value: rewardType},
+			{Path: "rewarded_at", Value: now},
+			{Path: "reviewed_by", Value: reviewedBy},
+			{Path: "updated_at", Value: now},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rewardErr error
+	switch rewardType {
+	case models.ReferralRewardCoupon:
+		referral.RewardCode, referral.RewardAmount, rewardErr = h.issueReferralCoupon(settings.RewardPromotionID, referralID)
+	default:
+		referral.RewardAmount = settings.RewardAmount
+		referral.RewardGiftCardID, rewardErr = h.issueReferralCredit(&referral, settings)
+	}
+
+	if rewardErr != nil {
+		// Release the claim so the payout can be retried
+		ref.Update(h.db.Context, []firestore.Update{
+			{Path: "status", Value: previousStatus},
+			{Path: "rewarded_at", Value: firestore.Delete},
+			{Path: "updated_at", Value: time.Now()},
+		})
+		return nil, rewardErr
+	}
+
+	_, err = ref.Update(h.db.Context, []firestore.Update{
+		{Path: "reward_amount", Value: referral.RewardAmount},
+		{Path: "reward_gift_card_id", Value: referral.RewardGiftCardID},
+		{Path: "reward_code", Value: referral.RewardCode},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	if err != nil {
+		log.Printf("Failed to record reward for referral %s: %v", referralID, err)
+	}
+
+	return &referral, nil
+}
+
+// issueReferralCredit gives the referrer store credit as a gift card
+func (h *ReferralHandler) issueReferralCredit(referral *models.Referral, settings ReferralSettings) (string, error) {
+	doc, err := h.db.Client.Collection("mobile_users").Doc(referral.ReferrerID).Get(h.db.Context)
+	if err != nil {
+		return "", fmt.Errorf("referrer not found: %v", err)
+	}
+	var referrer models.MobileUser
+	if err := doc.DataTo(&referrer); err != nil {
+		return "", err
+	}
+
+	name := referrer.Name
+	if name == "" {
+		name = strings.TrimSpace(referrer.Profile.FirstName + " " + referrer.Profile.LastName)
+	}
+
+	card, err := h.giftCardHandler.issueGiftCard(issueGiftCardParams{
+		Amount: settings.RewardAmount,
+		Recipient: models.GiftCardRecipient{
+			Name:    name,
+			Email:   referrer.Email,
+			Phone:   referrer.MobileNumber,
+			Message: "Thank you for referring a friend to TRIPUND!",
+		},
+		PurchaserUserID: referral.ReferrerID,
+		PurchaserName:   "TRIPUND Lifestyle",
+		ValidityMonths:  settings.RewardValidityMonths,
+		CreatedBy:       "referral:" + referral.ID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := h.giftCardHandler.deliverGiftCard(card); err != nil {
+		log.Printf("Failed to deliver referral gift card %s: %v", card.ID, err)
+	}
+	return card.ID, nil
+}
+
+// issueReferralCoupon creates a single-use code under the reward promotion
+func (h *ReferralHandler) issueReferralCoupon(promoID, referralID string) (string, float64, error) {
+	promoDoc, err := h.db.Client.Collection("promotions").Doc(promoID).Get(h.db.Context)
+	if err != nil {
+		return "", 0, fmt.Errorf("reward promotion not found: %v", err)
+	}
+	var promo models.Promotion
+	if err := promoDoc.DataTo(&promo); err != nil {
+		return "", 0, err
+	}
+
+	generated, err := h.promotionHandler.generateUniquePromotionCodes("REF", defaultPromotionCodeLength, 1)
+	if err != nil {
+		return "", 0, err
+	}
+
+	now := time.Now()
+	code := models.PromotionCode{
+		Code:        generated[0],
+		PromotionID: promoID,
+		BatchID:     "referral_" + referralID,
+		Status:      models.PromotionCodeAvailable,
+		CreatedAt:   now,
+	}
+	if _, err := h.db.Client.Collection("promotion_codes").Doc(code.Code).Create(h.db.Context, code); err != nil {
+		return "", 0, err
+	}
+
+	promoDoc.Ref.Update(h.db.Context, []firestore.Update{
+		{Path: "unique_codes", Value: true},
+		{Path: "unique_code_count", Value: firestore.Increment(1)},
+		{Path: "updated_at", Value: now},
+	})
+
+	// Percentage coupons have no fixed value; record the fixed amount when there is one
+	var amount float64
+	if promo.Type == models.PromotionTypeFixed {
+		amount = promo.Discount
+	}
+	return code.Code, amount, nil
+}
+
+// Admin endpoints
+
+// GetReferrals reports referrals, conversions and payouts (admin)
+func (h *ReferralHandler) GetReferrals(c *gin.Context) {
+	statusFilter := c.Query("status")
+	referrerFilter := c.Query("referrer_id")
+
+	docs, err := h.db.Client.Collection("referrals").Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referrals"})
+		return
+	}
+
+	type referrerStats struct {
+		ReferrerID  string  `json:"referrer_id"`
+		Referrals   int     `json:"referrals"`
+		Conversions int     `json:"conversions"`
+		Payouts     float64 `json:"payouts"`
+	}
+
+	referrals := make([]models.Referral, 0)
+	byReferrer := make(map[string]*referrerStats)
+	counts := make(map[models.ReferralStatus]int)
+	var conversions, couponsIssued, giftCardsIssued int
+	var payouts, convertedRevenue float64
+
+	for _, doc := range docs {
+		var referral models.Referral
+		if err := doc.DataTo(&referral); err != nil {
+			continue
+		}
+		referral.ID = doc.Ref.ID
+
+		// Summary covers every referral; the list honours the filters
+		counts[referral.Status]++
+		stats := byReferrer[referral.ReferrerID]
+		if stats == nil {
+			stats = &referrerStats{ReferrerID: referral.ReferrerID}
+			byReferrer[referral.ReferrerID] = stats
+		}
+		stats.Referrals++
+		if referral.OrderID != "" {
+			conversions++
+			stats.Conversions++
+			convertedRevenue += referral.OrderTotal
+		}
+		if referral.Status == models.ReferralStatusRewarded {
+			payouts += referral.RewardAmount
+			stats.Payouts += referral.RewardAmount
+			if referral.RewardCode != "" {
+				couponsIssued++
+			} else {
+				giftCardsIssued++
+			}
+		}
+
+		if statusFilter != "" && string(referral.Status) != statusFilter {
+			continue
+		}
+		if referrerFilter != "" && referral.ReferrerID != referrerFilter {
+			continue
+		}
+		referrals = append(referrals, referral)
+	}
+
+	sort.Slice(referrals, func(i, j int) bool {
+		return referrals[i].CreatedAt.After(referrals[j].CreatedAt)
+	})
+
+	topReferrers := make([]referrerStats, 0, len(byReferrer))
+	for _, stats := range byReferrer {
+		stats.Payouts = math.Round(stats.Payouts*100) / 100
+		topReferrers = append(topReferrers, *stats)
+	}
+	sort.Slice(topReferrers, func(i, j int) bool {
+		if topReferrers[i].Conversions != topReferrers[j].Conversions {
+			return topReferrers[i].Conversions > topReferrers[j].Conversions
+		}
+		return topReferrers[i].ReferrerID < topReferrers[j].ReferrerID
+	})
+	if len(topReferrers) > 20 {
+		topReferrers = topReferrers[:20]
+	}
+
+	conversionRate := 0.0
+	if len(docs) > 0 {
+		conversionRate = math.Round(float64(conversions)/float64(len(docs))*10000) / 100
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"referrals": referrals,
+		"count":     len(referrals),
+		"summary": gin.H{
+			"total":             len(docs),
+			"pending":           counts[models.ReferralStatusPending],
+			"flagged":           counts[models.ReferralStatusFlagged],
+			"rewarded":          counts[models.ReferralStatusRewarded],
+			"rejected":          counts[models.ReferralStatusRejected],
+			"conversions":       conversions,
+			"conversion_rate":   conversionRate,
+			"converted_revenue": math.Round(convertedRevenue*100) / 100,
+			"payouts":           math.Round(payouts*100) / 100,
+			"gift_cards_issued": giftCardsIssued,
+			"coupons_issued":    couponsIssued,
+		},
+		"top_referrers": topReferrers,
+	})
+}
+
+// ApproveReferral pays out a referral held by fraud checks (admin)
+func (h *ReferralHandler) ApproveReferral(c *gin.Context) {
+	var req models.ReviewReferralRequest
+	c.ShouldBindJSON(&req) // The review note is optional
+
+	referral, err := h.payReferral(c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Referral not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Note != "" {
+		h.db.Client.Collection("referrals").Doc(referral.ID).Update(h.db.Context, []firestore.Update{
+			{Path: "review_note", Value: req.Note},
+		})
+		referral.ReviewNote = req.Note
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Referral reward issued successfully",
+		"referral": referral,
+	})
+}
+
+// RejectReferral closes a referral without a payout (admin)
+func (h *ReferralHandler) RejectReferral(c *gin.Context) {
+	var req models.ReviewReferralRequest
+	c.ShouldBindJSON(&req) // The review note is optional
+
+	ref := h.db.Client.Collection("referrals").Doc(c.Param("id"))
+	err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var referral models.Referral
+		if err := doc.DataTo(&referral); err != nil {
+			return err
+		}
+		if referral.Status == models.ReferralStatusRewarded {
+			return fmt.Errorf("referral has already been rewarded")
+		}
+
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: models.ReferralStatusRejected},
+			{Path: "reviewed_by", Value: c.GetString("user_id")},
+			{Path: "review_note", Value: req.Note},
+			{Path: "updated_at", Value: time.Now()},
+		})
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Referral not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Referral rejected"})
+}
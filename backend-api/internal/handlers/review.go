@@ -0,0 +1,614 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+	"tripund-api/internal/services"
+)
+
+// Limits on what a review may contain
+const (
+	reviewMaxTitleLength = 120
+	reviewMaxBodyLength  = 2000
+	reviewMaxPhotos      = 5
+)
+
+// Review list sort orders
+const (
+	reviewSortNewest  = "newest"
+	reviewSortHighest = "highest"
+	reviewSortLowest  = "lowest"
+	reviewSortPhotos  = "photos"
+)
+
+type ReviewHandler struct {
+	db                  *database.Firebase
+	notificationHandler *NotificationHandler
+	emailService        *services.SendGridEmailService
+}
+
+func NewReviewHandler(db *database.Firebase) *ReviewHandler {
+	emailService, err := services.NewSendGridEmailService()
+	if err != nil {
+		log.Printf("WARNING: Review request emails disabled: %v", err)
+		emailService = nil
+	}
+	return &ReviewHandler{
+		db:                  db,
+		notificationHandler: NewNotificationHandler(db),
+		emailService:        emailService,
+	}
+}
+
+// reviewID keys reviews by product and customer, which allows one review each
+func reviewID(productID, userID string) string {
+	return productID + "_" + userID
+}
+
+// reviewerName shows the customer's first name and last initial, e.g. "Priya S."
+func reviewerName(user *models.MobileUser) string {
+	first, last := user.Profile.FirstName, user.Profile.LastName
+	if first == "" {
+		parts := strings.Fields(user.Name)
+		if len(parts) > 0 {
+			first = parts[0]
+		}
+		if len(parts) > 1 {
+			last = parts[len(parts)-1]
+		}
+	}
+	if first == "" {
+		return "TRIPUND Customer"
+	}
+	if last = strings.TrimSpace(last); last != "" {
+		return first + " " + strings.ToUpper(string([]rune(last)[:1])) + "."
+	}
+	return first
+}
+
+// validateReviewContent trims the review text and checks it and the photo URLs
+func validateReviewContent(title, body *string, photos []string) string {
+	*title = strings.TrimSpace(*title)
+	*body = strings.TrimSpace(*body)
+	if *body == "" {
+		return "Review text is required"
+	}
+	if len([]rune(*title)) > reviewMaxTitleLength {
+		return "Title must be at most " + strconv.Itoa(reviewMaxTitleLength) + " characters"
+	}
+	if len([]rune(*body)) > reviewMaxBodyLength {
+		return "Review must be at most " + strconv.Itoa(reviewMaxBodyLength) + " characters"
+	}
+	if len(photos) > reviewMaxPhotos {
+		return "At most " + strconv.Itoa(reviewMaxPhotos) + " photos can be added"
+	}
+	for _, photo := range photos {
+		if !isImageURL(photo) {
+			return "Photos must be uploaded image URLs"
+		}
+	}
+	return ""
+}
+
+// findDeliveredOrder returns the ID of a delivered order of the customer's containing
+// the product, or "" when there is none
+func (h *ReviewHandler) findDeliveredOrder(userID, productID string) string {
+	docs, err := h.db.Client.Collection("orders").Where("user_id", "==", userID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		log.Printf("Failed to load orders to verify review purchase: %v", err)
+		return ""
+	}
+	for _, doc := range docs {
+		var order models.Order
+		if err := doc.DataTo(&order); err != nil || order.Status != "delivered" {
+			continue
+		}
+		for _, item := range order.Items {
+			if item.ProductID == productID {
+				return doc.Ref.ID
+			}
+		}
+	}
+	return ""
+}
+
+// loadProductReviews returns every review of a product, filtering by status in memory
+// to avoid a composite index; an empty status returns them all
+func (h *ReviewHandler) loadProductReviews(productID, reviewStatus string) ([]models.Review, error) {
+	docs, err := h.db.Client.Collection("reviews").Where("product_id", "==", productID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	reviews := make([]models.Review, 0, len(docs))
+	for _, doc := range docs {
+		var review models.Review
+		if err := doc.DataTo(&review); err != nil {
+			continue
+		}
+		if reviewStatus != "" && review.Status != reviewStatus {
+			continue
+		}
+		review.ID = doc.Ref.ID
+		reviews = append(reviews, review)
+	}
+	return reviews, nil
+}
+
+// summarizeReviews computes the rating breakdown of approved reviews
+func summarizeReviews(reviews []models.Review) models.ReviewSummary {
+	summary := models.ReviewSummary{Distribution: map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}}
+	total := 0
+	for _, review := range reviews {
+		summary.ReviewCount++
+		summary.Distribution[review.Rating]++
+		total += review.Rating
+		if review.VerifiedPurchase {
+			summary.Verified++
+		}
+		if len(review.Photos) > 0 {
+			summary.WithPhotos++
+		}
+	}
+	if summary.ReviewCount > 0 {
+		summary.AverageRating = math.Round(float64(total)/float64(summary.ReviewCount)*10) / 10
+	}
+	return summary
+}
+
+// refreshProductRating recomputes the product's average rating and review count from
+// its approved reviews
+func (h *ReviewHandler) refreshProductRating(productID string) {
+	reviews, err := h.loadProductReviews(productID, models.ReviewStatusApproved)
+	if err != nil {
+		log.Printf("Failed to load reviews to rate product %s: %v", productID, err)
+		return
+	}
+	summary := summarizeReviews(reviews)
+
+	_, err = h.db.Client.Collection("products").Doc(productID).Update(h.db.Context, []firestore.Update{
+		{Path: "average_rating", Value: summary.AverageRating},
+		{Path: "review_count", Value: summary.ReviewCount},
+	})
+	if err != nil {
+		log.Printf("Failed to update rating of product %s: %v", productID, err)
+		return
+	}
+	productListings.clear()
+}
+
+// GetProductReviews lists a product's approved reviews with the rating breakdown.
+// Reviews can be sorted newest, highest, lowest or photos first and filtered by
+// ?rating= and ?verified=true.
+func (h *ReviewHandler) GetProductReviews(c *gin.Context) {
+	reviews, err := h.loadProductReviews(c.Param("id"), models.ReviewStatusApproved)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reviews"})
+		return
+	}
+	summary := summarizeReviews(reviews)
+
+	rating, _ := strconv.Atoi(c.Query("rating"))
+	verifiedOnly := c.Query("verified") == "true"
+	filtered := make([]models.Review, 0, len(reviews))
+	for _, review := range reviews {
+		if (rating > 0 && review.Rating != rating) || (verifiedOnly && !review.VerifiedPurchase) {
+			continue
+		}
+		review.UserID = ""
+		review.OrderID = ""
+		filtered = append(filtered, review)
+	}
+
+	sortBy := c.DefaultQuery("sort", reviewSortNewest)
+	sort.SliceStable(filtered, func(i, j int) bool {
+		a, b := filtered[i], filtered[j]
+		switch sortBy {
+		case reviewSortHighest:
+			if a.Rating != b.Rating {
+				return a.Rating > b.Rating
+			}
+		case reviewSortLowest:
+			if a.Rating != b.Rating {
+				return a.Rating < b.Rating
+			}
+		case reviewSortPhotos:
+			if (len(a.Photos) > 0) != (len(b.Photos) > 0) {
+				return len(a.Photos) > 0
+			}
+		}
+		return a.CreatedAt.After(b.CreatedAt)
+	})
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 50 {
+		limit = 10
+	}
+	start := min((page-1)*limit, len(filtered))
+	end := min(start+limit, len(filtered))
+
+	c.JSON(http.StatusOK, gin.H{
+		"reviews": filtered[start:end],
+		"summary": summary,
+		"total":   len(filtered),
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+// CreateReview submits the customer's review of a product for moderation. It is
+// marked as a verified purchase when they have a delivered order containing it.
+func (h *ReviewHandler) CreateReview(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req models.CreateReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateReviewContent(&req.Title, &req.Body, req.Photos); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	productDoc, err := h.db.Client.Collection("products").Doc(req.ProductID).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	var product models.Product
+	if err := database.DecodeProduct(productDoc, &product); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse product data"})
+		return
+	}
+
+	userDoc, err := h.db.Client.Collection("mobile_users").Doc(userID).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	var user models.MobileUser
+	if err := userDoc.DataTo(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user data"})
+		return
+	}
+
+	now := time.Now()
+	review := models.Review{
+		ID:          reviewID(req.ProductID, userID),
+		ProductID:   req.ProductID,
+		ProductName: product.Name,
+		UserID:      userID,
+		UserName:    reviewerName(&user),
+		Rating:      req.Rating,
+		Title:       req.Title,
+		Body:        req.Body,
+		Photos:      req.Photos,
+		OrderID:     h.findDeliveredOrder(userID, req.ProductID),
+		Status:      models.ReviewStatusPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	review.VerifiedPurchase = review.OrderID != ""
+
+	if _, err := h.db.Client.Collection("reviews").Doc(review.ID).Create(h.db.Context, review); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "You have already reviewed this product, edit your review instead"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save review"})
+		return
+	}
+
+	h.notificationHandler.NotifyNewReview(review.ID, product.Name, review.Rating)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Thank you! Your review will appear once it has been approved.",
+		"review":  review,
+	})
+}
+
+// GetMyReviews lists the customer's reviews in every state
+func (h *ReviewHandler) GetMyReviews(c *gin.Context) {
+	docs, err := h.db.Client.Collection("reviews").Where("user_id", "==", c.GetString("user_id")).Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reviews"})
+		return
+	}
+
+	reviews := make([]models.Review, 0, len(docs))
+	for _, doc := range docs {
+		var review models.Review
+		if err := doc.DataTo(&review); err == nil {
+			review.ID = doc.Ref.ID
+			reviews = append(reviews, review)
+		}
+	}
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].CreatedAt.After(reviews[j].CreatedAt) })
+
+	c.JSON(http.StatusOK, gin.H{"reviews": reviews, "count": len(reviews)})
+}
+
+// getOwnReview loads a review and checks it belongs to the customer
+func (h *ReviewHandler) getOwnReview(c *gin.Context) (*models.Review, bool) {
+	doc, err := h.db.Client.Collection("reviews").Doc(c.Param("id")).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return nil, false
+	}
+	var review models.Review
+	if err := doc.DataTo(&review); err != nil || review.UserID != c.GetString("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return nil, false
+	}
+	review.ID = doc.Ref.ID
+	return &review, true
+}
+
+// UpdateReview edits the customer's review, which then goes back to moderation
+func (h *ReviewHandler) UpdateReview(c *gin.Context) {
+	review, ok := h.getOwnReview(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateReviewRequest
+	req.ProductID = review.ProductID
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateReviewContent(&req.Title, &req.Body, req.Photos); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	wasApproved := review.Status == models.ReviewStatusApproved
+	review.Rating = req.Rating
+	review.Title = req.Title
+	review.Body = req.Body
+	review.Photos = req.Photos
+	review.Status = models.ReviewStatusPending
+	review.RejectionReason = ""
+	review.UpdatedAt = time.Now()
+	// A delivery since the first review now verifies it
+	if !review.VerifiedPurchase {
+		review.OrderID = h.findDeliveredOrder(review.UserID, review.ProductID)
+		review.VerifiedPurchase = review.OrderID != ""
+	}
+
+	if _, err := h.db.Client.Collection("reviews").Doc(review.ID).Set(h.db.Context, review); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update review"})
+		return
+	}
+	if wasApproved {
+		h.refreshProductRating(review.ProductID)
+	}
+	h.notificationHandler.NotifyNewReview(review.ID, review.ProductName, review.Rating)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Your review has been updated and will appear once it has been approved.",
+		"review":  review,
+	})
+}
+
+// DeleteReview removes the customer's own review
+func (h *ReviewHandler) DeleteReview(c *gin.Context) {
+	review, ok := h.getOwnReview(c)
+	if !ok {
+		return
+	}
+	h.deleteReview(c, review)
+}
+
+func (h *ReviewHandler) deleteReview(c *gin.Context, review *models.Review) {
+	if _, err := h.db.Client.Collection("reviews").Doc(review.ID).Delete(h.db.Context); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete review"})
+		return
+	}
+	if review.Status == models.ReviewStatusApproved {
+		h.refreshProductRating(review.ProductID)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Review deleted successfully"})
+}
+
+// GetAdminReviews lists reviews for moderation, newest first, optionally filtered by
+// ?status= and ?product_id=
+func (h *ReviewHandler) GetAdminReviews(c *gin.Context) {
+	var reviews []models.Review
+	if productID := c.Query("product_id"); productID != "" {
+		var err error
+		if reviews, err = h.loadProductReviews(productID, c.Query("status")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reviews"})
+			return
+		}
+	} else {
+		query := h.db.Client.Collection("reviews").Query
+		if reviewStatus := c.Query("status"); reviewStatus != "" {
+			query = query.Where("status", "==", reviewStatus)
+		}
+		docs, err := query.Documents(h.db.Context).GetAll()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reviews"})
+			return
+		}
+		for _, doc := range docs {
+			var review models.Review
+			if err := doc.DataTo(&review); err == nil {
+				review.ID = doc.Ref.ID
+				reviews = append(reviews, review)
+			}
+		}
+	}
+	if reviews == nil {
+		reviews = []models.Review{}
+	}
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].CreatedAt.After(reviews[j].CreatedAt) })
+
+	pending := 0
+	for _, review := range reviews {
+		if review.Status == models.ReviewStatusPending {
+			pending++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reviews": reviews, "count": len(reviews), "pending": pending})
+}
+
+// getReview loads a review for the admin endpoints
+func (h *ReviewHandler) getReview(c *gin.Context) (*models.Review, bool) {
+	doc, err := h.db.Client.Collection("reviews").Doc(c.Param("id")).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return nil, false
+	}
+	var review models.Review
+	if err := doc.DataTo(&review); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse review"})
+		return nil, false
+	}
+	review.ID = doc.Ref.ID
+	return &review, true
+}
+
+// ModerateReview approves or rejects a review and updates the product's rating
+func (h *ReviewHandler) ModerateReview(c *gin.Context) {
+	review, ok := h.getReview(c)
+	if !ok {
+		return
+	}
+
+	var req models.ModerateReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status != models.ReviewStatusApproved && req.Status != models.ReviewStatusRejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be approved or rejected"})
+		return
+	}
+	if req.Status == models.ReviewStatusRejected && strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to reject a review"})
+		return
+	}
+
+	now := time.Now()
+	updates := []firestore.Update{
+		{Path: "status", Value: req.Status},
+		{Path: "rejection_reason", Value: strings.TrimSpace(req.Reason)},
+		{Path: "moderated_by", Value: c.GetString("user_id")},
+		{Path: "moderated_at", Value: now},
+		{Path: "updated_at", Value: now},
+	}
+	if req.Status == models.ReviewStatusApproved {
+		updates[1].Value = firestore.Delete
+	}
+	if _, err := h.db.Client.Collection("reviews").Doc(review.ID).Update(h.db.Context, updates); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to moderate review"})
+		return
+	}
+
+	if review.Status != req.Status {
+		h.refreshProductRating(review.ProductID)
+	}
+	log.Printf("Review %s %s by %s", review.ID, req.Status, c.GetString("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Review " + req.Status})
+}
+
+// ReplyToReview sets the store's public reply to a review, replacing any earlier one
+func (h *ReviewHandler) ReplyToReview(c *gin.Context) {
+	review, ok := h.getReview(c)
+	if !ok {
+		return
+	}
+
+	var req models.ReplyReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Text = strings.TrimSpace(req.Text); req.Text == "" || len([]rune(req.Text)) > reviewMaxBodyLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reply must be between 1 and " + strconv.Itoa(reviewMaxBodyLength) + " characters"})
+		return
+	}
+
+	reply := models.ReviewReply{
+		Text:      req.Text,
+		RepliedBy: c.GetString("user_id"),
+		RepliedAt: time.Now(),
+	}
+	_, err := h.db.Client.Collection("reviews").Doc(review.ID).Update(h.db.Context, []firestore.Update{
+		{Path: "reply", Value: reply},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save reply"})
+		return
+	}
+
+	review.Reply = &reply
+	c.JSON(http.StatusOK, review)
+}
+
+// AdminDeleteReview removes any review
+func (h *ReviewHandler) AdminDeleteReview(c *gin.Context) {
+	review, ok := h.getReview(c)
+	if !ok {
+		return
+	}
+	h.deleteReview(c, review)
+}
+
+// SendReviewRequest emails the customer of a delivered order inviting them to review
+// its products. Each order is only asked about once.
+func (h *ReviewHandler) SendReviewRequest(order models.Order) error {
+	if h.emailService == nil {
+		return nil
+	}
+
+	request := models.ReviewRequest{
+		OrderID:     order.ID,
+		OrderNumber: order.OrderNumber,
+		UserID:      order.UserID,
+		SentAt:      time.Now(),
+	}
+	for _, item := range order.Items {
+		if item.ProductType != models.ProductTypeGiftCard {
+			request.ProductIDs = append(request.ProductIDs, item.ProductID)
+		}
+	}
+	if len(request.ProductIDs) == 0 {
+		return nil
+	}
+
+	// Create fails if this order was already asked about
+	ref := h.db.Client.Collection("review_requests").Doc(order.ID)
+	if _, err := ref.Create(h.db.Context, request); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return nil
+		}
+		return err
+	}
+
+	if err := h.emailService.SendReviewRequest(order); err != nil {
+		ref.Delete(h.db.Context)
+		return err
+	}
+	log.Printf("Review request sent for order %s", order.OrderNumber)
+	return nil
+}
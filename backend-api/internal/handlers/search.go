@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+	"tripund-api/internal/search"
+)
+
+// suggestCacheMaxAge is how long categories and popular queries are reused by autocomplete
+const suggestCacheMaxAge = 5 * time.Minute
+
+// suggestCache holds what autocomplete needs besides the product index, so suggestions
+// don't read Firestore on every keystroke
+type suggestCache struct {
+	categories []models.Category
+	queries    []models.SearchQueryStats
+	loadedAt   time.Time
+}
+
+// ProductSuggestion is a product shown in autocomplete
+type ProductSuggestion struct {
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Slug         string       `json:"slug"`
+	Image        string       `json:"image,omitempty"`
+	Price        float64      `json:"price"`
+	SalePrice    models.Money `json:"sale_price,omitempty"`
+	CurrentPrice float64      `json:"current_price"`
+}
+
+// CategorySuggestion is a category or subcategory shown in autocomplete
+type CategorySuggestion struct {
+	Name   string `json:"name"`
+	Slug   string `json:"slug"`
+	Parent string `json:"parent,omitempty"` // Set for subcategories
+}
+
+// searchTerm normalises a query for grouping search logs, so "Brass Diyas" and
+// "brass diya" count as the same search
+func searchTerm(query string) string {
+	term := strings.Join(search.Tokenize(query), " ")
+	if term == "" {
+		term = strings.ToLower(strings.TrimSpace(query))
+	}
+	if len(term) > 100 {
+		term = term[:100]
+	}
+	return term
+}
+
+// logSearch records a search and updates the query's running totals
+func (h *ProductHandler) logSearch(query string, results int, userID string) {
+	term := searchTerm(query)
+	if term == "" || strings.Contains(term, "/") {
+		return
+	}
+	now := time.Now()
+
+	_, _, err := h.db.Client.Collection("search_logs").Add(h.db.Context, models.SearchLog{
+		Query:     query,
+		Term:      term,
+		Results:   results,
+		UserID:    userID,
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Printf("Failed to log search %q: %v", query, err)
+	}
+
+	zeroResults := 0
+	if results == 0 {
+		zeroResults = 1
+	}
+	_, err = h.db.Client.Collection("search_queries").Doc(term).Set(h.db.Context, map[string]interface{}{
+		"term":             term,
+		"query":            query,
+		"count":            firestore.Increment(1),
+		"zero_results":     firestore.Increment(zeroResults),
+		"last_results":     results,
+		"last_searched_at": now,
+	}, firestore.MergeAll)
+	if err != nil {
+		log.Printf("Failed to update search stats for %q: %v", term, err)
+	}
+}
+
+// loadSearchSynonyms applies the saved synonym dictionary to the search index
+func (h *ProductHandler) loadSearchSynonyms() error {
+	synonyms, err := h.getSearchSynonyms()
+	if err != nil {
+		return err
+	}
+
+	groups := make([][]string, 0, len(synonyms))
+	for _, synonym := range synonyms {
+		groups = append(groups, synonym.Terms)
+	}
+	h.searchIndex.SetSynonyms(groups)
+	return nil
+}
+
+func (h *ProductHandler) getSearchSynonyms() ([]models.SearchSynonym, error) {
+	docs, err := h.db.Client.Collection("search_synonyms").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	synonyms := make([]models.SearchSynonym, 0, len(docs))
+	for _, doc := range docs {
+		var synonym models.SearchSynonym
+		if err := doc.DataTo(&synonym); err != nil {
+			continue
+		}
+		synonym.ID = doc.Ref.ID
+		synonyms = append(synonyms, synonym)
+	}
+	return synonyms, nil
+}
+
+// getSuggestCache returns categories and popular queries, reloading them when stale
+func (h *ProductHandler) getSuggestCache() *suggestCache {
+	h.suggestMu.Lock()
+	defer h.suggestMu.Unlock()
+
+	if h.suggestCache != nil && time.Since(h.suggestCache.loadedAt) < suggestCacheMaxAge {
+		return h.suggestCache
+	}
+
+	cache := &suggestCache{loadedAt: time.Now()}
+	if docs, err := h.db.Client.Collection("categories").Documents(h.db.Context).GetAll(); err == nil {
+		for _, doc := range docs {
+			var category models.Category
+			if err := doc.DataTo(&category); err != nil {
+				continue
+			}
+			category.ID = doc.Ref.ID
+			cache.categories = append(cache.categories, category)
+		}
+	} else {
+		log.Printf("Failed to load categories for suggestions: %v", err)
+	}
+
+	docs, err := h.db.Client.Collection("search_queries").OrderBy("count", firestore.Desc).Limit(200).Documents(h.db.Context).GetAll()
+	if err == nil {
+		for _, doc := range docs {
+			var stats models.SearchQueryStats
+			if err := doc.DataTo(&stats); err != nil || stats.LastResults == 0 {
+				continue
+			}
+			cache.queries = append(cache.queries, stats)
+		}
+	} else {
+		log.Printf("Failed to load popular searches for suggestions: %v", err)
+	}
+
+	h.suggestCache = cache
+	return cache
+}
+
+// matchesWordPrefix reports whether any word of text starts with prefix
+func matchesWordPrefix(text, prefix string) bool {
+	text = strings.ToLower(text)
+	return strings.HasPrefix(text, prefix) || strings.Contains(text, " "+prefix)
+}
+
+// SuggestProducts returns autocomplete suggestions for a partial query: matching
+// products, categories and popular searches
+func (h *ProductHandler) SuggestProducts(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query required"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "5"))
+	if limit < 1 || limit > 20 {
+		limit = 5
+	}
+
+	if err := h.ensureSearchIndex(); err != nil {
+		log.Printf("Failed to build search index: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load suggestions"})
+		return
+	}
+
+	products := make([]ProductSuggestion, 0, limit)
+	if hits, _ := h.searchIndex.Search(query, 0, limit); len(hits) > 0 {
+		refs := make([]*firestore.DocumentRef, len(hits))
+		for i, hit := range hits {
+			refs[i] = h.db.Client.Collection("products").Doc(hit.ID)
+		}
+		docs, err := h.db.Client.GetAll(h.db.Context, refs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load suggestions"})
+			return
+		}
+		for _, doc := range docs {
+			var product models.Product
+			if !doc.Exists() || database.DecodeProduct(doc, &product) != nil || product.Status != "active" {
+				continue
+			}
+			suggestion := ProductSuggestion{
+				ID:           doc.Ref.ID,
+				Name:         product.Name,
+				Slug:         product.Slug,
+				Price:        product.Price,
+				SalePrice:    product.SalePrice,
+				CurrentPrice: product.EffectivePrice(),
+			}
+			if len(product.Images) > 0 {
+				suggestion.Image = product.Images[0]
+			}
+			products = append(products, suggestion)
+		}
+	}
+
+	prefix := strings.ToLower(query)
+	cache := h.getSuggestCache()
+
+	categories := make([]CategorySuggestion, 0)
+	for _, category := range cache.categories {
+		if len(categories) >= limit {
+			break
+		}
+		if matchesWordPrefix(category.Name, prefix) {
+			categories = append(categories, CategorySuggestion{Name: category.Name, Slug: category.Slug})
+		}
+		for _, child := range category.Children {
+			if len(categories) < limit && matchesWordPrefix(child.Name, prefix) {
+				categories = append(categories, CategorySuggestion{
+					Name:   child.Name,
+					Slug:   strings.ReplaceAll(strings.ToLower(child.Name), " ", "-"),
+					Parent: category.Name,
+				})
+			}
+		}
+	}
+
+	queries := make([]string, 0)
+	for _, stats := range cache.queries {
+		if len(queries) >= limit {
+			break
+		}
+		if strings.ToLower(stats.Query) != prefix && matchesWordPrefix(stats.Query, prefix) {
+			queries = append(queries, stats.Query)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":      query,
+		"products":   products,
+		"categories": categories,
+		"queries":    queries,
+	})
+}
+
+// Admin endpoints
+
+// GetSearchSynonyms lists the synonym dictionary
+func (h *ProductHandler) GetSearchSynonyms(c *gin.Context) {
+	synonyms, err := h.getSearchSynonyms()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch synonyms"})
+		return
+	}
+
+	sort.Slice(synonyms, func(i, j int) bool {
+		return synonyms[i].CreatedAt.After(synonyms[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"synonyms": synonyms,
+		"count":    len(synonyms),
+	})
+}
+
+// normalizeSynonymTerms trims, lowercases and de-duplicates a synonym group
+func normalizeSynonymTerms(terms []string) ([]string, error) {
+	normalized := make([]string, 0, len(terms))
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if term == "" || seen[term] {
+			continue
+		}
+		seen[term] = true
+		if len(search.Tokenize(term)) == 0 {
+			return nil, fmt.Errorf("%q is not a searchable word", term)
+		}
+		normalized = append(normalized, term)
+	}
+	if len(normalized) < 2 {
+		return nil, fmt.Errorf("a synonym group needs at least two different terms")
+	}
+	return normalized, nil
+}
+
+// CreateSearchSynonym adds a synonym group and applies it to searches straight away
+func (h *ProductHandler) CreateSearchSynonym(c *gin.Context) {
+	var synonym models.SearchSynonym
+	if err := c.ShouldBindJSON(&synonym); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	terms, err := normalizeSynonymTerms(synonym.Terms)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	synonym.Terms = terms
+	synonym.CreatedAt = now
+	synonym.UpdatedAt = now
+	synonym.CreatedBy = c.GetString("user_id")
+
+	docRef, _, err := h.db.Client.Collection("search_synonyms").Add(h.db.Context, synonym)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create synonym"})
+		return
+	}
+	synonym.ID = docRef.ID
+
+	if err := h.loadSearchSynonyms(); err != nil {
+		log.Printf("Failed to reload search synonyms: %v", err)
+	}
+
+	c.JSON(http.StatusCreated, synonym)
+}
+
+// UpdateSearchSynonym replaces the terms of a synonym group
+func (h *ProductHandler) UpdateSearchSynonym(c *gin.Context) {
+	synonymID := c.Param("id")
+
+	var req models.SearchSynonym
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	terms, err := normalizeSynonymTerms(req.Terms)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = h.db.Client.Collection("search_synonyms").Doc(synonymID).Update(h.db.Context, []firestore.Update{
+		{Path: "terms", Value: terms},
+		{Path: "note", Value: req.Note},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Synonym not found"})
+		return
+	}
+
+	if err := h.loadSearchSynonyms(); err != nil {
+		log.Printf("Failed to reload search synonyms: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Synonym updated successfully"})
+}
+
+// DeleteSearchSynonym removes a synonym group
+func (h *ProductHandler) DeleteSearchSynonym(c *gin.Context) {
+	if _, err := h.db.Client.Collection("search_synonyms").Doc(c.Param("id")).Delete(h.db.Context); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete synonym"})
+		return
+	}
+
+	if err := h.loadSearchSynonyms(); err != nil {
+		log.Printf("Failed to reload search synonyms: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Synonym deleted successfully"})
+}
+
+// GetSearchInsights reports the most frequent searches and the searches that found
+// nothing over the last `days` days (default 30)
+func (h *ProductHandler) GetSearchInsights(c *gin.Context) {
+	since := analyticsStartDate(c)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 200 {
+		limit = 20
+	}
+
+	docs, err := h.db.Client.Collection("search_logs").Where("created_at", ">=", since).Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch search logs"})
+		return
+	}
+
+	insights := make(map[string]*models.SearchQueryInsight)
+	resultTotals := make(map[string]int)
+	var searches, zeroResults int
+	for _, doc := range docs {
+		var entry models.SearchLog
+		if err := doc.DataTo(&entry); err != nil {
+			continue
+		}
+		searches++
+
+		insight := insights[entry.Term]
+		if insight == nil {
+			insight = &models.SearchQueryInsight{Query: entry.Query}
+			insights[entry.Term] = insight
+		}
+		insight.Searches++
+		resultTotals[entry.Term] += entry.Results
+		if entry.Results == 0 {
+			insight.ZeroResults++
+			zeroResults++
+		}
+		if entry.CreatedAt.After(insight.LastSeen) {
+			insight.LastSeen = entry.CreatedAt
+			insight.Query = entry.Query
+		}
+	}
+
+	top := make([]models.SearchQueryInsight, 0, len(insights))
+	zero := make([]models.SearchQueryInsight, 0)
+	for term, insight := range insights {
+		insight.AvgResults = roundAmount(float64(resultTotals[term]) / float64(insight.Searches))
+		top = append(top, *insight)
+		if insight.ZeroResults > 0 {
+			zero = append(zero, *insight)
+		}
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Searches != top[j].Searches {
+			return top[i].Searches > top[j].Searches
+		}
+		return top[i].Query < top[j].Query
+	})
+	sort.Slice(zero, func(i, j int) bool {
+		if zero[i].ZeroResults != zero[j].ZeroResults {
+			return zero[i].ZeroResults > zero[j].ZeroResults
+		}
+		return zero[i].LastSeen.After(zero[j].LastSeen)
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	if len(zero) > limit {
+		zero = zero[:limit]
+	}
+
+	var zeroRate float64
+	if searches > 0 {
+		zeroRate = roundAmount(float64(zeroResults) / float64(searches) * 100)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"since":               since,
+		"searches":            searches,
+		"unique_queries":      len(insights),
+		"zero_result_rate":    zeroRate,
+		"top_queries":         top,
+		"zero_result_queries": zero,
+	})
+}
@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
 	"tripund-api/internal/database"
+	"tripund-api/internal/models"
 )
 
 type SettingsHandler struct {
@@ -22,6 +25,8 @@ type Settings struct {
 	Shipping ShippingSettings `json:"shipping" firestore:"shipping"`
 	Payment  PaymentSettings  `json:"payment" firestore:"payment"`
 	Invoice  InvoiceSettings  `json:"invoice" firestore:"invoice"`
+	Referral ReferralSettings `json:"referral" firestore:"referral"`
+	Inventory InventorySettings `json:"inventory" firestore:"inventory"`
 	UpdatedAt time.Time       `json:"updated_at" firestore:"updated_at"`
 }
 
@@ -42,6 +47,124 @@ type ShippingSettings struct {
 	DeliveryZones         []string `json:"delivery_zones" firestore:"delivery_zones"`
 }
 
+// defaultShippingSettings apply until shipping settings are saved
+var defaultShippingSettings = ShippingSettings{
+	FreeShippingThreshold: 5000,
+	StandardShippingRate:  100,
+	ExpressShippingRate:   200,
+	ProcessingTime:        2,
+	DeliveryZones:         []string{"Mumbai", "Delhi", "Bangalore", "Chennai"},
+}
+
+// Fee returns the shipping charge for a cart worth subtotal. Standard delivery is free
+// from FreeShippingThreshold; express is always charged when it has a rate.
+func (s ShippingSettings) Fee(method string, subtotal float64) float64 {
+	if method == models.ShippingMethodExpress && s.ExpressShippingRate > 0 {
+		return s.ExpressShippingRate
+	}
+	if subtotal >= s.FreeShippingThreshold {
+		return 0
+	}
+	return s.StandardShippingRate
+}
+
+// getShippingSettings returns the saved shipping settings, or the defaults
+func getShippingSettings(db *database.Firebase) ShippingSettings {
+	doc, err := db.Client.Collection("settings").Doc("main").Get(db.Context)
+	if err != nil {
+		return defaultShippingSettings
+	}
+
+	var settings Settings
+	if err := doc.DataTo(&settings); err != nil {
+		log.Printf("Failed to parse shipping settings: %v", err)
+		return defaultShippingSettings
+	}
+	return settings.Shipping
+}
+
+// TaxSettings is what orders are taxed by: the GST rate and the seller's state code
+type TaxSettings struct {
+	Rate          float64
+	HomeStateCode string
+}
+
+// defaultTaxSettings match the invoice defaults
+var defaultTaxSettings = TaxSettings{Rate: 18, HomeStateCode: "27"}
+
+// getTaxSettings returns the saved GST rate and home state code, or the defaults
+func getTaxSettings(db *database.Firebase) TaxSettings {
+	doc, err := db.Client.Collection("settings").Doc("main").Get(db.Context)
+	if err != nil {
+		return defaultTaxSettings
+	}
+
+	var settings Settings
+	if err := doc.DataTo(&settings); err != nil {
+		log.Printf("Failed to parse tax settings: %v", err)
+		return defaultTaxSettings
+	}
+	tax := defaultTaxSettings
+	if settings.Payment.TaxRate > 0 {
+		tax.Rate = settings.Payment.TaxRate
+	}
+	if settings.Invoice.HomeStateCode != "" {
+		tax.HomeStateCode = settings.Invoice.HomeStateCode
+	}
+	return tax
+}
+
+// normalizeShippingMethod maps a requested shipping method to standard or express
+func normalizeShippingMethod(method string) string {
+	if strings.EqualFold(strings.TrimSpace(method), models.ShippingMethodExpress) {
+		return models.ShippingMethodExpress
+	}
+	return models.ShippingMethodStandard
+}
+
+type InventorySettings struct {
+	AllocationRules   []string `json:"allocation_rules" firestore:"allocation_rules"`       // Order the allocation rules are applied in
+	LowStockThreshold int      `json:"low_stock_threshold" firestore:"low_stock_threshold"` // Reorder threshold of products and variants without their own
+	DigestEnabled     bool     `json:"digest_enabled" firestore:"digest_enabled"`           // Daily digest of low and out of stock items
+	DigestEmails      []string `json:"digest_emails" firestore:"digest_emails"`
+	DigestWhatsApp    []string `json:"digest_whatsapp" firestore:"digest_whatsapp"` // Phone numbers
+	DigestHour        int      `json:"digest_hour" firestore:"digest_hour"`         // Hour of the day, IST, the digest goes out
+}
+
+// defaultInventorySettings apply until inventory settings are saved
+var defaultInventorySettings = InventorySettings{
+	AllocationRules: []string{
+		models.AllocationRuleFullAvailability,
+		models.AllocationRuleProximity,
+		models.AllocationRulePriority,
+	},
+	LowStockThreshold: 5,
+	DigestHour:        9,
+}
+
+// getInventorySettings returns the saved inventory settings, with defaults for the
+// allocation rules and low-stock threshold if they aren't set
+func getInventorySettings(db *database.Firebase) InventorySettings {
+	doc, err := db.Client.Collection("settings").Doc("main").Get(db.Context)
+	if err != nil {
+		return defaultInventorySettings
+	}
+
+	var settings Settings
+	if err := doc.DataTo(&settings); err != nil {
+		log.Printf("Failed to parse inventory settings: %v", err)
+		return defaultInventorySettings
+	}
+	inventory := settings.Inventory
+	if len(inventory.AllocationRules) == 0 {
+		inventory.AllocationRules = defaultInventorySettings.AllocationRules
+	}
+	if inventory.LowStockThreshold <= 0 {
+		inventory.LowStockThreshold = defaultInventorySettings.LowStockThreshold
+	}
+	return inventory
+}
+
 type PaymentSettings struct {
 	RazorpayEnabled    bool    `json:"razorpay_enabled" firestore:"razorpay_enabled"`
 	RazorpayKey        string  `json:"razorpay_key" firestore:"razorpay_key"`
@@ -51,6 +174,18 @@ type PaymentSettings struct {
 	PrepaidDiscount    float64 `json:"prepaid_discount" firestore:"prepaid_discount"`
 }
 
+type ReferralSettings struct {
+	Enabled              bool    `json:"enabled" firestore:"enabled"`
+	RefereeDiscountType  string  `json:"referee_discount_type" firestore:"referee_discount_type"` // percentage or fixed
+	RefereeDiscount      float64 `json:"referee_discount" firestore:"referee_discount"`
+	RefereeMaxDiscount   float64 `json:"referee_max_discount" firestore:"referee_max_discount"`
+	RefereeMinOrderValue float64 `json:"referee_min_order_value" firestore:"referee_min_order_value"`
+	RewardType           string  `json:"reward_type" firestore:"reward_type"` // store_credit or coupon
+	RewardAmount         float64 `json:"reward_amount" firestore:"reward_amount"`
+	RewardPromotionID    string  `json:"reward_promotion_id" firestore:"reward_promotion_id"` // Parent promotion for coupon rewards
+	RewardValidityMonths int     `json:"reward_validity_months" firestore:"reward_validity_months"`
+}
+
 type InvoiceSettings struct {
 	GSTIN               string `json:"gstin" firestore:"gstin"`
 	RegisteredName      string `json:"registered_name" firestore:"registered_name"`
@@ -95,6 +230,9 @@ func (h *SettingsHandler) GetPublicSettings(c *gin.Context) {
 		"general": map[string]interface{}{
 			"currency": "INR",
 		},
+		"referral": map[string]interface{}{
+			"enabled": false,
+		},
 	}
 	
 	if err != nil {
@@ -124,6 +262,14 @@ func (h *SettingsHandler) GetPublicSettings(c *gin.Context) {
 		"general": map[string]interface{}{
 			"currency": settings.General.Currency,
 		},
+		"referral": map[string]interface{}{
+			"enabled":                 settings.Referral.Enabled,
+			"referee_discount_type":   settings.Referral.RefereeDiscountType,
+			"referee_discount":        settings.Referral.RefereeDiscount,
+			"referee_min_order_value": settings.Referral.RefereeMinOrderValue,
+			"reward_type":             settings.Referral.RewardType,
+			"reward_amount":           settings.Referral.RewardAmount,
+		},
 	}
 
 	c.JSON(http.StatusOK, gin.H{"settings": publicSettings})
@@ -142,13 +288,7 @@ func (h *SettingsHandler) GetSettings(c *gin.Context) {
 				StoreAddress: "Mumbai, India",
 				Currency:     "INR",
 			},
-			Shipping: ShippingSettings{
-				FreeShippingThreshold: 5000,
-				StandardShippingRate:  100,
-				ExpressShippingRate:   200,
-				ProcessingTime:        2,
-				DeliveryZones:         []string{"Mumbai", "Delhi", "Bangalore", "Chennai"},
-			},
+			Shipping: defaultShippingSettings,
 			Payment: PaymentSettings{
 				RazorpayEnabled: true,
 				CODEnabled:      true,
@@ -156,6 +296,16 @@ func (h *SettingsHandler) GetSettings(c *gin.Context) {
 				TaxRate:         18,
 				PrepaidDiscount: 5,
 			},
+			Referral: ReferralSettings{
+				Enabled:              false,
+				RefereeDiscountType:  string(models.PromotionTypePercentage),
+				RefereeDiscount:      10,
+				RefereeMaxDiscount:   500,
+				RewardType:           models.ReferralRewardStoreCredit,
+				RewardAmount:         250,
+				RewardValidityMonths: 6,
+			},
+			Inventory: defaultInventorySettings,
 			Invoice: InvoiceSettings{
 				GSTIN:               "",
 				RegisteredName:      "TRIPUND Lifestyle",
@@ -194,6 +344,19 @@ func (h *SettingsHandler) UpdateSettings(c *gin.Context) {
 		return
 	}
 
+	if settings.Inventory.DigestHour < 0 || settings.Inventory.DigestHour > 23 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Digest hour must be between 0 and 23"})
+		return
+	}
+	for _, rule := range settings.Inventory.AllocationRules {
+		switch rule {
+		case models.AllocationRuleFullAvailability, models.AllocationRuleProximity, models.AllocationRulePriority:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown allocation rule: " + rule})
+			return
+		}
+	}
+
 	settings.UpdatedAt = time.Now()
 
 	// Convert struct to map for MergeAll
@@ -202,6 +365,8 @@ func (h *SettingsHandler) UpdateSettings(c *gin.Context) {
 		"shipping":   settings.Shipping,
 		"payment":    settings.Payment,
 		"invoice":    settings.Invoice,
+		"referral":   settings.Referral,
+		"inventory":  settings.Inventory,
 		"updated_at": settings.UpdatedAt,
 	}
 
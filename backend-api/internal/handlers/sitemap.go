@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+)
+
+// sitemapMaxAge is how long a generated sitemap is served before it's rebuilt
+const sitemapMaxAge = time.Hour
+
+// sitemapPages are the storefront's fixed pages; those backed by managed content take
+// their last change from it
+var sitemapPages = []struct {
+	path        string
+	contentType string
+	priority    string
+}{
+	{"/", "", "1.0"},
+	{"/products", "", "0.9"},
+	{"/categories", "", "0.8"},
+	{"/about", "about", "0.5"},
+	{"/contact", "contact", "0.5"},
+	{"/faq", "faqs", "0.4"},
+	{"/shipping", "shipping", "0.4"},
+	{"/returns", "returns", "0.4"},
+	{"/privacy-policy", "legal", "0.3"},
+	{"/terms-conditions", "legal", "0.3"},
+}
+
+type sitemapURL struct {
+	Loc      string `xml:"loc"`
+	LastMod  string `xml:"lastmod,omitempty"`
+	Priority string `xml:"priority,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// SitemapHandler serves sitemap.xml for the storefront
+type SitemapHandler struct {
+	db      *database.Firebase
+	siteURL string
+
+	mu      sync.Mutex
+	sitemap []byte
+	builtAt time.Time
+}
+
+func NewSitemapHandler(db *database.Firebase, siteURL string) *SitemapHandler {
+	return &SitemapHandler{db: db, siteURL: strings.TrimSuffix(siteURL, "/")}
+}
+
+// sitemapDate formats a last change for the sitemap, leaving unknown ones out
+func sitemapDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02")
+}
+
+// build lists the fixed pages, the categories and the active products by slug.
+// Products without a slug are listed by ID, which their pages also accept.
+func (h *SitemapHandler) build() ([]byte, error) {
+	urlSet := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+
+	contentDocs, err := h.db.Client.Collection("content").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	contentUpdated := make(map[string]time.Time)
+	for _, doc := range contentDocs {
+		updatedAt, _ := doc.Data()["updated_at"].(time.Time)
+		contentUpdated[doc.Ref.ID] = updatedAt
+	}
+	for _, page := range sitemapPages {
+		urlSet.URLs = append(urlSet.URLs, sitemapURL{
+			Loc:      h.siteURL + page.path,
+			LastMod:  sitemapDate(contentUpdated[page.contentType]),
+			Priority: page.priority,
+		})
+	}
+
+	categoryDocs, err := h.db.Client.Collection("categories").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range categoryDocs {
+		category := categoryFromDoc(doc)
+		if category.Slug == "" {
+			continue
+		}
+		urlSet.URLs = append(urlSet.URLs, sitemapURL{
+			Loc:      h.siteURL + "/category/" + url.PathEscape(category.Slug),
+			LastMod:  sitemapDate(category.UpdatedAt),
+			Priority: "0.8",
+		})
+	}
+
+	productDocs, err := h.db.Client.Collection("products").Where("status", "==", models.ProductStatusActive).Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range productDocs {
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil {
+			continue
+		}
+		path := product.Slug
+		if path == "" {
+			path = doc.Ref.ID
+		}
+		urlSet.URLs = append(urlSet.URLs, sitemapURL{
+			Loc:      h.siteURL + "/products/" + url.PathEscape(path),
+			LastMod:  sitemapDate(product.UpdatedAt),
+			Priority: "0.7",
+		})
+	}
+
+	data, err := xml.MarshalIndent(urlSet, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// GetSitemap serves sitemap.xml, rebuilding it when it's older than sitemapMaxAge
+func (h *SitemapHandler) GetSitemap(c *gin.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sitemap == nil || time.Since(h.builtAt) >= sitemapMaxAge {
+		sitemap, err := h.build()
+		if err != nil {
+			log.Printf("Failed to build sitemap: %v", err)
+			if h.sitemap == nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build sitemap"})
+				return
+			}
+		} else {
+			h.sitemap = sitemap
+			h.builtAt = time.Now()
+		}
+	}
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", h.sitemap)
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+	"tripund-api/internal/utils"
+)
+
+// slugTakenError is a slug already used by another document of the same kind
+type slugTakenError struct {
+	kind string
+	slug string
+}
+
+func (e slugTakenError) Error() string {
+	return fmt.Sprintf("slug %q is already used by another %s", e.slug, e.kind)
+}
+
+// slugCollections are the collections holding each kind of slugged document
+var slugCollections = map[string]string{
+	models.SlugKindProduct:  "products",
+	models.SlugKindCategory: "categories",
+}
+
+// slugRedirectID is the ID of the redirect record for an old slug, and of its reservation
+func slugRedirectID(kind, slug string) string {
+	return kind + ":" + slug
+}
+
+// slugRef is the reservation of a slug
+func slugRef(db *database.Firebase, kind, slug string) *firestore.DocumentRef {
+	return db.Client.Collection("slugs").Doc(slugRedirectID(kind, slug))
+}
+
+// slugTaken reports whether a document of the kind other than exceptID has reserved or
+// uses the slug. Documents saved before reservations are found by their slug field.
+func slugTaken(db *database.Firebase, kind, slug, exceptID string) (bool, error) {
+	reservation, err := slugRef(db, kind, slug).Get(db.Context)
+	if err == nil {
+		targetID, _ := reservation.Data()["target_id"].(string)
+		if targetID != exceptID {
+			return true, nil
+		}
+	} else if status.Code(err) != codes.NotFound {
+		return false, err
+	}
+
+	docs, err := db.Client.Collection(slugCollections[kind]).Where("slug", "==", slug).Documents(db.Context).GetAll()
+	if err != nil {
+		return false, err
+	}
+	for _, doc := range docs {
+		if doc.Ref.ID != exceptID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// uniqueSlug returns the slug, or the slug with the lowest suffix from -2 on that no
+// other document of the kind uses
+func uniqueSlug(db *database.Firebase, kind, slug, exceptID string) (string, error) {
+	candidate := slug
+	for n := 2; ; n++ {
+		taken, err := slugTaken(db, kind, candidate, exceptID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", slug, n)
+	}
+}
+
+// settleSlug works out the slug a document is saved with: the requested one made
+// URL-safe, or one from the name when none is requested. A requested slug another
+// document uses is refused; one from the name gets a numeric suffix instead.
+func settleSlug(db *database.Firebase, kind, id, requested, name string) (string, error) {
+	if slug := utils.GenerateSlug(requested); slug != "" {
+		taken, err := slugTaken(db, kind, slug, id)
+		if err != nil {
+			return "", err
+		}
+		if taken {
+			return "", slugTakenError{kind: kind, slug: slug}
+		}
+		return slug, nil
+	}
+	slug := utils.GenerateSlug(name)
+	if slug == "" {
+		return "", nil
+	}
+	return uniqueSlug(db, kind, slug, id)
+}
+
+// reserveSlug claims a document's new slug and releases its old one, in the
+// transaction that saves the document. Should another document claim the slug first,
+// the transaction fails with AlreadyExists, which slugConflict reports as taken.
+func reserveSlug(tx *firestore.Transaction, db *database.Firebase, kind, slug, oldSlug, targetID string) error {
+	if slug == oldSlug {
+		return nil
+	}
+	if oldSlug != "" {
+		if err := tx.Delete(slugRef(db, kind, oldSlug)); err != nil {
+			return err
+		}
+	}
+	if slug == "" {
+		return nil
+	}
+	return tx.Create(slugRef(db, kind, slug), newSlugReservation(kind, slug, targetID))
+}
+
+func newSlugReservation(kind, slug, targetID string) models.SlugReservation {
+	return models.SlugReservation{
+		Kind:      kind,
+		Slug:      slug,
+		TargetID:  targetID,
+		CreatedAt: time.Now(),
+	}
+}
+
+// deleteSluggedDoc deletes a document of the kind and releases its slug together
+func deleteSluggedDoc(db *database.Firebase, kind, id string) error {
+	ref := db.Client.Collection(slugCollections[kind]).Doc(id)
+	return db.Client.RunTransaction(db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := tx.Delete(ref); err != nil {
+			return err
+		}
+		if slug, _ := doc.Data()["slug"].(string); slug != "" {
+			return tx.Delete(slugRef(db, kind, slug))
+		}
+		return nil
+	})
+}
+
+// slugConflict turns the failure of a write that reserved a slug another document
+// claimed first into a slugTakenError
+func slugConflict(err error, kind, slug string) error {
+	if status.Code(err) == codes.AlreadyExists {
+		return slugTakenError{kind: kind, slug: slug}
+	}
+	return err
+}
+
+// ReserveExistingSlugs reserves the slugs of products and categories saved before
+// slugs were reserved. Slugs already reserved are left alone.
+func ReserveExistingSlugs(db *database.Firebase) {
+	reserved := 0
+	for kind, collection := range slugCollections {
+		docs, err := db.Client.Collection(collection).Documents(db.Context).GetAll()
+		if err != nil {
+			log.Printf("Failed to load %s slugs to reserve: %v", kind, err)
+			continue
+		}
+		for _, doc := range docs {
+			slug, _ := doc.Data()["slug"].(string)
+			if slug == "" {
+				continue
+			}
+			_, err := slugRef(db, kind, slug).Create(db.Context, newSlugReservation(kind, slug, doc.Ref.ID))
+			if err == nil {
+				reserved++
+			} else if status.Code(err) != codes.AlreadyExists {
+				log.Printf("Failed to reserve %s slug %s: %v", kind, slug, err)
+			}
+		}
+	}
+	if reserved > 0 {
+		log.Printf("Reserved %d existing slugs", reserved)
+	}
+}
+
+// recordSlugRedirect keeps a document's old slug leading to it after the slug changes,
+// and drops any redirect away from the new slug, which now belongs to the document
+func recordSlugRedirect(db *database.Firebase, kind, oldSlug, newSlug, targetID string) {
+	redirects := db.Client.Collection("slug_redirects")
+	if newSlug != "" {
+		if _, err := redirects.Doc(slugRedirectID(kind, newSlug)).Delete(db.Context); err != nil {
+			log.Printf("Failed to drop redirect from %s slug %s: %v", kind, newSlug, err)
+		}
+	}
+	if oldSlug == "" || oldSlug == newSlug {
+		return
+	}
+	redirect := models.SlugRedirect{
+		Kind:      kind,
+		OldSlug:   oldSlug,
+		TargetID:  targetID,
+		CreatedAt: time.Now(),
+	}
+	if _, err := redirects.Doc(slugRedirectID(kind, oldSlug)).Set(db.Context, redirect); err != nil {
+		log.Printf("Failed to record redirect from %s slug %s: %v", kind, oldSlug, err)
+	}
+}
+
+// resolveSlug finds the document of the kind with a slug, following the redirect of a
+// slug it used to have. It reports whether the slug was an old one.
+func resolveSlug(db *database.Firebase, kind, slug string) (*firestore.DocumentSnapshot, bool, error) {
+	collection := db.Client.Collection(slugCollections[kind])
+	docs, err := collection.Where("slug", "==", slug).Limit(1).Documents(db.Context).GetAll()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(docs) > 0 {
+		return docs[0], false, nil
+	}
+
+	redirectDoc, err := db.Client.Collection("slug_redirects").Doc(slugRedirectID(kind, slug)).Get(db.Context)
+	if err != nil {
+		return nil, false, err
+	}
+	var redirect models.SlugRedirect
+	if err := redirectDoc.DataTo(&redirect); err != nil {
+		return nil, false, err
+	}
+	doc, err := collection.Doc(redirect.TargetID).Get(db.Context)
+	if err != nil {
+		return nil, false, err
+	}
+	slugNow, _ := doc.Data()["slug"].(string)
+	return doc, slugNow != "", nil
+}
+
+// redirectToSlug answers a request for an old slug with a permanent redirect to the
+// same path under the current slug
+func redirectToSlug(c *gin.Context, oldSlug string, doc *firestore.DocumentSnapshot) {
+	slug, _ := doc.Data()["slug"].(string)
+	location := strings.TrimSuffix(c.Request.URL.Path, oldSlug) + slug
+	c.Header("Location", location)
+	c.JSON(http.StatusMovedPermanently, gin.H{"id": doc.Ref.ID, "slug": slug, "location": location})
+}
+
+// slugLookupFailed responds to a slug that couldn't be resolved to a document of the kind
+func slugLookupFailed(c *gin.Context, kind string, err error) {
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%s%s not found", strings.ToUpper(kind[:1]), kind[1:])})
+		return
+	}
+	log.Printf("Failed to resolve %s slug %s: %v", kind, c.Param("slug"), err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch " + kind})
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+	"tripund-api/internal/services"
+)
+
+// istZone is the store's time zone, for the daily digest
+var istZone = time.FixedZone("IST", 5*60*60+30*60)
+
+// lowStockUnits lists a product's stock units: its variants, or the product itself
+// when it has none. Each has the stock, threshold and name an alert would show.
+func lowStockUnits(product *models.Product, defaultThreshold int) []models.LowStockAlert {
+	threshold := defaultThreshold
+	if product.ReorderThreshold != nil {
+		threshold = *product.ReorderThreshold
+	}
+	if len(product.Variants) == 0 {
+		return []models.LowStockAlert{{
+			ID:          product.ID,
+			ProductID:   product.ID,
+			ProductName: product.Name,
+			SKU:         product.SKU,
+			Stock:       product.StockQuantity,
+			Threshold:   threshold,
+		}}
+	}
+
+	units := make([]models.LowStockAlert, 0, len(product.Variants))
+	for _, variant := range product.Variants {
+		unit := models.LowStockAlert{
+			ID:          strings.ReplaceAll(ledgerKey(product.ID, variant.ID, variant.SKU), "/", "_"),
+			ProductID:   product.ID,
+			VariantID:   variant.ID,
+			VariantSKU:  variant.SKU,
+			ProductName: product.Name,
+			SKU:         product.SKU,
+			Stock:       variant.StockQuantity,
+			Threshold:   threshold,
+		}
+		if variant.ReorderThreshold != nil {
+			unit.Threshold = *variant.ReorderThreshold
+		}
+		var label []string
+		for _, part := range []string{variant.Color, variant.Size} {
+			if part != "" {
+				label = append(label, part)
+			}
+		}
+		if len(label) > 0 {
+			unit.ProductName += " (" + strings.Join(label, " / ") + ")"
+		}
+		if variant.SKU != "" {
+			unit.SKU = variant.SKU
+		}
+		units = append(units, unit)
+	}
+	return units
+}
+
+// tracksLowStock reports whether a product's stock is watched for alerts
+func tracksLowStock(product *models.Product) bool {
+	return !product.IsGiftCard() && !product.IsBundle() && product.Status != models.ProductStatusArchived
+}
+
+// checkLowStock alerts once for each of a product's stock units that has dropped to
+// its reorder threshold, again if it then runs out, and clears the alerts of units
+// restocked above their threshold
+func (h *InventoryHandler) checkLowStock(product *models.Product) {
+	if !tracksLowStock(product) {
+		return
+	}
+	alerts := h.db.Client.Collection("low_stock_alerts")
+	now := time.Now()
+
+	for _, unit := range lowStockUnits(product, getInventorySettings(h.db).LowStockThreshold) {
+		ref := alerts.Doc(unit.ID)
+		doc, err := ref.Get(h.db.Context)
+		if err != nil && status.Code(err) != codes.NotFound {
+			log.Printf("Failed to check low stock alert %s: %v", unit.ID, err)
+			continue
+		}
+		alerted := err == nil
+
+		if unit.Stock > unit.Threshold {
+			if alerted {
+				if _, err := ref.Delete(h.db.Context); err != nil {
+					log.Printf("Failed to clear low stock alert %s: %v", unit.ID, err)
+				}
+			}
+			continue
+		}
+
+		unit.OutOfStock = unit.Stock <= 0
+		unit.UpdatedAt = now
+		if !alerted {
+			unit.AlertedAt = now
+			if _, err := ref.Create(h.db.Context, unit); err != nil {
+				// Another stock change raised it first
+				if status.Code(err) != codes.AlreadyExists {
+					log.Printf("Failed to save low stock alert %s: %v", unit.ID, err)
+				}
+				continue
+			}
+			if unit.OutOfStock {
+				h.notificationHandler.NotifyOutOfStock(unit.ProductName)
+			} else {
+				h.notificationHandler.NotifyLowStock(unit.ProductName, unit.Stock)
+			}
+			continue
+		}
+
+		var existing models.LowStockAlert
+		if err := doc.DataTo(&existing); err != nil {
+			continue
+		}
+		if existing.Stock == unit.Stock && existing.OutOfStock == unit.OutOfStock {
+			continue
+		}
+		unit.AlertedAt = existing.AlertedAt
+		if _, err := ref.Set(h.db.Context, unit); err != nil {
+			log.Printf("Failed to update low stock alert %s: %v", unit.ID, err)
+			continue
+		}
+		if unit.OutOfStock && !existing.OutOfStock {
+			h.notificationHandler.NotifyOutOfStock(unit.ProductName)
+		}
+	}
+}
+
+// checkProductLowStock loads a product and checks its stock against its thresholds
+func (h *InventoryHandler) checkProductLowStock(productID string) {
+	doc, err := h.db.Client.Collection("products").Doc(productID).Get(h.db.Context)
+	if err != nil {
+		log.Printf("Failed to load product %s for low stock check: %v", productID, err)
+		return
+	}
+	var product models.Product
+	if err := database.DecodeProduct(doc, &product); err != nil {
+		return
+	}
+	product.ID = doc.Ref.ID
+	h.checkLowStock(&product)
+}
+
+// StockAlertHandler lists low stock and sends the daily digest of it
+type StockAlertHandler struct {
+	db              *database.Firebase
+	emailService    *services.SendGridEmailService
+	whatsappService *services.WhatsAppService
+}
+
+func NewStockAlertHandler(db *database.Firebase, whatsappService *services.WhatsAppService) *StockAlertHandler {
+	emailService, err := services.NewSendGridEmailService()
+	if err != nil {
+		log.Printf("WARNING: Low stock digest emails disabled: %v", err)
+		emailService = nil
+	}
+	return &StockAlertHandler{db: db, emailService: emailService, whatsappService: whatsappService}
+}
+
+// lowStock returns every stock unit at or below its reorder threshold, out of stock
+// first, from the current stock of all products
+func (h *StockAlertHandler) lowStock() ([]models.LowStockAlert, error) {
+	docs, err := h.db.Client.Collection("products").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	threshold := getInventorySettings(h.db).LowStockThreshold
+
+	low := make([]models.LowStockAlert, 0)
+	for _, doc := range docs {
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil {
+			continue
+		}
+		product.ID = doc.Ref.ID
+		if !tracksLowStock(&product) {
+			continue
+		}
+		for _, unit := range lowStockUnits(&product, threshold) {
+			if unit.Stock <= unit.Threshold {
+				unit.OutOfStock = unit.Stock <= 0
+				low = append(low, unit)
+			}
+		}
+	}
+	sort.Slice(low, func(i, j int) bool {
+		if low[i].Stock != low[j].Stock {
+			return low[i].Stock < low[j].Stock
+		}
+		return low[i].ProductName < low[j].ProductName
+	})
+	return low, nil
+}
+
+// GetLowStock lists products and variants that are out of stock or at their reorder
+// threshold
+func (h *StockAlertHandler) GetLowStock(c *gin.Context) {
+	low, err := h.lowStock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch low stock"})
+		return
+	}
+
+	outOfStock := 0
+	for _, unit := range low {
+		if unit.OutOfStock {
+			outOfStock++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":        low,
+		"count":        len(low),
+		"out_of_stock": outOfStock,
+	})
+}
+
+// sendDigest sends the low stock digest to the configured emails and WhatsApp numbers
+func (h *StockAlertHandler) sendDigest(settings InventorySettings) (int, error) {
+	low, err := h.lowStock()
+	if err != nil {
+		return 0, err
+	}
+
+	data := services.LowStockDigestData{Date: time.Now().In(istZone).Format("2 Jan 2006")}
+	for _, unit := range low {
+		item := services.LowStockDigestItem{
+			ProductName: unit.ProductName,
+			SKU:         unit.SKU,
+			Stock:       unit.Stock,
+			Threshold:   unit.Threshold,
+		}
+		if unit.OutOfStock {
+			data.OutOfStock = append(data.OutOfStock, item)
+		} else {
+			data.LowStock = append(data.LowStock, item)
+		}
+	}
+
+	if h.emailService != nil {
+		for _, email := range settings.DigestEmails {
+			if err := h.emailService.SendLowStockDigest(email, data); err != nil {
+				log.Printf("Failed to email low stock digest to %s: %v", email, err)
+			}
+		}
+	}
+
+	if len(settings.DigestWhatsApp) > 0 {
+		message := lowStockDigestText(data)
+		for _, phone := range settings.DigestWhatsApp {
+			if _, err := h.whatsappService.SendTextMessage(phone, message); err != nil {
+				log.Printf("Failed to send low stock digest to %s on WhatsApp: %v", phone, err)
+			}
+		}
+	}
+	return len(low), nil
+}
+
+// lowStockDigestText is the digest as a WhatsApp message, listing out of stock items
+// and then low ones with their stock
+func lowStockDigestText(data services.LowStockDigestData) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*TRIPUND stock digest, %s*\n", data.Date)
+	if len(data.OutOfStock) == 0 && len(data.LowStock) == 0 {
+		b.WriteString("\nEverything is above its reorder threshold.")
+		return b.String()
+	}
+	if len(data.OutOfStock) > 0 {
+		fmt.Fprintf(&b, "\n*Out of stock (%d)*\n", len(data.OutOfStock))
+		for _, item := range data.OutOfStock {
+			fmt.Fprintf(&b, "• %s [%s]\n", item.ProductName, item.SKU)
+		}
+	}
+	if len(data.LowStock) > 0 {
+		fmt.Fprintf(&b, "\n*Low stock (%d)*\n", len(data.LowStock))
+		for _, item := range data.LowStock {
+			fmt.Fprintf(&b, "• %s [%s]: %d left\n", item.ProductName, item.SKU, item.Stock)
+		}
+	}
+	return b.String()
+}
+
+// SendLowStockDigest sends the digest now, whether or not the daily digest is enabled
+func (h *StockAlertHandler) SendLowStockDigest(c *gin.Context) {
+	settings := getInventorySettings(h.db)
+	if len(settings.DigestEmails) == 0 && len(settings.DigestWhatsApp) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No digest emails or WhatsApp numbers are set up"})
+		return
+	}
+
+	count, err := h.sendDigest(settings)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send low stock digest"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Low stock digest sent", "count": count})
+}
+
+// StartDailyDigest sends the digest once a day from the configured hour. Every
+// instance checks hourly; the one that creates the day's low_stock_digests document
+// sends it.
+func (h *StockAlertHandler) StartDailyDigest() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			settings := getInventorySettings(h.db)
+			local := now.In(istZone)
+			if !settings.DigestEnabled || local.Hour() < settings.DigestHour {
+				continue
+			}
+
+			day := local.Format("2006-01-02")
+			_, err := h.db.Client.Collection("low_stock_digests").Doc(day).Create(h.db.Context, map[string]interface{}{
+				"sent_at": now,
+			})
+			if status.Code(err) == codes.AlreadyExists {
+				continue
+			} else if err != nil {
+				log.Printf("Failed to claim low stock digest for %s: %v", day, err)
+				continue
+			}
+
+			if _, err := h.sendDigest(settings); err != nil {
+				log.Printf("Failed to send low stock digest for %s: %v", day, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,540 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tripund-api/internal/database"
+	"tripund-api/internal/models"
+)
+
+// errLocationShort is returned when an order is allocated to a location that can't ship
+// all of it
+var errLocationShort = errors.New("location doesn't have enough stock for the order")
+
+// loadLocations returns all stock locations, lowest priority number first
+func (h *InventoryHandler) loadLocations() ([]models.StockLocation, error) {
+	docs, err := h.db.Client.Collection("stock_locations").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	locations := make([]models.StockLocation, 0, len(docs))
+	for _, doc := range docs {
+		var location models.StockLocation
+		if err := doc.DataTo(&location); err != nil {
+			continue
+		}
+		location.ID = doc.Ref.ID
+		locations = append(locations, location)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].Priority != locations[j].Priority {
+			return locations[i].Priority < locations[j].Priority
+		}
+		return locations[i].Name < locations[j].Name
+	})
+	return locations, nil
+}
+
+// getLocation returns a stock location, or nil if it doesn't exist
+func (h *InventoryHandler) getLocation(locationID string) (*models.StockLocation, error) {
+	doc, err := h.db.Client.Collection("stock_locations").Doc(locationID).Get(h.db.Context)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var location models.StockLocation
+	if err := doc.DataTo(&location); err != nil {
+		return nil, err
+	}
+	location.ID = doc.Ref.ID
+	return &location, nil
+}
+
+// stockAt returns the stock per location of an order item's product or variant
+func stockAt(product *models.Product, variantID string) map[string]int {
+	if variantID != "" && len(product.Variants) > 0 {
+		if i := variantIndex(product, variantID); i >= 0 {
+			return product.Variants[i].LocationStock
+		}
+		return nil
+	}
+	return product.LocationStock
+}
+
+// pincodeProximity is the number of leading digits two pincodes share. Indian pincodes
+// narrow down from region to sorting district to post office, so more shared digits
+// means closer.
+func pincodeProximity(a, b string) int {
+	a, b = strings.ReplaceAll(a, " ", ""), strings.ReplaceAll(b, " ", "")
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// allocateOrder splits an order's stock lines across locations. Active locations
+// are ranked by the rules, in order, and each line is taken from the best ranked
+// locations holding it; what no location holds is left unassigned. With forcedLocation
+// everything ships from that location, which must hold all of it.
+func allocateOrder(order models.Order, products map[string]*models.Product, locations []models.StockLocation, rules []string, forcedLocation string) ([]models.StockAllocation, error) {
+	type need struct {
+		line  stockLine
+		stock map[string]int
+	}
+	var needs []need
+	totals := make(map[string]int) // Quantity per product or variant, for stock taken by several lines
+	for _, line := range orderStockLines(order) {
+		var stock map[string]int
+		if product := products[line.ProductID]; product != nil {
+			stock = stockAt(product, line.VariantID)
+		}
+		needs = append(needs, need{line: line, stock: stock})
+		totals[ledgerKey(line.ProductID, line.VariantID, "")] += line.Quantity
+	}
+
+	holdsAll := func(locationID string) bool {
+		for _, n := range needs {
+			if n.stock[locationID] < totals[ledgerKey(n.line.ProductID, n.line.VariantID, "")] {
+				return false
+			}
+		}
+		return true
+	}
+
+	var ranked []models.StockLocation
+	if forcedLocation != "" {
+		if !holdsAll(forcedLocation) {
+			return nil, errLocationShort
+		}
+		ranked = []models.StockLocation{{ID: forcedLocation}}
+	} else {
+		full := make(map[string]bool)
+		for _, location := range locations {
+			if location.Active {
+				full[location.ID] = holdsAll(location.ID)
+				ranked = append(ranked, location)
+			}
+		}
+		pincode := order.ShippingAddress.PostalCode
+		sort.SliceStable(ranked, func(i, j int) bool {
+			a, b := ranked[i], ranked[j]
+			for _, rule := range rules {
+				switch rule {
+				case models.AllocationRuleFullAvailability:
+					if full[a.ID] != full[b.ID] {
+						return full[a.ID]
+					}
+				case models.AllocationRuleProximity:
+					if pa, pb := pincodeProximity(a.Pincode, pincode), pincodeProximity(b.Pincode, pincode); pa != pb {
+						return pa > pb
+					}
+				case models.AllocationRulePriority:
+					if a.Priority != b.Priority {
+						return a.Priority < b.Priority
+					}
+				}
+			}
+			return false
+		})
+	}
+
+	var allocations []models.StockAllocation
+	taken := make(map[string]int)
+	for _, n := range needs {
+		remaining := n.line.Quantity
+		for _, location := range ranked {
+			if remaining == 0 {
+				break
+			}
+			key := ledgerKey(n.line.ProductID, n.line.VariantID, "") + "@" + location.ID
+			quantity := min(remaining, n.stock[location.ID]-taken[key])
+			if quantity <= 0 {
+				continue
+			}
+			taken[key] += quantity
+			remaining -= quantity
+			allocations = append(allocations, models.StockAllocation{
+				ItemIndex:  n.line.ItemIndex,
+				Component:  n.line.Component,
+				ProductID:  n.line.ProductID,
+				VariantID:  n.line.VariantID,
+				LocationID: location.ID,
+				Quantity:   quantity,
+			})
+		}
+		if remaining > 0 {
+			allocations = append(allocations, models.StockAllocation{
+				ItemIndex: n.line.ItemIndex,
+				Component: n.line.Component,
+				ProductID: n.line.ProductID,
+				VariantID: n.line.VariantID,
+				Quantity:  remaining,
+			})
+		}
+	}
+	return allocations, nil
+}
+
+// planOrderAllocation loads the order's products and the locations and allocates it
+func (h *InventoryHandler) planOrderAllocation(order models.Order, forcedLocation string) ([]models.StockAllocation, error) {
+	products := make(map[string]*models.Product)
+	for _, line := range orderStockLines(order) {
+		if products[line.ProductID] != nil {
+			continue
+		}
+		doc, err := h.db.Client.Collection("products").Doc(line.ProductID).Get(h.db.Context)
+		if status.Code(err) == codes.NotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil {
+			return nil, err
+		}
+		products[line.ProductID] = &product
+	}
+
+	locations, err := h.loadLocations()
+	if err != nil {
+		return nil, err
+	}
+	return allocateOrder(order, products, locations, getInventorySettings(h.db).AllocationRules, forcedLocation)
+}
+
+// ensureOrderAllocation returns the order's saved allocation, allocating and saving it
+// if it has none. The check and save run in a transaction so concurrent calls, e.g.
+// from payment verification and the webhook, agree on one allocation.
+func (h *InventoryHandler) ensureOrderAllocation(order models.Order) ([]models.StockAllocation, error) {
+	orderRef := h.db.Client.Collection("orders").Doc(order.ID)
+	var allocations []models.StockAllocation
+	err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(orderRef)
+		if err != nil {
+			return err
+		}
+		var stored models.Order
+		if err := doc.DataTo(&stored); err != nil {
+			return err
+		}
+		if len(stored.Allocations) > 0 {
+			allocations = stored.Allocations
+			return nil
+		}
+
+		allocations, err = h.planOrderAllocation(order, "")
+		if err != nil {
+			return err
+		}
+		if len(allocations) == 0 {
+			return nil
+		}
+		return tx.Update(orderRef, []firestore.Update{{Path: "allocations", Value: allocations}})
+	})
+	return allocations, err
+}
+
+// GetStockLocations lists stock locations with the number of units each holds
+func (h *InventoryHandler) GetStockLocations(c *gin.Context) {
+	locations, err := h.loadLocations()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock locations"})
+		return
+	}
+
+	docs, err := h.db.Client.Collection("products").Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+	units := make(map[string]int)
+	unassigned := 0
+	for _, doc := range docs {
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil || product.IsGiftCard() {
+			continue
+		}
+		for locationID, quantity := range product.LocationStock {
+			units[locationID] += quantity
+		}
+		unassigned += unassignedStock(product.StockQuantity, product.LocationStock)
+		for _, variant := range product.Variants {
+			for locationID, quantity := range variant.LocationStock {
+				units[locationID] += quantity
+			}
+			unassigned += unassignedStock(variant.StockQuantity, variant.LocationStock)
+		}
+	}
+
+	type locationSummary struct {
+		models.StockLocation
+		Units int `json:"units"`
+	}
+	summaries := make([]locationSummary, 0, len(locations))
+	for _, location := range locations {
+		summaries = append(summaries, locationSummary{location, units[location.ID]})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"locations":        summaries,
+		"count":            len(summaries),
+		"unassigned_units": unassigned,
+	})
+}
+
+// saveStockLocation validates a location request against the other locations' codes
+func (h *InventoryHandler) saveStockLocation(c *gin.Context, location *models.StockLocation) bool {
+	var req models.StockLocationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+	locations, err := h.loadLocations()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock locations"})
+		return false
+	}
+	for _, other := range locations {
+		if other.ID != location.ID && other.Code == code {
+			c.JSON(http.StatusConflict, gin.H{"error": "Another location uses this code"})
+			return false
+		}
+	}
+
+	location.Name = strings.TrimSpace(req.Name)
+	location.Code = code
+	location.Type = req.Type
+	location.ContactName = req.ContactName
+	location.Phone = req.Phone
+	location.Address = req.Address
+	location.City = req.City
+	location.State = req.State
+	location.Pincode = strings.ReplaceAll(req.Pincode, " ", "")
+	location.Priority = req.Priority
+	location.Active = req.Active == nil || *req.Active
+	location.UpdatedAt = time.Now()
+	return true
+}
+
+// CreateStockLocation adds a godown or partner location
+func (h *InventoryHandler) CreateStockLocation(c *gin.Context) {
+	location := models.StockLocation{CreatedAt: time.Now()}
+	if !h.saveStockLocation(c, &location) {
+		return
+	}
+
+	docRef, _, err := h.db.Client.Collection("stock_locations").Add(h.db.Context, location)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stock location"})
+		return
+	}
+	location.ID = docRef.ID
+
+	c.JSON(http.StatusCreated, location)
+}
+
+// UpdateStockLocation edits a location. Deactivating it stops new orders being
+// allocated to it; its stock stays until transferred.
+func (h *InventoryHandler) UpdateStockLocation(c *gin.Context) {
+	location, err := h.getLocation(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock location"})
+		return
+	}
+	if location == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock location not found"})
+		return
+	}
+	if !h.saveStockLocation(c, location) {
+		return
+	}
+
+	if _, err := h.db.Client.Collection("stock_locations").Doc(location.ID).Set(h.db.Context, location); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update stock location"})
+		return
+	}
+
+	c.JSON(http.StatusOK, location)
+}
+
+// locationStockLevels lists every product and variant with stock at a location
+func (h *InventoryHandler) locationStockLevels(locationID string) ([]models.LocationStockLevel, error) {
+	docs, err := h.db.Client.Collection("products").Documents(h.db.Context).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	levels := make([]models.LocationStockLevel, 0)
+	for _, doc := range docs {
+		var product models.Product
+		if err := database.DecodeProduct(doc, &product); err != nil {
+			continue
+		}
+		if quantity := product.LocationStock[locationID]; quantity > 0 {
+			levels = append(levels, models.LocationStockLevel{
+				ProductID:   doc.Ref.ID,
+				ProductName: product.Name,
+				SKU:         product.SKU,
+				Quantity:    quantity,
+			})
+		}
+		for _, variant := range product.Variants {
+			if quantity := variant.LocationStock[locationID]; quantity > 0 {
+				levels = append(levels, models.LocationStockLevel{
+					ProductID:   doc.Ref.ID,
+					ProductName: product.Name,
+					SKU:         product.SKU,
+					VariantID:   variant.ID,
+					VariantSKU:  variant.SKU,
+					Quantity:    quantity,
+				})
+			}
+		}
+	}
+	return levels, nil
+}
+
+// GetLocationStock lists the stock held at a location
+func (h *InventoryHandler) GetLocationStock(c *gin.Context) {
+	levels, err := h.locationStockLevels(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch location stock"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"stock": levels, "count": len(levels)})
+}
+
+// DeleteStockLocation removes a location that no longer holds any stock
+func (h *InventoryHandler) DeleteStockLocation(c *gin.Context) {
+	locationID := c.Param("id")
+	levels, err := h.locationStockLevels(locationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch location stock"})
+		return
+	}
+	if len(levels) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Transfer this location's stock before deleting it", "stock": levels})
+		return
+	}
+
+	if _, err := h.db.Client.Collection("stock_locations").Doc(locationID).Delete(h.db.Context); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete stock location"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stock location deleted"})
+}
+
+// TransferStock moves stock of a product or variant between locations, or assigns
+// unassigned stock to a location. Both ledger entries are written with the stock.
+func (h *InventoryHandler) TransferStock(c *gin.Context) {
+	var req models.StockTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.FromLocationID == req.ToLocationID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Source and destination must differ"})
+		return
+	}
+	if !h.locationsExist(c, req.FromLocationID, req.ToLocationID) {
+		return
+	}
+
+	move := stockMove{
+		ProductID: req.ProductID,
+		VariantID: req.VariantID,
+		Type:      models.StockMovementTransfer,
+		Reason:    strings.TrimSpace(req.Reason),
+		Actor:     c.GetString("user_id"),
+	}
+	out, in := move, move
+	out.LocationID, out.Delta = req.FromLocationID, -req.Quantity
+	in.LocationID, in.Delta = req.ToLocationID, req.Quantity
+
+	if err := h.applyProductStockMoves(req.ProductID, []stockMove{out, in}); err != nil {
+		if errors.Is(err, errInsufficientStock) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Not enough stock at the source location"})
+			return
+		}
+		if errors.Is(err, errBundleStock) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stock transferred"})
+}
+
+// AllocateOrder allocates an order to locations by the rules, or to one location, for
+// orders whose stock hasn't been taken yet
+func (h *InventoryHandler) AllocateOrder(c *gin.Context) {
+	orderID := c.Param("id")
+	var req models.AllocateOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	doc, err := h.db.Client.Collection("orders").Doc(orderID).Get(h.db.Context)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	var order models.Order
+	if err := doc.DataTo(&order); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse order"})
+		return
+	}
+	order.ID = orderID
+
+	movements, err := h.db.Client.Collection("stock_movements").Where("order_id", "==", orderID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check order stock"})
+		return
+	}
+	for _, movement := range movements {
+		if moveType, _ := movement.Data()["type"].(string); moveType == models.StockMovementSale {
+			c.JSON(http.StatusConflict, gin.H{"error": "Stock has already been taken for this order"})
+			return
+		}
+	}
+
+	if !h.locationsExist(c, req.LocationID) {
+		return
+	}
+
+	allocations, err := h.planOrderAllocation(order, req.LocationID)
+	if errors.Is(err, errLocationShort) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This location doesn't have enough stock for the order"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to allocate order"})
+		return
+	}
+
+	_, err = h.db.Client.Collection("orders").Doc(orderID).Update(h.db.Context, []firestore.Update{
+		{Path: "allocations", Value: allocations},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save allocation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"allocations": allocations})
+}
@@ -1,25 +1,37 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
 	"tripund-api/internal/database"
 	"tripund-api/internal/models"
+	"tripund-api/internal/services"
 	"tripund-api/internal/utils"
 )
 
 type StockRequestHandler struct {
-	db *database.Firebase
+	db              *database.Firebase
+	emailService    *services.SendGridEmailService
+	whatsappService *services.WhatsAppService
 }
 
-func NewStockRequestHandler(db *database.Firebase) *StockRequestHandler {
-	return &StockRequestHandler{db: db}
+func NewStockRequestHandler(db *database.Firebase, whatsappService *services.WhatsAppService) *StockRequestHandler {
+	emailService, err := services.NewSendGridEmailService()
+	if err != nil {
+		log.Printf("WARNING: Back in stock emails disabled: %v", err)
+		emailService = nil
+	}
+	return &StockRequestHandler{db: db, emailService: emailService, whatsappService: whatsappService}
 }
 
 // CreateStockRequest allows users to request out-of-stock products
@@ -53,7 +65,7 @@ func (h *StockRequestHandler) CreateStockRequest(c *gin.Context) {
 	}
 
 	var product models.Product
-	if err := productDoc.DataTo(&product); err != nil {
+	if err := database.DecodeProduct(productDoc, &product); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse product data"})
 		return
 	}
@@ -319,4 +331,185 @@ func (h *StockRequestHandler) DeleteStockRequest(c *gin.Context) {
 		"success": true,
 		"message": "Stock request deleted successfully",
 	})
-}
\ No newline at end of file
+}
+// restockedUnit is a product, or one of its variants, whose stock went from zero to
+// positive
+type restockedUnit struct {
+	color string
+	size  string
+	stock int
+}
+
+// restockedUnits compares a product's stock before and after a change
+func restockedUnits(before, after *models.Product) []restockedUnit {
+	if len(after.Variants) == 0 {
+		if before.StockQuantity <= 0 && after.StockQuantity > 0 {
+			return []restockedUnit{{stock: after.StockQuantity}}
+		}
+		return nil
+	}
+
+	previous := make(map[string]int)
+	for _, variant := range before.Variants {
+		previous[ledgerKey("", variant.ID, variant.SKU)] = variant.StockQuantity
+	}
+	var units []restockedUnit
+	for _, variant := range after.Variants {
+		if previous[ledgerKey("", variant.ID, variant.SKU)] <= 0 && variant.StockQuantity > 0 && variant.Available {
+			units = append(units, restockedUnit{color: variant.Color, size: variant.Size, stock: variant.StockQuantity})
+		}
+	}
+	return units
+}
+
+// matches reports whether a request is for this unit. Requests without a colour or
+// size accept any.
+func (u restockedUnit) matches(request models.StockRequest) bool {
+	return (request.VariantColor == "" || strings.EqualFold(request.VariantColor, u.color)) &&
+		(request.VariantSize == "" || strings.EqualFold(request.VariantSize, u.size))
+}
+
+// backInStockURL links to the product with the requested variant selected
+func backInStockURL(productID string, request models.StockRequest) string {
+	link := productURL(productID)
+	query := url.Values{}
+	if request.VariantColor != "" {
+		query.Set("color", request.VariantColor)
+	}
+	if request.VariantSize != "" {
+		query.Set("size", request.VariantSize)
+	}
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link
+}
+
+// NotifyBackInStock tells pending requesters of a product, or of its variants, that
+// came back into stock. Requests are served by priority and then first come first
+// served; while stock is below total demand, only requests the new stock can cover
+// are notified and the rest stay pending for the next restock.
+func (h *StockRequestHandler) NotifyBackInStock(before, after *models.Product) {
+	if after.Status != "" && after.Status != models.ProductStatusActive {
+		return
+	}
+	units := restockedUnits(before, after)
+	if len(units) == 0 {
+		return
+	}
+
+	docs, err := h.db.Client.Collection("stock_requests").Where("product_id", "==", after.ID).Documents(h.db.Context).GetAll()
+	if err != nil {
+		log.Printf("Failed to fetch stock requests for product %s: %v", after.ID, err)
+		return
+	}
+	var pending []models.StockRequest
+	for _, doc := range docs {
+		var request models.StockRequest
+		if err := doc.DataTo(&request); err == nil && request.Status == "pending" {
+			request.ID = doc.Ref.ID
+			pending = append(pending, request)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		if pending[i].Priority != pending[j].Priority {
+			return pending[i].Priority > pending[j].Priority
+		}
+		return pending[i].RequestedAt.Before(pending[j].RequestedAt)
+	})
+
+	for _, request := range pending {
+		for i := range units {
+			unit := &units[i]
+			if unit.stock <= 0 || !unit.matches(request) {
+				continue
+			}
+			if err := h.notifyRequester(after, request); err != nil {
+				log.Printf("Failed to notify stock request %s: %v", request.ID, err)
+				break
+			}
+			unit.stock -= max(request.Quantity, 1)
+			break
+		}
+	}
+}
+
+// errRequestHandled is returned when another restock already claimed a request
+var errRequestHandled = errors.New("stock request is no longer pending")
+
+// notifyRequester marks a pending request contacted and sends the message, by WhatsApp
+// if the requester has a phone number and otherwise, or if that fails, by email. The
+// request goes back to pending if neither can be sent.
+func (h *StockRequestHandler) notifyRequester(product *models.Product, request models.StockRequest) error {
+	ref := h.db.Client.Collection("stock_requests").Doc(request.ID)
+	now := time.Now()
+	err := h.db.Client.RunTransaction(h.db.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if current, _ := doc.Data()["status"].(string); current != "pending" {
+			return errRequestHandled
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: "contacted"},
+			{Path: "contacted_at", Value: now},
+			{Path: "updated_at", Value: now},
+		})
+	})
+	if errors.Is(err, errRequestHandled) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	name := product.Name
+	var label []string
+	for _, part := range []string{request.VariantColor, request.VariantSize} {
+		if part != "" {
+			label = append(label, part)
+		}
+	}
+	if len(label) > 0 {
+		name += " (" + strings.Join(label, " / ") + ")"
+	}
+	link := backInStockURL(product.ID, request)
+
+	via := ""
+	if request.UserPhone != "" && h.whatsappService != nil {
+		path := strings.TrimPrefix(link, "https://tripundlifestyle.com/")
+		if err := h.whatsappService.SendBackInStock(request.UserPhone, request.UserName, name, path); err == nil {
+			via = "whatsapp"
+		}
+	}
+	if via == "" && request.UserEmail != "" && h.emailService != nil {
+		data := services.BackInStockData{
+			CustomerName: request.UserName,
+			ProductName:  name,
+			ProductURL:   link,
+			ImageURL:     request.ProductImage,
+		}
+		if err := h.emailService.SendBackInStock(request.UserEmail, data); err == nil {
+			via = "email"
+		}
+	}
+
+	if via == "" {
+		_, err := ref.Update(h.db.Context, []firestore.Update{
+			{Path: "status", Value: "pending"},
+			{Path: "contacted_at", Value: firestore.Delete},
+			{Path: "updated_at", Value: time.Now()},
+		})
+		if err != nil {
+			log.Printf("Failed to return stock request %s to pending: %v", request.ID, err)
+		}
+		return errors.New("no WhatsApp number or email reached the requester")
+	}
+
+	_, err = ref.Update(h.db.Context, []firestore.Update{{Path: "notified_via", Value: via}})
+	if err != nil {
+		log.Printf("Failed to record how stock request %s was notified: %v", request.ID, err)
+	}
+	log.Printf("Stock request %s: %s notified by %s that %s is back in stock", request.ID, request.UserName, via, name)
+	return nil
+}
@@ -56,6 +56,26 @@ func RequirePermission(permission string) gin.HandlerFunc {
 	}
 }
 
+// HasPermission reports whether the admin making the request has a permission, for
+// handlers whose behaviour depends on it
+func HasPermission(c *gin.Context, permission string) bool {
+	claims, _ := c.Get("claims")
+	jwtClaims, ok := claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	if role, _ := jwtClaims["role"].(string); role == models.RoleSuperAdmin {
+		return true
+	}
+	permissions, _ := jwtClaims["permissions"].([]interface{})
+	for _, p := range permissions {
+		if pStr, ok := p.(string); ok && pStr == permission {
+			return true
+		}
+	}
+	return false
+}
+
 // RequireRole middleware checks if user has specific role or higher
 func RequireRole(requiredRole string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -78,10 +78,11 @@ const (
 	PermissionUsersDelete = "users.delete"
 	
 	// Product Management
-	PermissionProductsView   = "products.view"
-	PermissionProductsCreate = "products.create"
-	PermissionProductsEdit   = "products.edit"
-	PermissionProductsDelete = "products.delete"
+	PermissionProductsView    = "products.view"
+	PermissionProductsCreate  = "products.create"
+	PermissionProductsEdit    = "products.edit"
+	PermissionProductsDelete  = "products.delete"
+	PermissionProductsPublish = "products.publish" // Make product edits live; without it edits are saved as drafts
 	
 	// Order Management
 	PermissionOrdersView   = "orders.view"
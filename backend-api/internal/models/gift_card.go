@@ -0,0 +1,118 @@
+package models
+
+import "time"
+
+type GiftCardStatus string
+
+const (
+	GiftCardStatusActive   GiftCardStatus = "active"
+	GiftCardStatusRedeemed GiftCardStatus = "redeemed" // Balance fully used
+	GiftCardStatusExpired  GiftCardStatus = "expired"
+	GiftCardStatusVoided   GiftCardStatus = "voided"
+)
+
+type GiftCardTransactionType string
+
+const (
+	GiftCardTransactionIssue  GiftCardTransactionType = "issue"
+	GiftCardTransactionRedeem GiftCardTransactionType = "redeem"
+	GiftCardTransactionRefund GiftCardTransactionType = "refund"
+	GiftCardTransactionVoid   GiftCardTransactionType = "void"
+)
+
+// GiftCardValidityMonths is the default validity of a newly issued gift card
+const GiftCardValidityMonths = 12
+
+// GiftCard is a prepaid voucher with a balance that can be redeemed across orders
+type GiftCard struct {
+	ID             string         `json:"id" firestore:"id"`
+	Code           string         `json:"code" firestore:"code"`
+	PIN            string         `json:"-" firestore:"pin"`
+	InitialBalance float64        `json:"initial_balance" firestore:"initial_balance"`
+	Balance        float64        `json:"balance" firestore:"balance"`
+	Currency       string         `json:"currency" firestore:"currency"`
+	Status         GiftCardStatus `json:"status" firestore:"status"`
+
+	// Purchase information (empty for cards issued by admin)
+	PurchaseOrderID string `json:"purchase_order_id,omitempty" firestore:"purchase_order_id,omitempty"`
+	PurchaserUserID string `json:"purchaser_user_id,omitempty" firestore:"purchaser_user_id,omitempty"`
+	PurchaserName   string `json:"purchaser_name,omitempty" firestore:"purchaser_name,omitempty"`
+
+	// Recipient and delivery
+	RecipientName  string     `json:"recipient_name" firestore:"recipient_name"`
+	RecipientEmail string     `json:"recipient_email,omitempty" firestore:"recipient_email,omitempty"`
+	RecipientPhone string     `json:"recipient_phone,omitempty" firestore:"recipient_phone,omitempty"`
+	Message        string     `json:"message,omitempty" firestore:"message,omitempty"`
+	DeliveryMethod string     `json:"delivery_method" firestore:"delivery_method"` // email, whatsapp, both
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty" firestore:"delivered_at,omitempty"`
+
+	// Validity
+	ExpiresAt  time.Time  `json:"expires_at" firestore:"expires_at"`
+	VoidedAt   *time.Time `json:"voided_at,omitempty" firestore:"voided_at,omitempty"`
+	VoidedBy   string     `json:"voided_by,omitempty" firestore:"voided_by,omitempty"`
+	VoidReason string     `json:"void_reason,omitempty" firestore:"void_reason,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" firestore:"updated_at"`
+	CreatedBy string    `json:"created_by,omitempty" firestore:"created_by,omitempty"`
+}
+
+// IsRedeemable reports whether the card can be used for payment at the given time
+func (g *GiftCard) IsRedeemable(now time.Time) bool {
+	return g.Status == GiftCardStatusActive && g.Balance > 0 && now.Before(g.ExpiresAt)
+}
+
+// GiftCardTransaction records every change to a gift card balance
+type GiftCardTransaction struct {
+	ID           string                  `json:"id" firestore:"id"`
+	GiftCardID   string                  `json:"gift_card_id" firestore:"gift_card_id"`
+	OrderID      string                  `json:"order_id,omitempty" firestore:"order_id,omitempty"`
+	Type         GiftCardTransactionType `json:"type" firestore:"type"`
+	Amount       float64                 `json:"amount" firestore:"amount"`
+	BalanceAfter float64                 `json:"balance_after" firestore:"balance_after"`
+	Note         string                  `json:"note,omitempty" firestore:"note,omitempty"`
+	CreatedBy    string                  `json:"created_by,omitempty" firestore:"created_by,omitempty"`
+	CreatedAt    time.Time               `json:"created_at" firestore:"created_at"`
+}
+
+// GiftCardIssuance records the gift cards issued for an order. Its ID is the order ID,
+// so an order's cards are issued only once however often payment is confirmed.
+type GiftCardIssuance struct {
+	OrderID     string    `json:"order_id" firestore:"order_id"`
+	GiftCardIDs []string  `json:"gift_card_ids" firestore:"gift_card_ids"`
+	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
+}
+
+// GiftCardRecipient carries delivery details for a gift card line in an order
+type GiftCardRecipient struct {
+	Name           string `json:"name" firestore:"name"`
+	Email          string `json:"email,omitempty" firestore:"email,omitempty"`
+	Phone          string `json:"phone,omitempty" firestore:"phone,omitempty"`
+	Message        string `json:"message,omitempty" firestore:"message,omitempty"`
+	DeliveryMethod string `json:"delivery_method,omitempty" firestore:"delivery_method,omitempty"` // email, whatsapp, both
+}
+
+type IssueGiftCardRequest struct {
+	Amount         float64 `json:"amount" binding:"required,gt=0"`
+	RecipientName  string  `json:"recipient_name" binding:"required"`
+	RecipientEmail string  `json:"recipient_email,omitempty"`
+	RecipientPhone string  `json:"recipient_phone,omitempty"`
+	Message        string  `json:"message,omitempty"`
+	DeliveryMethod string  `json:"delivery_method,omitempty"`
+	ValidityMonths int     `json:"validity_months,omitempty"`
+}
+
+type GiftCardBalanceRequest struct {
+	Code string `json:"code" binding:"required"`
+	PIN  string `json:"pin" binding:"required"`
+}
+
+// GiftCardAttempts counts failed gift card checks from one IP address or on one code,
+// keyed by "ip:<address>" or "code:<code>", to stop PINs being guessed
+type GiftCardAttempts struct {
+	Key         string     `json:"key" firestore:"key"`
+	Failures    int        `json:"failures" firestore:"failures"`
+	WindowStart time.Time  `json:"window_start" firestore:"window_start"`
+	LockedUntil *time.Time `json:"locked_until,omitempty" firestore:"locked_until,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at" firestore:"updated_at"`
+}
@@ -0,0 +1,86 @@
+package models
+
+import "time"
+
+// Stock movement types
+const (
+	StockMovementSale        = "sale"        // Stock sold on an order
+	StockMovementReservation = "reservation" // Stock held for an order that isn't paid yet
+	StockMovementRelease     = "release"     // Sold or held stock put back, e.g. when an order is cancelled
+	StockMovementRestock     = "restock"     // New stock received
+	StockMovementReturn      = "return"      // Goods returned by a customer and back in stock
+	StockMovementAdjustment  = "adjustment"  // Manual correction, e.g. damage or edits in the product form
+	StockMovementStocktake   = "stocktake"   // Physical count replacing the recorded stock
+	StockMovementTransfer    = "transfer"    // Stock moved between locations, one entry out and one in
+)
+
+// StockMovement is one entry of the inventory ledger. Every change to a product's or
+// variant's stock is recorded, so current stock is the sum of the deltas of its
+// entries. Variant movements have a VariantID or, for variants without one, a VariantSKU.
+// Movements at a location have its LocationID, and their Before and After are the
+// stock at that location.
+type StockMovement struct {
+	ID         string    `json:"id" firestore:"-"`
+	ProductID  string    `json:"product_id" firestore:"product_id"`
+	VariantID  string    `json:"variant_id,omitempty" firestore:"variant_id,omitempty"`
+	VariantSKU string    `json:"variant_sku,omitempty" firestore:"variant_sku,omitempty"`
+	LocationID string    `json:"location_id,omitempty" firestore:"location_id,omitempty"`
+	SKU        string    `json:"sku" firestore:"sku"`
+	Type       string    `json:"type" firestore:"type"`
+	Delta      int       `json:"delta" firestore:"delta"` // Negative when stock goes out
+	Before     int       `json:"before" firestore:"before"`
+	After      int       `json:"after" firestore:"after"`
+	OrderID    string    `json:"order_id,omitempty" firestore:"order_id,omitempty"`
+	Reason     string    `json:"reason,omitempty" firestore:"reason,omitempty"`
+	Actor      string    `json:"actor" firestore:"actor"` // Admin user ID, or "system"
+	CreatedAt  time.Time `json:"created_at" firestore:"created_at"`
+}
+
+// StockDiscrepancy is a product or variant whose stock doesn't match its ledger
+type StockDiscrepancy struct {
+	ProductID   string `json:"product_id"`
+	ProductName string `json:"product_name"`
+	VariantID   string `json:"variant_id,omitempty"`
+	VariantSKU  string `json:"variant_sku,omitempty"`
+	SKU         string `json:"sku"`
+	Stock       int    `json:"stock"`
+	LedgerStock int    `json:"ledger_stock"`
+	Difference  int    `json:"difference"` // Stock minus ledger stock
+}
+
+type StockAdjustmentRequest struct {
+	ProductID  string `json:"product_id" binding:"required"`
+	VariantID  string `json:"variant_id"`
+	LocationID string `json:"location_id"`
+	Type       string `json:"type" binding:"required"`     // restock, return or adjustment
+	Quantity   int    `json:"quantity" binding:"required"` // Change in stock
+	OrderID    string `json:"order_id"`
+	Reason     string `json:"reason"`
+}
+
+type StocktakeRequest struct {
+	Items []struct {
+		ProductID  string `json:"product_id" binding:"required"`
+		VariantID  string `json:"variant_id"`
+		LocationID string `json:"location_id"`
+		Count      int    `json:"count" binding:"min=0"`
+	} `json:"items" binding:"required,dive"`
+	Reason string `json:"reason"`
+}
+
+// LowStockAlert is a product or variant at or below its reorder threshold. It exists
+// from when the stock first crosses the threshold until it is restocked above it, so
+// each crossing alerts once.
+type LowStockAlert struct {
+	ID          string    `json:"id" firestore:"-"`
+	ProductID   string    `json:"product_id" firestore:"product_id"`
+	VariantID   string    `json:"variant_id,omitempty" firestore:"variant_id,omitempty"`
+	VariantSKU  string    `json:"variant_sku,omitempty" firestore:"variant_sku,omitempty"`
+	ProductName string    `json:"product_name" firestore:"product_name"` // With the variant's colour and size
+	SKU         string    `json:"sku" firestore:"sku"`
+	Stock       int       `json:"stock" firestore:"stock"`
+	Threshold   int       `json:"threshold" firestore:"threshold"`
+	OutOfStock  bool      `json:"out_of_stock" firestore:"out_of_stock"`
+	AlertedAt   time.Time `json:"alerted_at" firestore:"alerted_at"`
+	UpdatedAt   time.Time `json:"updated_at" firestore:"updated_at"`
+}
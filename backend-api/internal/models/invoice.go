@@ -39,6 +39,8 @@ type InvoiceLineItem struct {
 	IGSTRate    float64 `json:"igst_rate,omitempty" firestore:"igst_rate"`
 	IGSTAmount  float64 `json:"igst_amount,omitempty" firestore:"igst_amount"`
 	TotalAmount float64 `json:"total_amount" firestore:"total_amount"`
+	TaxExempt   bool    `json:"tax_exempt,omitempty" firestore:"tax_exempt"` // e.g. gift card purchase, taxed at redemption
+	Components  []InvoiceLineItem `json:"components,omitempty" firestore:"components,omitempty"` // A bundle's contents under their own HSN codes; the line's amounts are their sums
 }
 
 type InvoiceAddress struct {
@@ -79,6 +81,8 @@ type TaxSummary struct {
 	GrandTotal       float64 `json:"grand_total" firestore:"grand_total"`
 	RoundingAmount   float64 `json:"rounding_amount,omitempty" firestore:"rounding_amount"`
 	FinalAmount      float64 `json:"final_amount" firestore:"final_amount"`
+	GiftCardRedeemed float64 `json:"gift_card_redeemed,omitempty" firestore:"gift_card_redeemed"`
+	AmountPayable    float64 `json:"amount_payable" firestore:"amount_payable"`
 }
 
 type Invoice struct {
@@ -113,6 +117,10 @@ type Invoice struct {
 	// Tax Summary
 	TaxSummary        TaxSummary         `json:"tax_summary" firestore:"tax_summary"`
 	
+	// Gift card used as payment; the goods remain fully taxable
+	GiftCardCode      string             `json:"gift_card_code,omitempty" firestore:"gift_card_code"`
+	GiftCardRedeemed  float64            `json:"gift_card_redeemed,omitempty" firestore:"gift_card_redeemed"`
+	
 	// Payment Information
 	BankDetails       BankDetails        `json:"bank_details" firestore:"bank_details"`
 	PaymentTerms      string             `json:"payment_terms,omitempty" firestore:"payment_terms"`
@@ -170,6 +178,8 @@ func (i *Invoice) CalculateTaxSummary() {
 		GrandTotal:       grandTotal,
 		RoundingAmount:   roundingAmount,
 		FinalAmount:      finalAmount,
+		GiftCardRedeemed: i.GiftCardRedeemed,
+		AmountPayable:    finalAmount - i.GiftCardRedeemed,
 	}
 }
 
@@ -183,6 +193,10 @@ func (i *Invoice) IsB2B() bool {
 
 // Helper to determine if IGST or CGST+SGST should be applied
 func (item *InvoiceLineItem) ApplyGST(gstRate float64, isInterState bool) {
+	if item.TaxExempt {
+		gstRate = 0
+	}
+	
 	if isInterState {
 		// Inter-state: Apply IGST
 		item.IGSTRate = gstRate
@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// Stock location types
+const (
+	LocationTypeWarehouse = "warehouse" // Our own godown
+	LocationTypePartner   = "partner"   // Stock held at an artisan partner
+)
+
+// Allocation rules, applied in the configured order to rank the locations an order
+// can ship from
+const (
+	AllocationRuleFullAvailability = "full_availability" // Locations that can ship the whole order first
+	AllocationRuleProximity        = "proximity"         // Locations whose pincode is closest to the delivery pincode first
+	AllocationRulePriority         = "priority"          // Locations with the lowest priority number first
+)
+
+// StockLocation is a place stock is held. Products and variants keep their quantity
+// at each location in LocationStock, keyed by location ID.
+type StockLocation struct {
+	ID          string    `json:"id" firestore:"-"`
+	Name        string    `json:"name" firestore:"name"`
+	Code        string    `json:"code" firestore:"code"`
+	Type        string    `json:"type" firestore:"type"`
+	ContactName string    `json:"contact_name,omitempty" firestore:"contact_name,omitempty"`
+	Phone       string    `json:"phone,omitempty" firestore:"phone,omitempty"`
+	Address     string    `json:"address,omitempty" firestore:"address,omitempty"`
+	City        string    `json:"city,omitempty" firestore:"city,omitempty"`
+	State       string    `json:"state,omitempty" firestore:"state,omitempty"`
+	Pincode     string    `json:"pincode" firestore:"pincode"`
+	Priority    int       `json:"priority" firestore:"priority"` // Lower ships first
+	Active      bool      `json:"active" firestore:"active"`     // Inactive locations keep their stock but aren't allocated orders
+	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// StockAllocation is the quantity of an order item shipped from a location. An empty
+// LocationID is stock not assigned to any location. For bundle items, Component is the
+// 1-based index of the bundle component allocated.
+type StockAllocation struct {
+	ItemIndex  int    `json:"item_index" firestore:"item_index"`
+	Component  int    `json:"component,omitempty" firestore:"component,omitempty"`
+	ProductID  string `json:"product_id" firestore:"product_id"`
+	VariantID  string `json:"variant_id,omitempty" firestore:"variant_id,omitempty"`
+	LocationID string `json:"location_id" firestore:"location_id"`
+	Quantity   int    `json:"quantity" firestore:"quantity"`
+}
+
+// LocationStockLevel is a product's or variant's stock at one location
+type LocationStockLevel struct {
+	ProductID   string `json:"product_id"`
+	ProductName string `json:"product_name"`
+	SKU         string `json:"sku"`
+	VariantID   string `json:"variant_id,omitempty"`
+	VariantSKU  string `json:"variant_sku,omitempty"`
+	Quantity    int    `json:"quantity"`
+}
+
+type StockLocationRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Code        string `json:"code" binding:"required"`
+	Type        string `json:"type" binding:"required,oneof=warehouse partner"`
+	ContactName string `json:"contact_name"`
+	Phone       string `json:"phone"`
+	Address     string `json:"address"`
+	City        string `json:"city"`
+	State       string `json:"state"`
+	Pincode     string `json:"pincode" binding:"required"`
+	Priority    int    `json:"priority"`
+	Active      *bool  `json:"active"` // Defaults to true
+}
+
+type StockTransferRequest struct {
+	ProductID      string `json:"product_id" binding:"required"`
+	VariantID      string `json:"variant_id"`
+	FromLocationID string `json:"from_location_id"` // Empty moves unassigned stock
+	ToLocationID   string `json:"to_location_id" binding:"required"`
+	Quantity       int    `json:"quantity" binding:"required,min=1"`
+	Reason         string `json:"reason"`
+}
+
+type AllocateOrderRequest struct {
+	LocationID string `json:"location_id"` // Ship everything from this location instead of applying the rules
+}
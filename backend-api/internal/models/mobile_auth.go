@@ -43,6 +43,10 @@ type MobileUser struct {
 	Wishlist     []string  `firestore:"wishlist,omitempty" json:"wishlist,omitempty"`
 	Addresses    []UserAddress `firestore:"addresses,omitempty" json:"addresses,omitempty"`
 	OrderHistory []string  `firestore:"order_history,omitempty" json:"order_history,omitempty"`
+	
+	// Referral program
+	ReferralCode string    `firestore:"referral_code,omitempty" json:"referral_code,omitempty"`
+	ReferredBy   string    `firestore:"referred_by,omitempty" json:"referred_by,omitempty"` // Referrer's user ID
 }
 
 type MobileUserProfile struct {
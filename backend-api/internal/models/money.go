@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Money is an amount in rupees. It reads JSON numbers and numeric strings, with blank
+// strings and null as zero, since the admin forms send prices as text.
+type Money float64
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	amount, err := ParseMoney(value)
+	if err != nil {
+		return err
+	}
+	*m = Money(amount)
+	return nil
+}
+
+// ParseMoney reads an amount stored or sent as a number or as text such as "1,299" or
+// "₹499". Nil and blank text are zero; anything else that isn't a non-negative amount
+// is an error.
+func ParseMoney(value interface{}) (float64, error) {
+	var amount float64
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		amount = v
+	case float32:
+		amount = float64(v)
+	case int64:
+		amount = float64(v)
+	case int:
+		amount = float64(v)
+	case Money:
+		amount = float64(v)
+	case string:
+		text := strings.NewReplacer("₹", "", "Rs.", "", "Rs", "", ",", "").Replace(strings.TrimSpace(v))
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return 0, nil
+		}
+		parsed, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not an amount", v)
+		}
+		amount = parsed
+	default:
+		return 0, fmt.Errorf("%v (%T) is not an amount", value, value)
+	}
+	if math.IsNaN(amount) || math.IsInf(amount, 0) || amount < 0 {
+		return 0, fmt.Errorf("%v is not a valid amount", value)
+	}
+	return amount, nil
+}
+
+// legacyTimeLayouts are the layouts older documents stored times as text in
+var legacyTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+// ParseTime reads a time stored as a timestamp, as text in one of the layouts older
+// documents used, or as Unix seconds or milliseconds. Nil is the zero time.
+func ParseTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return v, nil
+	case int64:
+		if v > 1e12 {
+			return time.UnixMilli(v), nil
+		}
+		return time.Unix(v, 0), nil
+	case float64:
+		return ParseTime(int64(v))
+	case string:
+		text := strings.TrimSpace(v)
+		if text == "" {
+			return time.Time{}, nil
+		}
+		for _, layout := range legacyTimeLayouts {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("%q is not a time", v)
+	}
+	return time.Time{}, fmt.Errorf("%v (%T) is not a time", value, value)
+}
@@ -3,24 +3,34 @@ package models
 import "time"
 
 type Order struct {
-	ID            string      `json:"id" firestore:"id"`
-	OrderNumber   string      `json:"order_number" firestore:"order_number"`
-	UserID        string      `json:"user_id" firestore:"user_id"`
-	GuestEmail    string      `json:"guest_email,omitempty" firestore:"guest_email,omitempty"`
-	GuestName     string      `json:"guest_name,omitempty" firestore:"guest_name,omitempty"`
-	GuestPhone    string      `json:"guest_phone,omitempty" firestore:"guest_phone,omitempty"`
-	Items         []OrderItem `json:"items" firestore:"items"`
-	ShippingAddress UserAddress  `json:"shipping_address" firestore:"shipping_address"`
-	BillingAddress  UserAddress  `json:"billing_address" firestore:"billing_address"`
-	Payment       Payment     `json:"payment" firestore:"payment"`
-	Totals        OrderTotals `json:"totals" firestore:"totals"`
-	Status        string      `json:"status" firestore:"status"`
-	Tracking      *Tracking   `json:"tracking,omitempty" firestore:"tracking"`
-	Notes         string      `json:"notes" firestore:"notes"`
-	CreatedAt     time.Time   `json:"created_at" firestore:"created_at"`
-	UpdatedAt     time.Time   `json:"updated_at" firestore:"updated_at"`
+	ID                string             `json:"id" firestore:"id"`
+	OrderNumber       string             `json:"order_number" firestore:"order_number"`
+	UserID            string             `json:"user_id" firestore:"user_id"`
+	GuestEmail        string             `json:"guest_email,omitempty" firestore:"guest_email,omitempty"`
+	GuestName         string             `json:"guest_name,omitempty" firestore:"guest_name,omitempty"`
+	GuestPhone        string             `json:"guest_phone,omitempty" firestore:"guest_phone,omitempty"`
+	Items             []OrderItem        `json:"items" firestore:"items"`
+	ShippingAddress   UserAddress        `json:"shipping_address" firestore:"shipping_address"`
+	BillingAddress    UserAddress        `json:"billing_address" firestore:"billing_address"`
+	ShippingMethod    string             `json:"shipping_method,omitempty" firestore:"shipping_method,omitempty"` // standard or express
+	Payment           Payment            `json:"payment" firestore:"payment"`
+	Totals            OrderTotals        `json:"totals" firestore:"totals"`
+	AppliedOffers     []AppliedOffer     `json:"applied_offers,omitempty" firestore:"applied_offers,omitempty"`
+	DiscountDecisions []DiscountDecision `json:"discount_decisions,omitempty" firestore:"discount_decisions,omitempty"` // Why each discount was applied or rejected
+	Status            string             `json:"status" firestore:"status"`
+	Tracking          *Tracking          `json:"tracking,omitempty" firestore:"tracking"`
+	Allocations       []StockAllocation  `json:"allocations,omitempty" firestore:"allocations,omitempty"` // Locations the items ship from
+	StockHeld         bool               `json:"stock_held,omitempty" firestore:"stock_held,omitempty"`   // Stock is reserved while payment is awaited
+	Notes             string             `json:"notes" firestore:"notes"`
+	CreatedAt         time.Time          `json:"created_at" firestore:"created_at"`
+	UpdatedAt         time.Time          `json:"updated_at" firestore:"updated_at"`
 }
 
+const (
+	ShippingMethodStandard = "standard"
+	ShippingMethodExpress  = "express"
+)
+
 type OrderItem struct {
 	ProductID    string  `json:"product_id" firestore:"product_id"`
 	ProductName  string  `json:"product_name" firestore:"product_name"`
@@ -31,37 +41,62 @@ type OrderItem struct {
 	Discount     float64 `json:"discount" firestore:"discount"`
 	Total        float64 `json:"total" firestore:"total"`
 	// Variant information if applicable
-	VariantID    string  `json:"variant_id,omitempty" firestore:"variant_id,omitempty"`
-	VariantColor string  `json:"variant_color,omitempty" firestore:"variant_color,omitempty"`
-	VariantSize  string  `json:"variant_size,omitempty" firestore:"variant_size,omitempty"`
+	VariantID    string `json:"variant_id,omitempty" firestore:"variant_id,omitempty"`
+	VariantColor string `json:"variant_color,omitempty" firestore:"variant_color,omitempty"`
+	VariantSize  string `json:"variant_size,omitempty" firestore:"variant_size,omitempty"`
+	// Gift card information if applicable
+	ProductType string             `json:"product_type,omitempty" firestore:"product_type,omitempty"`
+	GiftCard    *GiftCardRecipient `json:"gift_card,omitempty" firestore:"gift_card,omitempty"`
+	GiftCardIDs []string           `json:"gift_card_ids,omitempty" firestore:"gift_card_ids,omitempty"`
+	// Bundle contents, as they were when the order was placed
+	BundleComponents []OrderBundleComponent `json:"bundle_components,omitempty" firestore:"bundle_components,omitempty"`
+}
+
+// OrderBundleComponent is a component of a bundle order item. ValueShare is the part
+// of the bundle's price it accounts for, by the components' prices, for invoicing.
+type OrderBundleComponent struct {
+	ProductID   string  `json:"product_id" firestore:"product_id"`
+	VariantID   string  `json:"variant_id,omitempty" firestore:"variant_id,omitempty"`
+	ProductName string  `json:"product_name" firestore:"product_name"`
+	SKU         string  `json:"sku" firestore:"sku"`
+	Quantity    int     `json:"quantity" firestore:"quantity"` // Per bundle
+	HSNCode     string  `json:"hsn_code,omitempty" firestore:"hsn_code,omitempty"`
+	ValueShare  float64 `json:"value_share" firestore:"value_share"`
 }
 
 type Payment struct {
-	Method          string    `json:"method" firestore:"method"`
-	Status          string    `json:"status" firestore:"status"`
-	TransactionID   string    `json:"transaction_id" firestore:"transaction_id"`
-	RazorpayOrderID string    `json:"razorpay_order_id" firestore:"razorpay_order_id"`
-	RazorpayPaymentID string  `json:"razorpay_payment_id" firestore:"razorpay_payment_id"`
-	RazorpaySignature string  `json:"razorpay_signature" firestore:"razorpay_signature"`
-	PaymentMethod   string    `json:"payment_method,omitempty" firestore:"payment_method,omitempty"` // card, upi, netbanking, wallet
-	Bank            string    `json:"bank,omitempty" firestore:"bank,omitempty"`                     // Bank name for netbanking/cards
-	Wallet          string    `json:"wallet,omitempty" firestore:"wallet,omitempty"`                 // Wallet name if applicable
-	Amount          float64   `json:"amount" firestore:"amount"`
-	Currency        string    `json:"currency" firestore:"currency"`
-	PaidAt          time.Time `json:"paid_at" firestore:"paid_at"`
+	Method            string    `json:"method" firestore:"method"`
+	Status            string    `json:"status" firestore:"status"`
+	TransactionID     string    `json:"transaction_id" firestore:"transaction_id"`
+	RazorpayOrderID   string    `json:"razorpay_order_id" firestore:"razorpay_order_id"`
+	RazorpayPaymentID string    `json:"razorpay_payment_id" firestore:"razorpay_payment_id"`
+	RazorpaySignature string    `json:"razorpay_signature" firestore:"razorpay_signature"`
+	PaymentMethod     string    `json:"payment_method,omitempty" firestore:"payment_method,omitempty"` // card, upi, netbanking, wallet
+	Bank              string    `json:"bank,omitempty" firestore:"bank,omitempty"`                     // Bank name for netbanking/cards
+	Wallet            string    `json:"wallet,omitempty" firestore:"wallet,omitempty"`                 // Wallet name if applicable
+	Amount            float64   `json:"amount" firestore:"amount"`
+	Currency          string    `json:"currency" firestore:"currency"`
+	PaidAt            time.Time `json:"paid_at" firestore:"paid_at"`
 }
 
 type OrderTotals struct {
-	Subtotal     float64 `json:"subtotal" firestore:"subtotal"`
-	Discount     float64 `json:"discount" firestore:"discount"`
-	Tax          float64 `json:"tax" firestore:"tax"`
-	CGST         float64 `json:"cgst" firestore:"cgst"`
-	SGST         float64 `json:"sgst" firestore:"sgst"`
-	IGST         float64 `json:"igst" firestore:"igst"`
-	Shipping     float64 `json:"shipping" firestore:"shipping"`
-	Total        float64 `json:"total" firestore:"total"`
-	CouponCode   string  `json:"coupon_code" firestore:"coupon_code"`
-	CouponAmount float64 `json:"coupon_amount" firestore:"coupon_amount"`
+	Subtotal         float64 `json:"subtotal" firestore:"subtotal"`
+	Discount         float64 `json:"discount" firestore:"discount"`
+	Tax              float64 `json:"tax" firestore:"tax"`
+	CGST             float64 `json:"cgst" firestore:"cgst"`
+	SGST             float64 `json:"sgst" firestore:"sgst"`
+	IGST             float64 `json:"igst" firestore:"igst"`
+	Shipping         float64 `json:"shipping" firestore:"shipping"`
+	Total            float64 `json:"total" firestore:"total"`
+	CouponCode       string  `json:"coupon_code" firestore:"coupon_code"`
+	CouponAmount     float64 `json:"coupon_amount" firestore:"coupon_amount"`
+	OfferDiscount    float64 `json:"offer_discount,omitempty" firestore:"offer_discount,omitempty"` // Automatic promotions
+	PrepaidDiscount  float64 `json:"prepaid_discount,omitempty" firestore:"prepaid_discount,omitempty"`
+	ReferralDiscount float64 `json:"referral_discount,omitempty" firestore:"referral_discount,omitempty"`
+	ShippingDiscount float64 `json:"shipping_discount,omitempty" firestore:"shipping_discount,omitempty"` // Shipping charge waived by a free shipping promotion
+	// Gift card redemption is a payment instrument, not a discount
+	GiftCardCode   string  `json:"gift_card_code,omitempty" firestore:"gift_card_code,omitempty"`
+	GiftCardAmount float64 `json:"gift_card_amount,omitempty" firestore:"gift_card_amount,omitempty"`
 }
 
 type Tracking struct {
@@ -80,4 +115,3 @@ type Cart struct {
 	CreatedAt time.Time  `json:"created_at" firestore:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at" firestore:"updated_at"`
 }
-
@@ -1,29 +1,71 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
 // ProductVariant represents a product variation (size/color combination)
 type ProductVariant struct {
 	ID            string      `json:"id" firestore:"id"`
 	Color         string      `json:"color" firestore:"color"`
 	Size          string      `json:"size" firestore:"size"`
-	Price         interface{} `json:"price" firestore:"price"`
-	SalePrice     interface{} `json:"sale_price,omitempty" firestore:"sale_price,omitempty"`
+	Price         Money       `json:"price" firestore:"price"` // 0 sells at the product's price
+	SalePrice     Money       `json:"sale_price,omitempty" firestore:"sale_price,omitempty"`
+	SaleEndsAt    *time.Time  `json:"sale_ends_at,omitempty" firestore:"sale_ends_at,omitempty"` // End of the scheduled sale the sale price belongs to
+	CurrentPrice  float64     `json:"current_price" firestore:"-"`                              // What the customer pays now, for API responses
 	SKU           string      `json:"sku" firestore:"sku"`
 	StockQuantity int         `json:"stock_quantity" firestore:"stock_quantity"`
+	LocationStock map[string]int `json:"location_stock,omitempty" firestore:"location_stock,omitempty"` // Stock per location ID
+	ReorderThreshold *int     `json:"reorder_threshold,omitempty" firestore:"reorder_threshold,omitempty"` // Low-stock alert level; the product's applies if unset
 	Images        []string    `json:"images,omitempty" firestore:"images,omitempty"`
 	Available     bool        `json:"available" firestore:"available"`
 }
 
+// Product types; an empty type is a regular physical product
+const (
+	ProductTypeGiftCard = "gift_card"
+	ProductTypeBundle   = "bundle" // Kit made of other products, stocked through them
+)
+
+// BundleComponent is a product, or one of its variants, and how many of it go into
+// one bundle
+type BundleComponent struct {
+	ProductID string `json:"product_id" firestore:"product_id"`
+	VariantID string `json:"variant_id,omitempty" firestore:"variant_id,omitempty"`
+	Quantity  int    `json:"quantity" firestore:"quantity"`
+}
+
+// Product statuses; only active products are shown in the store
+const (
+	ProductStatusActive   = "active"
+	ProductStatusDraft    = "draft"
+	ProductStatusArchived = "archived"
+)
+
+// Stock statuses set by admins
+const (
+	StockStatusInStock    = "in_stock"
+	StockStatusOutOfStock = "out_of_stock"
+	StockStatusBackorder  = "on_backorder"
+)
+
 type Product struct {
 	ID               string                 `json:"id" firestore:"-"`
 	SKU              string                 `json:"sku" firestore:"sku"`
+	ProductType      string                 `json:"product_type,omitempty" firestore:"product_type,omitempty"`
 	Name             string                 `json:"name" firestore:"name"`
 	Slug             string                 `json:"slug" firestore:"slug"`
 	Description      string                 `json:"description" firestore:"description"`
 	ShortDescription string                 `json:"short_description" firestore:"short_description"`
 	Price            float64                `json:"price" firestore:"price"`
-	SalePrice        interface{}            `json:"sale_price" firestore:"sale_price"`
+	SalePrice        Money                  `json:"sale_price" firestore:"sale_price"`
+	SaleEndsAt       *time.Time             `json:"sale_ends_at,omitempty" firestore:"sale_ends_at,omitempty"` // End of the scheduled sale the sale price belongs to
+	CurrentPrice     float64                `json:"current_price" firestore:"-"`                              // What the customer pays now, for API responses
 	ManageStock      bool                   `json:"manage_stock" firestore:"manage_stock"`
-	StockQuantity    int                    `json:"stock_quantity" firestore:"stock_quantity"`
+	StockQuantity    int                    `json:"stock_quantity" firestore:"stock_quantity"` // Sellable stock, summed across locations
+	LocationStock    map[string]int         `json:"location_stock,omitempty" firestore:"location_stock,omitempty"` // Stock per location ID
+	ReorderThreshold *int                   `json:"reorder_threshold,omitempty" firestore:"reorder_threshold,omitempty"` // Low-stock alert level; the store default applies if unset
 	StockStatus      string                 `json:"stock_status" firestore:"stock_status"`
 	Featured         bool                   `json:"featured" firestore:"featured"`
 	Status           string                 `json:"status" firestore:"status"`
@@ -34,8 +76,8 @@ type Product struct {
 	Attributes       []map[string]interface{} `json:"attributes" firestore:"attributes"`
 	Dimensions       map[string]interface{}   `json:"dimensions" firestore:"dimensions"`
 	Weight           map[string]interface{}   `json:"weight" firestore:"weight"`
-	CreatedAt        interface{}            `json:"created_at" firestore:"created_at"`
-	UpdatedAt        interface{}            `json:"updated_at" firestore:"updated_at"`
+	CreatedAt        time.Time              `json:"created_at" firestore:"created_at"`
+	UpdatedAt        time.Time              `json:"updated_at" firestore:"updated_at"`
 	ParsedDescription map[string]interface{} `json:"parsed_description,omitempty" firestore:"parsed_description,omitempty"`
 	
 	// Variant support
@@ -43,6 +85,112 @@ type Product struct {
 	Variants         []ProductVariant  `json:"variants,omitempty" firestore:"variants,omitempty"`
 	AvailableColors  []string          `json:"available_colors,omitempty" firestore:"available_colors,omitempty"`
 	AvailableSizes   []string          `json:"available_sizes,omitempty" firestore:"available_sizes,omitempty"`
+	
+	// Bundle components; a bundle's stock is how many complete bundles its components make
+	BundleComponents []BundleComponent `json:"bundle_components,omitempty" firestore:"bundle_components,omitempty"`
+	BundleProductIDs []string          `json:"-" firestore:"bundle_product_ids,omitempty"` // Component product IDs, for finding the bundles a product is in
+
+	HSNCode string `json:"hsn_code,omitempty" firestore:"hsn_code,omitempty"` // Invoiced under the default HSN code if unset
+
+	// Review summary, maintained from approved reviews
+	AverageRating float64 `json:"average_rating" firestore:"average_rating"`
+	ReviewCount   int     `json:"review_count" firestore:"review_count"`
+
+	// Published questions and answers, loaded with the product and not stored on it
+	Questions []ProductQuestion `json:"questions,omitempty" firestore:"-"`
+}
+
+// IsGiftCard reports whether the product is a purchasable gift card
+func (p *Product) IsGiftCard() bool {
+	return p.ProductType == ProductTypeGiftCard
+}
+
+// IsBundle reports whether the product is a bundle of other products
+func (p *Product) IsBundle() bool {
+	return p.ProductType == ProductTypeBundle
+}
+
+// EffectivePrice is what the customer pays: the sale price when one is set below the
+// regular price and its sale hasn't ended, otherwise the price
+func (p *Product) EffectivePrice() float64 {
+	if sale := float64(p.SalePrice); sale > 0 && sale < p.Price && saleRunning(p.SaleEndsAt) {
+		return sale
+	}
+	return p.Price
+}
+
+// VariantPrice is what the customer pays for a variant. Variants without a price of
+// their own sell at the product's.
+func (p *Product) VariantPrice(variant *ProductVariant) float64 {
+	price := float64(variant.Price)
+	if price <= 0 {
+		return p.EffectivePrice()
+	}
+	if sale := float64(variant.SalePrice); sale > 0 && sale < price && saleRunning(variant.SaleEndsAt) {
+		return sale
+	}
+	return price
+}
+
+// SetCurrentPrices fills in the prices the product and its variants sell at now
+func (p *Product) SetCurrentPrices() {
+	p.CurrentPrice = p.EffectivePrice()
+	for i := range p.Variants {
+		p.Variants[i].CurrentPrice = p.VariantPrice(&p.Variants[i])
+	}
+}
+
+// saleRunning reports whether a sale ending at endsAt is still on. The scheduler
+// clears ended sales, so this only covers the time until it runs.
+func saleRunning(endsAt *time.Time) bool {
+	return endsAt == nil || time.Now().Before(*endsAt)
+}
+
+// InStock reports whether the product can be bought now. Products with variants are in
+// stock while any variant is; products that don't manage stock follow their stock status.
+func (p *Product) InStock() bool {
+	if p.HasVariants && len(p.Variants) > 0 {
+		for _, variant := range p.Variants {
+			if variant.Available && variant.StockQuantity > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	if !p.ManageStock {
+		return p.StockStatus != StockStatusOutOfStock
+	}
+	return p.StockQuantity > 0
+}
+
+// Colors returns the product's colours from its variants and available colours
+func (p *Product) Colors() []string {
+	colors := append([]string{}, p.AvailableColors...)
+	for _, variant := range p.Variants {
+		colors = append(colors, variant.Color)
+	}
+	return uniqueFold(colors)
+}
+
+// Sizes returns the product's sizes from its variants and available sizes
+func (p *Product) Sizes() []string {
+	sizes := append([]string{}, p.AvailableSizes...)
+	for _, variant := range p.Variants {
+		sizes = append(sizes, variant.Size)
+	}
+	return uniqueFold(sizes)
+}
+
+// uniqueFold drops blanks and case-insensitive duplicates, keeping the first spelling
+func uniqueFold(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value != "" && !containsFold(result, value) {
+			result = append(result, value)
+		}
+	}
+	return result
 }
 
 // Removed individual structs as we're using map[string]interface{} 
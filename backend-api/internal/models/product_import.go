@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Product import row outcomes
+const (
+	ImportActionCreate = "create"
+	ImportActionUpdate = "update"
+	ImportActionError  = "error"
+)
+
+// ProductImport records one bulk product upload and the outcome of each row. Dry runs
+// are recorded too so their report can be downloaded before the real upload.
+type ProductImport struct {
+	ID        string             `json:"id" firestore:"-"`
+	FileName  string             `json:"file_name" firestore:"file_name"`
+	Format    string             `json:"format" firestore:"format"` // csv or xlsx
+	DryRun    bool               `json:"dry_run" firestore:"dry_run"`
+	TotalRows int                `json:"total_rows" firestore:"total_rows"`
+	Created   int                `json:"created" firestore:"created"` // Products created
+	Updated   int                `json:"updated" firestore:"updated"` // Products updated
+	Failed    int                `json:"failed" firestore:"failed"`   // Rows rejected
+	Warnings  []string           `json:"warnings,omitempty" firestore:"warnings,omitempty"`
+	Results   []ProductImportRow `json:"results" firestore:"results"`
+	CreatedBy string             `json:"created_by,omitempty" firestore:"created_by,omitempty"`
+	CreatedAt time.Time          `json:"created_at" firestore:"created_at"`
+}
+
+// ProductImportRow is the outcome of one row of an import file. Row is the line in the
+// file, counting the header as row 1.
+type ProductImportRow struct {
+	Row        int      `json:"row" firestore:"row"`
+	SKU        string   `json:"sku" firestore:"sku"`
+	VariantSKU string   `json:"variant_sku,omitempty" firestore:"variant_sku,omitempty"`
+	Action     string   `json:"action" firestore:"action"`
+	ProductID  string   `json:"product_id,omitempty" firestore:"product_id,omitempty"`
+	Errors     []string `json:"errors,omitempty" firestore:"errors,omitempty"`
+}
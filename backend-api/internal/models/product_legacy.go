@@ -0,0 +1,79 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// LegacyProduct reads product documents written before prices and times were typed,
+// which hold them as strings, integers or floats. Its fields shadow the Product's.
+type LegacyProduct struct {
+	Product
+	Price     interface{}     `firestore:"price"`
+	SalePrice interface{}     `firestore:"sale_price"`
+	Variants  []LegacyVariant `firestore:"variants,omitempty"`
+	CreatedAt interface{}     `firestore:"created_at"`
+	UpdatedAt interface{}     `firestore:"updated_at"`
+}
+
+// LegacyVariant reads a variant of a legacy product document
+type LegacyVariant struct {
+	ProductVariant
+	Price     interface{} `firestore:"price"`
+	SalePrice interface{} `firestore:"sale_price,omitempty"`
+}
+
+// ProductAnomaly is a stored product value that couldn't be read as its field's type.
+// The field is read as zero.
+type ProductAnomaly struct {
+	Field   string      `json:"field"`
+	Value   interface{} `json:"value"`
+	Problem string      `json:"problem"`
+}
+
+func (a ProductAnomaly) String() string {
+	return fmt.Sprintf("%s: %s", a.Field, a.Problem)
+}
+
+// Normalize converts the legacy values to the Product's types. It returns the fields
+// whose stored values were converted and those that couldn't be read.
+func (l *LegacyProduct) Normalize() (Product, []string, []ProductAnomaly) {
+	product := l.Product
+	var converted []string
+	var anomalies []ProductAnomaly
+
+	money := func(field string, value interface{}) float64 {
+		amount, err := ParseMoney(value)
+		if err != nil {
+			anomalies = append(anomalies, ProductAnomaly{Field: field, Value: value, Problem: err.Error()})
+		} else if _, typed := value.(float64); !typed && value != nil {
+			converted = append(converted, field)
+		}
+		return amount
+	}
+	when := func(field string, value interface{}) time.Time {
+		t, err := ParseTime(value)
+		if err != nil {
+			anomalies = append(anomalies, ProductAnomaly{Field: field, Value: value, Problem: err.Error()})
+		} else if value == nil || t.IsZero() {
+			anomalies = append(anomalies, ProductAnomaly{Field: field, Value: value, Problem: "missing"})
+		} else if _, typed := value.(time.Time); !typed {
+			converted = append(converted, field)
+		}
+		return t
+	}
+
+	product.Price = money("price", l.Price)
+	product.SalePrice = Money(money("sale_price", l.SalePrice))
+	product.CreatedAt = when("created_at", l.CreatedAt)
+	product.UpdatedAt = when("updated_at", l.UpdatedAt)
+
+	product.Variants = nil
+	for i, legacy := range l.Variants {
+		variant := legacy.ProductVariant
+		variant.Price = Money(money(fmt.Sprintf("variants[%d].price", i), legacy.Price))
+		variant.SalePrice = Money(money(fmt.Sprintf("variants[%d].sale_price", i), legacy.SalePrice))
+		product.Variants = append(product.Variants, variant)
+	}
+	return product, converted, anomalies
+}
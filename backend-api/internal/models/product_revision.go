@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Product revision actions
+const (
+	RevisionActionCreate   = "create"   // The product as first created
+	RevisionActionPublish  = "publish"  // Edits made live, directly or from a draft
+	RevisionActionRollback = "rollback" // An earlier revision restored
+	RevisionActionSchedule = "schedule" // A product schedule started or ended
+)
+
+// ProductFieldChange is one field of a product changed by a revision
+type ProductFieldChange struct {
+	Field  string      `json:"field" firestore:"field"`
+	Before interface{} `json:"before" firestore:"before"`
+	After  interface{} `json:"after" firestore:"after"`
+}
+
+// ProductRevision is a published version of a product: what changed, who published it,
+// and the whole product as it was afterwards, to roll back to
+type ProductRevision struct {
+	ID            string                 `json:"id" firestore:"-"`
+	ProductID     string                 `json:"product_id" firestore:"product_id"`
+	Action        string                 `json:"action" firestore:"action"`
+	Changes       []ProductFieldChange   `json:"changes" firestore:"changes"`
+	Snapshot      map[string]interface{} `json:"snapshot,omitempty" firestore:"snapshot"`
+	AuthorID      string                 `json:"author_id" firestore:"author_id"`
+	DraftAuthorID string                 `json:"draft_author_id,omitempty" firestore:"draft_author_id,omitempty"` // Who drafted the edits, when published from a draft
+	RolledBackTo  string                 `json:"rolled_back_to,omitempty" firestore:"rolled_back_to,omitempty"`   // Revision a rollback restored
+	CreatedAt     time.Time              `json:"created_at" firestore:"created_at"`
+}
+
+// ProductDraft is edits to a product waiting for someone with the publish permission.
+// A product has at most one draft, stored under the product's ID.
+type ProductDraft struct {
+	ProductID     string                 `json:"product_id" firestore:"-"`
+	Changes       map[string]interface{} `json:"changes" firestore:"changes"`
+	BaseUpdatedAt time.Time              `json:"base_updated_at" firestore:"base_updated_at"` // When the live product was last changed as the draft was started
+	CreatedBy     string                 `json:"created_by" firestore:"created_by"`
+	UpdatedBy     string                 `json:"updated_by" firestore:"updated_by"`
+	CreatedAt     time.Time              `json:"created_at" firestore:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at" firestore:"updated_at"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// Product schedule types
+const (
+	ScheduleTypePrice     = "price"     // Change the price and/or sale price from StartsAt on
+	ScheduleTypeSale      = "sale"      // Sale price from StartsAt until EndsAt, then the previous sale price again
+	ScheduleTypePublish   = "publish"   // Make the product active
+	ScheduleTypeUnpublish = "unpublish" // Take the product out of the store as a draft or archived
+)
+
+// Product schedule states
+const (
+	ScheduleStateScheduled = "scheduled" // Waiting for StartsAt
+	ScheduleStateActive    = "active"    // Sale running until EndsAt
+	ScheduleStateCompleted = "completed"
+	ScheduleStateCancelled = "cancelled"
+	ScheduleStateFailed    = "failed" // Couldn't be applied, see Error
+)
+
+// ProductSchedule is a change to a product's, or a variant's, prices or status that
+// the scheduler applies at a set time
+type ProductSchedule struct {
+	ID        string   `json:"id" firestore:"-"`
+	ProductID string   `json:"product_id" firestore:"product_id"`
+	VariantID string   `json:"variant_id,omitempty" firestore:"variant_id,omitempty"`
+	Type      string   `json:"type" firestore:"type"`
+	Price     *float64 `json:"price,omitempty" firestore:"price,omitempty"`
+	SalePrice *float64 `json:"sale_price,omitempty" firestore:"sale_price,omitempty"` // 0 in a price change removes the sale price
+	Status    string   `json:"status,omitempty" firestore:"status,omitempty"`         // Product status an unpublish sets
+
+	StartsAt time.Time  `json:"starts_at" firestore:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at,omitempty" firestore:"ends_at,omitempty"` // Sales only
+
+	// The sale price a sale replaced, restored when it ends
+	PreviousSalePrice Money `json:"previous_sale_price,omitempty" firestore:"previous_sale_price,omitempty"`
+
+	State     string     `json:"state" firestore:"state"`
+	Error     string     `json:"error,omitempty" firestore:"error,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty" firestore:"started_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty" firestore:"ended_at,omitempty"`
+	CreatedBy string     `json:"created_by" firestore:"created_by"`
+	CreatedAt time.Time  `json:"created_at" firestore:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" firestore:"updated_at"`
+}
+
+type ProductScheduleRequest struct {
+	VariantID string     `json:"variant_id"`
+	Type      string     `json:"type" binding:"required,oneof=price sale publish unpublish"`
+	Price     *float64   `json:"price"`
+	SalePrice *float64   `json:"sale_price"`
+	Status    string     `json:"status"` // draft (default) or archived, for unpublish
+	StartsAt  time.Time  `json:"starts_at" binding:"required"`
+	EndsAt    *time.Time `json:"ends_at"`
+}
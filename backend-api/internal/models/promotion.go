@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
@@ -9,6 +10,14 @@ type PromotionType string
 const (
 	PromotionTypePercentage PromotionType = "percentage"
 	PromotionTypeFixed      PromotionType = "fixed"
+
+	// Automatic offer types, applied without a code
+	PromotionTypeBuyXGetY PromotionType = "buy_x_get_y" // e.g. buy 2 get 1 free
+	PromotionTypeTiered   PromotionType = "tiered"      // e.g. spend ₹2,000 get 10%, ₹5,000 get 15%
+	PromotionTypeFreeGift PromotionType = "free_gift"   // free item once MinOrderValue is reached
+
+	// Waives the shipping charge; works as a code or as an automatic offer
+	PromotionTypeFreeShipping PromotionType = "free_shipping"
 )
 
 type PromotionStatus string
@@ -26,38 +35,284 @@ type Promotion struct {
 	Type        PromotionType   `json:"type" firestore:"type"`
 	Discount    float64         `json:"discount" firestore:"discount"`
 	Status      PromotionStatus `json:"status" firestore:"status"`
-	
+
+	// Automatic promotions apply to every qualifying cart and have no code
+	Automatic bool `json:"automatic" firestore:"automatic"`
+
+	// Unique single-use codes generated in batches under this promotion
+	UniqueCodes     bool `json:"unique_codes,omitempty" firestore:"unique_codes,omitempty"`
+	UniqueCodeCount int  `json:"unique_code_count,omitempty" firestore:"unique_code_count,omitempty"`
+
+	// Buy X get Y: for every BuyQuantity+GetQuantity eligible units, the cheapest
+	// GetQuantity units get GetDiscountPercent off (100 means free)
+	BuyQuantity        int     `json:"buy_quantity,omitempty" firestore:"buy_quantity,omitempty"`
+	GetQuantity        int     `json:"get_quantity,omitempty" firestore:"get_quantity,omitempty"`
+	GetDiscountPercent float64 `json:"get_discount_percent,omitempty" firestore:"get_discount_percent,omitempty"`
+
+	// Tiered spend discounts, evaluated against the eligible value
+	Tiers []PromotionTier `json:"tiers,omitempty" firestore:"tiers,omitempty"`
+
+	// Free gift added once the eligible value reaches MinOrderValue
+	FreeGiftProductID string `json:"free_gift_product_id,omitempty" firestore:"free_gift_product_id,omitempty"`
+	FreeGiftVariantID string `json:"free_gift_variant_id,omitempty" firestore:"free_gift_variant_id,omitempty"`
+	FreeGiftQuantity  int    `json:"free_gift_quantity,omitempty" firestore:"free_gift_quantity,omitempty"`
+
+	// Free shipping restrictions; empty lists mean every zone and method. Zones match the
+	// delivery city, state or the start of the postal code (e.g. "Mumbai", "Kerala", "560").
+	// MaxDiscount, if set, caps the shipping charge waived.
+	ShippingZones   []string `json:"shipping_zones,omitempty" firestore:"shipping_zones,omitempty"`
+	ShippingMethods []string `json:"shipping_methods,omitempty" firestore:"shipping_methods,omitempty"` // standard, express
+
+	// Stacking rules. Promotions are evaluated by descending priority; an exclusive
+	// promotion is never combined with another discount, and a non-empty StackableWith
+	// limits combinations to the listed promotion IDs or discount kinds.
+	Priority      int      `json:"priority" firestore:"priority"`
+	Exclusive     bool     `json:"exclusive" firestore:"exclusive"`
+	StackableWith []string `json:"stackable_with,omitempty" firestore:"stackable_with,omitempty"`
+
 	// Usage limits
 	MaxUses        int `json:"max_uses" firestore:"max_uses"`
 	UsedCount      int `json:"used_count" firestore:"used_count"`
 	MaxUsesPerUser int `json:"max_uses_per_user" firestore:"max_uses_per_user"`
-	
+
 	// Conditions
 	MinOrderValue float64 `json:"min_order_value" firestore:"min_order_value"`
 	MaxDiscount   float64 `json:"max_discount" firestore:"max_discount"`
-	
+
 	// Validity
 	StartDate time.Time `json:"start_date" firestore:"start_date"`
 	EndDate   time.Time `json:"end_date" firestore:"end_date"`
-	
+
+	// Applicability; empty include lists mean every product is eligible
+	ProductIDs           []string `json:"product_ids,omitempty" firestore:"product_ids,omitempty"`
+	ExcludeProductIDs    []string `json:"exclude_product_ids,omitempty" firestore:"exclude_product_ids,omitempty"`
+	Categories           []string `json:"categories,omitempty" firestore:"categories,omitempty"`
+	ExcludeCategories    []string `json:"exclude_categories,omitempty" firestore:"exclude_categories,omitempty"`
+	Subcategories        []string `json:"subcategories,omitempty" firestore:"subcategories,omitempty"`
+	ExcludeSubcategories []string `json:"exclude_subcategories,omitempty" firestore:"exclude_subcategories,omitempty"`
+	Tags                 []string `json:"tags,omitempty" firestore:"tags,omitempty"`
+	ExcludeTags          []string `json:"exclude_tags,omitempty" firestore:"exclude_tags,omitempty"`
+
 	// User restrictions
 	NewCustomersOnly bool     `json:"new_customers_only" firestore:"new_customers_only"`
 	AllowedUserIds   []string `json:"allowed_user_ids" firestore:"allowed_user_ids"`
-	
+
 	// Display settings
 	ShowInBanner bool `json:"show_in_banner" firestore:"show_in_banner"`
-	
+
 	// Timestamps
 	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" firestore:"updated_at"`
 	CreatedBy string    `json:"created_by" firestore:"created_by"`
 }
 
+// PromotionTier is one spend threshold of a tiered promotion
+type PromotionTier struct {
+	MinSpend float64       `json:"min_spend" firestore:"min_spend"`
+	Type     PromotionType `json:"type" firestore:"type"` // percentage or fixed
+	Discount float64       `json:"discount" firestore:"discount"`
+}
+
+// AppliedOffer records an automatic promotion applied to a cart or order
+type AppliedOffer struct {
+	PromotionID string        `json:"promotion_id" firestore:"promotion_id"`
+	Description string        `json:"description" firestore:"description"`
+	Type        PromotionType `json:"type" firestore:"type"`
+	Savings     float64       `json:"savings" firestore:"savings"`
+	FreeGift    *OrderItem    `json:"free_gift,omitempty" firestore:"free_gift,omitempty"`
+}
+
+// Discount kinds referenced by stacking rules
+const (
+	DiscountKindAutomatic   = "automatic"
+	DiscountKindPromoCode   = "promo_code"
+	DiscountKindPrepaid     = "prepaid"      // PaymentSettings.PrepaidDiscount for online payments
+	DiscountKindReferral    = "referral"     // First-order discount for referred customers
+	DiscountKindStoreCredit = "store_credit" // Gift card balance used as payment
+)
+
+// DiscountDecision explains whether a discount was applied to a cart and, if not, why
+type DiscountDecision struct {
+	Kind        string  `json:"kind" firestore:"kind"`
+	PromotionID string  `json:"promotion_id,omitempty" firestore:"promotion_id,omitempty"`
+	Code        string  `json:"code,omitempty" firestore:"code,omitempty"`
+	Description string  `json:"description" firestore:"description"`
+	Priority    int     `json:"priority" firestore:"priority"`
+	Applied     bool    `json:"applied" firestore:"applied"`
+	Amount      float64 `json:"amount" firestore:"amount"`
+	Reason      string  `json:"reason,omitempty" firestore:"reason,omitempty"`
+}
+
+type PromotionCodeStatus string
+
+const (
+	PromotionCodeAvailable PromotionCodeStatus = "available"
+	PromotionCodeRedeemed  PromotionCodeStatus = "redeemed"
+	PromotionCodeVoid      PromotionCodeStatus = "void"
+)
+
+// PromotionCode is a single-use code belonging to a parent promotion. Documents are
+// keyed by the code itself so validation is a direct lookup.
+type PromotionCode struct {
+	Code            string              `json:"code" firestore:"code"`
+	PromotionID     string              `json:"promotion_id" firestore:"promotion_id"`
+	BatchID         string              `json:"batch_id" firestore:"batch_id"`
+	Status          PromotionCodeStatus `json:"status" firestore:"status"`
+	RedeemedAt      *time.Time          `json:"redeemed_at,omitempty" firestore:"redeemed_at,omitempty"`
+	RedeemedOrderID string              `json:"redeemed_order_id,omitempty" firestore:"redeemed_order_id,omitempty"`
+	RedeemedOrderNo string              `json:"redeemed_order_number,omitempty" firestore:"redeemed_order_number,omitempty"`
+	RedeemedBy      string              `json:"redeemed_by,omitempty" firestore:"redeemed_by,omitempty"`
+	CreatedAt       time.Time           `json:"created_at" firestore:"created_at"`
+}
+
+// PromotionCodeBatch records one generation run of unique codes
+type PromotionCodeBatch struct {
+	ID          string    `json:"id" firestore:"id"`
+	PromotionID string    `json:"promotion_id" firestore:"promotion_id"`
+	Name        string    `json:"name,omitempty" firestore:"name,omitempty"` // e.g. influencer or campaign
+	Prefix      string    `json:"prefix" firestore:"prefix"`
+	Length      int       `json:"length" firestore:"length"`
+	Count       int       `json:"count" firestore:"count"`
+	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
+	CreatedBy   string    `json:"created_by,omitempty" firestore:"created_by,omitempty"`
+}
+
+type GeneratePromotionCodesRequest struct {
+	Name   string `json:"name,omitempty"`
+	Prefix string `json:"prefix"`
+	Length int    `json:"length"`
+	Count  int    `json:"count" binding:"required,min=1,max=10000"`
+}
+
+type PromotionUsageStatus string
+
+const (
+	PromotionUsageReserved PromotionUsageStatus = "reserved" // Held by an order awaiting payment
+	PromotionUsageRedeemed PromotionUsageStatus = "redeemed"
+	PromotionUsageReversed PromotionUsageStatus = "reversed" // Order cancelled or fully refunded
+)
+
 type PromotionUsage struct {
-	ID           string    `json:"id" firestore:"id,omitempty"`
-	PromotionID  string    `json:"promotion_id" firestore:"promotion_id"`
-	UserID       string    `json:"user_id" firestore:"user_id"`
-	OrderID      string    `json:"order_id" firestore:"order_id"`
-	DiscountApplied float64 `json:"discount_applied" firestore:"discount_applied"`
-	UsedAt       time.Time `json:"used_at" firestore:"used_at"`
-}
\ No newline at end of file
+	ID              string    `json:"id" firestore:"id,omitempty"`
+	PromotionID     string    `json:"promotion_id" firestore:"promotion_id"`
+	UserID          string    `json:"user_id" firestore:"user_id"`
+	OrderID         string    `json:"order_id" firestore:"order_id"`
+	DiscountApplied float64   `json:"discount_applied" firestore:"discount_applied"`
+	UsedAt          time.Time `json:"used_at" firestore:"used_at"`
+
+	// Redemption accounting
+	OrderNumber    string               `json:"order_number,omitempty" firestore:"order_number,omitempty"`
+	Code           string               `json:"code,omitempty" firestore:"code,omitempty"`
+	CustomerKeys   []string             `json:"customer_keys,omitempty" firestore:"customer_keys,omitempty"` // user, verified phone and email identities
+	Status         PromotionUsageStatus `json:"status,omitempty" firestore:"status,omitempty"`
+	ReversedAt     *time.Time           `json:"reversed_at,omitempty" firestore:"reversed_at,omitempty"`
+	ReversalReason string               `json:"reversal_reason,omitempty" firestore:"reversal_reason,omitempty"`
+}
+
+// IsActive reports whether the usage still counts towards limits. Records written
+// before usage statuses were introduced have no status and count as redeemed.
+func (u *PromotionUsage) IsActive() bool {
+	return u.Status != PromotionUsageReversed
+}
+
+// IsAutomaticType reports whether the promotion type is an automatic offer
+func (p *Promotion) IsAutomaticType() bool {
+	return p.Type == PromotionTypeBuyXGetY || p.Type == PromotionTypeTiered || p.Type == PromotionTypeFreeGift
+}
+
+// AllowsShippingMethod reports whether free shipping covers the shipping method
+func (p *Promotion) AllowsShippingMethod(method string) bool {
+	return len(p.ShippingMethods) == 0 || containsFold(p.ShippingMethods, method)
+}
+
+// CoversShippingZone reports whether free shipping covers the delivery address
+func (p *Promotion) CoversShippingZone(address UserAddress) bool {
+	if len(p.ShippingZones) == 0 {
+		return true
+	}
+	postalCode := strings.ReplaceAll(address.PostalCode, " ", "")
+	for _, zone := range p.ShippingZones {
+		zone = strings.TrimSpace(zone)
+		if zone == "" {
+			continue
+		}
+		if strings.EqualFold(zone, strings.TrimSpace(address.City)) ||
+			strings.EqualFold(zone, strings.TrimSpace(address.State)) ||
+			(postalCode != "" && strings.HasPrefix(postalCode, zone)) {
+			return true
+		}
+	}
+	return false
+}
+
+// DiscountKind returns the stacking kind of the promotion
+func (p *Promotion) DiscountKind() string {
+	if p.Automatic {
+		return DiscountKindAutomatic
+	}
+	return DiscountKindPromoCode
+}
+
+// CombinesWith reports whether the promotion's own rules allow it to be combined with
+// another discount, identified by promotion ID (empty for prepaid and store credit) and kind
+func (p *Promotion) CombinesWith(promotionID, kind string) bool {
+	if p.Exclusive {
+		return false
+	}
+	if len(p.StackableWith) == 0 {
+		return true
+	}
+	return (promotionID != "" && containsFold(p.StackableWith, promotionID)) || containsFold(p.StackableWith, kind)
+}
+
+// HasProductRules reports whether the promotion is limited to specific products
+func (p *Promotion) HasProductRules() bool {
+	return len(p.ProductIDs) > 0 || len(p.ExcludeProductIDs) > 0 ||
+		len(p.Categories) > 0 || len(p.ExcludeCategories) > 0 ||
+		len(p.Subcategories) > 0 || len(p.ExcludeSubcategories) > 0 ||
+		len(p.Tags) > 0 || len(p.ExcludeTags) > 0
+}
+
+// AppliesToProduct reports whether a product is eligible for the promotion.
+// Exclusions always win; if any include list is set the product must match one of them.
+// Gift cards are never discounted.
+func (p *Promotion) AppliesToProduct(product *Product) bool {
+	if product == nil || product.IsGiftCard() {
+		return false
+	}
+
+	if containsFold(p.ExcludeProductIDs, product.ID) ||
+		intersectsFold(p.ExcludeCategories, product.Categories) ||
+		intersectsFold(p.ExcludeSubcategories, product.Subcategories) ||
+		intersectsFold(p.ExcludeTags, product.Tags) {
+		return false
+	}
+
+	hasIncludes := len(p.ProductIDs) > 0 || len(p.Categories) > 0 || len(p.Subcategories) > 0 || len(p.Tags) > 0
+	if !hasIncludes {
+		return true
+	}
+
+	return containsFold(p.ProductIDs, product.ID) ||
+		intersectsFold(p.Categories, product.Categories) ||
+		intersectsFold(p.Subcategories, product.Subcategories) ||
+		intersectsFold(p.Tags, product.Tags)
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), strings.TrimSpace(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func intersectsFold(list []string, values []string) bool {
+	for _, value := range values {
+		if containsFold(list, value) {
+			return true
+		}
+	}
+	return false
+}
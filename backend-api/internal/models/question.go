@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// Question and answer moderation states; only published ones are shown on the product
+const (
+	QuestionStatusPending   = "pending"
+	QuestionStatusPublished = "published"
+	QuestionStatusRejected  = "rejected"
+)
+
+// Who wrote an answer
+const (
+	AnswerAuthorStore    = "store"
+	AnswerAuthorCustomer = "customer"
+)
+
+// ProductQuestion is a customer's question about a product with its answers. Questions
+// are published with their first published answer.
+type ProductQuestion struct {
+	ID             string          `json:"id" firestore:"-"`
+	ProductID      string          `json:"product_id" firestore:"product_id"`
+	ProductName    string          `json:"product_name" firestore:"product_name"`
+	UserID         string          `json:"user_id,omitempty" firestore:"user_id"`
+	UserName       string          `json:"user_name" firestore:"user_name"`
+	Question       string          `json:"question" firestore:"question"`
+	Status         string          `json:"status" firestore:"status"`
+	Answers        []ProductAnswer `json:"answers" firestore:"answers"`
+	InvitedUserIDs []string        `json:"invited_user_ids,omitempty" firestore:"invited_user_ids,omitempty"` // Buyers asked to answer
+	AnsweredAt     *time.Time      `json:"answered_at,omitempty" firestore:"answered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at" firestore:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at" firestore:"updated_at"`
+}
+
+// ProductAnswer answers a product question, either from the store or from a customer
+// who bought the product. Customer answers are moderated before they are shown.
+type ProductAnswer struct {
+	ID         string    `json:"id" firestore:"id"`
+	Text       string    `json:"text" firestore:"text"`
+	AuthorType string    `json:"author_type" firestore:"author_type"` // store or customer
+	AuthorID   string    `json:"author_id,omitempty" firestore:"author_id"`
+	AuthorName string    `json:"author_name" firestore:"author_name"`
+	Status     string    `json:"status" firestore:"status"`
+	CreatedAt  time.Time `json:"created_at" firestore:"created_at"`
+}
+
+type CreateQuestionRequest struct {
+	ProductID string `json:"product_id" binding:"required"`
+	Question  string `json:"question" binding:"required"`
+}
+
+type AnswerQuestionRequest struct {
+	Text string `json:"text" binding:"required"`
+}
+
+type ModerateQuestionRequest struct {
+	Status string `json:"status" binding:"required"` // published or rejected
+}
@@ -0,0 +1,71 @@
+package models
+
+import "time"
+
+type ReferralStatus string
+
+const (
+	ReferralStatusPending  ReferralStatus = "pending"  // Referee signed up, first order not yet delivered
+	ReferralStatusRewarded ReferralStatus = "rewarded" // First order delivered and referrer paid out
+	ReferralStatusFlagged  ReferralStatus = "flagged"  // Held for admin review by fraud checks
+	ReferralStatusRejected ReferralStatus = "rejected"
+)
+
+// Referral reward types
+const (
+	ReferralRewardStoreCredit = "store_credit" // Issued as a gift card
+	ReferralRewardCoupon      = "coupon"       // Single-use code under the reward promotion
+)
+
+// ReferralCode maps a customer's shareable code to their account. Documents are keyed
+// by the code itself.
+type ReferralCode struct {
+	Code      string    `json:"code" firestore:"code"`
+	UserID    string    `json:"user_id" firestore:"user_id"`
+	DeviceIDs []string  `json:"-" firestore:"device_ids,omitempty"` // Devices the referrer used, for fraud checks
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+}
+
+// Referral links a referee to the customer who invited them. Documents are keyed by the
+// referee's user ID so an account can only be referred once.
+type Referral struct {
+	ID            string         `json:"id" firestore:"id"`
+	Code          string         `json:"code" firestore:"code"`
+	ReferrerID    string         `json:"referrer_id" firestore:"referrer_id"`
+	ReferrerPhone string         `json:"referrer_phone,omitempty" firestore:"referrer_phone,omitempty"`
+	RefereeID     string         `json:"referee_id" firestore:"referee_id"`
+	RefereePhone  string         `json:"referee_phone,omitempty" firestore:"referee_phone,omitempty"`
+	RefereeName   string         `json:"referee_name,omitempty" firestore:"referee_name,omitempty"`
+	DeviceID      string         `json:"device_id,omitempty" firestore:"device_id,omitempty"`
+	Status        ReferralStatus `json:"status" firestore:"status"`
+	FraudFlags    []string       `json:"fraud_flags,omitempty" firestore:"fraud_flags,omitempty"`
+
+	// Conversion: the referee's first delivered order
+	OrderID     string     `json:"order_id,omitempty" firestore:"order_id,omitempty"`
+	OrderNumber string     `json:"order_number,omitempty" firestore:"order_number,omitempty"`
+	OrderTotal  float64    `json:"order_total,omitempty" firestore:"order_total,omitempty"`
+	ConvertedAt *time.Time `json:"converted_at,omitempty" firestore:"converted_at,omitempty"`
+
+	// Payout to the referrer
+	RewardType       string     `json:"reward_type,omitempty" firestore:"reward_type,omitempty"`
+	RewardAmount     float64    `json:"reward_amount,omitempty" firestore:"reward_amount,omitempty"`
+	RewardGiftCardID string     `json:"reward_gift_card_id,omitempty" firestore:"reward_gift_card_id,omitempty"`
+	RewardCode       string     `json:"reward_code,omitempty" firestore:"reward_code,omitempty"`
+	RewardedAt       *time.Time `json:"rewarded_at,omitempty" firestore:"rewarded_at,omitempty"`
+
+	// Admin review of flagged referrals
+	ReviewedBy string `json:"reviewed_by,omitempty" firestore:"reviewed_by,omitempty"`
+	ReviewNote string `json:"review_note,omitempty" firestore:"review_note,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+type ApplyReferralRequest struct {
+	Code     string `json:"code" binding:"required"`
+	DeviceID string `json:"device_id,omitempty"`
+}
+
+type ReviewReferralRequest struct {
+	Note string `json:"note,omitempty"`
+}
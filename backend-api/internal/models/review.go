@@ -0,0 +1,76 @@
+package models
+
+import "time"
+
+// Review moderation states; only approved reviews are shown and counted in the rating
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusApproved = "approved"
+	ReviewStatusRejected = "rejected"
+)
+
+// Review is a customer's star rating and write-up of a product. There is at most one
+// per customer and product.
+type Review struct {
+	ID               string       `json:"id" firestore:"-"`
+	ProductID        string       `json:"product_id" firestore:"product_id"`
+	ProductName      string       `json:"product_name" firestore:"product_name"`
+	UserID           string       `json:"user_id" firestore:"user_id"`
+	UserName         string       `json:"user_name" firestore:"user_name"`
+	Rating           int          `json:"rating" firestore:"rating"` // 1-5 stars
+	Title            string       `json:"title,omitempty" firestore:"title,omitempty"`
+	Body             string       `json:"body" firestore:"body"`
+	Photos           []string     `json:"photos,omitempty" firestore:"photos,omitempty"`
+	VerifiedPurchase bool         `json:"verified_purchase" firestore:"verified_purchase"`
+	OrderID          string       `json:"order_id,omitempty" firestore:"order_id,omitempty"` // Delivered order that verified the purchase
+	Status           string       `json:"status" firestore:"status"`
+	RejectionReason  string       `json:"rejection_reason,omitempty" firestore:"rejection_reason,omitempty"`
+	Reply            *ReviewReply `json:"reply,omitempty" firestore:"reply,omitempty"`
+	ModeratedBy      string       `json:"moderated_by,omitempty" firestore:"moderated_by,omitempty"`
+	ModeratedAt      *time.Time   `json:"moderated_at,omitempty" firestore:"moderated_at,omitempty"`
+	CreatedAt        time.Time    `json:"created_at" firestore:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at" firestore:"updated_at"`
+}
+
+// ReviewReply is the store's public response to a review
+type ReviewReply struct {
+	Text      string    `json:"text" firestore:"text"`
+	RepliedBy string    `json:"replied_by,omitempty" firestore:"replied_by,omitempty"`
+	RepliedAt time.Time `json:"replied_at" firestore:"replied_at"`
+}
+
+// ReviewSummary is the rating breakdown of a product's approved reviews
+type ReviewSummary struct {
+	AverageRating float64     `json:"average_rating"`
+	ReviewCount   int         `json:"review_count"`
+	Verified      int         `json:"verified"`
+	WithPhotos    int         `json:"with_photos"`
+	Distribution  map[int]int `json:"distribution"` // Stars -> number of reviews
+}
+
+// ReviewRequest records the email inviting a customer to review a delivered order, so
+// each order is only asked about once
+type ReviewRequest struct {
+	OrderID     string    `json:"order_id" firestore:"order_id"`
+	OrderNumber string    `json:"order_number" firestore:"order_number"`
+	UserID      string    `json:"user_id" firestore:"user_id"`
+	ProductIDs  []string  `json:"product_ids" firestore:"product_ids"`
+	SentAt      time.Time `json:"sent_at" firestore:"sent_at"`
+}
+
+type CreateReviewRequest struct {
+	ProductID string   `json:"product_id" binding:"required"`
+	Rating    int      `json:"rating" binding:"required,min=1,max=5"`
+	Title     string   `json:"title"`
+	Body      string   `json:"body" binding:"required"`
+	Photos    []string `json:"photos"`
+}
+
+type ModerateReviewRequest struct {
+	Status string `json:"status" binding:"required"` // approved or rejected
+	Reason string `json:"reason"`
+}
+
+type ReplyReviewRequest struct {
+	Text string `json:"text" binding:"required"`
+}
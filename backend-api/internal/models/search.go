@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// SearchSynonym is a group of words customers use for the same thing, including Hindi
+// transliterations, e.g. diya, deepak and divo. Searching for any of them finds the others.
+type SearchSynonym struct {
+	ID        string    `json:"id" firestore:"-"`
+	Terms     []string  `json:"terms" firestore:"terms"`
+	Note      string    `json:"note,omitempty" firestore:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" firestore:"updated_at"`
+	CreatedBy string    `json:"created_by,omitempty" firestore:"created_by,omitempty"`
+}
+
+// SearchLog records one product search
+type SearchLog struct {
+	Query     string    `json:"query" firestore:"query"`
+	Term      string    `json:"term" firestore:"term"` // Normalised query used for grouping
+	Results   int       `json:"results" firestore:"results"`
+	UserID    string    `json:"user_id,omitempty" firestore:"user_id,omitempty"`
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+}
+
+// SearchQueryStats aggregates every search for one normalised query. Documents are
+// keyed by the term and feed the popular queries shown in autocomplete.
+type SearchQueryStats struct {
+	Term           string    `json:"term" firestore:"term"`
+	Query          string    `json:"query" firestore:"query"` // Most recent spelling as typed
+	Count          int       `json:"count" firestore:"count"`
+	ZeroResults    int       `json:"zero_results" firestore:"zero_results"`
+	LastResults    int       `json:"last_results" firestore:"last_results"`
+	LastSearchedAt time.Time `json:"last_searched_at" firestore:"last_searched_at"`
+}
+
+// SearchQueryInsight is a query in the admin search report
+type SearchQueryInsight struct {
+	Query       string    `json:"query"`
+	Searches    int       `json:"searches"`
+	ZeroResults int       `json:"zero_results"`
+	AvgResults  float64   `json:"avg_results"`
+	LastSeen    time.Time `json:"last_seen"`
+}
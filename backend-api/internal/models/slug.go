@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Kinds of slugged document
+const (
+	SlugKindProduct  = "product"
+	SlugKindCategory = "category"
+)
+
+// SlugRedirect keeps a slug that was changed leading to its document, so links shared
+// with the old slug still work
+type SlugRedirect struct {
+	Kind      string    `json:"kind" firestore:"kind"`
+	OldSlug   string    `json:"old_slug" firestore:"old_slug"`
+	TargetID  string    `json:"target_id" firestore:"target_id"`
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+}
+
+// SlugReservation claims a slug for one document of its kind. Reservations are stored
+// under "{kind}:{slug}" and written in the transaction that saves the document, so two
+// documents can't take the same slug at once.
+type SlugReservation struct {
+	Kind      string    `json:"kind" firestore:"kind"`
+	Slug      string    `json:"slug" firestore:"slug"`
+	TargetID  string    `json:"target_id" firestore:"target_id"`
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+}
@@ -29,6 +29,7 @@ type StockRequest struct {
 	Status        string    `firestore:"status" json:"status"` // pending, contacted, fulfilled, cancelled
 	Priority      int       `firestore:"priority" json:"priority"` // 1-5 (5 being highest)
 	AdminNotes    string    `firestore:"admin_notes,omitempty" json:"admin_notes,omitempty"`
+	NotifiedVia   string    `firestore:"notified_via,omitempty" json:"notified_via,omitempty"` // whatsapp or email, when told automatically it is back in stock
 	
 	// Timestamps
 	RequestedAt   time.Time `firestore:"requested_at" json:"requested_at"`
@@ -0,0 +1,346 @@
+// Package search is a small in-process full-text index. Documents are held in memory and
+// ranked by field weight, term rarity and match quality (exact, prefix or within a typo
+// or two).
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Match quality multipliers
+const (
+	exactMatch   = 1.0
+	prefixMatch  = 0.7
+	typoMatch    = 0.5 // per edit tolerated, see fuzzyQuality
+	synonymMatch = 0.9
+)
+
+// minPrefixLength is the shortest query term that also matches longer terms
+const minPrefixLength = 2
+
+// Field is a piece of a document's text with its ranking weight. Exact fields, such as
+// SKUs, are also indexed as a single term so the whole value can be searched for.
+type Field struct {
+	Text   string
+	Weight float64
+	Exact  bool
+}
+
+// Document is something to be indexed. Boost is added to the score of every match and
+// breaks ties, e.g. for featured products.
+type Document struct {
+	ID     string
+	Fields []Field
+	Boost  float64
+}
+
+// Hit is a matching document and its score
+type Hit struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+type indexedDoc struct {
+	terms []string
+	boost float64
+}
+
+// Index is safe for concurrent use
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]*indexedDoc
+	postings map[string]map[string]float64 // term -> document -> summed field weight
+	terms    []string                      // sorted vocabulary for prefix and typo matching
+	dirty    bool
+	builtAt  time.Time
+	synonyms map[string][]string // term -> equivalent terms
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*indexedDoc),
+		postings: make(map[string]map[string]float64),
+	}
+}
+
+// Rebuild replaces the contents of the index
+func (idx *Index) Rebuild(docs []Document) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.docs = make(map[string]*indexedDoc, len(docs))
+	idx.postings = make(map[string]map[string]float64)
+	for _, doc := range docs {
+		idx.put(doc)
+	}
+	idx.dirty = true
+	idx.builtAt = time.Now()
+}
+
+// Put adds or replaces a document
+func (idx *Index) Put(doc Document) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(doc.ID)
+	idx.put(doc)
+	idx.dirty = true
+}
+
+// Remove deletes a document if it is indexed
+func (idx *Index) Remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(id)
+	idx.dirty = true
+}
+
+// SetSynonyms replaces the synonym groups applied to queries. Each group lists words
+// that mean the same thing, e.g. diya, deepak and divo; a query for one also finds
+// documents containing the others, ranked slightly lower than direct matches.
+func (idx *Index) SetSynonyms(groups [][]string) {
+	synonyms := make(map[string][]string)
+	for _, group := range groups {
+		var terms []string
+		for _, word := range group {
+			terms = append(terms, Tokenize(word)...)
+		}
+		for _, term := range terms {
+			for _, other := range terms {
+				if other != term && !contains(synonyms[term], other) {
+					synonyms[term] = append(synonyms[term], other)
+				}
+			}
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.synonyms = synonyms
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// BuiltAt is when the index was last rebuilt; zero if it never has been
+func (idx *Index) BuiltAt() time.Time {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.builtAt
+}
+
+// Len returns the number of indexed documents
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+func (idx *Index) put(doc Document) {
+	weights := make(map[string]float64)
+	for _, field := range doc.Fields {
+		// A term counts once per field however often it appears
+		seen := make(map[string]bool)
+		terms := Tokenize(field.Text)
+		if field.Exact {
+			if exact := normalizeExact(field.Text); exact != "" {
+				terms = append(terms, exact)
+			}
+		}
+		for _, term := range terms {
+			if !seen[term] {
+				seen[term] = true
+				weights[term] += field.Weight
+			}
+		}
+	}
+
+	indexed := &indexedDoc{boost: doc.Boost}
+	for term, weight := range weights {
+		postings := idx.postings[term]
+		if postings == nil {
+			postings = make(map[string]float64)
+			idx.postings[term] = postings
+		}
+		postings[doc.ID] = weight
+		indexed.terms = append(indexed.terms, term)
+	}
+	idx.docs[doc.ID] = indexed
+}
+
+func (idx *Index) remove(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// sortTerms refreshes the sorted vocabulary after writes
+func (idx *Index) sortTerms() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.dirty {
+		return
+	}
+
+	idx.terms = make([]string, 0, len(idx.postings))
+	for term := range idx.postings {
+		idx.terms = append(idx.terms, term)
+	}
+	sort.Strings(idx.terms)
+	idx.dirty = false
+}
+
+// Search ranks the documents matching query and returns the page starting at offset
+// along with the total number of matches. Every query term must match unless that
+// leaves nothing, in which case documents matching any term are returned, ranked by
+// how many they match.
+func (idx *Index) Search(query string, offset, limit int) ([]Hit, int) {
+	queryTerms := Tokenize(query)
+	if len(queryTerms) == 0 {
+		// Only stop words; search for them rather than return nothing
+		for _, word := range strings.Fields(strings.ToLower(query)) {
+			queryTerms = append(queryTerms, word)
+		}
+	}
+	if len(queryTerms) == 0 {
+		return []Hit{}, 0
+	}
+
+	idx.sortTerms()
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	scores := make(map[string]float64)
+	matched := make(map[string]int)
+	for _, term := range queryTerms {
+		termScores := idx.scoreTerm(term)
+		for _, synonym := range idx.synonyms[term] {
+			for id, score := range idx.scoreTerm(synonym) {
+				if score *= synonymMatch; score > termScores[id] {
+					termScores[id] = score
+				}
+			}
+		}
+		for id, score := range termScores {
+			scores[id] += score
+			matched[id]++
+		}
+	}
+
+	// The whole query as typed, e.g. a SKU with punctuation
+	if exact := normalizeExact(query); exact != "" {
+		for id, weight := range idx.postings[exact] {
+			scores[id] += weight * idx.rarity(exact)
+			matched[id] = len(queryTerms)
+		}
+	}
+
+	requireAll := false
+	for _, count := range matched {
+		if count == len(queryTerms) {
+			requireAll = true
+			break
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		if requireAll && matched[id] < len(queryTerms) {
+			continue
+		}
+		if !requireAll {
+			score *= float64(matched[id]) / float64(len(queryTerms))
+		}
+		hits = append(hits, Hit{ID: id, Score: score + idx.docs[id].boost})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	for i := range hits {
+		hits[i].Score = math.Round(hits[i].Score*1000) / 1000
+	}
+
+	total := len(hits)
+	if offset >= total {
+		return []Hit{}, total
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	return hits[offset:end], total
+}
+
+// scoreTerm returns the best score each document earns for one query term
+func (idx *Index) scoreTerm(queryTerm string) map[string]float64 {
+	scores := make(map[string]float64)
+	consider := func(term string, quality float64) {
+		rarity := idx.rarity(term)
+		for id, weight := range idx.postings[term] {
+			if score := weight * rarity * quality; score > scores[id] {
+				scores[id] = score
+			}
+		}
+	}
+
+	consider(queryTerm, exactMatch)
+
+	if len([]rune(queryTerm)) >= minPrefixLength {
+		start := sort.SearchStrings(idx.terms, queryTerm)
+		for i := start; i < len(idx.terms) && strings.HasPrefix(idx.terms[i], queryTerm); i++ {
+			if idx.terms[i] != queryTerm {
+				consider(idx.terms[i], prefixMatch)
+			}
+		}
+	}
+
+	if limit := maxEdits(queryTerm); limit > 0 {
+		for _, term := range idx.terms {
+			if term == queryTerm {
+				continue
+			}
+			if distance := editDistance(queryTerm, term, limit); distance <= limit {
+				consider(term, fuzzyQuality(distance))
+			}
+		}
+	}
+
+	return scores
+}
+
+// fuzzyQuality halves the match quality for each typo
+func fuzzyQuality(distance int) float64 {
+	return typoMatch / float64(distance)
+}
+
+// rarity is the inverse document frequency of a term, so rare words rank higher
+func (idx *Index) rarity(term string) float64 {
+	df := len(idx.postings[term])
+	if df == 0 {
+		return 0
+	}
+	return 1 + math.Log(float64(len(idx.docs))/float64(df))
+}
@@ -7,6 +7,7 @@ import (
 	"html/template"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -62,6 +63,57 @@ type OrderEmailItem struct {
 	ImageURL     string
 }
 
+type ReviewRequestData struct {
+	CustomerName string
+	OrderNumber  string
+	Items        []ReviewRequestItem
+}
+
+type ReviewRequestItem struct {
+	ProductName string
+	ImageURL    string
+	ReviewURL   string
+}
+
+type QuestionEmailData struct {
+	CustomerName string
+	ProductName  string
+	ProductURL   string
+	Question     string
+	Answer       string
+}
+
+type BackInStockData struct {
+	CustomerName string
+	ProductName  string // With the requested colour and size
+	ProductURL   string
+	ImageURL     string
+}
+
+type LowStockDigestData struct {
+	Date       string
+	OutOfStock []LowStockDigestItem
+	LowStock   []LowStockDigestItem
+}
+
+type LowStockDigestItem struct {
+	ProductName string
+	SKU         string
+	Stock       int
+	Threshold   int
+}
+
+type GiftCardEmailData struct {
+	RecipientName string
+	SenderName    string
+	Amount        float64
+	Code          string
+	PIN           string
+	Message       string
+	ExpiryDate    string
+	RedeemURL     string
+}
+
 func NewSendGridEmailService() (*SendGridEmailService, error) {
 	apiKey := os.Getenv("SENDGRID_API_KEY")
 	if apiKey == "" {
@@ -623,6 +675,470 @@ func (s *SendGridEmailService) renderShippingConfirmationTemplate(data ShippingC
 	return buf.String(), nil
 }
 
+// SendGiftCard delivers a gift card code and PIN to its recipient
+func (s *SendGridEmailService) SendGiftCard(card models.GiftCard) error {
+	if card.RecipientEmail == "" {
+		return fmt.Errorf("gift card %s has no recipient email", card.ID)
+	}
+
+	data := GiftCardEmailData{
+		RecipientName: card.RecipientName,
+		SenderName:    card.PurchaserName,
+		Amount:        card.Balance,
+		Code:          card.Code,
+		PIN:           card.PIN,
+		Message:       card.Message,
+		ExpiryDate:    card.ExpiresAt.Format("January 2, 2006"),
+		RedeemURL:     "https://tripundlifestyle.com/products",
+	}
+	if data.SenderName == "" {
+		data.SenderName = "TRIPUND Lifestyle"
+	}
+
+	subject := fmt.Sprintf("🎁 You've received a ₹%.0f TRIPUND Gift Card", card.Balance)
+	htmlBody, err := s.renderDatabaseTemplate("gift_card", data)
+	if err != nil {
+		log.Printf("Failed to render database gift card template, using fallback: %v", err)
+		htmlBody, err = s.renderGiftCardTemplate(data)
+		if err != nil {
+			return fmt.Errorf("failed to render gift card template: %v", err)
+		}
+	}
+
+	return s.sendEmail(card.RecipientEmail, card.RecipientName, subject, htmlBody)
+}
+
+func (s *SendGridEmailService) renderGiftCardTemplate(data GiftCardEmailData) (string, error) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Your TRIPUND Gift Card</title>
+    <style>
+        body { font-family: 'Georgia', serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto; padding: 20px; background-color: #FFF8F0; }
+        .email-container { background-color: white; border-radius: 16px; overflow: hidden; box-shadow: 0 6px 16px rgba(139, 69, 19, 0.15); }
+        .header { background: linear-gradient(135deg, #8B4513 0%, #D2691E 100%); color: white; padding: 30px 20px; text-align: center; }
+        .header h1 { margin: 0; font-size: 28px; letter-spacing: 1px; }
+        .content { padding: 30px; }
+        .greeting { font-size: 18px; color: #5D2E0C; margin-bottom: 20px; }
+        .gift-card { background: linear-gradient(135deg, #FFD700 0%, #FFA500 100%); border-radius: 16px; padding: 30px; text-align: center; color: #5D2E0C; margin: 25px 0; border: 2px dashed #8B4513; }
+        .gift-card .amount { font-size: 44px; font-weight: bold; margin: 10px 0; }
+        .code-box { background: white; border-radius: 8px; padding: 15px; margin: 15px auto; max-width: 320px; }
+        .code-box .label { font-size: 12px; text-transform: uppercase; color: #8B4513; letter-spacing: 1px; }
+        .code-box .value { font-family: 'Courier New', monospace; font-size: 22px; font-weight: bold; letter-spacing: 2px; }
+        .message { background: #FFF3E0; border-left: 4px solid #D2691E; padding: 15px 20px; font-style: italic; margin: 20px 0; }
+        .cta { display: inline-block; background: #8B4513; color: white; padding: 14px 32px; border-radius: 30px; text-decoration: none; font-weight: bold; }
+        .terms { font-size: 12px; color: #777; margin-top: 25px; }
+        .footer { background: #5D2E0C; color: #FFE4C4; padding: 20px; text-align: center; font-size: 14px; }
+        .footer a { color: #FFD700; }
+    </style>
+</head>
+<body>
+    <div class="email-container">
+        <div class="header">
+            <h1>🪔 A Gift For You 🪔</h1>
+            <p>from {{.SenderName}}</p>
+        </div>
+        <div class="content">
+            <div class="greeting">Dear {{.RecipientName}},</div>
+            <p>You have received a TRIPUND Lifestyle gift card. Use it to pick handcrafted décor, festive essentials and more.</p>
+            {{if .Message}}<div class="message">“{{.Message}}”</div>{{end}}
+            <div class="gift-card">
+                <div>TRIPUND GIFT CARD</div>
+                <div class="amount">₹{{printf "%.0f" .Amount}}</div>
+                <div class="code-box">
+                    <div class="label">Gift Card Code</div>
+                    <div class="value">{{.Code}}</div>
+                </div>
+                <div class="code-box">
+                    <div class="label">PIN</div>
+                    <div class="value">{{.PIN}}</div>
+                </div>
+                <div>Valid until {{.ExpiryDate}}</div>
+            </div>
+            <p style="text-align: center;"><a class="cta" href="{{.RedeemURL}}">Start Shopping</a></p>
+            <div class="terms">
+                Enter the code and PIN at checkout. The balance can be used across multiple orders until it runs out or the card expires.
+                Gift cards cannot be exchanged for cash. Keep your PIN private.
+            </div>
+        </div>
+        <div class="footer">
+            <p><strong>TRIPUND Lifestyle</strong><br>Premium Indian Handicrafts & Home Décor</p>
+            <p>Visit us at <a href="https://tripundlifestyle.com">tripundlifestyle.com</a></p>
+        </div>
+    </div>
+</body>
+</html>
+`
+
+	t, err := template.New("giftCard").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// SendReviewRequest asks the customer of a delivered order to review what they bought
+func (s *SendGridEmailService) SendReviewRequest(order models.Order) error {
+	data := ReviewRequestData{
+		CustomerName: order.GuestName,
+		OrderNumber:  order.OrderNumber,
+	}
+	email := order.GuestEmail
+
+	if order.UserID != "guest" && order.GuestEmail == "" {
+		if s.db == nil {
+			return fmt.Errorf("no database connection to fetch user email for order %s", order.ID)
+		}
+		userDoc, err := s.db.Collection("mobile_users").Doc(order.UserID).Get(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to get user email for order %s: %v", order.ID, err)
+		}
+		var user struct {
+			Email   string `firestore:"email"`
+			Profile struct {
+				FirstName string `firestore:"first_name"`
+				LastName  string `firestore:"last_name"`
+			} `firestore:"profile"`
+		}
+		if err := userDoc.DataTo(&user); err != nil {
+			return fmt.Errorf("failed to parse user data for order %s: %v", order.ID, err)
+		}
+		email = user.Email
+		data.CustomerName = strings.TrimSpace(user.Profile.FirstName + " " + user.Profile.LastName)
+	}
+	if email == "" {
+		return fmt.Errorf("order %s has no customer email", order.ID)
+	}
+	if data.CustomerName == "" {
+		data.CustomerName = "Customer"
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range order.Items {
+		if item.ProductType == models.ProductTypeGiftCard || seen[item.ProductID] {
+			continue
+		}
+		seen[item.ProductID] = true
+		data.Items = append(data.Items, ReviewRequestItem{
+			ProductName: item.ProductName,
+			ImageURL:    item.ProductImage,
+			ReviewURL:   fmt.Sprintf("https://tripundlifestyle.com/products/%s?review=1", item.ProductID),
+		})
+	}
+	if len(data.Items) == 0 {
+		return nil
+	}
+
+	subject := fmt.Sprintf("How did you like your order %s? | TRIPUND Lifestyle", order.OrderNumber)
+	htmlBody, err := s.renderDatabaseTemplate("review_request", data)
+	if err != nil {
+		log.Printf("Failed to render database review request template, using fallback: %v", err)
+		htmlBody, err = s.renderReviewRequestTemplate(data)
+		if err != nil {
+			return fmt.Errorf("failed to render review request template: %v", err)
+		}
+	}
+
+	return s.sendEmail(email, data.CustomerName, subject, htmlBody)
+}
+
+func (s *SendGridEmailService) renderReviewRequestTemplate(data ReviewRequestData) (string, error) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Review your TRIPUND order</title>
+    <style>
+        body { font-family: 'Georgia', serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto; padding: 20px; background-color: #FFF8F0; }
+        .email-container { background-color: white; border-radius: 16px; overflow: hidden; box-shadow: 0 6px 16px rgba(139, 69, 19, 0.15); }
+        .header { background: linear-gradient(135deg, #8B4513 0%, #D2691E 100%); color: white; padding: 30px 20px; text-align: center; }
+        .header h1 { margin: 0; font-size: 26px; }
+        .content { padding: 30px; }
+        .item { display: flex; align-items: center; border-bottom: 1px solid #F0E0D0; padding: 15px 0; }
+        .item img { width: 72px; height: 72px; object-fit: cover; border-radius: 8px; margin-right: 15px; }
+        .item .name { flex: 1; font-weight: bold; color: #5D2E0C; }
+        .cta { display: inline-block; background: #8B4513; color: white; padding: 10px 20px; border-radius: 20px; text-decoration: none; font-size: 14px; }
+        .footer { background: #5D2E0C; color: #FFE4C4; padding: 20px; text-align: center; font-size: 14px; }
+        .footer a { color: #FFD700; }
+    </style>
+</head>
+<body>
+    <div class="email-container">
+        <div class="header">
+            <h1>⭐ How was your order? ⭐</h1>
+            <p>Order {{.OrderNumber}}</p>
+        </div>
+        <div class="content">
+            <p>Dear {{.CustomerName}},</p>
+            <p>We hope you are enjoying your handcrafted pieces. A short review helps other customers and the artisans who made them.</p>
+            {{range .Items}}
+            <div class="item">
+                {{if .ImageURL}}<img src="{{.ImageURL}}" alt="{{.ProductName}}">{{end}}
+                <div class="name">{{.ProductName}}</div>
+                <a class="cta" href="{{.ReviewURL}}">Write a review</a>
+            </div>
+            {{end}}
+        </div>
+        <div class="footer">
+            <p><strong>TRIPUND Lifestyle</strong><br>Premium Indian Handicrafts & Home Décor</p>
+            <p>Visit us at <a href="https://tripundlifestyle.com">tripundlifestyle.com</a></p>
+        </div>
+    </div>
+</body>
+</html>
+`
+
+	t, err := template.New("reviewRequest").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// SendQuestionAnswered tells a customer their product question has been answered
+func (s *SendGridEmailService) SendQuestionAnswered(toEmail string, data QuestionEmailData) error {
+	subject := fmt.Sprintf("Your question about %s has been answered | TRIPUND Lifestyle", data.ProductName)
+	return s.sendQuestionEmail("question_answered", toEmail, subject, data)
+}
+
+// SendAnswerInvite asks a customer who bought a product to answer a question about it
+func (s *SendGridEmailService) SendAnswerInvite(toEmail string, data QuestionEmailData) error {
+	subject := fmt.Sprintf("Can you help another customer with %s? | TRIPUND Lifestyle", data.ProductName)
+	return s.sendQuestionEmail("answer_invite", toEmail, subject, data)
+}
+
+func (s *SendGridEmailService) sendQuestionEmail(templateType, toEmail, subject string, data QuestionEmailData) error {
+	if data.CustomerName == "" {
+		data.CustomerName = "Customer"
+	}
+	htmlBody, err := s.renderDatabaseTemplate(templateType, data)
+	if err != nil {
+		log.Printf("Failed to render database %s template, using fallback: %v", templateType, err)
+		htmlBody, err = s.renderQuestionTemplate(data)
+		if err != nil {
+			return fmt.Errorf("failed to render %s template: %v", templateType, err)
+		}
+	}
+	return s.sendEmail(toEmail, data.CustomerName, subject, htmlBody)
+}
+
+// renderQuestionTemplate shows the question, and the answer once there is one
+func (s *SendGridEmailService) renderQuestionTemplate(data QuestionEmailData) (string, error) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.ProductName}} | TRIPUND Lifestyle</title>
+    <style>
+        body { font-family: 'Georgia', serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto; padding: 20px; background-color: #FFF8F0; }
+        .email-container { background-color: white; border-radius: 16px; overflow: hidden; box-shadow: 0 6px 16px rgba(139, 69, 19, 0.15); }
+        .header { background: linear-gradient(135deg, #8B4513 0%, #D2691E 100%); color: white; padding: 30px 20px; text-align: center; }
+        .header h1 { margin: 0; font-size: 24px; }
+        .content { padding: 30px; }
+        .question { background: #FFF3E0; border-left: 4px solid #D2691E; padding: 15px 20px; margin: 20px 0; }
+        .answer { background: #F1F8E9; border-left: 4px solid #689F38; padding: 15px 20px; margin: 20px 0; }
+        .cta { display: inline-block; background: #8B4513; color: white; padding: 14px 32px; border-radius: 30px; text-decoration: none; font-weight: bold; }
+        .footer { background: #5D2E0C; color: #FFE4C4; padding: 20px; text-align: center; font-size: 14px; }
+        .footer a { color: #FFD700; }
+    </style>
+</head>
+<body>
+    <div class="email-container">
+        <div class="header">
+            <h1>{{.ProductName}}</h1>
+        </div>
+        <div class="content">
+            <p>Dear {{.CustomerName}},</p>
+            {{if .Answer}}
+            <p>Your question has been answered.</p>
+            <div class="question"><strong>Q:</strong> {{.Question}}</div>
+            <div class="answer"><strong>A:</strong> {{.Answer}}</div>
+            <p style="text-align: center;"><a class="cta" href="{{.ProductURL}}">View Product</a></p>
+            {{else}}
+            <p>You bought this piece from us, so you may be able to help another customer with their question.</p>
+            <div class="question"><strong>Q:</strong> {{.Question}}</div>
+            <p style="text-align: center;"><a class="cta" href="{{.ProductURL}}">Answer the Question</a></p>
+            {{end}}
+        </div>
+        <div class="footer">
+            <p><strong>TRIPUND Lifestyle</strong><br>Premium Indian Handicrafts & Home Décor</p>
+            <p>Visit us at <a href="https://tripundlifestyle.com">tripundlifestyle.com</a></p>
+        </div>
+    </div>
+</body>
+</html>
+`
+
+	t, err := template.New("question").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// SendBackInStock tells a customer who requested a product that it is available again
+func (s *SendGridEmailService) SendBackInStock(toEmail string, data BackInStockData) error {
+	if data.CustomerName == "" {
+		data.CustomerName = "Customer"
+	}
+	htmlBody, err := s.renderDatabaseTemplate("back_in_stock", data)
+	if err != nil {
+		log.Printf("Failed to render database back in stock template, using fallback: %v", err)
+		htmlBody, err = s.renderBackInStockTemplate(data)
+		if err != nil {
+			return fmt.Errorf("failed to render back in stock template: %v", err)
+		}
+	}
+
+	subject := fmt.Sprintf("%s is back in stock | TRIPUND Lifestyle", data.ProductName)
+	return s.sendEmail(toEmail, data.CustomerName, subject, htmlBody)
+}
+
+func (s *SendGridEmailService) renderBackInStockTemplate(data BackInStockData) (string, error) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Back in Stock | TRIPUND Lifestyle</title>
+    <style>
+        body { font-family: 'Georgia', serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto; padding: 20px; background-color: #FFF8F0; }
+        .email-container { background-color: white; border-radius: 16px; overflow: hidden; box-shadow: 0 6px 16px rgba(139, 69, 19, 0.15); }
+        .header { background: linear-gradient(135deg, #8B4513 0%, #D2691E 100%); color: white; padding: 30px 20px; text-align: center; }
+        .header h1 { margin: 0; font-size: 24px; }
+        .content { padding: 30px; text-align: center; }
+        .content img { max-width: 240px; border-radius: 12px; margin: 10px 0 20px; }
+        .cta { display: inline-block; background: #8B4513; color: white; padding: 14px 32px; border-radius: 30px; text-decoration: none; font-weight: bold; }
+        .footer { background: #5D2E0C; color: #FFE4C4; padding: 20px; text-align: center; font-size: 14px; }
+        .footer a { color: #FFD700; }
+    </style>
+</head>
+<body>
+    <div class="email-container">
+        <div class="header">
+            <h1>It's Back in Stock!</h1>
+        </div>
+        <div class="content">
+            <p>Dear {{.CustomerName}},</p>
+            <p>You asked us to let you know when <strong>{{.ProductName}}</strong> was available again. It's back, in limited quantity.</p>
+            {{if .ImageURL}}<img src="{{.ImageURL}}" alt="{{.ProductName}}">{{end}}
+            <p><a class="cta" href="{{.ProductURL}}">Shop Now</a></p>
+        </div>
+        <div class="footer">
+            <p><strong>TRIPUND Lifestyle</strong><br>Premium Indian Handicrafts & Home Décor</p>
+            <p>Visit us at <a href="https://tripundlifestyle.com">tripundlifestyle.com</a></p>
+        </div>
+    </div>
+</body>
+</html>
+`
+
+	t, err := template.New("backInStock").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// SendLowStockDigest sends the daily list of products and variants that are out of
+// stock or at their reorder threshold
+func (s *SendGridEmailService) SendLowStockDigest(toEmail string, data LowStockDigestData) error {
+	htmlBody, err := s.renderDatabaseTemplate("low_stock_digest", data)
+	if err != nil {
+		log.Printf("Failed to render database low stock digest template, using fallback: %v", err)
+		htmlBody, err = s.renderLowStockDigestTemplate(data)
+		if err != nil {
+			return fmt.Errorf("failed to render low stock digest template: %v", err)
+		}
+	}
+
+	subject := fmt.Sprintf("Stock digest for %s: %d out of stock, %d low", data.Date, len(data.OutOfStock), len(data.LowStock))
+	return s.sendEmail(toEmail, "", subject, htmlBody)
+}
+
+func (s *SendGridEmailService) renderLowStockDigestTemplate(data LowStockDigestData) (string, error) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Stock Digest | TRIPUND Lifestyle</title>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.5; color: #333; max-width: 700px; margin: 0 auto; padding: 20px; }
+        h1 { color: #8B4513; font-size: 22px; }
+        h2 { font-size: 17px; margin-top: 28px; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { text-align: left; padding: 8px; border-bottom: 1px solid #eee; }
+        th { background: #FFF3E0; }
+        .out { color: #C62828; }
+    </style>
+</head>
+<body>
+    <h1>Stock digest for {{.Date}}</h1>
+    {{if .OutOfStock}}
+    <h2 class="out">Out of stock ({{len .OutOfStock}})</h2>
+    <table>
+        <tr><th>Product</th><th>SKU</th></tr>
+        {{range .OutOfStock}}<tr><td>{{.ProductName}}</td><td>{{.SKU}}</td></tr>{{end}}
+    </table>
+    {{end}}
+    {{if .LowStock}}
+    <h2>Low stock ({{len .LowStock}})</h2>
+    <table>
+        <tr><th>Product</th><th>SKU</th><th>Stock</th><th>Reorder at</th></tr>
+        {{range .LowStock}}<tr><td>{{.ProductName}}</td><td>{{.SKU}}</td><td>{{.Stock}}</td><td>{{.Threshold}}</td></tr>{{end}}
+    </table>
+    {{end}}
+    {{if not (or .OutOfStock .LowStock)}}<p>Everything is above its reorder threshold.</p>{{end}}
+</body>
+</html>
+`
+
+	t, err := template.New("lowStockDigest").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
 // SendRawEmail sends an email with custom content (for template testing)
 func (s *SendGridEmailService) SendRawEmail(toEmail, subject, htmlBody string) error {
 	return s.sendEmail(toEmail, "", subject, htmlBody)
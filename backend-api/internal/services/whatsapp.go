@@ -532,6 +532,104 @@ func (w *WhatsAppService) SendShippingConfirmation(phoneNumber, customerName, or
 	return nil
 }
 
+// Send gift card using approved template gift_card_v1
+func (w *WhatsAppService) SendGiftCard(phoneNumber, recipientName, senderName, amount, code, pin, expiryDate string) error {
+	// Ensure phone number has +91 prefix for India
+	cleanPhone := strings.ReplaceAll(strings.ReplaceAll(phoneNumber, "+", ""), " ", "")
+	if !strings.HasPrefix(cleanPhone, "91") {
+		cleanPhone = "91" + cleanPhone
+	}
+	
+	templateContent := &models.TemplateContent{
+		Name: "gift_card_v1",
+		Language: models.LanguageContent{
+			Code: "en_US",
+		},
+		Components: []models.ComponentContent{
+			{
+				Type: "body",
+				Parameters: []models.ParameterContent{
+					{Type: "text", Text: recipientName},
+					{Type: "text", Text: senderName},
+					{Type: "text", Text: amount},
+					{Type: "text", Text: code},
+					{Type: "text", Text: pin},
+					{Type: "text", Text: expiryDate},
+				},
+			},
+		},
+	}
+	
+	requestBody := models.SendMessageRequest{
+		MessagingProduct: "whatsapp",
+		RecipientType:    "individual",
+		To:               cleanPhone,
+		Type:             "template",
+		Template:         templateContent,
+	}
+	
+	_, err := w.sendMessage(requestBody)
+	if err != nil {
+		log.Printf("Failed to send WhatsApp gift card to %s: %v", phoneNumber, err)
+		return err
+	}
+	
+	log.Printf("WhatsApp gift card sent successfully to %s using template gift_card_v1", phoneNumber)
+	return nil
+}
+
+// SendBackInStock tells a customer who requested a product that it is available again,
+// using the approved template back_in_stock_v1. Its button links to
+// https://tripundlifestyle.com/{{1}}, filled with productPath.
+func (w *WhatsAppService) SendBackInStock(phoneNumber, customerName, productName, productPath string) error {
+	// Ensure phone number has +91 prefix for India
+	cleanPhone := strings.ReplaceAll(strings.ReplaceAll(phoneNumber, "+", ""), " ", "")
+	if !strings.HasPrefix(cleanPhone, "91") {
+		cleanPhone = "91" + cleanPhone
+	}
+
+	templateContent := &models.TemplateContent{
+		Name: "back_in_stock_v1",
+		Language: models.LanguageContent{
+			Code: "en_US",
+		},
+		Components: []models.ComponentContent{
+			{
+				Type: "body",
+				Parameters: []models.ParameterContent{
+					{Type: "text", Text: customerName},
+					{Type: "text", Text: productName},
+				},
+			},
+			{
+				Type:    "button",
+				SubType: "url",
+				Index:   "0",
+				Parameters: []models.ParameterContent{
+					{Type: "text", Text: productPath},
+				},
+			},
+		},
+	}
+
+	requestBody := models.SendMessageRequest{
+		MessagingProduct: "whatsapp",
+		RecipientType:    "individual",
+		To:               cleanPhone,
+		Type:             "template",
+		Template:         templateContent,
+	}
+
+	_, err := w.sendMessage(requestBody)
+	if err != nil {
+		log.Printf("Failed to send WhatsApp back in stock message to %s: %v", phoneNumber, err)
+		return err
+	}
+
+	log.Printf("WhatsApp back in stock message sent successfully to %s using template back_in_stock_v1", phoneNumber)
+	return nil
+}
+
 // Helper function to generate IDs
 func generateID(prefix string) string {
 	return fmt.Sprintf("%s_%d", prefix, time.Now().UnixNano())
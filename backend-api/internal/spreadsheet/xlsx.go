@@ -0,0 +1,317 @@
+// Package spreadsheet reads and writes the first worksheet of simple XLSX workbooks as
+// rows of text, enough for importing and exporting tabular data. Formulas, styles and
+// dates are not interpreted; cells are returned as the text or number stored in them.
+package spreadsheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// MaxSheetSize caps the uncompressed size of a worksheet that will be read
+const MaxSheetSize = 50 << 20
+
+var ErrNoWorksheet = errors.New("workbook has no worksheet")
+
+// ReadXLSX returns the rows of the first worksheet. Rows are padded so every cell up to
+// the last filled column of that row is present; trailing empty rows are dropped.
+func ReadXLSX(r io.ReaderAt, size int64) ([][]string, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not an xlsx file: %w", err)
+	}
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	sheetPath, err := firstSheetPath(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var shared []string
+	if file, ok := files["xl/sharedStrings.xml"]; ok {
+		if shared, err = readSharedStrings(file); err != nil {
+			return nil, err
+		}
+	}
+
+	file, ok := files[sheetPath]
+	if !ok {
+		return nil, ErrNoWorksheet
+	}
+	return readSheet(file, shared)
+}
+
+type relationship struct {
+	ID     string `xml:"Id,attr"`
+	Target string `xml:"Target,attr"`
+}
+
+// firstSheetPath follows the workbook relationships to the first sheet, falling back to
+// the conventional location
+func firstSheetPath(files map[string]*zip.File) (string, error) {
+	var workbook struct {
+		Sheets []struct {
+			RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var rels struct {
+		Relationships []relationship `xml:"Relationship"`
+	}
+
+	if err := decodeFile(files["xl/workbook.xml"], &workbook); err == nil && len(workbook.Sheets) > 0 {
+		if err := decodeFile(files["xl/_rels/workbook.xml.rels"], &rels); err == nil {
+			for _, rel := range rels.Relationships {
+				if rel.ID != workbook.Sheets[0].RID {
+					continue
+				}
+				target := rel.Target
+				if strings.HasPrefix(target, "/") {
+					return strings.TrimPrefix(target, "/"), nil
+				}
+				return path.Join("xl", target), nil
+			}
+		}
+	}
+
+	if _, ok := files["xl/worksheets/sheet1.xml"]; ok {
+		return "xl/worksheets/sheet1.xml", nil
+	}
+	return "", ErrNoWorksheet
+}
+
+func decodeFile(file *zip.File, v interface{}) error {
+	if file == nil {
+		return ErrNoWorksheet
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(io.LimitReader(rc, MaxSheetSize)).Decode(v)
+}
+
+// richText is a shared or inline string, either plain or split into formatted runs
+type richText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t richText) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+	var b strings.Builder
+	for _, run := range t.Runs {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
+
+func readSharedStrings(file *zip.File) ([]string, error) {
+	var table struct {
+		Items []richText `xml:"si"`
+	}
+	if err := decodeFile(file, &table); err != nil {
+		return nil, fmt.Errorf("invalid shared strings: %w", err)
+	}
+	shared := make([]string, len(table.Items))
+	for i, item := range table.Items {
+		shared[i] = item.String()
+	}
+	return shared, nil
+}
+
+func readSheet(file *zip.File, shared []string) ([][]string, error) {
+	var sheet struct {
+		Rows []struct {
+			Index int `xml:"r,attr"`
+			Cells []struct {
+				Ref    string   `xml:"r,attr"`
+				Type   string   `xml:"t,attr"`
+				Value  string   `xml:"v"`
+				Inline richText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodeFile(file, &sheet); err != nil {
+		return nil, fmt.Errorf("invalid worksheet: %w", err)
+	}
+
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		// Rows without a number follow the previous one; skipped rows are blank
+		index := len(rows)
+		if row.Index > 0 {
+			index = row.Index - 1
+		}
+		for len(rows) < index {
+			rows = append(rows, []string{})
+		}
+
+		values := make([]string, 0, len(row.Cells))
+		for _, cell := range row.Cells {
+			column := len(values)
+			if cell.Ref != "" {
+				if parsed, ok := columnIndex(cell.Ref); ok {
+					column = parsed
+				}
+			}
+			for len(values) < column {
+				values = append(values, "")
+			}
+
+			var value string
+			switch cell.Type {
+			case "s":
+				var i int
+				if _, err := fmt.Sscanf(cell.Value, "%d", &i); err == nil && i >= 0 && i < len(shared) {
+					value = shared[i]
+				}
+			case "inlineStr":
+				value = cell.Inline.String()
+			default:
+				value = cell.Value
+			}
+			if column < len(values) {
+				values[column] = value
+			} else {
+				values = append(values, value)
+			}
+		}
+		if index < len(rows) {
+			rows[index] = values
+		} else {
+			rows = append(rows, values)
+		}
+	}
+
+	for len(rows) > 0 && isBlank(rows[len(rows)-1]) {
+		rows = rows[:len(rows)-1]
+	}
+	return rows, nil
+}
+
+func isBlank(row []string) bool {
+	for _, value := range row {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// columnIndex converts the letters of a cell reference such as "AB12" to a zero-based
+// column number
+func columnIndex(ref string) (int, bool) {
+	column := 0
+	letters := 0
+	for _, r := range ref {
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A'+1)
+		letters++
+	}
+	if letters == 0 {
+		return 0, false
+	}
+	return column - 1, true
+}
+
+// columnName converts a zero-based column number to its letters, e.g. 27 is "AB"
+func columnName(column int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name
+}
+
+const (
+	contentTypesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	rootRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	workbookRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	workbookXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+)
+
+// WriteXLSX writes rows as a single-sheet workbook. Every cell is stored as text so
+// values such as SKUs with leading zeros survive a round trip.
+func WriteXLSX(w io.Writer, sheetName string, rows [][]string) error {
+	archive := zip.NewWriter(w)
+
+	var name bytes.Buffer
+	if err := xml.EscapeText(&name, []byte(sheetName)); err != nil {
+		return err
+	}
+
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", contentTypesXML},
+		{"_rels/.rels", rootRelsXML},
+		{"xl/_rels/workbook.xml.rels", workbookRelsXML},
+		{"xl/workbook.xml", fmt.Sprintf(workbookXML, name.String())},
+	}
+	for _, part := range parts {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(file, part.content); err != nil {
+			return err
+		}
+	}
+
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if err := writeSheet(sheet, rows); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func writeSheet(w io.Writer, rows [][]string) error {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range rows {
+		fmt.Fprintf(&b, `<row r="%d">`, i+1)
+		for j, value := range row {
+			if value == "" {
+				continue
+			}
+			fmt.Fprintf(&b, `<c r="%s%d" t="inlineStr"><is><t xml:space="preserve">`, columnName(j), i+1)
+			if err := xml.EscapeText(&b, []byte(value)); err != nil {
+				return err
+			}
+			b.WriteString(`</t></is></c>`)
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	_, err := w.Write(b.Bytes())
+	return err
+}
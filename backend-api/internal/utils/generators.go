@@ -4,10 +4,15 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 	mathrand "math/rand"
+	"strings"
 	"time"
 )
 
+// codeAlphabet omits characters that are easily confused (0/O, 1/I/L)
+const codeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
 func GenerateID() string {
 	bytes := make([]byte, 16)
 	rand.Read(bytes)
@@ -35,4 +40,56 @@ func GenerateOTP(length int) string {
 		otp += fmt.Sprintf("%d", rng.Intn(10))
 	}
 	return otp
-}
\ No newline at end of file
+}
+
+// GenerateCode returns a random code of the given length drawn from codeAlphabet
+func GenerateCode(length int) string {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(codeAlphabet)))
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			n = big.NewInt(int64(mathrand.Intn(len(codeAlphabet))))
+		}
+		sb.WriteByte(codeAlphabet[n.Int64()])
+	}
+	return sb.String()
+}
+
+// GenerateGiftCardCode returns a code formatted as TGC-XXXX-XXXX-XXXX
+func GenerateGiftCardCode() string {
+	code := GenerateCode(12)
+	return fmt.Sprintf("TGC-%s-%s-%s", code[0:4], code[4:8], code[8:12])
+}
+
+// GenerateSecurePIN returns a numeric PIN using a cryptographic source
+func GenerateSecurePIN(length int) string {
+	pin := ""
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			n = big.NewInt(int64(mathrand.Intn(10)))
+		}
+		pin += n.String()
+	}
+	return pin
+}
+
+// GenerateSlug lowercases text and joins its letters and digits with dashes,
+// e.g. "Brass Diya (Set of 2)" becomes "brass-diya-set-of-2"
+func GenerateSlug(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
@@ -86,6 +86,7 @@ export default function CheckoutPage() {
   } | null>(null);
   const [promoLoading, setPromoLoading] = useState(false);
   const [savedAddresses, setSavedAddresses] = useState<any[]>([]);
+  const [quote, setQuote] = useState<{ total: number; amount_payable: number } | null>(null);
   const [selectedAddressId, setSelectedAddressId] = useState<string>('');
   const [showNewAddressForm, setShowNewAddressForm] = useState(false);
 
@@ -135,6 +136,50 @@ export default function CheckoutPage() {
     };
   
   const grandTotal = discountedTotal + shipping; // Total is already GST-inclusive
+  const paymentMethod = watch('paymentMethod');
+  const postalCode = watch('address.postalCode');
+
+  // Order lines as the backend prices them
+  const orderItems = items.map(item => ({
+    product_id: item.product_id,
+    quantity: item.quantity,
+    // Include variant information if present
+    ...(item.product.variant_info && {
+      variant_id: item.product.variant_info.variant_id,
+      variant_color: item.product.variant_info.color,
+      variant_size: item.product.variant_info.size,
+    }),
+  }));
+
+  // The backend prices the order; the total shown is its quote, with the estimate
+  // above only until the quote arrives
+  useEffect(() => {
+    if (items.length === 0) {
+      return;
+    }
+    let cancelled = false;
+    api.post('/checkout/quote', {
+      items: orderItems,
+      coupon_code: appliedPromo?.code || '',
+      payment_method: paymentMethod,
+      shipping_method: shippingMethod,
+      address: selectedState ? { state: selectedState, postal_code: postalCode || '', country: 'India' } : undefined,
+      user_id: user?.id,
+    }).then(response => {
+      if (!cancelled) {
+        setQuote({ total: response.data.total, amount_payable: response.data.amount_payable });
+      }
+    }).catch(() => {
+      if (!cancelled) {
+        setQuote(null);
+      }
+    });
+    return () => {
+      cancelled = true;
+    };
+  }, [items, appliedPromo, paymentMethod, shippingMethod, selectedState, postalCode]);
+
+  const orderTotal = quote ? quote.total : grandTotal + (paymentMethod === 'cod' ? 50 : 0);
 
   useEffect(() => {
     if (items.length === 0) {
@@ -269,16 +314,15 @@ export default function CheckoutPage() {
       const orderResponse = await api.post(orderEndpoint, orderData);
       const createdOrder = orderResponse.data.order;
 
-      // Then create the Razorpay order
+      // Then create the Razorpay order for the amount the backend priced the order at
       const response = await api.post(paymentEndpoint, {
-        amount: grandTotal,
         currency: 'INR',
         order_id: createdOrder.id,
       });
 
       const options = {
         key: response.data.key_id || import.meta.env.VITE_RAZORPAY_KEY || 'rzp_test_xxxxx',
-        amount: response.data.amount || grandTotal * 100,
+        amount: response.data.amount,
         currency: response.data.currency || 'INR',
         name: 'TRIPUND Lifestyle',
         description: 'Artisan Marketplace Purchase',
@@ -339,25 +383,10 @@ export default function CheckoutPage() {
         postal_code: data.address.postalCode,
         country: data.address.country || 'India'
       },
-      items: items.map(item => ({
-        product_id: item.product_id,
-        quantity: item.quantity,
-        price: item.price,
-        // Include variant information if present
-        ...(item.product.variant_info && {
-          variant_id: item.product.variant_info.variant_id,
-          variant_color: item.product.variant_info.color,
-          variant_size: item.product.variant_info.size,
-        }),
-      })),
+      items: orderItems,
+      // Amounts are worked out by the backend; only the promo code is sent
       totals: {
-        subtotal: gstBreakdown.basePrice,
-        shipping,
-        tax: gstBreakdown.totalGST,
-        cgst: gstBreakdown.cgst,
-        sgst: gstBreakdown.sgst,
-        igst: gstBreakdown.igst,
-        total: grandTotal,
+        coupon_code: appliedPromo?.code || '',
       },
       paymentMethod: data.paymentMethod,
       shippingMethod: shippingMethod,
@@ -868,7 +897,7 @@ export default function CheckoutPage() {
                       <span className="text-green-600">-₹{promoDiscount.toLocaleString()}</span>
                     </div>
                   )}
-                  {watch('paymentMethod') === 'cod' && (
+                  {paymentMethod === 'cod' && !quote && (
                     <div className="flex justify-between">
                       <span className="text-gray-600">COD Charges</span>
                       <span>₹50</span>
@@ -922,7 +951,7 @@ export default function CheckoutPage() {
                   <div className="flex justify-between text-lg font-semibold">
                     <span>Total (incl. GST)</span>
                     <span>
-                      ₹{formatPrice(grandTotal + (watch('paymentMethod') === 'cod' ? 50 : 0))}
+                      ₹{formatPrice(orderTotal)}
                     </span>
                   </div>
                 </div>